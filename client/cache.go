@@ -0,0 +1,289 @@
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	// DefaultCacheMaxStaleness is how long a cached key is served while the key server is unreachable by default.
+	DefaultCacheMaxStaleness = 24 * time.Hour
+
+	// cacheKeyFile holds the random key the cache is encrypted with, it never leaves the machine
+	cacheKeyFile = "cache.key"
+	cacheKeySize = 32
+	cacheExt     = ".cache"
+)
+
+// machineIDPaths are read in order to bind the cache to the machine
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// CacheEntry is a key cached by GetKey.
+type CacheEntry struct {
+	Path      string     `json:"path"`
+	Key       string     `json:"key"`
+	Version   int        `json:"version,omitempty"`
+	FetchedAt time.Time  `json:"fetched_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Stale returns true if the entry was fetched longer than maxStaleness ago, entries never go stale when it is zero.
+func (e *CacheEntry) Stale(now time.Time, maxStaleness time.Duration) bool {
+	return maxStaleness > 0 && now.Sub(e.FetchedAt) > maxStaleness
+}
+
+// Expired returns true if the cached key expired on the key server.
+func (e *CacheEntry) Expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+// Cache keeps the keys fetched from the key server encrypted on disk so they can be served while the key server
+// is unreachable. Every entry is encrypted with AES-256-GCM under a key derived from a random cache key stored
+// next to the entries and the machine ID, so a cache copied to another machine cannot be read.
+// The cache is only as safe as the permissions of its dir: anyone able to read the cache key on the machine can
+// decrypt the entries, and without a machine ID, see MachineBound, so can anyone holding a copy of the dir.
+type Cache struct {
+	dir          string
+	aead         cipher.AEAD
+	maxStaleness time.Duration
+	machineBound bool
+	now          func() time.Time
+}
+
+// OpenCache opens the cache in dir, creating dir and its cache key if they don't exist.
+// Cached keys older than maxStaleness are no longer served, they never go stale when it is zero.
+func OpenCache(dir string, maxStaleness time.Duration) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("error creating cache dir %s: %w", dir, err)
+	}
+	key, err := cacheKey(filepath.Join(dir, cacheKeyFile))
+	if err != nil {
+		return nil, err
+	}
+	id, machineBound := machineID()
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("zypher-cache:" + id))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache cipher: %w", err)
+	}
+	return &Cache{
+		dir:          dir,
+		aead:         aead,
+		maxStaleness: maxStaleness,
+		machineBound: machineBound,
+		now:          time.Now,
+	}, nil
+}
+
+// cacheKey reads the cache key at path or generates it if it does not exist.
+func cacheKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) != cacheKeySize {
+			return nil, fmt.Errorf("invalid cache key at %s", path)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading cache key at %s: %w", path, err)
+	}
+	key = make([]byte, cacheKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating cache key: %w", err)
+	}
+	if err := writeFile(path, key); err != nil {
+		return nil, fmt.Errorf("error writing cache key at %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// machineID returns the ID of the machine and true, or false if it has none.
+func machineID() (string, bool) {
+	for _, path := range machineIDPaths {
+		if id, err := os.ReadFile(path); err == nil && len(bytes.TrimSpace(id)) > 0 {
+			return string(bytes.TrimSpace(id)), true
+		}
+	}
+	return "", false
+}
+
+// MachineBound returns true if the cache is bound to the ID of the machine. Machines without
+// /etc/machine-id, such as macOS, have none: the cache is then only protected by the permissions of its dir.
+func (c *Cache) MachineBound() bool {
+	return c.machineBound
+}
+
+// Dir returns the dir of the cache.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// MaxStaleness returns how long a cached key is served while the key server is unreachable.
+func (c *Cache) MaxStaleness() time.Duration {
+	return c.maxStaleness
+}
+
+// Get returns the cached entry of the key at path or nil if it is not cached.
+func (c *Cache) Get(path string) (*CacheEntry, error) {
+	name := entryName(path)
+	b, err := os.ReadFile(filepath.Join(c.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading cached %s: %w", path, err)
+	}
+	e, err := c.decrypt(name, b)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cached %s: %w", path, err)
+	}
+	return e, nil
+}
+
+// Put caches the entry replacing the previous one of its path.
+func (c *Cache) Put(e CacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshaling cached %s: %w", e.Path, err)
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating nonce: %w", err)
+	}
+	// the entry is bound to its file name so it cannot be swapped with another one
+	name := entryName(e.Path)
+	sealed := c.aead.Seal(nonce, nonce, b, []byte(name))
+	if err := writeFile(filepath.Join(c.dir, name), sealed); err != nil {
+		return fmt.Errorf("error writing cached %s: %w", e.Path, err)
+	}
+	return nil
+}
+
+// Delete removes the cached entry of the key at path if any.
+func (c *Cache) Delete(path string) error {
+	if err := os.Remove(filepath.Join(c.dir, entryName(path))); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting cached %s: %w", path, err)
+	}
+	return nil
+}
+
+// Invalidate removes the cached entry of the key at path unless it caches the version.
+func (c *Cache) Invalidate(path string, version int) error {
+	e, err := c.Get(path)
+	if err == nil && e != nil && e.Version == version {
+		return nil
+	}
+	return c.Delete(path)
+}
+
+// Entries returns the cached entries sorted by path and the number of entries that could not be decrypted,
+// e.g. entries written on another machine or with another cache key.
+func (c *Cache) Entries() ([]CacheEntry, int, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*"+cacheExt))
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries []CacheEntry
+	unreadable := 0
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error reading cache entry %s: %w", f, err)
+		}
+		e, err := c.decrypt(filepath.Base(f), b)
+		if err != nil {
+			unreadable++
+			continue
+		}
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, unreadable, nil
+}
+
+// Clear removes every cached entry and returns how many were removed. The cache key is kept.
+func (c *Cache) Clear() (int, error) {
+	files, err := filepath.Glob(filepath.Join(c.dir, "*"+cacheExt))
+	if err != nil {
+		return 0, err
+	}
+	for i, f := range files {
+		if err := os.Remove(f); err != nil {
+			return i, fmt.Errorf("error deleting cache entry %s: %w", f, err)
+		}
+	}
+	return len(files), nil
+}
+
+// fallback returns the cached key at path if it can be served in place of the key server.
+func (c *Cache) fallback(path string) (string, bool) {
+	e, err := c.Get(path)
+	if err != nil || e == nil {
+		return "", false
+	}
+	now := c.now()
+	if e.Stale(now, c.maxStaleness) || e.Expired(now) {
+		return "", false
+	}
+	return e.Key, true
+}
+
+// decrypt opens the sealed entry of the file name.
+func (c *Cache) decrypt(name string, b []byte) (*CacheEntry, error) {
+	n := c.aead.NonceSize()
+	if len(b) < n {
+		return nil, fmt.Errorf("truncated cache entry")
+	}
+	plain, err := c.aead.Open(nil, b[:n], b[n:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting cache entry: %w", err)
+	}
+	e := &CacheEntry{}
+	if err := json.Unmarshal(plain, e); err != nil {
+		return nil, fmt.Errorf("error unmarshaling cache entry: %w", err)
+	}
+	return e, nil
+}
+
+// entryName returns the file name of the entry of the key at path, which does not reveal the path.
+func entryName(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:]) + cacheExt
+}
+
+// writeFile writes b to path readable by the owner only, replacing the file atomically.
+func writeFile(path string, b []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
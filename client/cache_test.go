@@ -0,0 +1,120 @@
+package client_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/client"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := client.OpenCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("error opening cache: %v", err)
+	}
+	fetchedAt := time.Now().UTC().Truncate(time.Second)
+	for _, e := range []client.CacheEntry{
+		{Path: "/twitter/prd", Key: "supersecretkey", Version: 2, FetchedAt: fetchedAt},
+		{Path: "/stripe/prd", Key: "anothersecretkey", Version: 1, FetchedAt: fetchedAt},
+	} {
+		if err := cache.Put(e); err != nil {
+			t.Fatalf("error caching %s: %v", e.Path, err)
+		}
+	}
+
+	t.Run("Get returns the cached entry", func(t *testing.T) {
+		e, err := cache.Get("/twitter/prd")
+		if err != nil || e == nil || e.Key != "supersecretkey" || e.Version != 2 || !e.FetchedAt.Equal(fetchedAt) {
+			t.Errorf("expected the cached entry, got %+v, %v", e, err)
+		}
+		if e, err := cache.Get("/github/prd"); err != nil || e != nil {
+			t.Errorf("expected no entry, got %+v, %v", e, err)
+		}
+	})
+
+	t.Run("entries are encrypted and named without their path", func(t *testing.T) {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"))
+		for _, f := range files {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatalf("error reading %s: %v", f, err)
+			}
+			if bytes.Contains(b, []byte("supersecretkey")) || bytes.Contains([]byte(f), []byte("twitter")) {
+				t.Errorf("expected %s not to reveal the cached key", f)
+			}
+			if info, _ := os.Stat(f); info.Mode().Perm() != 0600 {
+				t.Errorf("expected %s to be readable by its owner only, got %s", f, info.Mode())
+			}
+		}
+	})
+
+	t.Run("swapped entries cannot be read", func(t *testing.T) {
+		other := t.TempDir()
+		swapped, err := client.OpenCache(other, time.Hour)
+		if err != nil {
+			t.Fatalf("error opening cache: %v", err)
+		}
+		key, _ := os.ReadFile(filepath.Join(dir, "cache.key"))
+		if err := os.WriteFile(filepath.Join(other, "cache.key"), key, 0600); err != nil {
+			t.Fatalf("error copying cache key: %v", err)
+		}
+		files, _ := filepath.Glob(filepath.Join(dir, "*.cache"))
+		for i, f := range files {
+			b, _ := os.ReadFile(f)
+			// every entry is written under the name of the other one
+			if err := os.WriteFile(filepath.Join(other, filepath.Base(files[(i+1)%len(files)])), b, 0600); err != nil {
+				t.Fatalf("error copying entry: %v", err)
+			}
+		}
+		swapped, err = client.OpenCache(other, time.Hour)
+		if err != nil {
+			t.Fatalf("error opening cache: %v", err)
+		}
+		if _, err := swapped.Get("/twitter/prd"); err == nil {
+			t.Errorf("expected a swapped entry to fail to decrypt")
+		}
+		if entries, unreadable, err := swapped.Entries(); err != nil || len(entries) != 0 || unreadable != 2 {
+			t.Errorf("expected 2 unreadable entries, got %+v, %d, %v", entries, unreadable, err)
+		}
+	})
+
+	t.Run("Invalidate keeps the entry of the version only", func(t *testing.T) {
+		if err := cache.Invalidate("/twitter/prd", 2); err != nil {
+			t.Fatalf("error invalidating: %v", err)
+		}
+		if e, _ := cache.Get("/twitter/prd"); e == nil {
+			t.Errorf("expected the entry of the current version to be kept")
+		}
+		if err := cache.Invalidate("/twitter/prd", 3); err != nil {
+			t.Fatalf("error invalidating: %v", err)
+		}
+		if e, _ := cache.Get("/twitter/prd"); e != nil {
+			t.Errorf("expected the entry of a previous version to be removed, got %+v", e)
+		}
+	})
+
+	t.Run("Clear removes every entry", func(t *testing.T) {
+		if n, err := cache.Clear(); err != nil || n != 1 {
+			t.Errorf("expected 1 entry to be removed, got %d, %v", n, err)
+		}
+		if entries, _, err := cache.Entries(); err != nil || len(entries) != 0 {
+			t.Errorf("expected no entry, got %+v, %v", entries, err)
+		}
+	})
+}
+
+func TestCacheEntry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	expiresAt := now.Add(time.Hour)
+	e := client.CacheEntry{FetchedAt: now.Add(-2 * time.Hour), ExpiresAt: &expiresAt}
+	if !e.Stale(now, time.Hour) || e.Stale(now, 3*time.Hour) || e.Stale(now, 0) {
+		t.Errorf("expected the entry to be stale after an hour only")
+	}
+	if e.Expired(now) || !e.Expired(expiresAt) {
+		t.Errorf("expected the entry to expire at %s", expiresAt)
+	}
+}
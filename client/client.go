@@ -0,0 +1,721 @@
+// Package client is a Go client for the zypher key server REST API.
+//
+// Every request is signed by a Signer with a one-time token bound to the request method and path.
+// Signers can be backed by a private key file or by an ssh-agent identity.
+//
+//	signer, err := client.NewFileSigner("id_ed25519")
+//	if err != nil {
+//		return err
+//	}
+//	c := client.New("https://zypher.internal", signer, client.WithRetries(3, 100*time.Millisecond))
+//	key, err := c.GetKey(ctx, "twitter", "prd")
+//
+// Requests are traced with the global OpenTelemetry tracer provider and carry the trace context
+// in W3C traceparent headers when a propagator is installed with otel.SetTextMapPropagator.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/handlers"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/vtno/zypher/client"
+
+var (
+	// ErrBadRequest is returned when the key server rejects the request as invalid.
+	ErrBadRequest = errors.New("bad request")
+	// ErrUnauthorized is returned when the key server cannot authenticate the request signature.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when the signer is not granted the role required by the request
+	// or when a non-exportable key is requested.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound is returned when the requested key does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrGone is returned when the requested key expired or the requested changes can no longer be replayed
+	// from the key server change log.
+	ErrGone = errors.New("gone")
+	// ErrMisdirected is returned when a write is sent to a follower not forwarding writes to its primary.
+	ErrMisdirected = errors.New("misdirected request")
+)
+
+// StatusError is returned when the key server responds with an unexpected status code.
+// It matches ErrBadRequest, ErrUnauthorized, ErrForbidden, ErrNotFound, ErrGone and ErrMisdirected with errors.Is.
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s %s: key server responded with %s", e.Method, e.Path, e.Status)
+}
+
+// Is reports whether the status code matches one of the sentinel errors.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrGone:
+		return e.StatusCode == http.StatusGone
+	case ErrMisdirected:
+		return e.StatusCode == http.StatusMisdirectedRequest
+	}
+	return false
+}
+
+// Client talks to the key server REST API signing every request with a Signer.
+type Client struct {
+	baseURL    string
+	signer     Signer
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+	cache      *Cache
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used to send requests
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.httpClient = c
+	}
+}
+
+// WithRetries retries requests failing with a network error, 429, 502, 503 or 504 up to maxRetries times.
+// The delay between attempts starts at backoff and doubles on every attempt with jitter added.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(cl *Client) {
+		cl.maxRetries = maxRetries
+		cl.backoff = backoff
+	}
+}
+
+// WithCache keeps the keys fetched by GetKey in the cache and serves them from it while the key server is
+// unreachable, as long as they are not stale. Cached keys are evicted once the key server answers that they
+// were deleted, expired or are denied, and once a watch reports they changed.
+func WithCache(cache *Cache) Option {
+	return func(cl *Client) {
+		cl.cache = cache
+	}
+}
+
+// New returns a Client for the key server at baseURL.
+func New(baseURL string, signer Signer, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		signer:     signer,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// GetKey fetches the key by name and env, the key at the path /<name>/<env>.
+// The name may be a namespace e.g. payments/stripe for the key /payments/stripe/prod.
+// With WithCache, the cached key is returned when the key server is unreachable.
+func (c *Client) GetKey(ctx context.Context, name, env string) (string, error) {
+	params := url.Values{}
+	params.Add("name", name)
+	params.Add("env", env)
+	kgr := &handlers.KeyGetResponse{}
+	err := c.do(ctx, "GET", "/key", params, nil, http.StatusOK, kgr)
+	if c.cache == nil {
+		if err != nil {
+			return "", err
+		}
+		return kgr.Key, nil
+	}
+
+	path := "/" + strings.Trim(name, "/") + "/" + env
+	switch {
+	case err == nil:
+		// a cache that cannot be written only loses the fallback
+		_ = c.cache.Put(CacheEntry{
+			Path:      path,
+			Key:       kgr.Key,
+			Version:   kgr.Version,
+			FetchedAt: c.cache.now(),
+			ExpiresAt: kgr.ExpiresAt,
+		})
+		return kgr.Key, nil
+	case revoked(err):
+		_ = c.cache.Delete(path)
+	case unreachable(ctx, err):
+		if key, found := c.cache.fallback(path); found {
+			return key, nil
+		}
+	}
+	return "", err
+}
+
+// revoked returns true if the key server answered that the key is gone or no longer readable by the client.
+func revoked(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrGone) || errors.Is(err, ErrForbidden) ||
+		errors.Is(err, ErrUnauthorized)
+}
+
+// unreachable returns true if the request failed because the key server could not be reached or is unavailable.
+func unreachable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.StatusCode == http.StatusBadGateway || se.StatusCode == http.StatusServiceUnavailable ||
+			se.StatusCode == http.StatusGatewayTimeout
+	}
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+// GetKeyVersion fetches a version of the key by name and env along with the rotation of the current version.
+// The current version is returned when version is zero.
+func (c *Client) GetKeyVersion(ctx context.Context, name, env string, version int) (*KeyVersion, error) {
+	params := url.Values{}
+	params.Add("name", name)
+	params.Add("env", env)
+	if version != 0 {
+		params.Add("version", strconv.Itoa(version))
+	}
+	kgr := &handlers.KeyGetResponse{}
+	if err := c.do(ctx, "GET", "/key", params, nil, http.StatusOK, kgr); err != nil {
+		return nil, err
+	}
+	return &KeyVersion{
+		Key:         kgr.Key,
+		Version:     kgr.Version,
+		KeyRotation: keyRotationOf(kgr.KeyRotation),
+		KeyExpiry:   keyExpiryOf(kgr.KeyExpiry),
+	}, nil
+}
+
+// putOptions are the options of a key stored by PutKey.
+type putOptions struct {
+	nonExportable  bool
+	rotationPeriod string
+	ttl            string
+	expiresAt      *time.Time
+}
+
+// PutOption customizes a key stored by PutKey.
+type PutOption func(*putOptions)
+
+// NonExportable prevents the key from ever being returned by GetKey.
+// It can only be used through Encrypt and Decrypt.
+func NonExportable() PutOption {
+	return func(o *putOptions) {
+		o.nonExportable = true
+	}
+}
+
+// RotateEvery rotates the key automatically on the key server once it gets older than the period
+// e.g. "90d" or "12h". "0" removes the rotation policy.
+func RotateEvery(period string) PutOption {
+	return func(o *putOptions) {
+		o.rotationPeriod = period
+	}
+}
+
+// ExpireAfter expires the key on the key server once the ttl elapsed e.g. "7d" or "12h". "0" removes the expiry.
+// GetKey returns ErrGone once the key expired.
+func ExpireAfter(ttl string) PutOption {
+	return func(o *putOptions) {
+		o.ttl = ttl
+	}
+}
+
+// ExpireAt expires the key on the key server at t.
+func ExpireAt(t time.Time) PutOption {
+	return func(o *putOptions) {
+		o.expiresAt = &t
+	}
+}
+
+// PutKey creates or replaces the key by name and env.
+// A replaced key is kept as a previous version available to decrypt.
+func (c *Client) PutKey(ctx context.Context, name, env, key string, opts ...PutOption) error {
+	o := &putOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	body, err := json.Marshal(&handlers.KeyPostRequest{
+		Name:           name,
+		Env:            env,
+		Key:            key,
+		NonExportable:  o.nonExportable,
+		RotationPeriod: o.rotationPeriod,
+		TTL:            o.ttl,
+		ExpiresAt:      o.expiresAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling request body: %w", err)
+	}
+	return c.do(ctx, "POST", "/key", nil, body, http.StatusCreated, nil)
+}
+
+// ListKeys returns the path, name, env and rotation of every key readable by the client, optionally filtered by
+// a path prefix e.g. /payments/ for every key below the payments namespace.
+func (c *Client) ListKeys(ctx context.Context, prefix string) ([]KeyListEntry, error) {
+	params := url.Values{}
+	if prefix != "" {
+		params.Add("prefix", prefix)
+	}
+	klr := &handlers.KeyListResponse{}
+	if err := c.do(ctx, "GET", "/keys", params, nil, http.StatusOK, klr); err != nil {
+		return nil, err
+	}
+	keys := make([]KeyListEntry, 0, len(klr.Keys))
+	for _, k := range klr.Keys {
+		keys = append(keys, KeyListEntry{
+			Path:        k.Path,
+			Name:        k.Name,
+			Env:         k.Env,
+			Version:     k.Version,
+			KeyRotation: keyRotationOf(k.KeyRotation),
+			KeyExpiry:   keyExpiryOf(k.KeyExpiry),
+		})
+	}
+	return keys, nil
+}
+
+// DeleteKey deletes the key by name and env.
+func (c *Client) DeleteKey(ctx context.Context, name, env string) error {
+	params := url.Values{}
+	params.Add("name", name)
+	params.Add("env", env)
+	return c.do(ctx, "DELETE", "/key", params, nil, http.StatusNoContent, nil)
+}
+
+// Encrypt encrypts the plaintext on the key server with the key by name and env.
+func (c *Client) Encrypt(ctx context.Context, name, env string, plaintext []byte) ([]byte, error) {
+	return c.transit(ctx, "/transit/encrypt", name, env, plaintext)
+}
+
+// Decrypt decrypts the ciphertext on the key server with the key by name and env.
+// ErrBadRequest is returned if the ciphertext cannot be decrypted with the key.
+func (c *Client) Decrypt(ctx context.Context, name, env string, ciphertext []byte) ([]byte, error) {
+	return c.transit(ctx, "/transit/decrypt", name, env, ciphertext)
+}
+
+func (c *Client) transit(ctx context.Context, path, name, env string, payload []byte) ([]byte, error) {
+	body, err := json.Marshal(&handlers.TransitRequest{
+		Name:    name,
+		Env:     env,
+		Payload: base64.StdEncoding.EncodeToString(payload),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request body: %w", err)
+	}
+	tr := &handlers.TransitResponse{}
+	if err := c.do(ctx, "POST", path, nil, body, http.StatusOK, tr); err != nil {
+		return nil, err
+	}
+	out, err := base64.StdEncoding.DecodeString(tr.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding response payload: %w", err)
+	}
+	return out, nil
+}
+
+// DataKey is a data key generated by the key server.
+type DataKey struct {
+	// Key is the plaintext data key to encrypt with locally. It should never be persisted.
+	Key string
+	// WrappedKey is the data key encrypted by the master key, to be stored along with the ciphertext.
+	WrappedKey []byte
+}
+
+// GenerateDataKey asks the key server for a new data key wrapped by the master key by name and env.
+func (c *Client) GenerateDataKey(ctx context.Context, name, env string) (*DataKey, error) {
+	params := url.Values{}
+	params.Add("name", name)
+	params.Add("env", env)
+	dkr := &handlers.DataKeyResponse{}
+	if err := c.do(ctx, "POST", "/datakey", params, nil, http.StatusCreated, dkr); err != nil {
+		return nil, err
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(dkr.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding wrapped key: %w", err)
+	}
+	return &DataKey{Key: dkr.Key, WrappedKey: wrapped}, nil
+}
+
+// UnwrapDataKey decrypts a data key wrapped by the master key by name and env.
+// ErrBadRequest is returned if the data key was not wrapped by the master key.
+func (c *Client) UnwrapDataKey(ctx context.Context, name, env string, wrapped []byte) (string, error) {
+	body, err := json.Marshal(&handlers.DataKeyUnwrapRequest{
+		Name:       name,
+		Env:        env,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error marshaling request body: %w", err)
+	}
+	dur := &handlers.DataKeyUnwrapResponse{}
+	if err := c.do(ctx, "POST", "/datakey/unwrap", nil, body, http.StatusOK, dur); err != nil {
+		return "", err
+	}
+	return dur.Key, nil
+}
+
+// DefaultWatchWait is how long a watch request waits on the key server for a change.
+const DefaultWatchWait = 30 * time.Second
+
+// KeyUpdate is a change of a key delivered by Watch.
+type KeyUpdate struct {
+	Path    string
+	Version int
+	// Deleted is true once the key has been deleted.
+	Deleted bool
+	// Err is set when the key server could not be watched, the watch is retried after a delay.
+	Err error
+}
+
+// Watch delivers the current version of the key by name and env then every change of it until ctx is done,
+// so services caching the key can reload it once it has been rotated. The channel is closed once ctx is done.
+func (c *Client) Watch(ctx context.Context, name, env string) <-chan KeyUpdate {
+	params := url.Values{}
+	params.Add("name", name)
+	params.Add("env", env)
+	return c.watch(ctx, params)
+}
+
+// WatchPrefix delivers the current version of every key under the path prefix e.g. /payments/
+// then every change of them, including keys created or deleted, until ctx is done.
+func (c *Client) WatchPrefix(ctx context.Context, prefix string) <-chan KeyUpdate {
+	params := url.Values{}
+	params.Add("prefix", prefix)
+	return c.watch(ctx, params)
+}
+
+// watch long-polls GET /key/watch, the delay between failed requests doubles from 1s up to 30s.
+func (c *Client) watch(ctx context.Context, params url.Values) <-chan KeyUpdate {
+	updates := make(chan KeyUpdate)
+	go func() {
+		defer close(updates)
+		deliver := func(u KeyUpdate) bool {
+			select {
+			case updates <- u:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		params.Set("wait", DefaultWatchWait.String())
+		versions := map[string]int{}
+		backoff := time.Second
+		for ctx.Err() == nil {
+			kwr := &handlers.KeyWatchResponse{}
+			if err := c.do(ctx, "GET", "/key/watch", params, nil, http.StatusOK, kwr); err != nil {
+				if ctx.Err() != nil || !deliver(KeyUpdate{Err: err}) {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				if backoff *= 2; backoff > 30*time.Second {
+					backoff = 30 * time.Second
+				}
+				continue
+			}
+			backoff = time.Second
+			params.Set("since", kwr.Since)
+			if !kwr.Changed {
+				continue
+			}
+			current := make(map[string]int, len(kwr.Keys))
+			for _, kv := range kwr.Keys {
+				current[kv.Path] = kv.Version
+				if v, found := versions[kv.Path]; found && v == kv.Version {
+					continue
+				}
+				if c.cache != nil {
+					_ = c.cache.Invalidate(kv.Path, kv.Version)
+				}
+				if !deliver(KeyUpdate{Path: kv.Path, Version: kv.Version, Deleted: kv.Version == 0}) {
+					return
+				}
+			}
+			// keys under a prefix are no longer listed once deleted
+			for path := range versions {
+				if _, found := current[path]; !found {
+					if c.cache != nil {
+						_ = c.cache.Delete(path)
+					}
+					if !deliver(KeyUpdate{Path: path, Deleted: true}) {
+						return
+					}
+				}
+			}
+			versions = current
+		}
+	}()
+	return updates
+}
+
+// GetPolicy returns the policy attached to the namespace or key path e.g. /payments.
+// With effective the policies of every level of the path are returned merged. It requires the admin role.
+func (c *Client) GetPolicy(ctx context.Context, path string, effective bool) (*Policy, error) {
+	params := url.Values{}
+	params.Add("path", path)
+	if effective {
+		params.Add("effective", "true")
+	}
+	pr := &handlers.PolicyResponse{}
+	if err := c.do(ctx, "GET", "/policy", params, nil, http.StatusOK, pr); err != nil {
+		return nil, err
+	}
+	return policyOf(pr), nil
+}
+
+// PutPolicy attaches the policy to the namespace or key path, replacing the previous one.
+// It requires the admin role.
+func (c *Client) PutPolicy(ctx context.Context, path string, policy Policy) error {
+	body, err := json.Marshal(&handlers.PolicyRequest{
+		Path: path,
+		PolicyResponse: handlers.PolicyResponse{
+			Readers:        policy.Readers,
+			Writers:        policy.Writers,
+			RotationPeriod: policy.RotationPeriod,
+			NonExportable:  policy.NonExportable,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error marshaling request body: %w", err)
+	}
+	return c.do(ctx, "PUT", "/policy", nil, body, http.StatusNoContent, nil)
+}
+
+// DeletePolicy detaches the policy of the namespace or key path. It requires the admin role.
+func (c *Client) DeletePolicy(ctx context.Context, path string) error {
+	params := url.Values{}
+	params.Add("path", path)
+	return c.do(ctx, "DELETE", "/policy", params, nil, http.StatusNoContent, nil)
+}
+
+// Audit returns the audit events matching the filter along with the head of the audit log.
+// It requires the admin role.
+func (c *Client) Audit(ctx context.Context, filter AuditFilter) (*AuditLog, error) {
+	params := url.Values{}
+	for k, v := range map[string]string{"identity": filter.Identity, "action": filter.Action, "path": filter.Path, "name": filter.Name, "env": filter.Env} {
+		if v != "" {
+			params.Add(k, v)
+		}
+	}
+	if !filter.Since.IsZero() {
+		params.Add("since", filter.Since.Format(time.RFC3339))
+	}
+	if !filter.Until.IsZero() {
+		params.Add("until", filter.Until.Format(time.RFC3339))
+	}
+	if filter.Limit > 0 {
+		params.Add("limit", strconv.Itoa(filter.Limit))
+	}
+	ar := &handlers.AuditResponse{}
+	if err := c.do(ctx, "GET", "/audit", params, nil, http.StatusOK, ar); err != nil {
+		return nil, err
+	}
+	log := &AuditLog{Events: make([]AuditEvent, 0, len(ar.Events)), Head: AuditHead{Seq: ar.Head.Seq, Hash: ar.Head.Hash}}
+	for _, e := range ar.Events {
+		log.Events = append(log.Events, auditEventOf(e))
+	}
+	return log, nil
+}
+
+// Backup streams an encrypted snapshot of the key server store to w.
+// It requires the admin role. Backups are restored with zypher server restore.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	return c.do(ctx, "GET", "/sys/backup", nil, nil, http.StatusOK, w)
+}
+
+// ReplicationSnapshot returns every value of the key server store along with the head of its change log.
+// It requires the replicator role.
+func (c *Client) ReplicationSnapshot(ctx context.Context) (*Changes, error) {
+	rr := &handlers.ReplicationResponse{}
+	if err := c.do(ctx, "GET", "/sys/replication/snapshot", nil, nil, http.StatusOK, rr); err != nil {
+		return nil, err
+	}
+	return changesOf(rr), nil
+}
+
+// ReplicationChanges returns the changes recorded after the since sequence number,
+// waiting up to wait for a change when there are none. It requires the replicator role.
+// ErrGone is returned when the changes cannot be replayed and a snapshot has to be loaded instead.
+func (c *Client) ReplicationChanges(ctx context.Context, since uint64, wait time.Duration) (*Changes, error) {
+	params := url.Values{}
+	params.Add("since", strconv.FormatUint(since, 10))
+	if wait > 0 {
+		params.Add("wait", wait.String())
+	}
+	rr := &handlers.ReplicationResponse{}
+	if err := c.do(ctx, "GET", "/sys/replication/changes", params, nil, http.StatusOK, rr); err != nil {
+		return nil, err
+	}
+	return changesOf(rr), nil
+}
+
+// ReplicationStatus returns whether the key server is a primary or a follower and its change log head.
+// It requires the admin role.
+func (c *Client) ReplicationStatus(ctx context.Context) (*ReplicationStatus, error) {
+	rs := &handlers.ReplicationStatus{}
+	if err := c.do(ctx, "GET", "/sys/replication/status", nil, nil, http.StatusOK, rs); err != nil {
+		return nil, err
+	}
+	return replicationStatusOf(rs), nil
+}
+
+// Promote stops a follower from replicating its primary and makes it accept writes.
+// It requires the admin role and succeeds on a key server that is already a primary.
+func (c *Client) Promote(ctx context.Context) (*ReplicationStatus, error) {
+	rs := &handlers.ReplicationStatus{}
+	if err := c.do(ctx, "POST", "/sys/promote", nil, nil, http.StatusOK, rs); err != nil {
+		return nil, err
+	}
+	return replicationStatusOf(rs), nil
+}
+
+// WebhookDeliveries returns the recent webhook deliveries of the key server and those that failed every attempt.
+// It requires the admin role.
+func (c *Client) WebhookDeliveries(ctx context.Context) (*WebhookDeliveries, error) {
+	wr := &handlers.WebhookDeliveriesResponse{}
+	if err := c.do(ctx, "GET", "/sys/webhooks/deliveries", nil, nil, http.StatusOK, wr); err != nil {
+		return nil, err
+	}
+	return &WebhookDeliveries{
+		Deliveries:  webhookDeliveriesOf(wr.Deliveries),
+		DeadLetters: webhookDeliveriesOf(wr.DeadLetters),
+	}, nil
+}
+
+// do sends the request, retrying as configured, and decodes the JSON response into out if not nil.
+// The response is copied as is if out is an io.Writer.
+// Every attempt is signed with a new token since tokens can only be used once.
+func (c *Client) do(ctx context.Context, method, path string, params url.Values, body []byte, expected int, out interface{}) (err error) {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, method+" "+path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(method)),
+	)
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	delay := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.send(ctx, method, path, params, body)
+		if err == nil {
+			err = receive(resp, method, path, expected, out)
+			var se *StatusError
+			if !errors.As(err, &se) || !retryable(se.StatusCode) {
+				return err
+			}
+		} else if ctx.Err() != nil {
+			return err
+		}
+
+		if attempt >= c.maxRetries {
+			return err
+		}
+		wait := delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+}
+
+// receive reads the response into out if it has the expected status, a StatusError is returned otherwise.
+// The body of the response is closed.
+func receive(resp *http.Response, method, path string, expected int, out interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != expected {
+		return &StatusError{Method: method, Path: path, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	if out == nil {
+		return nil
+	}
+	if w, ok := out.(io.Writer); ok {
+		if _, err := io.Copy(w, resp.Body); err != nil {
+			return fmt.Errorf("error reading response body: %w", err)
+		}
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("error decoding response body: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) send(ctx context.Context, method, path string, params url.Values, body []byte) (*http.Response, error) {
+	u := c.baseURL + path
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, fmt.Errorf("error creating %s request to %s: %w", method, path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	header, err := auth.SignedAuthHeader(c.signer, method, req.URL.Path+"?"+req.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", header)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending %s request to %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+func retryable(statusCode int) bool {
+	switch statusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,584 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher"
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/server"
+	"github.com/vtno/zypher/internal/server/audit"
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/backup"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type pubKeyProvider struct {
+	pub ed25519.PublicKey
+}
+
+func (p *pubKeyProvider) Get() (auth.Verifier, error) {
+	return auth.NewVerifier(p.pub)
+}
+
+// startServer starts a key server on port trusting the returned private key as root key.
+// backupKey encrypts the backups of the key server started by startServer
+const backupKey = "0123456789abcdef0123456789abcdef"
+
+func startServer(t *testing.T, port int) ed25519.PrivateKey {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating root key: %v", err)
+	}
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	a, err := auth.NewAuth(bbStore, auth.WithPubKeyProvider(&pubKeyProvider{pub: pub}))
+	if err != nil {
+		t.Fatalf("error creating auth: %v", err)
+	}
+	auditLog, err := audit.OpenLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening audit log: %v", err)
+	}
+	s, err := server.NewServer(bbStore, a, zap.NewNop(),
+		server.WithPort(port),
+		server.WithAuditLog(auditLog),
+		server.WithBackupKey(zypher.NewCipher(backupKey)),
+	)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	go s.Start()
+	t.Cleanup(func() { s.Stop(context.Background()) })
+
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+		if err == nil {
+			conn.Close()
+			return priv
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("server did not start")
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	priv := startServer(t, 8084)
+	c := client.New("http://localhost:8084", client.NewKeySigner(priv))
+
+	if err := c.PutKey(ctx, "twitter", "prd", "supersecretkey"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := c.PutKey(ctx, "stripe", "prd", "anothersecretkey"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	t.Run("GetKey returns the stored key", func(t *testing.T) {
+		key, err := c.GetKey(ctx, "twitter", "prd")
+		if err != nil {
+			t.Fatalf("error getting key: %v", err)
+		}
+		if key != "supersecretkey" {
+			t.Errorf("expected key to be %s, got %s", "supersecretkey", key)
+		}
+	})
+
+	t.Run("ListKeys returns the keys matching the prefix", func(t *testing.T) {
+		keys, err := c.ListKeys(ctx, "twi")
+		if err != nil {
+			t.Fatalf("error listing keys: %v", err)
+		}
+		if len(keys) != 1 || keys[0].Path != "/twitter/prd" || keys[0].Name != "twitter" || keys[0].Env != "prd" || keys[0].Version != 1 {
+			t.Errorf("expected /twitter/prd version 1 only, got %v", keys)
+		}
+	})
+
+	t.Run("PutKey keeps the replaced key as a previous version", func(t *testing.T) {
+		if err := c.PutKey(ctx, "slack", "prd", "0123456789abcdef", client.RotateEvery("90d")); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+		ciphertext, err := c.Encrypt(ctx, "slack", "prd", []byte("some payload"))
+		if err != nil {
+			t.Fatalf("error encrypting payload: %v", err)
+		}
+		if err := c.PutKey(ctx, "slack", "prd", "fedcba9876543210"); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+
+		current, err := c.GetKeyVersion(ctx, "slack", "prd", 0)
+		if err != nil {
+			t.Fatalf("error getting key: %v", err)
+		}
+		if current.Key != "fedcba9876543210" || current.Version != 2 || current.RotationPeriod != "2160h0m0s" || current.Overdue {
+			t.Errorf("expected current version 2 rotated every 2160h0m0s, got %+v", current)
+		}
+		previous, err := c.GetKeyVersion(ctx, "slack", "prd", 1)
+		if err != nil {
+			t.Fatalf("error getting key: %v", err)
+		}
+		if previous.Key != "0123456789abcdef" {
+			t.Errorf("expected version 1 to be %s, got %s", "0123456789abcdef", previous.Key)
+		}
+		if _, err := c.GetKeyVersion(ctx, "slack", "prd", 3); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected unknown version to be not found, got %v", err)
+		}
+
+		// payloads encrypted with previous versions can still be decrypted
+		plaintext, err := c.Decrypt(ctx, "slack", "prd", ciphertext)
+		if err != nil {
+			t.Fatalf("error decrypting payload: %v", err)
+		}
+		if string(plaintext) != "some payload" {
+			t.Errorf("expected plaintext to be %s, got %s", "some payload", plaintext)
+		}
+	})
+
+	t.Run("DeleteKey deletes the key", func(t *testing.T) {
+		if err := c.DeleteKey(ctx, "twitter", "prd"); err != nil {
+			t.Fatalf("error deleting key: %v", err)
+		}
+		if _, err := c.GetKey(ctx, "twitter", "prd"); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected deleted key to be not found, got %v", err)
+		}
+		if err := c.DeleteKey(ctx, "twitter", "prd"); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected deleting a missing key to be not found, got %v", err)
+		}
+	})
+
+	t.Run("Encrypt and Decrypt use a non-exportable key on the server", func(t *testing.T) {
+		if err := c.PutKey(ctx, "github", "prd", "0123456789abcdef0123456789abcdef", client.NonExportable()); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+		if _, err := c.GetKey(ctx, "github", "prd"); !errors.Is(err, client.ErrForbidden) {
+			t.Errorf("expected non-exportable key to be forbidden, got %v", err)
+		}
+
+		ciphertext, err := c.Encrypt(ctx, "github", "prd", []byte("some payload"))
+		if err != nil {
+			t.Fatalf("error encrypting payload: %v", err)
+		}
+		plaintext, err := c.Decrypt(ctx, "github", "prd", ciphertext)
+		if err != nil {
+			t.Fatalf("error decrypting payload: %v", err)
+		}
+		if string(plaintext) != "some payload" {
+			t.Errorf("expected plaintext to be %s, got %s", "some payload", plaintext)
+		}
+
+		ciphertext[len(ciphertext)-1] ^= 0xff
+		if _, err := c.Decrypt(ctx, "github", "prd", ciphertext); !errors.Is(err, client.ErrBadRequest) {
+			t.Errorf("expected tampered ciphertext to be rejected, got %v", err)
+		}
+		if _, err := c.Encrypt(ctx, "gitlab", "prd", []byte("some payload")); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected unknown key to be not found, got %v", err)
+		}
+	})
+
+	t.Run("GenerateDataKey returns a data key unwrapped by UnwrapDataKey", func(t *testing.T) {
+		dk, err := c.GenerateDataKey(ctx, "github", "prd")
+		if err != nil {
+			t.Fatalf("error generating data key: %v", err)
+		}
+		if len(dk.Key) != 32 {
+			t.Errorf("expected data key to be 32 bytes long, got %d", len(dk.Key))
+		}
+		key, err := c.UnwrapDataKey(ctx, "github", "prd", dk.WrappedKey)
+		if err != nil {
+			t.Fatalf("error unwrapping data key: %v", err)
+		}
+		if key != dk.Key {
+			t.Errorf("expected unwrapped key to be %s, got %s", dk.Key, key)
+		}
+		if _, err := c.UnwrapDataKey(ctx, "stripe", "prd", dk.WrappedKey); !errors.Is(err, client.ErrBadRequest) {
+			t.Errorf("expected a key wrapped by another master key to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("requests signed by an unknown key are rejected", func(t *testing.T) {
+		_, other, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error generating key: %v", err)
+		}
+		_, err = client.New("http://localhost:8084", client.NewKeySigner(other)).GetKey(ctx, "stripe", "prd")
+		if !errors.Is(err, client.ErrUnauthorized) {
+			t.Errorf("expected request to be unauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Audit returns the audited requests", func(t *testing.T) {
+		ar, err := c.Audit(ctx, client.AuditFilter{Name: "twitter", Env: "prd"})
+		if err != nil {
+			t.Fatalf("error querying audit log: %v", err)
+		}
+		var actions []string
+		for _, e := range ar.Events {
+			if e.Identity != "root" || e.SourceIP != "127.0.0.1" || e.RequestID == "" {
+				t.Errorf("expected event to be audited with identity, source IP and request ID, got %+v", e)
+			}
+			actions = append(actions, e.Action+" "+e.Result)
+		}
+		expected := []string{"key.write success", "key.read success", "key.delete success", "key.read failure", "key.delete failure"}
+		if !reflect.DeepEqual(actions, expected) {
+			t.Errorf("expected actions %v, got %v", expected, actions)
+		}
+
+		denied, err := c.Audit(ctx, client.AuditFilter{Action: "key.read", Env: "prd", Name: "stripe"})
+		if err != nil {
+			t.Fatalf("error querying audit log: %v", err)
+		}
+		if n := len(denied.Events); n != 1 || denied.Events[0].Result != audit.ResultDenied || denied.Events[0].Reason == "" {
+			t.Errorf("expected the request signed by an unknown key to be denied, got %+v", denied.Events)
+		}
+
+		byPath, err := c.Audit(ctx, client.AuditFilter{Path: "/twitter/prd"})
+		if err != nil {
+			t.Fatalf("error querying audit log: %v", err)
+		}
+		// the audit query above is itself audited with the name and env it filters
+		if len(byPath.Events) != len(ar.Events)+1 || byPath.Events[0].Path != "/twitter/prd" {
+			t.Errorf("expected the requests of /twitter/prd to be audited by path, got %+v", byPath.Events)
+		}
+		if ar.Head.Seq == 0 || ar.Head.Hash == "" {
+			t.Errorf("expected the head of the audit log, got %+v", ar.Head)
+		}
+	})
+
+	t.Run("Backup streams a snapshot that can be restored", func(t *testing.T) {
+		if err := c.PutKey(ctx, "backup", "prd", "backedupkey"); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+		var b bytes.Buffer
+		if err := c.Backup(ctx, &b); err != nil {
+			t.Fatalf("error taking backup: %v", err)
+		}
+		dbPath := filepath.Join(t.TempDir(), "restored.db")
+		if err := backup.Restore(&b, zypher.NewCipher(backupKey), dbPath); err != nil {
+			t.Fatalf("error restoring backup: %v", err)
+		}
+		restored, err := store.NewBBoltStore(dbPath)
+		if err != nil {
+			t.Fatalf("error opening restored store: %v", err)
+		}
+		defer restored.Close()
+		if e, err := restored.Get(context.Background(), "/backup", "prd"); err != nil || string(e.Value) != "backedupkey" {
+			t.Errorf("expected the restored store to hold the key, got %+v %v", e, err)
+		}
+	})
+}
+
+func TestClient_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	priv := startServer(t, 8094)
+	c := client.New("http://localhost:8094", client.NewKeySigner(priv))
+	if err := c.PutKey(ctx, "payments/stripe", "prd", "v1"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	next := func(t *testing.T, updates <-chan client.KeyUpdate) client.KeyUpdate {
+		select {
+		case u := <-updates:
+			if u.Err != nil {
+				t.Fatalf("error watching: %v", u.Err)
+			}
+			return u
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected an update")
+		}
+		return client.KeyUpdate{}
+	}
+
+	key := c.Watch(ctx, "payments/stripe", "prd")
+	prefix := c.WatchPrefix(ctx, "/payments/")
+	t.Run("delivers the current versions first", func(t *testing.T) {
+		expected := client.KeyUpdate{Path: "/payments/stripe/prd", Version: 1}
+		if u := next(t, key); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+		if u := next(t, prefix); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+	})
+
+	t.Run("delivers rotations of the key", func(t *testing.T) {
+		if err := c.PutKey(ctx, "payments/stripe", "prd", "v2"); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+		expected := client.KeyUpdate{Path: "/payments/stripe/prd", Version: 2}
+		if u := next(t, key); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+		if u := next(t, prefix); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+	})
+
+	t.Run("delivers keys created and deleted under the prefix", func(t *testing.T) {
+		if err := c.PutKey(ctx, "payments/adyen", "prd", "v1"); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+		expected := client.KeyUpdate{Path: "/payments/adyen/prd", Version: 1}
+		if u := next(t, prefix); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+		if err := c.DeleteKey(ctx, "payments/stripe", "prd"); err != nil {
+			t.Fatalf("error deleting key: %v", err)
+		}
+		expected = client.KeyUpdate{Path: "/payments/stripe/prd", Deleted: true}
+		if u := next(t, key); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+		if u := next(t, prefix); u != expected {
+			t.Errorf("expected %+v, got %+v", expected, u)
+		}
+	})
+
+	t.Run("closes the channel once the context is done", func(t *testing.T) {
+		cancel()
+		for range key {
+		}
+	})
+}
+
+func TestClient_Retries(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		failures      int32
+		status        int
+		expectedCalls int32
+		expectedErr   error
+	}{
+		{
+			name:          "retries unavailable responses until the request succeeds",
+			failures:      2,
+			status:        http.StatusServiceUnavailable,
+			expectedCalls: 3,
+		},
+		{
+			name:          "gives up after the maximum number of retries",
+			failures:      5,
+			status:        http.StatusTooManyRequests,
+			expectedCalls: 4,
+			expectedErr:   &client.StatusError{Method: "GET", Path: "/key", StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests"},
+		},
+		{
+			name:          "does not retry client errors",
+			failures:      1,
+			status:        http.StatusNotFound,
+			expectedCalls: 1,
+			expectedErr:   client.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			tokens := make(map[string]bool)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// every attempt must be signed with a new token
+				token := r.Header.Get("Authorization")
+				if tokens[token] {
+					t.Errorf("token %s was sent twice", token)
+				}
+				tokens[token] = true
+				if atomic.AddInt32(&calls, 1) <= tt.failures {
+					w.WriteHeader(tt.status)
+					return
+				}
+				fmt.Fprint(w, `{"key":"supersecretkey"}`)
+			}))
+			defer srv.Close()
+
+			c := client.New(srv.URL, client.NewKeySigner(priv), client.WithRetries(3, time.Millisecond))
+			key, err := c.GetKey(context.Background(), "twitter", "prd")
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) && !reflect.DeepEqual(err, tt.expectedErr) {
+					t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("error getting key: %v", err)
+			} else if key != "supersecretkey" {
+				t.Errorf("expected key to be %s, got %s", "supersecretkey", key)
+			}
+			if calls != tt.expectedCalls {
+				t.Errorf("expected %d calls, got %d", tt.expectedCalls, calls)
+			}
+		})
+	}
+}
+
+func TestNewAgentSigner(t *testing.T) {
+	// an empty agent holds no identity to sign with
+	a := agent.NewKeyring().(agent.ExtendedAgent)
+	s, err := client.NewAgentSigner(a, "")
+	if err == nil {
+		t.Fatalf("expected an error without an agent identity")
+	}
+	if s != nil {
+		t.Errorf("expected a nil signer along with the error, got %#v", s)
+	}
+}
+
+func TestClient_TraceContext(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key: %v", err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		fmt.Fprint(w, `{"key":"supersecretkey"}`)
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.NewKeySigner(priv))
+	if _, err := c.GetKey(context.Background(), "twitter", "prd"); err != nil {
+		t.Fatalf("error getting key: %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Name != "GET /key" {
+		t.Fatalf("expected a GET /key span, got %v", spans)
+	}
+	expected := fmt.Sprintf("00-%s-%s-01", spans[0].SpanContext.TraceID(), spans[0].SpanContext.SpanID())
+	if traceparent != expected {
+		t.Errorf("expected traceparent to be %s, got %s", expected, traceparent)
+	}
+}
+
+func TestClient_Cache(t *testing.T) {
+	ctx := context.Background()
+	priv := startServer(t, 8097)
+	dir := t.TempDir()
+	cache, err := client.OpenCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("error opening cache: %v", err)
+	}
+	c := client.New("http://localhost:8097", client.NewKeySigner(priv), client.WithCache(cache))
+	// nothing listens on port 1 so every request of offline fails as if the key server was down
+	offline := client.New("http://localhost:1", client.NewKeySigner(priv), client.WithCache(cache), client.WithRetries(0, time.Millisecond))
+	if err := c.PutKey(ctx, "twitter", "prd", "supersecretkey"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	t.Run("serves the cached key while the key server is unreachable", func(t *testing.T) {
+		if _, err := offline.GetKey(ctx, "twitter", "prd"); err == nil {
+			t.Fatalf("expected an error before the key is cached")
+		}
+		if _, err := c.GetKey(ctx, "twitter", "prd"); err != nil {
+			t.Fatalf("error getting key: %v", err)
+		}
+		key, err := offline.GetKey(ctx, "twitter", "prd")
+		if err != nil {
+			t.Fatalf("error getting cached key: %v", err)
+		}
+		if key != "supersecretkey" {
+			t.Errorf("expected key to be %s, got %s", "supersecretkey", key)
+		}
+	})
+
+	t.Run("does not serve keys older than the max staleness", func(t *testing.T) {
+		stale, err := client.OpenCache(dir, time.Nanosecond)
+		if err != nil {
+			t.Fatalf("error opening cache: %v", err)
+		}
+		offline := client.New("http://localhost:1", client.NewKeySigner(priv), client.WithCache(stale), client.WithRetries(0, time.Millisecond))
+		if _, err := offline.GetKey(ctx, "twitter", "prd"); err == nil {
+			t.Errorf("expected a stale key not to be served")
+		}
+	})
+
+	t.Run("evicts keys deleted on the key server", func(t *testing.T) {
+		if err := c.DeleteKey(ctx, "twitter", "prd"); err != nil {
+			t.Fatalf("error deleting key: %v", err)
+		}
+		if _, err := c.GetKey(ctx, "twitter", "prd"); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected %v, got %v", client.ErrNotFound, err)
+		}
+		if e, err := cache.Get("/twitter/prd"); err != nil || e != nil {
+			t.Errorf("expected the deleted key to be evicted, got %+v, %v", e, err)
+		}
+		if _, err := offline.GetKey(ctx, "twitter", "prd"); err == nil {
+			t.Errorf("expected an evicted key not to be served")
+		}
+	})
+}
+
+// TestClient_publicAPI checks that modules outside zypher, which cannot import its internal packages,
+// can use every method of the client.
+func TestClient_publicAPI(t *testing.T) {
+	seen := map[reflect.Type]bool{}
+	var check func(where string, typ reflect.Type)
+	check = func(where string, typ reflect.Type) {
+		if seen[typ] {
+			return
+		}
+		seen[typ] = true
+		if strings.Contains(typ.PkgPath(), "/internal/") {
+			t.Errorf("%s uses %s of an internal package", where, typ)
+			return
+		}
+		switch typ.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Chan:
+			check(where, typ.Elem())
+		case reflect.Map:
+			check(where, typ.Key())
+			check(where, typ.Elem())
+		case reflect.Func:
+			for i := 0; i < typ.NumIn(); i++ {
+				check(where, typ.In(i))
+			}
+			for i := 0; i < typ.NumOut(); i++ {
+				check(where, typ.Out(i))
+			}
+		case reflect.Struct:
+			for i := 0; i < typ.NumField(); i++ {
+				if f := typ.Field(i); f.IsExported() {
+					check(where, f.Type)
+				}
+			}
+		}
+	}
+	c := reflect.TypeOf(&client.Client{})
+	for i := 0; i < c.NumMethod(); i++ {
+		m := c.Method(i)
+		check("Client."+m.Name, m.Type)
+	}
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"crypto"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Signer signs the encoded request token of every request.
+// Signers also holding an SSH user certificate can implement Certificate() *ssh.Certificate
+// to have the certificate embedded into the token.
+type Signer interface {
+	SignToken(encoded string) ([]byte, error)
+}
+
+// NewKeySigner returns a Signer using a private key loaded in memory.
+// RSA keys produce PKCS#1 v1.5 signatures.
+func NewKeySigner(key crypto.Signer) Signer {
+	return auth.NewKeySigner(key, false)
+}
+
+// NewFileSigner returns a Signer using the PEM encoded private key at path
+// in PKCS#1, PKCS#8, SEC 1 or OpenSSH format.
+func NewFileSigner(path string) (Signer, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key at %s: %w", path, err)
+	}
+	key, err := auth.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key: %w", err)
+	}
+	return NewKeySigner(key), nil
+}
+
+// NewAgentSigner returns a Signer delegating signatures to the agent identity matching the fingerprint.
+// When fingerprint is empty the agent must hold exactly one identity.
+func NewAgentSigner(a agent.ExtendedAgent, fingerprint string) (Signer, error) {
+	s, err := auth.NewAgentSigner(a, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DialAgentSigner connects to the ssh-agent listening on SSH_AUTH_SOCK
+// and returns a Signer for the identity matching the fingerprint.
+// The returned io.Closer releases the agent connection.
+func DialAgentSigner(fingerprint string) (Signer, io.Closer, error) {
+	a, conn, err := auth.DialAgent()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := NewAgentSigner(a, fingerprint)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return s, conn, nil
+}
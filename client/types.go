@@ -0,0 +1,245 @@
+package client
+
+import (
+	"time"
+
+	"github.com/vtno/zypher/internal/server/audit"
+	"github.com/vtno/zypher/internal/server/handlers"
+	"github.com/vtno/zypher/internal/server/webhook"
+)
+
+// KeyRotation reports the age of the current version of a key and its rotation policy.
+type KeyRotation struct {
+	RotatedAt *time.Time
+	// Age is how long ago the current version was created e.g. 2160h0m0s.
+	Age            string
+	RotationPeriod string
+	// Overdue is true if the key is older than its rotation period.
+	Overdue bool
+}
+
+// KeyExpiry reports when a key expires. GetKey returns ErrGone once it expired.
+type KeyExpiry struct {
+	ExpiresAt *time.Time
+	Expired   bool
+}
+
+// KeyVersion is a version of a key returned by GetKeyVersion.
+type KeyVersion struct {
+	Key     string
+	Version int
+	KeyRotation
+	KeyExpiry
+}
+
+// KeyListEntry is a key listed by ListKeys.
+type KeyListEntry struct {
+	Path string
+	// Name and Env are the namespace and last segment of the path.
+	Name    string
+	Env     string
+	Version int
+	KeyRotation
+	KeyExpiry
+}
+
+// Policy is attached to a namespace or key path.
+type Policy struct {
+	// Readers and Writers are the identities allowed to read and write the keys, every identity when empty.
+	Readers []string
+	Writers []string
+	// RotationPeriod rotates the keys without a rotation period of their own e.g. 90d.
+	RotationPeriod string
+	NonExportable  bool
+}
+
+// AuditFilter selects the audit events returned by Audit, every event matches the zero value.
+type AuditFilter struct {
+	Identity string
+	Action   string
+	Path     string
+	Name     string
+	Env      string
+	Since    time.Time
+	Until    time.Time
+	// Limit only keeps the most recent events.
+	Limit int
+}
+
+// AuditEvent is a request recorded in the audit log of the key server.
+type AuditEvent struct {
+	Seq       int64
+	Time      time.Time
+	RequestID string
+	// Identity is the name of the authenticated identity, empty if the request was not authenticated.
+	Identity string
+	Action   string
+	// Path is the path of the key or namespace of the request e.g. /payments/stripe/prd.
+	Path   string
+	Name   string
+	Env    string
+	Result string
+	Status int
+	// Reason explains why a request was denied.
+	Reason   string
+	SourceIP string
+	PrevHash string
+	Hash     string
+}
+
+// AuditHead identifies the last event of an audit log.
+type AuditHead struct {
+	Seq  int64
+	Hash string
+}
+
+// AuditLog holds the audit events returned by Audit.
+type AuditLog struct {
+	Events []AuditEvent
+	// Head is the last event of the log. It can be recorded to later detect truncation with zypher audit verify.
+	Head AuditHead
+}
+
+// Change is a mutation recorded in the change log of the key server.
+type Change struct {
+	Seq    uint64
+	Op     string
+	Bucket string
+	Key    string
+	// Value is the record of the value along with its metadata.
+	Value []byte
+}
+
+// Changes holds changes of the key server store and the sequence number of the last change.
+type Changes struct {
+	Head    uint64
+	Changes []Change
+}
+
+// ReplicationStatus describes the replication role of a key server.
+type ReplicationStatus struct {
+	// Role is either primary or follower.
+	Role string
+	// Primary is the URL of the primary replicated by a follower.
+	Primary string
+	// Head is the sequence number of the last change of the store.
+	Head uint64
+}
+
+// WebhookEvent is an event delivered to the webhooks of the key server.
+type WebhookEvent struct {
+	ID   string
+	Type string
+	Time time.Time
+	// Path and Version identify the changed key.
+	Path    string
+	Version int
+	// Identity made the change, it is empty for automatic rotations.
+	Identity string
+	// SourceIP and Failures describe a burst of failed authentications.
+	SourceIP string
+	Failures int
+}
+
+// WebhookDelivery is the delivery of an event to a webhook URL.
+type WebhookDelivery struct {
+	ID       string
+	URL      string
+	Event    WebhookEvent
+	Status   string
+	Attempts int
+	// StatusCode and Error describe the last attempt.
+	StatusCode int
+	Error      string
+	Updated    time.Time
+}
+
+// WebhookDeliveries holds the recent webhook deliveries of the key server.
+type WebhookDeliveries struct {
+	// Deliveries are the most recent deliveries since the server started, the oldest first.
+	Deliveries []WebhookDelivery
+	// DeadLetters are the deliveries that failed every attempt, the oldest first.
+	DeadLetters []WebhookDelivery
+}
+
+func keyRotationOf(kr handlers.KeyRotation) KeyRotation {
+	return KeyRotation{
+		RotatedAt:      kr.RotatedAt,
+		Age:            kr.Age,
+		RotationPeriod: kr.RotationPeriod,
+		Overdue:        kr.Overdue,
+	}
+}
+
+func keyExpiryOf(ke handlers.KeyExpiry) KeyExpiry {
+	return KeyExpiry{
+		ExpiresAt: ke.ExpiresAt,
+		Expired:   ke.Expired,
+	}
+}
+
+func policyOf(pr *handlers.PolicyResponse) *Policy {
+	return &Policy{
+		Readers:        pr.Readers,
+		Writers:        pr.Writers,
+		RotationPeriod: pr.RotationPeriod,
+		NonExportable:  pr.NonExportable,
+	}
+}
+
+func auditEventOf(e audit.Event) AuditEvent {
+	return AuditEvent{
+		Seq:       e.Seq,
+		Time:      e.Time,
+		RequestID: e.RequestID,
+		Identity:  e.Identity,
+		Action:    e.Action,
+		Path:      e.Path,
+		Name:      e.Name,
+		Env:       e.Env,
+		Result:    e.Result,
+		Status:    e.Status,
+		Reason:    e.Reason,
+		SourceIP:  e.SourceIP,
+		PrevHash:  e.PrevHash,
+		Hash:      e.Hash,
+	}
+}
+
+func changesOf(rr *handlers.ReplicationResponse) *Changes {
+	changes := &Changes{Head: rr.Head, Changes: make([]Change, 0, len(rr.Changes))}
+	for _, c := range rr.Changes {
+		changes.Changes = append(changes.Changes, Change{Seq: c.Seq, Op: c.Op, Bucket: c.Bucket, Key: c.Key, Value: c.Value})
+	}
+	return changes
+}
+
+func replicationStatusOf(rs *handlers.ReplicationStatus) *ReplicationStatus {
+	return &ReplicationStatus{Role: rs.Role, Primary: rs.Primary, Head: rs.Head}
+}
+
+func webhookDeliveriesOf(ds []webhook.Delivery) []WebhookDelivery {
+	deliveries := make([]WebhookDelivery, 0, len(ds))
+	for _, d := range ds {
+		deliveries = append(deliveries, WebhookDelivery{
+			ID:  d.ID,
+			URL: d.URL,
+			Event: WebhookEvent{
+				ID:       d.Event.ID,
+				Type:     d.Event.Type,
+				Time:     d.Event.Time,
+				Path:     d.Event.Path,
+				Version:  d.Event.Version,
+				Identity: d.Event.Identity,
+				SourceIP: d.Event.SourceIP,
+				Failures: d.Event.Failures,
+			},
+			Status:     d.Status,
+			Attempts:   d.Attempts,
+			StatusCode: d.StatusCode,
+			Error:      d.Error,
+			Updated:    d.Updated,
+		})
+	}
+	return deliveries
+}
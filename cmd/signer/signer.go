@@ -1,38 +1,59 @@
 package main
 
 import (
-	"crypto"
-	"crypto/rand"
-	"crypto/sha256"
-	"crypto/x509"
-	"encoding/base64"
-	"encoding/pem"
+	"flag"
 	"fmt"
 	"os"
 	"path"
+	"strings"
+
+	"github.com/vtno/zypher/internal/server/auth"
 )
 
 func main() {
-	file, err := os.ReadFile("zypher")
-	if (err != nil) {
-		fmt.Printf("error reading private key: %v", err)
-		os.Exit(1)
-	}
-	block, _ := pem.Decode(file)
-	privKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
-	if (err != nil) {
-		fmt.Printf("error parsing private key: %v", err)
+	fs := flag.NewFlagSet("signer", flag.ExitOnError)
+	keyPath := fs.String("key", "zypher", "a path of the private key")
+	pss := fs.Bool("pss", false, "sign with RSA-PSS instead of PKCS#1 v1.5 when the key is an RSA key")
+	useAgent := fs.Bool("agent", false, "sign with an identity held by the ssh-agent at SSH_AUTH_SOCK instead of a key file")
+	fingerprint := fs.String("fingerprint", "", "fingerprint of the ssh-agent identity to sign with")
+	fs.Parse(os.Args[1:])
+
+	if fs.NArg() != 2 {
+		fmt.Printf("usage: %s [-key <path>] [-pss] [-agent] [-fingerprint <fingerprint>] <method> <path[?query]>", path.Base(os.Args[0]))
 		os.Exit(1)
 	}
-	if len(os.Args) != 2 {
-		fmt.Printf("usage: %s <token>", path.Base(os.Args[0]))
-		os.Exit(1)
+
+	var signer auth.TokenSigner
+	if *useAgent {
+		a, conn, err := auth.DialAgent()
+		if err != nil {
+			fmt.Printf("error connecting to ssh-agent: %v", err)
+			os.Exit(1)
+		}
+		defer conn.Close()
+		signer, err = auth.NewAgentSigner(a, *fingerprint)
+		if err != nil {
+			fmt.Printf("error selecting agent identity: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		file, err := os.ReadFile(*keyPath)
+		if (err != nil) {
+			fmt.Printf("error reading private key: %v", err)
+			os.Exit(1)
+		}
+		privKey, err := auth.ParsePrivateKey(file)
+		if (err != nil) {
+			fmt.Printf("error parsing private key: %v", err)
+			os.Exit(1)
+		}
+		signer = auth.NewKeySigner(privKey, *pss)
 	}
-	hashed := sha256.Sum256([]byte(os.Args[1]))
-	sig, err := privKey.Sign(rand.Reader, hashed[:], crypto.SHA256)
+
+	header, err := auth.SignedAuthHeader(signer, strings.ToUpper(fs.Arg(0)), fs.Arg(1))
 	if err != nil {
 		fmt.Printf("error signing token: %v", err)
 		os.Exit(1)
 	}
-	fmt.Printf("%s", base64.StdEncoding.EncodeToString(sig))
+	fmt.Printf("%s", header)
 }
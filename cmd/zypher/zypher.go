@@ -7,8 +7,12 @@ import (
 
 	"github.com/mitchellh/cli"
 	"github.com/vtno/zypher"
+	"github.com/vtno/zypher/internal/cache"
 	"github.com/vtno/zypher/internal/crypto"
+	"github.com/vtno/zypher/internal/key"
 	"github.com/vtno/zypher/internal/keygen"
+	"github.com/vtno/zypher/internal/login"
+	"github.com/vtno/zypher/internal/server/audit"
 )
 
 const version = "0.2.0"
@@ -27,9 +31,45 @@ func main() {
 		"keygen": func() (cli.Command, error) {
 			return keygen.NewKeyGenCmd(), nil
 		},
+		"key get": func() (cli.Command, error) {
+			return key.NewGetCmd(), nil
+		},
+		"key put": func() (cli.Command, error) {
+			return key.NewPutCmd(), nil
+		},
+		"key list": func() (cli.Command, error) {
+			return key.NewListCmd(), nil
+		},
+		"key delete": func() (cli.Command, error) {
+			return key.NewDeleteCmd(), nil
+		},
+		"key status": func() (cli.Command, error) {
+			return key.NewStatusCmd(), nil
+		},
+		"cache status": func() (cli.Command, error) {
+			return cache.NewStatusCmd(), nil
+		},
+		"cache clear": func() (cli.Command, error) {
+			return cache.NewClearCmd(), nil
+		},
+		"audit verify": func() (cli.Command, error) {
+			return audit.NewVerifyCmd(), nil
+		},
+		"login": func() (cli.Command, error) {
+			return login.NewLoginCmd(), nil
+		},
 		"server": func() (cli.Command, error) {
 			return server.NewServerCmd(), nil
 		},
+		"server restore": func() (cli.Command, error) {
+			return server.NewRestoreCmd(), nil
+		},
+		"server promote": func() (cli.Command, error) {
+			return server.NewPromoteCmd(), nil
+		},
+		"server migrate": func() (cli.Command, error) {
+			return server.NewMigrateCmd(), nil
+		},
 	}
 	_, err := c.Run()
 	if err != nil {
@@ -4,3 +4,5 @@ package zypher
 //go:generate mockgen -source=internal/server/server.go -destination=internal/server/mock.go -package=server
 //go:generate mockgen -source=internal/server/store/store.go -destination=internal/server/store/mock.go -package=store
 //go:generate mockgen -source=internal/server/auth/authentication.go -destination=internal/server/auth/mock.go -package=auth
+//go:generate mockgen -source=internal/key/base.go -destination=internal/key/mock.go -package=key
+//go:generate mockgen -source=internal/server/replication/follower.go -destination=internal/server/replication/mock.go -package=replication
@@ -0,0 +1,153 @@
+// Package cache manages the encrypted local cache of the keys fetched from the key server.
+package cache
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/config"
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const cacheOptionsHelp = `	--cache-dir=<dir>			dir of the cache. Default: ~/.cache/zypher. env: ZYPHER_CACHE_DIR
+	--cache-max-staleness=<duration>	how long a cached key is used while the key server is unreachable.
+						Default: 24h. env: ZYPHER_CACHE_MAX_STALENESS
+`
+
+const (
+	StatusHelpMsg = `Usage: zypher cache status [options]
+	prints the keys held in the local cache with their version and age,
+	flagging the keys too old to be used as STALE and the expired keys as EXPIRED
+available options:
+` + cacheOptionsHelp
+	StatusSynopsisMsg = "prints the keys held in the local cache"
+
+	ClearHelpMsg = `Usage: zypher cache clear [options] [<path>]
+	removes every key from the local cache, or the key at path e.g. /payments/stripe/prod
+available options:
+` + cacheOptionsHelp
+	ClearSynopsisMsg = "removes keys from the local cache"
+)
+
+type StatusCmd struct{}
+
+func NewStatusCmd() *StatusCmd {
+	return &StatusCmd{}
+}
+
+func (s *StatusCmd) Help() string {
+	return StatusHelpMsg
+}
+
+func (s *StatusCmd) Synopsis() string {
+	return StatusSynopsisMsg
+}
+
+func (s *StatusCmd) Run(args []string) int {
+	c, err := open(flag.NewFlagSet("cache status", flag.ContinueOnError), args)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("the cache is empty")
+		return 0
+	}
+	if err != nil {
+		fmt.Printf("error opening cache: %v\n", err)
+		return 1
+	}
+	entries, unreadable, err := c.Entries()
+	if err != nil {
+		fmt.Printf("error reading cache: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("cache %s, keys are used for up to %s while the key server is unreachable\n", c.Dir(), c.MaxStaleness())
+	if !c.MachineBound() {
+		fmt.Println("no machine ID found, the cache is only protected by the permissions of its dir")
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVERSION\tFETCHED\tAGE\tSTATUS")
+	for _, e := range entries {
+		status := "OK"
+		switch {
+		case e.Expired(now):
+			status = "EXPIRED"
+		case e.Stale(now, c.MaxStaleness()):
+			status = "STALE"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", e.Path, e.Version, e.FetchedAt.Format(time.RFC3339),
+			now.Sub(e.FetchedAt).Round(time.Second), status)
+	}
+	w.Flush()
+	if unreadable > 0 {
+		fmt.Printf("%d keys cannot be decrypted on this machine, remove them with zypher cache clear\n", unreadable)
+	}
+	return 0
+}
+
+type ClearCmd struct{}
+
+func NewClearCmd() *ClearCmd {
+	return &ClearCmd{}
+}
+
+func (c *ClearCmd) Help() string {
+	return ClearHelpMsg
+}
+
+func (c *ClearCmd) Synopsis() string {
+	return ClearSynopsisMsg
+}
+
+func (c *ClearCmd) Run(args []string) int {
+	fs := flag.NewFlagSet("cache clear", flag.ContinueOnError)
+	cache, err := open(fs, args)
+	if errors.Is(err, os.ErrNotExist) {
+		fmt.Println("the cache is empty")
+		return 0
+	}
+	if err != nil {
+		fmt.Printf("error opening cache: %v\n", err)
+		return 1
+	}
+	if fs.NArg() > 1 {
+		fmt.Print(ClearHelpMsg)
+		return 1
+	}
+
+	if fs.NArg() == 1 {
+		path := "/" + strings.Trim(fs.Arg(0), "/")
+		if err := cache.Delete(path); err != nil {
+			fmt.Printf("error clearing cache: %v\n", err)
+			return 1
+		}
+		fmt.Printf("removed %s from the cache\n", path)
+		return 0
+	}
+	n, err := cache.Clear()
+	if err != nil {
+		fmt.Printf("error clearing cache: %v\n", err)
+		return 1
+	}
+	fmt.Printf("removed %d keys from the cache\n", n)
+	return 0
+}
+
+// open parses the args with the cache flags registered on fs and opens the cache they locate.
+// It returns os.ErrNotExist if the cache has never been written.
+func open(fs *flag.FlagSet, args []string) (*client.Cache, error) {
+	cfg := &config.KeyServerConfig{}
+	keyserver.RegisterCacheFlags(fs, cfg)
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("error parsing flags: %w", err)
+	}
+	if _, err := os.Stat(cfg.CacheDir); err != nil {
+		return nil, err
+	}
+	return client.OpenCache(cfg.CacheDir, cfg.CacheMaxStaleness)
+}
@@ -0,0 +1,92 @@
+package cache_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/cache"
+)
+
+func TestCache_Run(t *testing.T) {
+	dir := t.TempDir()
+	c, err := client.OpenCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("error opening cache: %v", err)
+	}
+	for _, path := range []string{"/twitter/prd", "/stripe/prd"} {
+		if err := c.Put(client.CacheEntry{Path: path, Key: "supersecretkey", Version: 1, FetchedAt: time.Now()}); err != nil {
+			t.Fatalf("error caching %s: %v", path, err)
+		}
+	}
+
+	type test struct {
+		name            string
+		run             func(args []string) int
+		args            []string
+		expectedErrCode int
+		expectedPaths   []string
+	}
+
+	tests := []test{
+		{
+			name:            "status lists the cached keys",
+			run:             cache.NewStatusCmd().Run,
+			args:            []string{"--cache-dir", dir},
+			expectedErrCode: 0,
+			expectedPaths:   []string{"/stripe/prd", "/twitter/prd"},
+		},
+		{
+			name:            "status of a cache never written",
+			run:             cache.NewStatusCmd().Run,
+			args:            []string{"--cache-dir", filepath.Join(dir, "missing")},
+			expectedErrCode: 0,
+			expectedPaths:   []string{"/stripe/prd", "/twitter/prd"},
+		},
+		{
+			name:            "clear removes the key at path",
+			run:             cache.NewClearCmd().Run,
+			args:            []string{"--cache-dir", dir, "twitter/prd"},
+			expectedErrCode: 0,
+			expectedPaths:   []string{"/stripe/prd"},
+		},
+		{
+			name:            "clear refuses several paths",
+			run:             cache.NewClearCmd().Run,
+			args:            []string{"--cache-dir", dir, "/stripe/prd", "/twitter/prd"},
+			expectedErrCode: 1,
+			expectedPaths:   []string{"/stripe/prd"},
+		},
+		{
+			name:            "clear removes every key",
+			run:             cache.NewClearCmd().Run,
+			args:            []string{"--cache-dir", dir},
+			expectedErrCode: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := tt.run(tt.args); code != tt.expectedErrCode {
+				t.Errorf("expected error code %d, got %d", tt.expectedErrCode, code)
+			}
+			entries, _, err := c.Entries()
+			if err != nil {
+				t.Fatalf("error listing cache: %v", err)
+			}
+			var paths []string
+			for _, e := range entries {
+				paths = append(paths, e.Path)
+			}
+			if len(paths) != len(tt.expectedPaths) {
+				t.Fatalf("expected %v to be cached, got %v", tt.expectedPaths, paths)
+			}
+			for i := range paths {
+				if paths[i] != tt.expectedPaths[i] {
+					t.Errorf("expected %v to be cached, got %v", tt.expectedPaths, paths)
+				}
+			}
+		})
+	}
+}
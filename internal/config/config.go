@@ -1,14 +1,117 @@
 package config
 
+import "time"
+
 type Config struct {
 	Key       string
 	KeyFile   string
 	OutFile   string
 	Input     string
 	InputFile string
+
+	// KeyName and KeyEnv select the key fetched from the key server
+	KeyName   string
+	KeyEnv    string
+	KeyServer KeyServerConfig
+	// Envelope encrypts with a data key generated by the key server instead of the key itself
+	Envelope bool
+}
+
+// KeyServerConfig holds how to reach and authenticate against a key server
+type KeyServerConfig struct {
+	URL string
+	// SigningKeyPath is a path of the private key signing requests when UseAgent is false
+	SigningKeyPath string
+	UseAgent       bool
+	// Fingerprint selects the ssh-agent identity signing requests
+	Fingerprint string
+	// CACertPath is a path of PEM encoded CA certificates trusted to serve the key server
+	CACertPath string
+	// Tracing is the exporter of the traces of key server requests, tracing is disabled when empty
+	Tracing string
+	// Cache keeps fetched keys encrypted in CacheDir and serves them for up to CacheMaxStaleness
+	// while the key server is unreachable
+	Cache             bool
+	CacheDir          string
+	CacheMaxStaleness time.Duration
 }
 
 type ServerConfig struct {
 	Port           int
 	RootPubKeyPath string
+	ClockSkew      time.Duration
+
+	// Store selects the store of the keys e.g. bbolt://zypher.db, file://keys or sqlite://zypher.sqlite
+	Store string
+	// StoreKeyPath is a path of the key encrypting the files of a file store
+	StoreKeyPath string
+
+	TrustedUserCAPath string
+	PrincipalRoles    string
+	RevokedKeysPath   string
+
+	TLSCertPath   string
+	TLSKeyPath    string
+	ClientCAPath  string
+	TLSSelfSigned bool
+
+	// RotationInterval is how often keys are checked against their rotation policy
+	RotationInterval time.Duration
+	// ExpirySweepInterval is how often keys expired for longer than ExpiryGrace are purged
+	ExpirySweepInterval time.Duration
+	ExpiryGrace         time.Duration
+
+	// AuditLogPath is a path of the hash-chained audit log, auditing is disabled when empty
+	AuditLogPath string
+
+	// Metrics serves Prometheus metrics on GET /metrics, without authentication
+	Metrics bool
+	// MetricsAddr is a separate listen address of the metrics, they are served with the API when empty
+	MetricsAddr string
+
+	// Tracing is the exporter of the request traces, tracing is disabled when empty
+	Tracing string
+
+	// IPRate is the number of requests per second allowed from a source IP, rate limiting is disabled when zero
+	IPRate  float64
+	IPBurst int
+	// IdentityRate is the number of requests per second allowed from an identity, rate limiting is disabled when zero
+	IdentityRate  float64
+	IdentityBurst int
+	// LockoutThreshold is the number of consecutive signature failures locking a source IP out, lockout is disabled when zero
+	LockoutThreshold int
+	LockoutBase      time.Duration
+	LockoutMax       time.Duration
+
+	// BackupKeyPath is a path of the key encrypting backups, GET /sys/backup is served when it exists
+	BackupKeyPath string
+	BackupDir     string
+	// BackupInterval is how often a backup is written to BackupDir, scheduled backups are disabled when zero
+	BackupInterval  time.Duration
+	BackupRetention int
+
+	// ChangeLog records every mutation in a change log served to followers, followers always record one
+	ChangeLog bool
+	// ChangeLogCompactionInterval is how often the change log is truncated, it is never truncated when zero
+	ChangeLogCompactionInterval time.Duration
+	// ChangeLogRetention is how long the changes not replicated by a follower are kept since it was last seen
+	ChangeLogRetention time.Duration
+	// Follow is the URL of the primary replicated by the server, the server is a primary when empty
+	Follow string
+	// ReplicationKeyPath is a path of the private key signing the requests of a follower to its primary
+	ReplicationKeyPath string
+	// ReplicationCAPath is a path of PEM encoded CA certificates trusted to serve the primary
+	ReplicationCAPath string
+	// ForwardWrites forwards writes received by a follower to its primary instead of rejecting them
+	ForwardWrites bool
+
+	// Webhooks are comma separated URLs notified of key changes and failed authentication bursts, disabled when empty
+	Webhooks          string
+	WebhookSecretPath string
+	// WebhookEvents are comma separated event types delivered to the webhooks, every type when empty
+	WebhookEvents string
+	// WebhookPrefix only delivers the events of keys under the path prefix
+	WebhookPrefix     string
+	AuthFailureBurst  int
+	AuthFailureWindow time.Duration
 }
@@ -1,11 +1,16 @@
 package crypto
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"unicode"
 
+	"github.com/vtno/zypher/client"
 	"github.com/vtno/zypher/internal/config"
+	"github.com/vtno/zypher/internal/keyserver"
 )
 
 type Cipher interface {
@@ -22,12 +27,20 @@ type FileReaderWriter interface {
 	WriteFile(string, []byte, os.FileMode) error
 }
 
+// KeyFetcher fetches keys and data keys by name and env from a key server
+type KeyFetcher interface {
+	GetKey(ctx context.Context, name, env string) (string, error)
+	GenerateDataKey(ctx context.Context, name, env string) (*client.DataKey, error)
+	UnwrapDataKey(ctx context.Context, name, env string, wrapped []byte) (string, error)
+}
+
 type BaseCmd struct {
 	frw FileReaderWriter
 	fs  *flag.FlagSet
 	cfg *config.Config
 	cf  CipherFactory
 	ci  Cipher
+	kf  KeyFetcher
 }
 
 func WithFileReaderWriter(frw FileReaderWriter) func(*BaseCmd) {
@@ -36,9 +49,48 @@ func WithFileReaderWriter(frw FileReaderWriter) func(*BaseCmd) {
 	}
 }
 
+// WithKeyFetcher sets the KeyFetcher used instead of a key server client built from the flags
+func WithKeyFetcher(kf KeyFetcher) func(*BaseCmd) {
+	return func(c *BaseCmd) {
+		c.kf = kf
+	}
+}
+
+// keyFetcher returns the KeyFetcher set by WithKeyFetcher or a key server client built from the flags.
+// The returned func releases the client.
+func (b *BaseCmd) keyFetcher() (KeyFetcher, func(), error) {
+	if b.kf != nil {
+		return b.kf, func() {}, nil
+	}
+	if b.cfg.KeyServer.URL == "" {
+		return nil, nil, fmt.Errorf("--key-server is required")
+	}
+	c, done, err := keyserver.NewClient(&b.cfg.KeyServer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating key server client: %w", err)
+	}
+	return c, done, nil
+}
+
+// fetchKey fetches the configured key from the key server.
+// The key is only held in memory unless --cache keeps it encrypted on disk.
+func (b *BaseCmd) fetchKey() (string, error) {
+	if b.cfg.KeyName == "" || b.cfg.KeyEnv == "" {
+		return "", fmt.Errorf("--key-name and --key-env are required with --key-server")
+	}
+	kf, done, err := b.keyFetcher()
+	if err != nil {
+		return "", err
+	}
+	defer done()
+	key, err := kf.GetKey(context.Background(), b.cfg.KeyName, b.cfg.KeyEnv)
+	if err != nil {
+		return "", fmt.Errorf("error fetching key %s#%s: %w", b.cfg.KeyName, b.cfg.KeyEnv, err)
+	}
+	return key, nil
+}
+
 // init parse the flags and set the config struct
-// it also read the key from the zypher.key file or env variable
-// key file location is overridden if the config is parsed from flags
 func (b *BaseCmd) init(args []string) error {
 	err := b.fs.Parse(args)
 	if err != nil {
@@ -48,11 +100,24 @@ func (b *BaseCmd) init(args []string) error {
 	if len(b.fs.Args()) > 0 {
 		b.cfg.Input = b.fs.Args()[0]
 	}
+	return nil
+}
+
+// initCipher read the key from the key server, the zypher.key file or env variable
+// key file location is overridden if the config is parsed from flags
+func (b *BaseCmd) initCipher() error {
+	if b.cfg.Key == "" && b.cfg.KeyServer.URL != "" {
+		key, err := b.fetchKey()
+		if err != nil {
+			return err
+		}
+		b.cfg.Key = key
+	}
 
 	if b.cfg.Key == "" {
 		key, _ := b.frw.ReadFile(b.cfg.KeyFile)
 		if key != nil {
-			b.cfg.Key = string(key)
+			b.cfg.Key = strings.TrimRightFunc(string(key), unicode.IsSpace)
 		}
 	}
 
@@ -7,6 +7,7 @@ import (
 
 	"github.com/vtno/zypher/internal/config"
 	"github.com/vtno/zypher/internal/file"
+	"github.com/vtno/zypher/internal/keyserver"
 )
 
 type DecryptCmd struct {
@@ -20,6 +21,8 @@ available options:
 	-f, --file=<path-to-file>		input file to be encrypted
 	-o, --out=<path-to-file>		output file to be created
 	-kf, --key-file=<path-to-file>  	A path to key file. Default: zypher.key
+` + keyserver.FlagsHelp + `
+	the data key of input encrypted with --envelope is unwrapped by the key server
 `
 	DecryptSynopsis = "decrypts input value or file with the provided key and prints the decrypted value to stdout or a file"
 )
@@ -35,6 +38,7 @@ func NewDecryptCmd(cf CipherFactory, opts ...func(*BaseCmd)) *DecryptCmd {
 	fs.StringVar(&cfg.OutFile, "o", "", "output file to be created (shorthand)")
 	fs.StringVar(&cfg.InputFile, "file", "", "input file to be encrypted")
 	fs.StringVar(&cfg.InputFile, "f", "", "input file to be encrypted (shorthand)")
+	keyserver.RegisterFlags(fs, cfg)
 	d := &DecryptCmd{
 		base: BaseCmd{
 			cfg: cfg,
@@ -80,11 +84,27 @@ func (d *DecryptCmd) Run(args []string) int {
 		input = string(fileInput)
 	}
 
-	decodedInput, err := base64.StdEncoding.DecodeString(input)
+	header, decodedInput, isEnvelope, err := parseEnvelope(input)
 	if err != nil {
 		fmt.Printf("error decoding input: %v\n", err)
 		return 1
 	}
+	if isEnvelope {
+		if err := d.base.initUnwrappedDataKeyCipher(header); err != nil {
+			fmt.Printf("error initializing decrypt cmd %v", err)
+			return 1
+		}
+	} else {
+		if err := d.base.initCipher(); err != nil {
+			fmt.Printf("error initializing decrypt cmd %v", err)
+			return 1
+		}
+		decodedInput, err = base64.StdEncoding.DecodeString(input)
+		if err != nil {
+			fmt.Printf("error decoding input: %v\n", err)
+			return 1
+		}
+	}
 	decrypted, err := d.base.ci.Decrypt(decodedInput)
 	if err != nil {
 		fmt.Printf("error decrypting: %v\n", err)
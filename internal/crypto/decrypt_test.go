@@ -100,6 +100,21 @@ func TestDecrypt_Run(t *testing.T) {
 				return mockCipherFactory, mockFileReaderWriter
 			},
 		},
+		{
+			name:            "run successfully with key from a zypher.key file ending with a newline",
+			args:            []string{"-f", "input.enc"},
+			expectedErrCode: 0,
+			initMocks: func() (crypto.CipherFactory, crypto.FileReaderWriter) {
+				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+				mockCipher := crypto.NewMockCipher(ctrl)
+				mockCipher.EXPECT().Decrypt([]byte("encryptedcontent")).Times(1)
+				mockCipherFactory.EXPECT().NewCipher("key").Return(mockCipher).Times(1)
+				mockFileReaderWriter := crypto.NewMockFileReaderWriter(ctrl)
+				mockFileReaderWriter.EXPECT().ReadFile("zypher.key").Return([]byte("key\n"), nil).Times(1)
+				mockFileReaderWriter.EXPECT().ReadFile("input.enc").Return([]byte(base64Content), nil).Times(1)
+				return mockCipherFactory, mockFileReaderWriter
+			},
+		},
 		{
 			name:            "run successfully with key from overridden another.key file",
 			args:            []string{"-f", "input.enc", "-kf", "another.key"},
@@ -137,7 +152,8 @@ func TestDecrypt_Run(t *testing.T) {
 				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
 				mockCipher := crypto.NewMockCipher(ctrl)
 				mockCipher.EXPECT().Decrypt(gomock.Any()).Times(0)
-				mockCipherFactory.EXPECT().NewCipher(gomock.Any()).Return(mockCipher).Times(1)
+				// the input is read first to find out whether it is an envelope
+				mockCipherFactory.EXPECT().NewCipher(gomock.Any()).Times(0)
 				mockFileReaderWriter := crypto.NewMockFileReaderWriter(ctrl)
 				mockFileReaderWriter.EXPECT().ReadFile("not-exist.txt").Return(nil, errors.New("file not exist")).Times(1)
 				return mockCipherFactory, mockFileReaderWriter
@@ -7,6 +7,7 @@ import (
 
 	"github.com/vtno/zypher/internal/config"
 	"github.com/vtno/zypher/internal/file"
+	"github.com/vtno/zypher/internal/keyserver"
 )
 
 const (
@@ -16,6 +17,8 @@ available options:
 	-f, --file=<path-to-file>		input file to be encrypted
 	-o, --out=<path-to-file>		output file to be created
 	-kf, --key-file=<path-to-file>  	A path to key file. Default: zypher.key
+` + keyserver.FlagsHelp + `	--envelope				encrypts locally with a data key generated by the key server
+						and stores the data key wrapped by the key server key in the output
 `
 	SynopsisMsg = "encrypts input value or file with the provided key and prints the encrypted value to stdout or create a file"
 )
@@ -35,6 +38,8 @@ func NewEncryptCmd(cf CipherFactory, opts ...func(*BaseCmd)) *EncryptCmd {
 	fs.StringVar(&cfg.OutFile, "o", "", "output file to be created (shorthand)")
 	fs.StringVar(&cfg.InputFile, "file", "", "input file to be encrypted")
 	fs.StringVar(&cfg.InputFile, "f", "", "input file to be encrypted (shorthand)")
+	keyserver.RegisterFlags(fs, cfg)
+	fs.BoolVar(&cfg.Envelope, "envelope", false, "encrypts with a data key generated by the key server")
 
 	e := &EncryptCmd{
 		base: BaseCmd{
@@ -66,6 +71,19 @@ func (e *EncryptCmd) Run(args []string) int {
 		return 1
 	}
 
+	var header *envelopeHeader
+	if e.base.cfg.Envelope {
+		h, err := e.base.initDataKeyCipher()
+		if err != nil {
+			fmt.Printf("error initializing encrypt cmd: %v", err)
+			return 1
+		}
+		header = h
+	} else if err := e.base.initCipher(); err != nil {
+		fmt.Printf("error initializing encrypt cmd: %v", err)
+		return 1
+	}
+
 	var (
 		input []byte
 		err   error
@@ -90,6 +108,13 @@ func (e *EncryptCmd) Run(args []string) int {
 	}
 
 	base64Encrypted := base64.StdEncoding.EncodeToString(encrypted)
+	if header != nil {
+		base64Encrypted, err = encodeEnvelope(header, encrypted)
+		if err != nil {
+			fmt.Printf("error encoding envelope: %v\n", err)
+			return 1
+		}
+	}
 	if e.base.cfg.OutFile != "" {
 		if err := e.base.frw.WriteFile(e.base.cfg.OutFile, []byte(base64Encrypted), 0600); err != nil {
 			fmt.Printf("error writing to output file: %v\n", err)
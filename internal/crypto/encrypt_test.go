@@ -166,3 +166,104 @@ func TestEncrypt_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestEncrypt_Run_KeyServer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	type test struct {
+		name            string
+		args            []string
+		envs            map[string]string
+		expectedErrCode int
+		initMocks       func() (crypto.CipherFactory, crypto.KeyFetcher)
+	}
+
+	tests := []test{
+		{
+			name:            "run successfully with key fetched from the key server",
+			args:            []string{"--key-server", "https://zypher.local", "--key-name", "twitter", "--key-env", "prd", "sometext"},
+			expectedErrCode: 0,
+			initMocks: func() (crypto.CipherFactory, crypto.KeyFetcher) {
+				mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+				mockKeyFetcher.EXPECT().GetKey(gomock.Any(), "twitter", "prd").Return("serverkey", nil).Times(1)
+				mockCipher := crypto.NewMockCipher(ctrl)
+				mockCipher.EXPECT().Encrypt([]byte("sometext")).Times(1)
+				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+				mockCipherFactory.EXPECT().NewCipher("serverkey").Return(mockCipher).Times(1)
+				return mockCipherFactory, mockKeyFetcher
+			},
+		},
+		{
+			name:            "run successfully with the key server configured by env",
+			args:            []string{"sometext"},
+			envs:            map[string]string{"ZYPHER_KEY_SERVER": "https://zypher.local", "ZYPHER_KEY_NAME": "twitter", "ZYPHER_KEY_ENV": "prd"},
+			expectedErrCode: 0,
+			initMocks: func() (crypto.CipherFactory, crypto.KeyFetcher) {
+				mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+				mockKeyFetcher.EXPECT().GetKey(gomock.Any(), "twitter", "prd").Return("serverkey", nil).Times(1)
+				mockCipher := crypto.NewMockCipher(ctrl)
+				mockCipher.EXPECT().Encrypt([]byte("sometext")).Times(1)
+				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+				mockCipherFactory.EXPECT().NewCipher("serverkey").Return(mockCipher).Times(1)
+				return mockCipherFactory, mockKeyFetcher
+			},
+		},
+		{
+			name:            "prefers the key provided by flag over the key server",
+			args:            []string{"-k", "key", "--key-server", "https://zypher.local", "--key-name", "twitter", "--key-env", "prd", "sometext"},
+			expectedErrCode: 0,
+			initMocks: func() (crypto.CipherFactory, crypto.KeyFetcher) {
+				mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+				mockKeyFetcher.EXPECT().GetKey(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mockCipher := crypto.NewMockCipher(ctrl)
+				mockCipher.EXPECT().Encrypt([]byte("sometext")).Times(1)
+				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+				mockCipherFactory.EXPECT().NewCipher("key").Return(mockCipher).Times(1)
+				return mockCipherFactory, mockKeyFetcher
+			},
+		},
+		{
+			name:            "fails when the key server cannot provide the key",
+			args:            []string{"--key-server", "https://zypher.local", "--key-name", "twitter", "--key-env", "prd", "sometext"},
+			expectedErrCode: 1,
+			initMocks: func() (crypto.CipherFactory, crypto.KeyFetcher) {
+				mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+				mockKeyFetcher.EXPECT().GetKey(gomock.Any(), "twitter", "prd").Return("", errors.New("key server responded with 404 Not Found")).Times(1)
+				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+				mockCipherFactory.EXPECT().NewCipher(gomock.Any()).Times(0)
+				return mockCipherFactory, mockKeyFetcher
+			},
+		},
+		{
+			name:            "fails when the key name is missing",
+			args:            []string{"--key-server", "https://zypher.local", "--key-env", "prd", "sometext"},
+			expectedErrCode: 1,
+			initMocks: func() (crypto.CipherFactory, crypto.KeyFetcher) {
+				mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+				mockKeyFetcher.EXPECT().GetKey(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+				mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+				mockCipherFactory.EXPECT().NewCipher(gomock.Any()).Times(0)
+				return mockCipherFactory, mockKeyFetcher
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			for k, v := range tt.envs {
+				os.Setenv(k, v)
+			}
+			mockCipherFactory, mockKeyFetcher := tt.initMocks()
+			encryptCmd := crypto.NewEncryptCmd(
+				mockCipherFactory,
+				crypto.WithFileReaderWriter(crypto.NewMockFileReaderWriter(ctrl)),
+				crypto.WithKeyFetcher(mockKeyFetcher),
+			)
+			errCode := encryptCmd.Run(tt.args)
+			if errCode != tt.expectedErrCode {
+				t.Errorf("Expected code %d, got %d", tt.expectedErrCode, errCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package crypto
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// envelopePrefix starts the output of envelope encryption.
+// It contains characters outside of the base64 alphabet so it is never mistaken for a plain ciphertext.
+const envelopePrefix = "zypher-envelope:v1:"
+
+// envelopeHeader is stored in front of the ciphertext of envelope encryption
+// so decrypt can ask the key server to unwrap the data key.
+type envelopeHeader struct {
+	Name       string `json:"name"`
+	Env        string `json:"env"`
+	WrappedKey []byte `json:"wrapped_key"`
+}
+
+// encodeEnvelope returns zypher-envelope:v1:<base64 header>:<base64 ciphertext>.
+func encodeEnvelope(h *envelopeHeader, ciphertext []byte) (string, error) {
+	header, err := json.Marshal(h)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling envelope header: %w", err)
+	}
+	return envelopePrefix + base64.StdEncoding.EncodeToString(header) + ":" + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// parseEnvelope returns the header and ciphertext of an envelope.
+// ok is false if the input is not an envelope.
+func parseEnvelope(input string) (h *envelopeHeader, ciphertext []byte, ok bool, err error) {
+	input = strings.TrimSpace(input)
+	if !strings.HasPrefix(input, envelopePrefix) {
+		return nil, nil, false, nil
+	}
+	encodedHeader, encodedCiphertext, found := strings.Cut(strings.TrimPrefix(input, envelopePrefix), ":")
+	if !found {
+		return nil, nil, true, fmt.Errorf("malformed envelope")
+	}
+	header, err := base64.StdEncoding.DecodeString(encodedHeader)
+	if err != nil {
+		return nil, nil, true, fmt.Errorf("error decoding envelope header: %w", err)
+	}
+	h = &envelopeHeader{}
+	if err := json.Unmarshal(header, h); err != nil {
+		return nil, nil, true, fmt.Errorf("error unmarshaling envelope header: %w", err)
+	}
+	ciphertext, err = base64.StdEncoding.DecodeString(encodedCiphertext)
+	if err != nil {
+		return nil, nil, true, fmt.Errorf("error decoding envelope ciphertext: %w", err)
+	}
+	return h, ciphertext, true, nil
+}
+
+// initDataKeyCipher asks the key server for a new data key wrapped by the configured key
+// and sets the cipher to encrypt with the data key.
+func (b *BaseCmd) initDataKeyCipher() (*envelopeHeader, error) {
+	if b.cfg.KeyName == "" || b.cfg.KeyEnv == "" {
+		return nil, fmt.Errorf("--key-name and --key-env are required with --envelope")
+	}
+	kf, done, err := b.keyFetcher()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	dk, err := kf.GenerateDataKey(context.Background(), b.cfg.KeyName, b.cfg.KeyEnv)
+	if err != nil {
+		return nil, fmt.Errorf("error generating data key with %s#%s: %w", b.cfg.KeyName, b.cfg.KeyEnv, err)
+	}
+	b.ci = b.cf.NewCipher(dk.Key)
+	return &envelopeHeader{
+		Name:       b.cfg.KeyName,
+		Env:        b.cfg.KeyEnv,
+		WrappedKey: dk.WrappedKey,
+	}, nil
+}
+
+// initUnwrappedDataKeyCipher asks the key server to unwrap the data key of the envelope
+// and sets the cipher to decrypt with it.
+func (b *BaseCmd) initUnwrappedDataKeyCipher(h *envelopeHeader) error {
+	kf, done, err := b.keyFetcher()
+	if err != nil {
+		return err
+	}
+	defer done()
+	key, err := kf.UnwrapDataKey(context.Background(), h.Name, h.Env, h.WrappedKey)
+	if err != nil {
+		return fmt.Errorf("error unwrapping data key with %s#%s: %w", h.Name, h.Env, err)
+	}
+	b.ci = b.cf.NewCipher(key)
+	return nil
+}
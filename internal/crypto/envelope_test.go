@@ -0,0 +1,82 @@
+package crypto_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/crypto"
+	"go.uber.org/mock/gomock"
+)
+
+func TestEnvelope_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	os.Clearenv()
+
+	var envelope []byte
+	mockFileReaderWriter := crypto.NewMockFileReaderWriter(ctrl)
+	mockFileReaderWriter.EXPECT().WriteFile("input.enc", gomock.Any(), os.FileMode(0600)).DoAndReturn(func(_ string, data []byte, _ os.FileMode) error {
+		envelope = data
+		return nil
+	}).Times(1)
+	mockFileReaderWriter.EXPECT().ReadFile("input.enc").DoAndReturn(func(string) ([]byte, error) {
+		return envelope, nil
+	}).Times(1)
+
+	mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+	mockKeyFetcher.EXPECT().GetKey(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockKeyFetcher.EXPECT().GenerateDataKey(gomock.Any(), "twitter", "prd").Return(&client.DataKey{
+		Key:        "datakey",
+		WrappedKey: []byte("wrappeddatakey"),
+	}, nil).Times(1)
+	mockKeyFetcher.EXPECT().UnwrapDataKey(gomock.Any(), "twitter", "prd", []byte("wrappeddatakey")).Return("datakey", nil).Times(1)
+
+	mockCipher := crypto.NewMockCipher(ctrl)
+	mockCipher.EXPECT().Encrypt([]byte("sometext")).Return([]byte("encryptedtext"), nil).Times(1)
+	mockCipher.EXPECT().Decrypt([]byte("encryptedtext")).Return([]byte("sometext"), nil).Times(1)
+	mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+	mockCipherFactory.EXPECT().NewCipher("datakey").Return(mockCipher).Times(2)
+
+	encryptCmd := crypto.NewEncryptCmd(
+		mockCipherFactory,
+		crypto.WithFileReaderWriter(mockFileReaderWriter),
+		crypto.WithKeyFetcher(mockKeyFetcher),
+	)
+	args := []string{"--envelope", "--key-server", "https://zypher.local", "--key-name", "twitter", "--key-env", "prd", "-o", "input.enc", "sometext"}
+	if errCode := encryptCmd.Run(args); errCode != 0 {
+		t.Fatalf("Expected encrypt code 0, got %d", errCode)
+	}
+	if !strings.HasPrefix(string(envelope), "zypher-envelope:v1:") {
+		t.Errorf("Expected output to be an envelope, got %s", envelope)
+	}
+
+	// the key name and env are read from the envelope header
+	decryptCmd := crypto.NewDecryptCmd(
+		mockCipherFactory,
+		crypto.WithFileReaderWriter(mockFileReaderWriter),
+		crypto.WithKeyFetcher(mockKeyFetcher),
+	)
+	if errCode := decryptCmd.Run([]string{"--key-server", "https://zypher.local", "-f", "input.enc"}); errCode != 0 {
+		t.Errorf("Expected decrypt code 0, got %d", errCode)
+	}
+}
+
+func TestEnvelope_Run_RequiresKeyName(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	os.Clearenv()
+
+	mockKeyFetcher := crypto.NewMockKeyFetcher(ctrl)
+	mockKeyFetcher.EXPECT().GenerateDataKey(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	mockCipherFactory := crypto.NewMockCipherFactory(ctrl)
+	mockCipherFactory.EXPECT().NewCipher(gomock.Any()).Times(0)
+
+	encryptCmd := crypto.NewEncryptCmd(
+		mockCipherFactory,
+		crypto.WithFileReaderWriter(crypto.NewMockFileReaderWriter(ctrl)),
+		crypto.WithKeyFetcher(mockKeyFetcher),
+	)
+	if errCode := encryptCmd.Run([]string{"--envelope", "--key-server", "https://zypher.local", "sometext"}); errCode != 1 {
+		t.Errorf("Expected code 1, got %d", errCode)
+	}
+}
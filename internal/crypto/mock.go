@@ -5,9 +5,11 @@
 package crypto
 
 import (
+	context "context"
 	os "os"
 	reflect "reflect"
 
+	client "github.com/vtno/zypher/client"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -152,3 +154,71 @@ func (mr *MockFileReaderWriterMockRecorder) WriteFile(arg0, arg1, arg2 interface
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteFile", reflect.TypeOf((*MockFileReaderWriter)(nil).WriteFile), arg0, arg1, arg2)
 }
+
+// MockKeyFetcher is a mock of KeyFetcher interface.
+type MockKeyFetcher struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeyFetcherMockRecorder
+}
+
+// MockKeyFetcherMockRecorder is the mock recorder for MockKeyFetcher.
+type MockKeyFetcherMockRecorder struct {
+	mock *MockKeyFetcher
+}
+
+// NewMockKeyFetcher creates a new mock instance.
+func NewMockKeyFetcher(ctrl *gomock.Controller) *MockKeyFetcher {
+	mock := &MockKeyFetcher{ctrl: ctrl}
+	mock.recorder = &MockKeyFetcherMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKeyFetcher) EXPECT() *MockKeyFetcherMockRecorder {
+	return m.recorder
+}
+
+// GenerateDataKey mocks base method.
+func (m *MockKeyFetcher) GenerateDataKey(ctx context.Context, name, env string) (*client.DataKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GenerateDataKey", ctx, name, env)
+	ret0, _ := ret[0].(*client.DataKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GenerateDataKey indicates an expected call of GenerateDataKey.
+func (mr *MockKeyFetcherMockRecorder) GenerateDataKey(ctx, name, env interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GenerateDataKey", reflect.TypeOf((*MockKeyFetcher)(nil).GenerateDataKey), ctx, name, env)
+}
+
+// GetKey mocks base method.
+func (m *MockKeyFetcher) GetKey(ctx context.Context, name, env string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetKey", ctx, name, env)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetKey indicates an expected call of GetKey.
+func (mr *MockKeyFetcherMockRecorder) GetKey(ctx, name, env interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKey", reflect.TypeOf((*MockKeyFetcher)(nil).GetKey), ctx, name, env)
+}
+
+// UnwrapDataKey mocks base method.
+func (m *MockKeyFetcher) UnwrapDataKey(ctx context.Context, name, env string, wrapped []byte) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnwrapDataKey", ctx, name, env, wrapped)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UnwrapDataKey indicates an expected call of UnwrapDataKey.
+func (mr *MockKeyFetcherMockRecorder) UnwrapDataKey(ctx, name, env, wrapped interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnwrapDataKey", reflect.TypeOf((*MockKeyFetcher)(nil).UnwrapDataKey), ctx, name, env, wrapped)
+}
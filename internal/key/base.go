@@ -0,0 +1,67 @@
+package key
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/config"
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+// KeyClient wraps the key server REST API
+type KeyClient interface {
+	GetKey(ctx context.Context, name, env string) (string, error)
+	PutKey(ctx context.Context, name, env, key string, opts ...client.PutOption) error
+	ListKeys(ctx context.Context, prefix string) ([]client.KeyListEntry, error)
+	DeleteKey(ctx context.Context, name, env string) error
+}
+
+type BaseCmd struct {
+	fs  *flag.FlagSet
+	cfg *config.Config
+	kc  KeyClient
+}
+
+// WithKeyClient sets the KeyClient used instead of a client built from the flags
+func WithKeyClient(kc KeyClient) func(*BaseCmd) {
+	return func(b *BaseCmd) {
+		b.kc = kc
+	}
+}
+
+func newBaseCmd(name string, opts ...func(*BaseCmd)) BaseCmd {
+	b := BaseCmd{
+		fs:  flag.NewFlagSet(name, flag.ContinueOnError),
+		cfg: &config.Config{},
+	}
+	keyserver.RegisterFlags(b.fs, b.cfg)
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b
+}
+
+// init parses the flags and creates the key server client.
+// The returned func releases the client.
+func (b *BaseCmd) init(args []string, requireKey bool) (func(), error) {
+	if err := b.fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("error parsing flag from args: %w", err)
+	}
+	if requireKey && (b.cfg.KeyName == "" || b.cfg.KeyEnv == "") {
+		return nil, fmt.Errorf("--key-name and --key-env are required")
+	}
+	if b.kc != nil {
+		return func() {}, nil
+	}
+	if b.cfg.KeyServer.URL == "" {
+		return nil, fmt.Errorf("--key-server is required")
+	}
+	c, done, err := keyserver.NewClient(&b.cfg.KeyServer)
+	if err != nil {
+		return nil, fmt.Errorf("error creating key server client: %w", err)
+	}
+	b.kc = c
+	return done, nil
+}
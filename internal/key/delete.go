@@ -0,0 +1,47 @@
+package key
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const (
+	DeleteHelpMsg = `Usage: zypher key delete [options]
+	deletes the key from the key server
+available options:
+` + keyserver.FlagsHelp
+	DeleteSynopsisMsg = "deletes a key from the key server"
+)
+
+type DeleteCmd struct {
+	base BaseCmd
+}
+
+func NewDeleteCmd(opts ...func(*BaseCmd)) *DeleteCmd {
+	return &DeleteCmd{base: newBaseCmd("key delete", opts...)}
+}
+
+func (d *DeleteCmd) Help() string {
+	return DeleteHelpMsg
+}
+
+func (d *DeleteCmd) Synopsis() string {
+	return DeleteSynopsisMsg
+}
+
+func (d *DeleteCmd) Run(args []string) int {
+	done, err := d.base.init(args, true)
+	if err != nil {
+		fmt.Printf("error initializing key delete cmd: %v\n", err)
+		return 1
+	}
+	defer done()
+
+	if err := d.base.kc.DeleteKey(context.Background(), d.base.cfg.KeyName, d.base.cfg.KeyEnv); err != nil {
+		fmt.Printf("error deleting key: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+package key
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const (
+	GetHelpMsg = `Usage: zypher key get [options]
+	prints the key stored on the key server
+available options:
+` + keyserver.FlagsHelp
+	GetSynopsisMsg = "prints a key stored on the key server"
+)
+
+type GetCmd struct {
+	base BaseCmd
+}
+
+func NewGetCmd(opts ...func(*BaseCmd)) *GetCmd {
+	return &GetCmd{base: newBaseCmd("key get", opts...)}
+}
+
+func (g *GetCmd) Help() string {
+	return GetHelpMsg
+}
+
+func (g *GetCmd) Synopsis() string {
+	return GetSynopsisMsg
+}
+
+func (g *GetCmd) Run(args []string) int {
+	done, err := g.base.init(args, true)
+	if err != nil {
+		fmt.Printf("error initializing key get cmd: %v\n", err)
+		return 1
+	}
+	defer done()
+
+	key, err := g.base.kc.GetKey(context.Background(), g.base.cfg.KeyName, g.base.cfg.KeyEnv)
+	if err != nil {
+		fmt.Printf("error getting key: %v\n", err)
+		return 1
+	}
+	fmt.Println(key)
+	return 0
+}
@@ -0,0 +1,173 @@
+package key_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/key"
+	"go.uber.org/mock/gomock"
+)
+
+type runner interface {
+	Run([]string) int
+}
+
+func TestKey_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+
+	type test struct {
+		name            string
+		args            []string
+		expectedErrCode int
+		newCmd          func(*key.MockKeyClient) runner
+		initMocks       func(*key.MockKeyClient)
+	}
+
+	tests := []test{
+		{
+			name:            "get prints the key",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewGetCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().GetKey(gomock.Any(), "twitter", "prd").Return("somekey", nil).Times(1)
+			},
+		},
+		{
+			name:            "get fails without a key env",
+			args:            []string{"--key-name", "twitter"},
+			expectedErrCode: 1,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewGetCmd(key.WithKeyClient(m)) },
+			initMocks:       func(m *key.MockKeyClient) {},
+		},
+		{
+			name:            "get fails when the key server fails",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd"},
+			expectedErrCode: 1,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewGetCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().GetKey(gomock.Any(), "twitter", "prd").Return("", errors.New("key server responded with 404 Not Found")).Times(1)
+			},
+		},
+		{
+			name:            "put stores the key from args",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd", "somekey"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewPutCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().PutKey(gomock.Any(), "twitter", "prd", "somekey").Return(nil).Times(1)
+			},
+		},
+		{
+			name:            "put stores a generated key",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd", "--generate"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewPutCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().PutKey(gomock.Any(), "twitter", "prd", gomock.Len(32)).Return(nil).Times(1)
+			},
+		},
+		{
+			name:            "put stores a non-exportable key",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd", "--non-exportable", "somekey"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewPutCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().PutKey(gomock.Any(), "twitter", "prd", "somekey", gomock.Any()).Return(nil).Times(1)
+			},
+		},
+		{
+			name:            "put stores an expiring key",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd", "--ttl", "7d", "somekey"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewPutCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().PutKey(gomock.Any(), "twitter", "prd", "somekey", gomock.Any()).Return(nil).Times(1)
+			},
+		},
+		{
+			name:            "put fails with an invalid expiry time",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd", "--expires-at", "tomorrow", "somekey"},
+			expectedErrCode: 1,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewPutCmd(key.WithKeyClient(m)) },
+			initMocks:       func(m *key.MockKeyClient) {},
+		},
+		{
+			name:            "put fails without a key",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd"},
+			expectedErrCode: 1,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewPutCmd(key.WithKeyClient(m)) },
+			initMocks:       func(m *key.MockKeyClient) {},
+		},
+		{
+			name:            "list prints the keys matching the prefix",
+			args:            []string{"--prefix", "twi"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewListCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().ListKeys(gomock.Any(), "twi").Return([]client.KeyListEntry{{Name: "twitter", Env: "prd"}}, nil).Times(1)
+			},
+		},
+		{
+			name:            "status succeeds when no key is overdue",
+			args:            []string{},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewStatusCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().ListKeys(gomock.Any(), "").Return([]client.KeyListEntry{
+					{Name: "twitter", Env: "prd", Version: 2, KeyRotation: client.KeyRotation{Age: "24h0m0s", RotationPeriod: "2160h0m0s"}},
+					{Name: "stripe", Env: "prd", Version: 1},
+				}, nil).Times(1)
+			},
+		},
+		{
+			name:            "status fails when a key is overdue",
+			args:            []string{"--prefix", "twi"},
+			expectedErrCode: 1,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewStatusCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().ListKeys(gomock.Any(), "twi").Return([]client.KeyListEntry{
+					{Name: "twitter", Env: "prd", Version: 1, KeyRotation: client.KeyRotation{Age: "2184h0m0s", RotationPeriod: "2160h0m0s", Overdue: true}},
+				}, nil).Times(1)
+			},
+		},
+		{
+			name:            "status succeeds when an overdue key expired",
+			args:            []string{},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewStatusCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().ListKeys(gomock.Any(), "").Return([]client.KeyListEntry{
+					{
+						Name: "contractor", Env: "prd", Version: 1,
+						KeyRotation: client.KeyRotation{Age: "2184h0m0s", RotationPeriod: "2160h0m0s", Overdue: true},
+						KeyExpiry:   client.KeyExpiry{ExpiresAt: &time.Time{}, Expired: true},
+					},
+				}, nil).Times(1)
+			},
+		},
+		{
+			name:            "delete deletes the key",
+			args:            []string{"--key-name", "twitter", "--key-env", "prd"},
+			expectedErrCode: 0,
+			newCmd:          func(m *key.MockKeyClient) runner { return key.NewDeleteCmd(key.WithKeyClient(m)) },
+			initMocks: func(m *key.MockKeyClient) {
+				m.EXPECT().DeleteKey(gomock.Any(), "twitter", "prd").Return(nil).Times(1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Clearenv()
+			m := key.NewMockKeyClient(ctrl)
+			tt.initMocks(m)
+			if code := tt.newCmd(m).Run(tt.args); code != tt.expectedErrCode {
+				t.Errorf("expected error code %d, got %d", tt.expectedErrCode, code)
+			}
+		})
+	}
+}
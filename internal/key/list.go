@@ -0,0 +1,55 @@
+package key
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const (
+	ListHelpMsg = `Usage: zypher key list [options]
+	lists the paths of the keys stored on the key server e.g. /payments/stripe/prod
+available options:
+	--prefix=<prefix>			only lists keys whose path starts with prefix e.g. /payments/
+` + keyserver.FlagsHelp
+	ListSynopsisMsg = "lists keys stored on the key server"
+)
+
+type ListCmd struct {
+	base   BaseCmd
+	prefix string
+}
+
+func NewListCmd(opts ...func(*BaseCmd)) *ListCmd {
+	l := &ListCmd{base: newBaseCmd("key list", opts...)}
+	l.base.fs.StringVar(&l.prefix, "prefix", "", "only lists keys whose path starts with prefix")
+	return l
+}
+
+func (l *ListCmd) Help() string {
+	return ListHelpMsg
+}
+
+func (l *ListCmd) Synopsis() string {
+	return ListSynopsisMsg
+}
+
+func (l *ListCmd) Run(args []string) int {
+	done, err := l.base.init(args, false)
+	if err != nil {
+		fmt.Printf("error initializing key list cmd: %v\n", err)
+		return 1
+	}
+	defer done()
+
+	keys, err := l.base.kc.ListKeys(context.Background(), l.prefix)
+	if err != nil {
+		fmt.Printf("error listing keys: %v\n", err)
+		return 1
+	}
+	for _, k := range keys {
+		fmt.Println(k.Path)
+	}
+	return 0
+}
@@ -0,0 +1,99 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/key/base.go
+
+// Package key is a generated GoMock package.
+package key
+
+import (
+	context "context"
+	reflect "reflect"
+
+	client "github.com/vtno/zypher/client"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockKeyClient is a mock of KeyClient interface.
+type MockKeyClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockKeyClientMockRecorder
+}
+
+// MockKeyClientMockRecorder is the mock recorder for MockKeyClient.
+type MockKeyClientMockRecorder struct {
+	mock *MockKeyClient
+}
+
+// NewMockKeyClient creates a new mock instance.
+func NewMockKeyClient(ctrl *gomock.Controller) *MockKeyClient {
+	mock := &MockKeyClient{ctrl: ctrl}
+	mock.recorder = &MockKeyClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockKeyClient) EXPECT() *MockKeyClientMockRecorder {
+	return m.recorder
+}
+
+// DeleteKey mocks base method.
+func (m *MockKeyClient) DeleteKey(ctx context.Context, name, env string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteKey", ctx, name, env)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteKey indicates an expected call of DeleteKey.
+func (mr *MockKeyClientMockRecorder) DeleteKey(ctx, name, env interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteKey", reflect.TypeOf((*MockKeyClient)(nil).DeleteKey), ctx, name, env)
+}
+
+// GetKey mocks base method.
+func (m *MockKeyClient) GetKey(ctx context.Context, name, env string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetKey", ctx, name, env)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetKey indicates an expected call of GetKey.
+func (mr *MockKeyClientMockRecorder) GetKey(ctx, name, env interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKey", reflect.TypeOf((*MockKeyClient)(nil).GetKey), ctx, name, env)
+}
+
+// ListKeys mocks base method.
+func (m *MockKeyClient) ListKeys(ctx context.Context, prefix string) ([]client.KeyListEntry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListKeys", ctx, prefix)
+	ret0, _ := ret[0].([]client.KeyListEntry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListKeys indicates an expected call of ListKeys.
+func (mr *MockKeyClientMockRecorder) ListKeys(ctx, prefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListKeys", reflect.TypeOf((*MockKeyClient)(nil).ListKeys), ctx, prefix)
+}
+
+// PutKey mocks base method.
+func (m *MockKeyClient) PutKey(ctx context.Context, name, env, key string, opts ...client.PutOption) error {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, name, env, key}
+	for _, a := range opts {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "PutKey", varargs...)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PutKey indicates an expected call of PutKey.
+func (mr *MockKeyClientMockRecorder) PutKey(ctx, name, env, key interface{}, opts ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, name, env, key}, opts...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PutKey", reflect.TypeOf((*MockKeyClient)(nil).PutKey), varargs...)
+}
@@ -0,0 +1,101 @@
+package key
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/keygen"
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const (
+	PutHelpMsg = `Usage: zypher key put [options] [<key>]
+	stores the key on the key server
+available options:
+	--generate				generates a new AES-256 key instead of reading it from args
+	--non-exportable			the key can only be used through the transit API and is never returned by key get
+	--rotate-every=<period>			rotates the key on the key server once it gets older than period e.g. 90d, 12h.
+						0 removes the rotation policy
+	--ttl=<duration>			expires the key on the key server once duration elapsed e.g. 7d, 12h.
+						0 removes the expiry
+	--expires-at=<time>			expires the key on the key server at an RFC 3339 time e.g. 2024-12-31T00:00:00Z
+` + keyserver.FlagsHelp
+	PutSynopsisMsg = "stores a key on the key server"
+)
+
+type PutCmd struct {
+	base          BaseCmd
+	generate      bool
+	nonExportable bool
+	rotateEvery   string
+	ttl           string
+	expiresAt     string
+}
+
+func NewPutCmd(opts ...func(*BaseCmd)) *PutCmd {
+	p := &PutCmd{base: newBaseCmd("key put", opts...)}
+	p.base.fs.BoolVar(&p.generate, "generate", false, "generates a new AES-256 key")
+	p.base.fs.BoolVar(&p.nonExportable, "non-exportable", false, "the key can only be used through the transit API")
+	p.base.fs.StringVar(&p.rotateEvery, "rotate-every", "", "rotates the key once it gets older than period")
+	p.base.fs.StringVar(&p.ttl, "ttl", "", "expires the key once duration elapsed")
+	p.base.fs.StringVar(&p.expiresAt, "expires-at", "", "expires the key at an RFC 3339 time")
+	return p
+}
+
+func (p *PutCmd) Help() string {
+	return PutHelpMsg
+}
+
+func (p *PutCmd) Synopsis() string {
+	return PutSynopsisMsg
+}
+
+func (p *PutCmd) Run(args []string) int {
+	done, err := p.base.init(args, true)
+	if err != nil {
+		fmt.Printf("error initializing key put cmd: %v\n", err)
+		return 1
+	}
+	defer done()
+
+	var key string
+	switch {
+	case p.generate:
+		key, err = keygen.GenerateKey()
+		if err != nil {
+			fmt.Printf("error generating key: %v\n", err)
+			return 1
+		}
+	case p.base.fs.NArg() == 1:
+		key = p.base.fs.Arg(0)
+	default:
+		fmt.Print(PutHelpMsg)
+		return 1
+	}
+
+	var opts []client.PutOption
+	if p.nonExportable {
+		opts = append(opts, client.NonExportable())
+	}
+	if p.rotateEvery != "" {
+		opts = append(opts, client.RotateEvery(p.rotateEvery))
+	}
+	if p.ttl != "" {
+		opts = append(opts, client.ExpireAfter(p.ttl))
+	}
+	if p.expiresAt != "" {
+		t, err := time.Parse(time.RFC3339, p.expiresAt)
+		if err != nil {
+			fmt.Printf("error parsing --expires-at: %v\n", err)
+			return 1
+		}
+		opts = append(opts, client.ExpireAt(t))
+	}
+	if err := p.base.kc.PutKey(context.Background(), p.base.cfg.KeyName, p.base.cfg.KeyEnv, key, opts...); err != nil {
+		fmt.Printf("error putting key: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package key
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const (
+	StatusHelpMsg = `Usage: zypher key status [options]
+	prints the version, age, rotation policy and expiry of the keys stored on the key server
+	and flags the keys older than their rotation period as OVERDUE and the expired keys as EXPIRED.
+	exits with 1 if any key is overdue
+available options:
+	--prefix=<prefix>			only prints keys whose name starts with prefix
+` + keyserver.FlagsHelp
+	StatusSynopsisMsg = "prints the rotation status of keys stored on the key server"
+)
+
+type StatusCmd struct {
+	base   BaseCmd
+	prefix string
+}
+
+func NewStatusCmd(opts ...func(*BaseCmd)) *StatusCmd {
+	s := &StatusCmd{base: newBaseCmd("key status", opts...)}
+	s.base.fs.StringVar(&s.prefix, "prefix", "", "only prints keys whose name starts with prefix")
+	return s
+}
+
+func (s *StatusCmd) Help() string {
+	return StatusHelpMsg
+}
+
+func (s *StatusCmd) Synopsis() string {
+	return StatusSynopsisMsg
+}
+
+func (s *StatusCmd) Run(args []string) int {
+	done, err := s.base.init(args, false)
+	if err != nil {
+		fmt.Printf("error initializing key status cmd: %v\n", err)
+		return 1
+	}
+	defer done()
+
+	keys, err := s.base.kc.ListKeys(context.Background(), s.prefix)
+	if err != nil {
+		fmt.Printf("error listing keys: %v\n", err)
+		return 1
+	}
+
+	overdue := false
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVERSION\tAGE\tROTATION\tEXPIRES\tSTATUS")
+	for _, k := range keys {
+		age, rotation, expires, status := "unknown", "none", "never", "OK"
+		if k.Age != "" {
+			age = k.Age
+		}
+		if k.RotationPeriod != "" {
+			rotation = k.RotationPeriod
+		}
+		if k.ExpiresAt != nil {
+			expires = k.ExpiresAt.Format(time.RFC3339)
+		}
+		// an expired key is about to be purged rather than rotated
+		switch {
+		case k.Expired:
+			status = "EXPIRED"
+		case k.Overdue:
+			status = "OVERDUE"
+			overdue = true
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", k.Path, k.Version, age, rotation, expires, status)
+	}
+	w.Flush()
+
+	if overdue {
+		return 1
+	}
+	return 0
+}
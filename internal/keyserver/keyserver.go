@@ -0,0 +1,160 @@
+// Package keyserver builds key server clients from the command line flags shared by zypher commands.
+package keyserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/config"
+	"github.com/vtno/zypher/internal/tracing"
+)
+
+const (
+	defaultRetries = 2
+	defaultBackoff = 200 * time.Millisecond
+)
+
+// FlagsHelp documents the flags registered by RegisterFlags in the help of the commands using them.
+const FlagsHelp = `	--key-server=<url>			url of the key server. env: ZYPHER_KEY_SERVER
+	--key-name=<name>			name or namespace of the key on the key server e.g. payments/stripe.
+						env: ZYPHER_KEY_NAME
+	--key-env=<env>				env of the key on the key server. env: ZYPHER_KEY_ENV
+	--signing-key=<path-to-file>		private key signing key server requests. Default: zypher.
+						env: ZYPHER_SIGNING_KEY
+	--agent					sign key server requests with the ssh-agent at SSH_AUTH_SOCK.
+						env: ZYPHER_AGENT=true
+	--fingerprint=<fingerprint>		fingerprint of the ssh-agent identity to sign with
+	--key-server-ca=<path-to-file>		PEM encoded CA certificates trusted to serve the key server
+	--tracing=<exporter>			exports traces of key server requests to stderr, file://<path>
+						or an OTLP/HTTP collector url. env: ZYPHER_TRACING
+	--cache					keeps fetched keys in an encrypted local cache used while the key server
+						is unreachable. env: ZYPHER_CACHE
+	--cache-dir=<dir>			dir of the cache. Default: ~/.cache/zypher. env: ZYPHER_CACHE_DIR
+	--cache-max-staleness=<duration>	how long a cached key is used while the key server is unreachable.
+						0 never expires cached keys. Default: 24h. env: ZYPHER_CACHE_MAX_STALENESS
+`
+
+// RegisterFlags registers the flags selecting a key on a key server.
+// Each flag defaults to its ZYPHER_* environment variable.
+func RegisterFlags(fs *flag.FlagSet, cfg *config.Config) {
+	fs.StringVar(&cfg.KeyServer.URL, "key-server", os.Getenv("ZYPHER_KEY_SERVER"), "url of the key server to fetch the key from")
+	fs.StringVar(&cfg.KeyName, "key-name", os.Getenv("ZYPHER_KEY_NAME"), "name of the key on the key server")
+	fs.StringVar(&cfg.KeyEnv, "key-env", os.Getenv("ZYPHER_KEY_ENV"), "env of the key on the key server")
+	fs.StringVar(&cfg.KeyServer.SigningKeyPath, "signing-key", envOrDefault("ZYPHER_SIGNING_KEY", "zypher"), "private key signing key server requests")
+	useAgent, _ := strconv.ParseBool(os.Getenv("ZYPHER_AGENT"))
+	fs.BoolVar(&cfg.KeyServer.UseAgent, "agent", useAgent, "sign key server requests with the ssh-agent at SSH_AUTH_SOCK")
+	fs.StringVar(&cfg.KeyServer.Fingerprint, "fingerprint", os.Getenv("ZYPHER_FINGERPRINT"), "fingerprint of the ssh-agent identity signing key server requests")
+	fs.StringVar(&cfg.KeyServer.CACertPath, "key-server-ca", os.Getenv("ZYPHER_KEY_SERVER_CA"), "PEM encoded CA certificates trusted to serve the key server")
+	fs.StringVar(&cfg.KeyServer.Tracing, "tracing", os.Getenv("ZYPHER_TRACING"), "exports OpenTelemetry traces of key server requests")
+	RegisterCacheFlags(fs, &cfg.KeyServer)
+	cache, _ := strconv.ParseBool(os.Getenv("ZYPHER_CACHE"))
+	fs.BoolVar(&cfg.KeyServer.Cache, "cache", cache, "keeps fetched keys in an encrypted local cache used while the key server is unreachable")
+}
+
+// RegisterCacheFlags registers the flags locating the local cache of fetched keys.
+// Each flag defaults to its ZYPHER_* environment variable.
+func RegisterCacheFlags(fs *flag.FlagSet, cfg *config.KeyServerConfig) {
+	fs.StringVar(&cfg.CacheDir, "cache-dir", envOrDefault("ZYPHER_CACHE_DIR", DefaultCacheDir()), "dir of the local cache of fetched keys")
+	maxStaleness := client.DefaultCacheMaxStaleness
+	if d, err := time.ParseDuration(os.Getenv("ZYPHER_CACHE_MAX_STALENESS")); err == nil {
+		maxStaleness = d
+	}
+	fs.DurationVar(&cfg.CacheMaxStaleness, "cache-max-staleness", maxStaleness, "how long a cached key is used while the key server is unreachable")
+}
+
+// DefaultCacheDir returns the zypher dir of the user cache dir e.g. ~/.cache/zypher.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ".zypher-cache"
+	}
+	return filepath.Join(dir, "zypher")
+}
+
+func envOrDefault(name, def string) string {
+	if v, found := os.LookupEnv(name); found {
+		return v
+	}
+	return def
+}
+
+// NewClient returns a client signing requests with the ssh-agent or a private key file as configured.
+// The returned func releases the ssh-agent connection and flushes the traces of the requests.
+func NewClient(cfg *config.KeyServerConfig) (*client.Client, func(), error) {
+	c, closeClient, err := newClient(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.Tracing == "" {
+		return c, closeClient, nil
+	}
+	shutdown, err := tracing.Setup(context.Background(), "zypher", cfg.Tracing)
+	if err != nil {
+		closeClient()
+		return nil, nil, fmt.Errorf("error configuring tracing: %w", err)
+	}
+	return c, func() {
+		closeClient()
+		_ = shutdown(context.Background())
+	}, nil
+}
+
+func newClient(cfg *config.KeyServerConfig) (*client.Client, func(), error) {
+	httpClient, err := HTTPClient(cfg.CACertPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := []client.Option{client.WithRetries(defaultRetries, defaultBackoff), client.WithHTTPClient(httpClient)}
+	if cfg.Cache {
+		cache, err := client.OpenCache(cfg.CacheDir, cfg.CacheMaxStaleness)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !cache.MachineBound() {
+			fmt.Fprintf(os.Stderr, "warning: no machine ID found, the cache in %s is only protected by its permissions\n", cache.Dir())
+		}
+		opts = append(opts, client.WithCache(cache))
+	}
+
+	if cfg.UseAgent {
+		signer, conn, err := client.DialAgentSigner(cfg.Fingerprint)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client.New(cfg.URL, signer, opts...), func() { conn.Close() }, nil
+	}
+
+	signer, err := client.NewFileSigner(cfg.SigningKeyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return client.New(cfg.URL, signer, opts...), func() {}, nil
+}
+
+// HTTPClient returns an http.Client trusting the PEM encoded CA certificates at caCertPath to serve the key server.
+// The system roots are trusted when caCertPath is empty.
+func HTTPClient(caCertPath string) (*http.Client, error) {
+	if caCertPath == "" {
+		return http.DefaultClient, nil
+	}
+	pem, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading key server CA at %s: %w", caCertPath, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificate found in %s", caCertPath)
+	}
+	return &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
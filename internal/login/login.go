@@ -0,0 +1,87 @@
+package login
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type LoginCmd struct {
+	agent agent.ExtendedAgent
+}
+
+const (
+	HelpMsg = `Usage: zypher login [options] <method> <path>
+	signs a request token with an identity held by the ssh-agent at SSH_AUTH_SOCK
+	and prints the value of the Authorization header for the key server.
+	the path includes the query of the request, if any e.g. '/key?path=/payments/stripe/prd'
+available options:
+	--fingerprint=<fingerprint>		SHA256 or MD5 fingerprint of the agent identity to sign with.
+						required when the agent holds more than one identity
+`
+	SynopsisMsg = "signs a key server request with an ssh-agent identity"
+)
+
+// WithAgent sets the agent to sign with instead of connecting to SSH_AUTH_SOCK.
+func WithAgent(a agent.ExtendedAgent) func(*LoginCmd) {
+	return func(l *LoginCmd) {
+		l.agent = a
+	}
+}
+
+func NewLoginCmd(opts ...func(*LoginCmd)) *LoginCmd {
+	l := &LoginCmd{}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+func (l *LoginCmd) Help() string {
+	return HelpMsg
+}
+
+func (l *LoginCmd) Synopsis() string {
+	return SynopsisMsg
+}
+
+func (l *LoginCmd) Run(args []string) int {
+	var fingerprint string
+	fs := flag.NewFlagSet("login", flag.ContinueOnError)
+	fs.StringVar(&fingerprint, "fingerprint", "", "fingerprint of the agent identity to sign with")
+	if err := fs.Parse(args); err != nil {
+		fmt.Printf("error parsing flags: %v\n", err)
+		return 1
+	}
+	if fs.NArg() != 2 {
+		fmt.Print(HelpMsg)
+		return 1
+	}
+
+	a := l.agent
+	if a == nil {
+		dialed, conn, err := auth.DialAgent()
+		if err != nil {
+			fmt.Printf("error connecting to ssh-agent: %v\n", err)
+			return 1
+		}
+		defer conn.Close()
+		a = dialed
+	}
+
+	signer, err := auth.NewAgentSigner(a, fingerprint)
+	if err != nil {
+		fmt.Printf("error selecting agent identity: %v\n", err)
+		return 1
+	}
+	header, err := auth.SignedAuthHeader(signer, strings.ToUpper(fs.Arg(0)), fs.Arg(1))
+	if err != nil {
+		fmt.Printf("error signing token: %v\n", err)
+		return 1
+	}
+	fmt.Print(header)
+	return 0
+}
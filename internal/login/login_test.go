@@ -0,0 +1,73 @@
+package login_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/vtno/zypher/internal/login"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLogin_Run(t *testing.T) {
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	var pubs []ssh.PublicKey
+	for i := 0; i < 2; i++ {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("error generating key: %v", err)
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+			t.Fatalf("error adding key to agent: %v", err)
+		}
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			t.Fatalf("error converting public key: %v", err)
+		}
+		pubs = append(pubs, sshPub)
+	}
+
+	type test struct {
+		name            string
+		args            []string
+		expectedErrCode int
+	}
+
+	tests := []test{
+		{
+			name:            "run successfully with a matching fingerprint",
+			args:            []string{"--fingerprint", ssh.FingerprintSHA256(pubs[1]), "GET", "/key"},
+			expectedErrCode: 0,
+		},
+		{
+			name:            "run successfully with a legacy MD5 fingerprint",
+			args:            []string{"--fingerprint", "MD5:" + ssh.FingerprintLegacyMD5(pubs[0]), "GET", "/key"},
+			expectedErrCode: 0,
+		},
+		{
+			name:            "run with error when no identity matches the fingerprint",
+			args:            []string{"--fingerprint", "SHA256:unknown", "GET", "/key"},
+			expectedErrCode: 1,
+		},
+		{
+			name:            "run with error when the fingerprint is ambiguous",
+			args:            []string{"GET", "/key"},
+			expectedErrCode: 1,
+		},
+		{
+			name:            "run with error when method and path are missing",
+			args:            []string{"--fingerprint", ssh.FingerprintSHA256(pubs[0])},
+			expectedErrCode: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := login.NewLoginCmd(login.WithAgent(keyring))
+			if code := cmd.Run(tt.args); code != tt.expectedErrCode {
+				t.Errorf("expected error code %d, got %d", tt.expectedErrCode, code)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/vtno/zypher/internal/server/audit"
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/keyring"
+	"go.uber.org/zap"
+)
+
+// maxAuditedBodySize is the maximum size of a request body read to find the name and env of the key
+const maxAuditedBodySize = 1 << 20
+
+// auditActions names the action of every audited route by method and path
+var auditActions = map[string]string{
+	"GET /key":                      "key.read",
+	"POST /key":                     "key.write",
+	"DELETE /key":                   "key.delete",
+	"GET /keys":                     "key.list",
+	"GET /key/watch":                "key.watch",
+	"GET /policy":                   "policy.read",
+	"PUT /policy":                   "policy.write",
+	"DELETE /policy":                "policy.delete",
+	"POST /transit/encrypt":         "transit.encrypt",
+	"POST /transit/decrypt":         "transit.decrypt",
+	"POST /datakey":                 "datakey.generate",
+	"POST /datakey/unwrap":          "datakey.unwrap",
+	"GET /audit":                    "audit.query",
+	"GET /sys/backup":               "sys.backup",
+	"GET /sys/replication/snapshot": "sys.replication.snapshot",
+	"GET /sys/replication/changes":  "sys.replication.changes",
+	"GET /sys/replication/status":   "sys.replication.status",
+	"POST /sys/promote":             "sys.promote",
+	"GET /sys/webhooks/deliveries":  "sys.webhooks.deliveries",
+}
+
+// WithAuditLog records every request but /up and /metrics in the audit log and serves GET /audit to admins
+func WithAuditLog(l *audit.Log) ServerOption {
+	return func(s *Server) {
+		s.auditLog = l
+	}
+}
+
+// auditRecord collects the identity of an audited request while it is handled
+type auditRecord struct {
+	identity string
+	reason   string
+}
+
+// recordIdentity records the authenticated identity of the request in the audit log.
+func recordIdentity(r *http.Request, identity *auth.Identity) {
+	if ar, ok := r.Context().Value("audit").(*auditRecord); ok {
+		ar.identity = identity.Name
+	}
+}
+
+// recordDenial records why the request was denied in the audit log and the metrics.
+func recordDenial(r *http.Request, err error) {
+	if ar, ok := r.Context().Value("audit").(*auditRecord); ok {
+		ar.reason = err.Error()
+	}
+	recordAuthFailure(r, err)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// audited appends an event to the audit log once the request has been handled.
+// The request ID is taken from the X-Request-ID header or generated and returned in the response.
+func (s *Server) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auditLog == nil || r.URL.Path == "/up" || r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		path, name, env := auditedKey(r)
+		action, found := auditActions[r.Method+" "+r.URL.Path]
+		if !found {
+			action = r.Method + " " + r.URL.Path
+		}
+
+		ar := &auditRecord{}
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), "audit", ar)))
+
+		err := s.auditLog.Append(audit.Event{
+			RequestID: requestID,
+			Identity:  ar.identity,
+			Action:    action,
+			Path:      path,
+			Name:      name,
+			Env:       env,
+			Result:    audit.ResultOf(sr.status),
+			Status:    sr.status,
+			Reason:    ar.reason,
+			SourceIP:  sourceIP(r),
+		})
+		if err != nil {
+			s.logger.Error("error appending audit event", zap.String("request_id", requestID), zap.Error(err))
+		}
+	})
+}
+
+// auditedKey returns the path, name and env of the key from the query or the JSON body of the request,
+// a key named by its path /<name>/<env> is split at its last segment.
+// The body is restored so it can be read again by the handler.
+func auditedKey(r *http.Request) (string, string, string) {
+	params := r.URL.Query()
+	if path := params.Get("path"); path != "" {
+		return auditedPath(path)
+	}
+	if name := params.Get("name"); name != "" {
+		return auditedName(name, params.Get("env"))
+	}
+	if r.Body == nil || r.Method == "GET" {
+		return "", "", ""
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, maxAuditedBodySize))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(b), r.Body))
+	if err != nil {
+		return "", "", ""
+	}
+	var key struct {
+		Path string `json:"path"`
+		Name string `json:"name"`
+		Env  string `json:"env"`
+	}
+	_ = json.Unmarshal(b, &key)
+	if key.Path != "" {
+		return auditedPath(key.Path)
+	}
+	return auditedName(key.Name, key.Env)
+}
+
+// auditedPath returns the path with its namespace without its leading / and its last segment.
+func auditedPath(path string) (string, string, string) {
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return path, path, ""
+	}
+	return path, strings.TrimPrefix(path[:i], "/"), path[i+1:]
+}
+
+// auditedName returns the path of the key of the env along with its name and env.
+func auditedName(name, env string) (string, string, string) {
+	path, err := keyring.PathOf(name, env)
+	if err != nil {
+		return "", name, env
+	}
+	return path, name, env
+}
+
+// sourceIP returns the IP address of the client of the request.
+func sourceIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,340 @@
+// Package audit records every key server request in an append-only, hash-chained JSONL file.
+//
+// Every event embeds the hash of the previous event and its own hash computed over its JSON encoding,
+// so editing, inserting or removing an event breaks the chain.
+// Removing events from the end of the log is detected by comparing the last event with a Head recorded earlier.
+// An event is only acknowledged once its whole line is written and synced, a partial line left by a failed write or
+// a crash is truncated.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Result of an audited request.
+const (
+	ResultSuccess = "success"
+	ResultDenied  = "denied"
+	ResultFailure = "failure"
+)
+
+// Event is an audited request.
+type Event struct {
+	Seq       int64     `json:"seq"`
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	// Identity is the name of the authenticated identity, empty if the request was not authenticated.
+	Identity string `json:"identity,omitempty"`
+	Action   string `json:"action"`
+	// Path is the path of the key or namespace of the request e.g. /payments/stripe/prd.
+	Path   string `json:"path,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Env    string `json:"env,omitempty"`
+	Result string `json:"result"`
+	Status int    `json:"status"`
+	// Reason explains why a request was denied.
+	Reason   string `json:"reason,omitempty"`
+	SourceIP string `json:"source_ip"`
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// ResultOf returns the result of a request answered with the status code.
+func ResultOf(status int) string {
+	switch {
+	case status == 401 || status == 403:
+		return ResultDenied
+	case status >= 400:
+		return ResultFailure
+	}
+	return ResultSuccess
+}
+
+// computeHash returns the hex encoded SHA-256 of the JSON encoding of the event without its hash.
+func (e Event) computeHash() (string, error) {
+	e.Hash = ""
+	b, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling audit event: %w", err)
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Head identifies the last event of a log.
+type Head struct {
+	Seq  int64  `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+func (h Head) String() string {
+	return fmt.Sprintf("%d:%s", h.Seq, h.Hash)
+}
+
+// ParseHead parses a head formatted as seq:hash.
+func ParseHead(s string) (Head, error) {
+	seq, hash, found := strings.Cut(s, ":")
+	n, err := strconv.ParseInt(seq, 10, 64)
+	if !found || err != nil || hash == "" {
+		return Head{}, fmt.Errorf("invalid audit log head %q, expected seq:hash", s)
+	}
+	return Head{Seq: n, Hash: hash}, nil
+}
+
+// Log appends events to a hash-chained JSONL file.
+type Log struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+	// size is the offset following the last event written
+	size   int64
+	head   Head
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+type LogOption func(*Log)
+
+// WithLogger logs the partial event truncated when opening the log with the logger.
+func WithLogger(logger *zap.Logger) LogOption {
+	return func(l *Log) {
+		l.logger = logger
+	}
+}
+
+// OpenLog opens or creates the log at path.
+// The existing events are verified so new events are chained to a valid log.
+// A partial event ending the log is truncated.
+func OpenLog(path string, opts ...LogOption) (*Log, error) {
+	l := &Log{
+		path:   path,
+		logger: zap.NewNop(),
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log at %s: %w", path, err)
+	}
+	if err := l.recover(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	head, err := Verify(io.NewSectionReader(f, 0, l.size))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error verifying audit log at %s: %w", path, err)
+	}
+	l.f = f
+	l.head = head
+	return l, nil
+}
+
+// recover sets the size of the log to the end of its last line, truncating the partial line following it.
+func (l *Log) recover(f *os.File) error {
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading audit log at %s: %w", l.path, err)
+	}
+	size, err := lastLineEnd(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("error reading audit log at %s: %w", l.path, err)
+	}
+	if size < info.Size() {
+		l.logger.Warn("truncating partial event at the end of the audit log",
+			zap.String("path", l.path), zap.Int64("offset", size), zap.Int64("bytes", info.Size()-size))
+		if err := f.Truncate(size); err != nil {
+			return fmt.Errorf("error truncating audit log at %s: %w", l.path, err)
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("error syncing audit log at %s: %w", l.path, err)
+		}
+	}
+	l.size = size
+	return nil
+}
+
+// lastLineEnd returns the offset following the last newline of the file of the size, 0 if it has none.
+func lastLineEnd(f *os.File, size int64) (int64, error) {
+	buf := make([]byte, 4096)
+	for end := size; end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			return start + int64(i) + 1, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// Append chains the event to the last one and writes it to the log.
+// Seq, Time, PrevHash and Hash are set by the log.
+func (l *Log) Append(e Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e.Seq = l.head.Seq + 1
+	e.Time = l.now().UTC()
+	e.PrevHash = l.head.Hash
+	hash, err := e.computeHash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hash
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshaling audit event: %w", err)
+	}
+	b = append(b, '\n')
+	if _, err := l.f.Write(b); err != nil {
+		return l.rollback(fmt.Errorf("error writing audit event: %w", err))
+	}
+	if err := l.f.Sync(); err != nil {
+		return l.rollback(fmt.Errorf("error syncing audit log: %w", err))
+	}
+	l.size += int64(len(b))
+	l.head = Head{Seq: e.Seq, Hash: e.Hash}
+	return nil
+}
+
+// rollback truncates the log to its last event after a failed append.
+func (l *Log) rollback(err error) error {
+	if terr := l.f.Truncate(l.size); terr != nil {
+		return errors.Join(err, fmt.Errorf("error truncating audit log: %w", terr))
+	}
+	return err
+}
+
+// Head returns the last event appended to the log.
+func (l *Log) Head() Head {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.head
+}
+
+// Query returns the events matching the filter, oldest first.
+// Only the events appended when it is called are read.
+func (l *Log) Query(filter Filter) ([]Event, error) {
+	l.mu.Lock()
+	size := l.size
+	l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit log at %s: %w", l.path, err)
+	}
+	defer f.Close()
+
+	events := []Event{}
+	err = scan(io.LimitReader(f, size), func(e Event) error {
+		if filter.Match(e) {
+			events = append(events, e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[len(events)-filter.Limit:]
+	}
+	return events, nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+// Filter selects events of a Query. Empty fields match every event.
+type Filter struct {
+	Identity string
+	Action   string
+	Path     string
+	Name     string
+	Env      string
+	Since    time.Time
+	Until    time.Time
+	// Limit only keeps the most recent events.
+	Limit int
+}
+
+// Match returns true if the event matches the filter.
+func (f Filter) Match(e Event) bool {
+	return (f.Identity == "" || f.Identity == e.Identity) &&
+		(f.Action == "" || f.Action == e.Action) &&
+		(f.Path == "" || f.Path == e.Path) &&
+		(f.Name == "" || f.Name == e.Name) &&
+		(f.Env == "" || f.Env == e.Env) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || e.Time.Before(f.Until))
+}
+
+// Verify reads every event and checks they form an unbroken hash chain starting from the first event.
+// It returns the head of the log.
+func Verify(r io.Reader) (Head, error) {
+	head := Head{}
+	err := scan(r, func(e Event) error {
+		if e.Seq != head.Seq+1 {
+			return fmt.Errorf("event %d follows event %d", e.Seq, head.Seq)
+		}
+		if e.PrevHash != head.Hash {
+			return fmt.Errorf("event %d is not chained to event %d", e.Seq, head.Seq)
+		}
+		hash, err := e.computeHash()
+		if err != nil {
+			return err
+		}
+		if hash != e.Hash {
+			return fmt.Errorf("event %d has been modified", e.Seq)
+		}
+		head = Head{Seq: e.Seq, Hash: e.Hash}
+		return nil
+	})
+	return head, err
+}
+
+func scan(r io.Reader, fn func(Event) error) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	line := 0
+	for s.Scan() {
+		line++
+		var e Event
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return fmt.Errorf("error parsing audit event at line %d: %w", line, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("error reading audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,221 @@
+package audit_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vtno/zypher/internal/server/audit"
+)
+
+// writeLog appends an event for every action to a new log and returns its path.
+func writeLog(t *testing.T, actions ...string) string {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.OpenLog(path)
+	if err != nil {
+		t.Fatalf("error opening audit log: %v", err)
+	}
+	defer l.Close()
+	for _, action := range actions {
+		if err := l.Append(audit.Event{Identity: "alice", Action: action, Path: "/stripe/prd", Name: "stripe", Env: "prd", Result: audit.ResultSuccess, Status: 200}); err != nil {
+			t.Fatalf("error appending event: %v", err)
+		}
+	}
+	return path
+}
+
+func verify(t *testing.T, path string) (audit.Head, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("error opening audit log: %v", err)
+	}
+	defer f.Close()
+	return audit.Verify(f)
+}
+
+func TestLog_Append(t *testing.T) {
+	path := writeLog(t, "key.read", "key.write")
+
+	// reopening the log chains new events to the existing ones
+	l, err := audit.OpenLog(path)
+	if err != nil {
+		t.Fatalf("error reopening audit log: %v", err)
+	}
+	if err := l.Append(audit.Event{Identity: "bob", Action: "key.delete", Result: audit.ResultDenied, Status: 403}); err != nil {
+		t.Fatalf("error appending event: %v", err)
+	}
+	l.Close()
+
+	head, err := verify(t, path)
+	if err != nil {
+		t.Fatalf("expected audit log to be valid, got %v", err)
+	}
+	if head.Seq != 3 {
+		t.Errorf("expected head to be event 3, got %d", head.Seq)
+	}
+	if head != l.Head() {
+		t.Errorf("expected head %s, got %s", l.Head(), head)
+	}
+
+	events, err := l.Query(audit.Filter{Identity: "alice", Limit: 1})
+	if err != nil {
+		t.Fatalf("error querying audit log: %v", err)
+	}
+	if len(events) != 1 || events[0].Action != "key.write" {
+		t.Errorf("expected the last event of alice, got %+v", events)
+	}
+
+	events, err = l.Query(audit.Filter{Path: "/stripe/prd"})
+	if err != nil {
+		t.Fatalf("error querying audit log: %v", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expected the events of /stripe/prd, got %+v", events)
+	}
+}
+
+func TestOpenLog(t *testing.T) {
+	path := writeLog(t, "key.read", "key.write")
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatalf("error opening audit log: %v", err)
+	}
+	if _, err := f.WriteString(`{"seq":3,"time":"2026-`); err != nil {
+		t.Fatalf("error writing partial event: %v", err)
+	}
+	f.Close()
+
+	// the partial event left by a failed write is truncated
+	l, err := audit.OpenLog(path)
+	if err != nil {
+		t.Fatalf("expected audit log with a partial event to be opened, got %v", err)
+	}
+	if err := l.Append(audit.Event{Identity: "bob", Action: "key.delete", Result: audit.ResultSuccess, Status: 200}); err != nil {
+		t.Fatalf("error appending event: %v", err)
+	}
+	l.Close()
+
+	head, err := verify(t, path)
+	if err != nil {
+		t.Fatalf("expected audit log to be valid, got %v", err)
+	}
+	if head.Seq != 3 || head != l.Head() {
+		t.Errorf("expected head %s, got %s", l.Head(), head)
+	}
+}
+
+func TestLog_Query(t *testing.T) {
+	path := writeLog(t, "key.read")
+	l, err := audit.OpenLog(path)
+	if err != nil {
+		t.Fatalf("error opening audit log: %v", err)
+	}
+	defer l.Close()
+
+	// events being appended are not read
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := l.Append(audit.Event{Identity: "alice", Action: "key.write", Result: audit.ResultSuccess, Status: 200}); err != nil {
+				t.Errorf("error appending event: %v", err)
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := l.Query(audit.Filter{}); err != nil {
+			t.Fatalf("error querying audit log: %v", err)
+		}
+	}
+	wg.Wait()
+
+	events, err := l.Query(audit.Filter{})
+	if err != nil {
+		t.Fatalf("error querying audit log: %v", err)
+	}
+	if len(events) != 101 {
+		t.Errorf("expected 101 events, got %d", len(events))
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(lines []string) []string
+	}{
+		{
+			name: "detects an edited event",
+			tamper: func(lines []string) []string {
+				lines[1] = strings.Replace(lines[1], `"identity":"alice"`, `"identity":"bob"`, 1)
+				return lines
+			},
+		},
+		{
+			name: "detects a removed event",
+			tamper: func(lines []string) []string {
+				return append(lines[:1], lines[2:]...)
+			},
+		},
+		{
+			name: "detects events removed from the start",
+			tamper: func(lines []string) []string {
+				return lines[1:]
+			},
+		},
+		{
+			name: "detects reordered events",
+			tamper: func(lines []string) []string {
+				lines[0], lines[1] = lines[1], lines[0]
+				return lines
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeLog(t, "key.read", "key.write", "key.delete")
+			b, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("error reading audit log: %v", err)
+			}
+			lines := tt.tamper(strings.Split(strings.TrimSpace(string(b)), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatalf("error writing audit log: %v", err)
+			}
+			if _, err := verify(t, path); err == nil {
+				t.Errorf("expected tampered audit log to be invalid")
+			}
+			if _, err := audit.OpenLog(path); err == nil {
+				t.Errorf("expected tampered audit log not to be opened")
+			}
+		})
+	}
+}
+
+func TestVerifyCmd_Run(t *testing.T) {
+	path := writeLog(t, "key.read", "key.write", "key.delete")
+	head, err := verify(t, path)
+	if err != nil {
+		t.Fatalf("expected audit log to be valid, got %v", err)
+	}
+
+	if code := audit.NewVerifyCmd().Run([]string{"--head", head.String(), path}); code != 0 {
+		t.Errorf("expected code 0, got %d", code)
+	}
+
+	// truncate the last event
+	b, _ := os.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if err := os.WriteFile(path, []byte(strings.Join(lines[:2], "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("error writing audit log: %v", err)
+	}
+	if code := audit.NewVerifyCmd().Run([]string{path}); code != 0 {
+		t.Errorf("expected a truncated log to be valid without a head, got code %d", code)
+	}
+	if code := audit.NewVerifyCmd().Run([]string{"--head", head.String(), path}); code != 1 {
+		t.Errorf("expected truncation to be detected with the head, got code %d", code)
+	}
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+const (
+	VerifyHelpMsg = `Usage: zypher audit verify [options] <path-to-audit-log>
+	verifies the hash chain of the audit log and prints its head as seq:hash.
+	events that have been edited, inserted or removed are detected.
+available options:
+	--head=<seq:hash>			head printed by a previous verification or returned by GET /audit.
+						detects events removed from the end of the log
+`
+	VerifySynopsisMsg = "verifies the audit log of a key server"
+)
+
+type VerifyCmd struct {
+	fs   *flag.FlagSet
+	head string
+}
+
+func NewVerifyCmd() *VerifyCmd {
+	v := &VerifyCmd{fs: flag.NewFlagSet("audit verify", flag.ContinueOnError)}
+	v.fs.StringVar(&v.head, "head", "", "head printed by a previous verification")
+	return v
+}
+
+func (v *VerifyCmd) Help() string {
+	return VerifyHelpMsg
+}
+
+func (v *VerifyCmd) Synopsis() string {
+	return VerifySynopsisMsg
+}
+
+func (v *VerifyCmd) Run(args []string) int {
+	if err := v.fs.Parse(args); err != nil {
+		fmt.Printf("error parsing flag from args: %v\n", err)
+		return 1
+	}
+	if v.fs.NArg() != 1 {
+		fmt.Print(VerifyHelpMsg)
+		return 1
+	}
+
+	var expected *Head
+	if v.head != "" {
+		h, err := ParseHead(v.head)
+		if err != nil {
+			fmt.Printf("error parsing head: %v\n", err)
+			return 1
+		}
+		expected = &h
+	}
+
+	f, err := os.Open(v.fs.Arg(0))
+	if err != nil {
+		fmt.Printf("error opening audit log: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	head, err := Verify(f)
+	if err != nil {
+		fmt.Printf("audit log is invalid: %v\n", err)
+		return 1
+	}
+	if expected != nil && !contains(f.Name(), head, *expected) {
+		fmt.Printf("audit log is invalid: event %s is missing\n", expected)
+		return 1
+	}
+	fmt.Println(head)
+	return 0
+}
+
+// contains returns true if the verified log at path ending with head contains the expected event.
+func contains(path string, head, expected Head) bool {
+	if expected.Seq > head.Seq {
+		return false
+	}
+	if expected.Seq == head.Seq {
+		return expected.Hash == head.Hash
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	found := false
+	_ = scan(f, func(e Event) error {
+		if e.Seq == expected.Seq && e.Hash == expected.Hash {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
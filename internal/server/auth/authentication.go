@@ -1,26 +1,45 @@
 package auth
 
 import (
-	"crypto"
-	"crypto/rsa"
-	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/vtno/zypher/internal/server/store"
+	"golang.org/x/crypto/ssh"
 )
 
-const defaultAuthBucket = "auth"
+const (
+	defaultAuthBucket = "auth"
+	// DefaultClockSkew is the default maximum difference allowed
+	// between the time a token was issued and the server clock.
+	DefaultClockSkew = 5 * time.Minute
+)
 
 type Auth struct {
 	store      store.Store
 	pkProvider PubKeyProvider
-	rootPubKey *rsa.PublicKey
+	rootKey    Verifier
+	caProvider CAProvider
+	userCA     *userCA
+	// clientCertRoles maps verified TLS client certificate subjects to roles, nil when disabled
+	clientCertRoles map[string][]Role
+	nonces          NonceCache
+	clockSkew       time.Duration
+	now             func() time.Time
 }
 
 type PubKeyProvider interface {
-	Get() (*rsa.PublicKey, error)
+	Get() (Verifier, error)
+}
+
+// CAProvider provides the public keys of the certificate authorities trusted to issue user certificates.
+type CAProvider interface {
+	Get() ([]ssh.PublicKey, error)
 }
 
 type AuthOption func(*Auth)
@@ -31,51 +50,180 @@ func WithPubKeyProvider(p PubKeyProvider) AuthOption {
 	}
 }
 
-func parseTokenAndSigFromAuthHeader(authHeader string) (string, []byte) {
+// WithNonceCache sets the cache used to remember nonces of already used tokens.
+func WithNonceCache(c NonceCache) AuthOption {
+	return func(a *Auth) {
+		a.nonces = c
+	}
+}
+
+// WithClockSkew sets the maximum difference allowed between the token timestamp and the server clock.
+func WithClockSkew(d time.Duration) AuthOption {
+	return func(a *Auth) {
+		a.clockSkew = d
+	}
+}
+
+// WithTrustedUserCA trusts requests signed by keys holding a user certificate issued by the provided CAs.
+// principalRoles maps certificate principals to the roles granted to them
+// and revoked optionally lists revoked certificates and keys.
+func WithTrustedUserCA(p CAProvider, principalRoles map[string][]Role, revoked *RevocationList) AuthOption {
+	return func(a *Auth) {
+		a.caProvider = p
+		a.userCA = &userCA{
+			principalRoles: principalRoles,
+			revoked:        revoked,
+		}
+	}
+}
+
+// WithClientCertificates accepts the verified TLS client certificate of requests without an Authorization header as their identity.
+// principalRoles maps the certificate common name, email addresses and DNS names to the roles granted to them.
+func WithClientCertificates(principalRoles map[string][]Role) AuthOption {
+	return func(a *Auth) {
+		a.clientCertRoles = principalRoles
+	}
+}
+
+func parseTokenAndSigFromAuthHeader(authHeader string) (string, []byte, error) {
 	s := strings.Split(authHeader, " ")
 	if len(s) == 2 {
 		t := strings.Split(s[1], ":")
 		if len(t) == 2 {
 			sig, err := base64.StdEncoding.DecodeString(t[1])
 			if err != nil {
-				fmt.Printf("%e\n", fmt.Errorf("error decoding signature: %w", err))
-				return "", nil
+				return "", nil, fmt.Errorf("error decoding signature: %w", err)
 			}
-			return t[0], sig
+			return t[0], sig, nil
 		}
 	}
-	return "", nil
+	return "", nil, errors.New("malformed authorization header")
 }
 
 func NewAuth(store store.Store, opts ...AuthOption) (*Auth, error) {
-	a := &Auth{store: store}
+	a := &Auth{
+		store:     store,
+		clockSkew: DefaultClockSkew,
+		now:       time.Now,
+	}
 	for _, opt := range opts {
 		opt(a)
 	}
-	if a.pkProvider == nil {
-		return nil, fmt.Errorf("no pubkeyprovider is provided")
+	if a.pkProvider == nil && a.caProvider == nil && a.clientCertRoles == nil {
+		return nil, fmt.Errorf("no pubkeyprovider, trusted user CA or client certificate roles are provided")
 	}
-	pk, err := a.pkProvider.Get()
-	if err != nil {
-		return nil, fmt.Errorf("error getting public key: %w", err)
+	if a.nonces == nil {
+		a.nonces = NewMemoryNonceCache()
+	}
+	if a.pkProvider != nil {
+		v, err := a.pkProvider.Get()
+		if err != nil {
+			return nil, fmt.Errorf("error getting public key: %w", err)
+		}
+		a.rootKey = v
+	}
+	if a.caProvider != nil {
+		authorities, err := a.caProvider.Get()
+		if err != nil {
+			return nil, fmt.Errorf("error getting trusted user CA keys: %w", err)
+		}
+		a.userCA.authorities = authorities
+		a.userCA.now = a.now
 	}
-	a.rootPubKey = pk
 	return a, nil
 }
 
-// AuthenticateRoot extracts the signed payload from the Authorization header
-// then attempts to verify the signature using the root public key configured on the server
-func (a *Auth) AuthenticateRoot(authHeader string) bool {
+// Authenticate extracts the signed token from the Authorization header of the request
+// then attempts to verify the signature and returns the identity of the signer.
+// Tokens are either signed by the root public key configured on the server, which is granted the admin role,
+// or by a key holding a user certificate embedded in the token and issued by a trusted CA.
+// The token must match the method and path of the request, be issued within the allowed clock skew
+// and carry a nonce that has not been used before.
+// Requests without an Authorization header can be authenticated by their TLS client certificate
+// when WithClientCertificates is configured.
+func (a *Auth) Authenticate(r *http.Request) (*Identity, error) {
+	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		return false
+		if a.clientCertRoles != nil && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			return a.clientCertIdentity(r.TLS.VerifiedChains[0][0])
+		}
+		return nil, ErrMissingCredentials
+	}
+	encoded, sig, err := parseTokenAndSigFromAuthHeader(authHeader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedToken, err)
+	}
+	// the token is parsed before its signature is verified to find out which key it has to be verified with
+	token, err := ParseToken(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedToken, err)
 	}
-	token, sig := parseTokenAndSigFromAuthHeader(authHeader)
-	hashed := sha256.Sum256([]byte(token))
-	err := rsa.VerifyPKCS1v15(a.rootPubKey, crypto.SHA256, hashed[:], sig)
 
+	identity, verifier, err := a.signer(token)
 	if err != nil {
-		fmt.Printf("%e\n", fmt.Errorf("error verifying token: %w", err))
-		return false
+		return nil, fmt.Errorf("%w: %v", ErrUntrustedSigner, err)
+	}
+	if err := verifier.Verify([]byte(encoded), sig); err != nil {
+		return nil, fmt.Errorf("%w: error verifying token: %v", ErrInvalidSignature, err)
+	}
+
+	if token.Method != r.Method || token.Path != r.URL.Path || token.Query != CanonicalQuery(r.URL.RawQuery) {
+		return nil, fmt.Errorf("%w: token is issued for %s %s with query %q", ErrTokenMismatch, token.Method, token.Path, token.Query)
+	}
+
+	now := a.now()
+	issuedAt := token.Time()
+	if issuedAt.Before(now.Add(-a.clockSkew)) || issuedAt.After(now.Add(a.clockSkew)) {
+		return nil, fmt.Errorf("%w: token issued at %s", ErrTokenExpired, issuedAt)
+	}
+
+	// a token is accepted until issuedAt+clockSkew so the nonce only needs to be remembered until then
+	if !a.nonces.Use(token.Nonce, issuedAt.Add(a.clockSkew)) {
+		return nil, fmt.Errorf("%w: token nonce %s has already been used", ErrTokenReplayed, token.Nonce)
+	}
+	return identity, nil
+}
+
+// signer returns the identity claimed by the token and the verifier for its signature.
+func (a *Auth) signer(token *Token) (*Identity, Verifier, error) {
+	if token.Certificate == "" {
+		if a.rootKey == nil {
+			return nil, nil, errors.New("no root key is configured")
+		}
+		return RootIdentity(), a.rootKey, nil
+	}
+
+	if a.userCA == nil {
+		return nil, nil, errors.New("no trusted user CA is configured")
+	}
+	cert, err := parseCertificate(token.Certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+	identity, err := a.userCA.authenticate(cert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error checking certificate: %w", err)
+	}
+	verifier, err := NewSSHVerifier(cert.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return identity, verifier, nil
+}
+
+// clientCertIdentity returns the identity of a verified TLS client certificate.
+func (a *Auth) clientCertIdentity(cert *x509.Certificate) (*Identity, error) {
+	identity := &Identity{Name: cert.Subject.String()}
+	if cert.Subject.CommonName != "" {
+		identity.Principals = append(identity.Principals, cert.Subject.CommonName)
+	}
+	identity.Principals = append(identity.Principals, cert.EmailAddresses...)
+	identity.Principals = append(identity.Principals, cert.DNSNames...)
+	for _, p := range identity.Principals {
+		identity.Roles = append(identity.Roles, a.clientCertRoles[p]...)
+	}
+	if len(identity.Roles) == 0 {
+		return nil, fmt.Errorf("%w: no role is mapped to client certificate %s", ErrUntrustedSigner, identity.Name)
 	}
-	return true
+	return identity, nil
 }
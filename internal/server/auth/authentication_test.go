@@ -2,15 +2,26 @@ package auth_test
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/base64"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/vtno/zypher/internal/server/auth"
 	"github.com/vtno/zypher/internal/server/store"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 func createKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PublicKey) {
@@ -21,53 +32,538 @@ func createKeys(t *testing.T) (*rsa.PrivateKey, *rsa.PublicKey) {
 	return privKey, &privKey.PublicKey
 }
 
-func TestAuth_AuthenticateRoot(t *testing.T) {
+func signRequest(t *testing.T, privKey crypto.Signer, req *http.Request, token *auth.Token) *http.Request {
+	return signRequestWith(t, auth.Sign, privKey, req, token)
+}
+
+func signRequestWith(t *testing.T, sign func(crypto.Signer, []byte) ([]byte, error), privKey crypto.Signer, req *http.Request, token *auth.Token) *http.Request {
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatalf("error encoding token: %v", err)
+	}
+	sig, err := sign(privKey, []byte(encoded))
+	if err != nil {
+		t.Fatalf("error signing token: %v", err)
+	}
+	req.Header.Set("Authorization", auth.AuthHeader(encoded, sig))
+	return req
+}
+
+func newRequest(t *testing.T, method, path string) *http.Request {
+	req, err := http.NewRequest(method, "http://localhost:8080"+path, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	return req
+}
+
+func newToken(t *testing.T, method, path string) *auth.Token {
+	token, err := auth.NewToken(method, path)
+	if err != nil {
+		t.Fatalf("error creating token: %v", err)
+	}
+	return token
+}
+
+func TestAuth_Authenticate(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mProvider := auth.NewMockPubKeyProvider(ctrl)
 	mStore := store.NewMockStore(ctrl)
 	privKey, pub := createKeys(t)
-	mProvider.EXPECT().Get().Return(pub, nil).AnyTimes()
-	
-	hashToken := sha256.Sum256([]byte("token"))
-	sig, err := privKey.Sign(rand.Reader, hashToken[:], crypto.SHA256)
+	verifier, err := auth.NewVerifier(pub)
 	if err != nil {
-		t.Errorf("error signing token: %v", err)
+		t.Fatalf("error creating verifier: %v", err)
 	}
+	mProvider.EXPECT().Get().Return(verifier, nil).AnyTimes()
+
+	expiredToken := newToken(t, "GET", "/key")
+	expiredToken.Timestamp = time.Now().Add(-10 * time.Minute).Unix()
+	futureToken := newToken(t, "GET", "/key")
+	futureToken.Timestamp = time.Now().Add(10 * time.Minute).Unix()
 
 	type test struct {
 		name           string
-		args           []string
+		req            *http.Request
 		expectedResult bool
+		expectedReason string
 	}
 
+	invalidReq := newRequest(t, "GET", "/key")
+	invalidReq.Header.Set("Authorization", "Bearer invalid:signature")
+
 	tests := []test{
 		{
-			name: "should return false when authHeader is empty",
-			args:           []string{""},
+			name:           "should return false when authHeader is empty",
+			req:            newRequest(t, "GET", "/key"),
 			expectedResult: false,
+			expectedReason: "missing_credentials",
 		},
 		{
-			name: "should return false when signature is invalid",
-			args:           []string{"Bearer invalid:signature"},
+			name:           "should return false when signature is invalid",
+			req:            invalidReq,
 			expectedResult: false,
+			expectedReason: "malformed_token",
+		},
+		{
+			name:           "should return true when signature is valid",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key?name=twitter&env=prd"), newToken(t, "GET", "/key?name=twitter&env=prd")),
+			expectedResult: true,
 		},
 		{
-			name: "should return true when signature is valid",
-			args:           []string{"Bearer token:" + base64.StdEncoding.EncodeToString(sig)},
+			name:           "should return true when the query is signed in another order",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key?env=prd&name=twitter"), newToken(t, "GET", "/key?name=twitter&env=prd")),
 			expectedResult: true,
 		},
+		{
+			name:           "should return false when token is issued for another query",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key?name=twitter&env=prd"), newToken(t, "GET", "/key?name=stripe&env=prd")),
+			expectedResult: false,
+			expectedReason: "token_mismatch",
+		},
+		{
+			name:           "should return false when the query is not signed",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key?name=twitter&env=prd"), newToken(t, "GET", "/key")),
+			expectedResult: false,
+			expectedReason: "token_mismatch",
+		},
+		{
+			name:           "should return false when token is issued for another method",
+			req:            signRequest(t, privKey, newRequest(t, "POST", "/key"), newToken(t, "GET", "/key")),
+			expectedResult: false,
+			expectedReason: "token_mismatch",
+		},
+		{
+			name:           "should return false when token is issued for another path",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key"), newToken(t, "GET", "/up")),
+			expectedResult: false,
+			expectedReason: "token_mismatch",
+		},
+		{
+			name:           "should return false when token is expired",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key"), expiredToken),
+			expectedResult: false,
+			expectedReason: "token_expired",
+		},
+		{
+			name:           "should return false when token is issued in the future",
+			req:            signRequest(t, privKey, newRequest(t, "GET", "/key"), futureToken),
+			expectedResult: false,
+			expectedReason: "token_expired",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			auth, err := auth.NewAuth(mStore, auth.WithPubKeyProvider(mProvider))
+			a, err := auth.NewAuth(mStore, auth.WithPubKeyProvider(mProvider))
 			if err != nil {
 				t.Errorf("error initializing auth: %v", err)
 			}
-			result := auth.AuthenticateRoot(tt.args[0])
-			if result != tt.expectedResult {
+			_, err = a.Authenticate(tt.req)
+			if result := err == nil; result != tt.expectedResult {
 				t.Errorf("Expected %v, got %v", tt.expectedResult, result)
 			}
+			if err != nil && auth.FailureReason(err) != tt.expectedReason {
+				t.Errorf("expected failure reason %s, got %s: %v", tt.expectedReason, auth.FailureReason(err), err)
+			}
 		})
 	}
+
+	t.Run("should return false when the token is replayed", func(t *testing.T) {
+		a, err := auth.NewAuth(mStore, auth.WithPubKeyProvider(mProvider))
+		if err != nil {
+			t.Errorf("error initializing auth: %v", err)
+		}
+		req := signRequest(t, privKey, newRequest(t, "GET", "/key"), newToken(t, "GET", "/key"))
+		if _, err := a.Authenticate(req); err != nil {
+			t.Errorf("expected first request to be authenticated: %v", err)
+		}
+		if _, err := a.Authenticate(req); !errors.Is(err, auth.ErrTokenReplayed) {
+			t.Errorf("expected replayed request to be rejected with %v, got %v", auth.ErrTokenReplayed, err)
+		}
+	})
+}
+
+func TestAuth_Authenticate_KeyTypes(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mStore := store.NewMockStore(ctrl)
+
+	rsaKey, _ := createKeys(t)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating ed25519 key: %v", err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating P-256 key: %v", err)
+	}
+	p384Key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating P-384 key: %v", err)
+	}
+
+	type test struct {
+		name string
+		key  crypto.Signer
+		sign func(crypto.Signer, []byte) ([]byte, error)
+	}
+
+	tests := []test{
+		{name: "should verify RSA PKCS#1 v1.5 signatures", key: rsaKey, sign: auth.Sign},
+		{name: "should verify RSA-PSS signatures", key: rsaKey, sign: auth.SignPSS},
+		{name: "should verify Ed25519 signatures", key: edKey, sign: auth.Sign},
+		{name: "should verify ECDSA P-256 signatures", key: p256Key, sign: auth.Sign},
+		{name: "should verify ECDSA P-384 signatures", key: p384Key, sign: auth.Sign},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verifier, err := auth.NewVerifier(tt.key.Public())
+			if err != nil {
+				t.Fatalf("error creating verifier: %v", err)
+			}
+			mProvider := auth.NewMockPubKeyProvider(ctrl)
+			mProvider.EXPECT().Get().Return(verifier, nil)
+			a, err := auth.NewAuth(mStore, auth.WithPubKeyProvider(mProvider))
+			if err != nil {
+				t.Fatalf("error initializing auth: %v", err)
+			}
+			req := signRequestWith(t, tt.sign, tt.key, newRequest(t, "GET", "/key"), newToken(t, "GET", "/key"))
+			if _, err := a.Authenticate(req); err != nil {
+				t.Errorf("expected request to be authenticated: %v", err)
+			}
+		})
+	}
+}
+
+func TestAuth_Authenticate_Agent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mStore := store.NewMockStore(ctrl)
+
+	rsaKey, _ := createKeys(t)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating ed25519 key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating P-256 key: %v", err)
+	}
+
+	keyring := agent.NewKeyring().(agent.ExtendedAgent)
+	for _, k := range []interface{}{rsaKey, edKey, ecKey} {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: k}); err != nil {
+			t.Fatalf("error adding key to agent: %v", err)
+		}
+	}
+
+	for _, k := range []crypto.Signer{rsaKey, edKey, ecKey} {
+		pub, err := ssh.NewPublicKey(k.Public())
+		if err != nil {
+			t.Fatalf("error converting public key: %v", err)
+		}
+		t.Run("should verify agent signatures of "+pub.Type()+" identities", func(t *testing.T) {
+			signer, err := auth.NewAgentSigner(keyring, ssh.FingerprintSHA256(pub))
+			if err != nil {
+				t.Fatalf("error creating agent signer: %v", err)
+			}
+			verifier, err := auth.NewSSHVerifier(pub)
+			if err != nil {
+				t.Fatalf("error creating verifier: %v", err)
+			}
+			mProvider := auth.NewMockPubKeyProvider(ctrl)
+			mProvider.EXPECT().Get().Return(verifier, nil)
+			a, err := auth.NewAuth(mStore, auth.WithPubKeyProvider(mProvider))
+			if err != nil {
+				t.Fatalf("error initializing auth: %v", err)
+			}
+			header, err := auth.SignedAuthHeader(signer, "GET", "/key")
+			if err != nil {
+				t.Fatalf("error signing request: %v", err)
+			}
+			req := newRequest(t, "GET", "/key")
+			req.Header.Set("Authorization", header)
+			if _, err := a.Authenticate(req); err != nil {
+				t.Errorf("expected request to be authenticated: %v", err)
+			}
+		})
+	}
+
+	t.Run("should reject ssh-rsa signatures using SHA-1", func(t *testing.T) {
+		pub, err := ssh.NewPublicKey(rsaKey.Public())
+		if err != nil {
+			t.Fatalf("error converting public key: %v", err)
+		}
+		verifier, err := auth.NewSSHVerifier(pub)
+		if err != nil {
+			t.Fatalf("error creating verifier: %v", err)
+		}
+		sig, err := keyring.Sign(pub, []byte("token"))
+		if err != nil {
+			t.Fatalf("error signing with agent: %v", err)
+		}
+		if err := verifier.Verify([]byte("token"), ssh.Marshal(sig)); err == nil {
+			t.Errorf("expected ssh-rsa signature to be rejected")
+		}
+	})
+
+	t.Run("should require a fingerprint when the agent holds many identities", func(t *testing.T) {
+		if _, err := auth.NewAgentSigner(keyring, ""); err == nil {
+			t.Errorf("expected an error when no fingerprint is provided")
+		}
+	})
+}
+
+type caProvider []ssh.PublicKey
+
+func (p caProvider) Get() ([]ssh.PublicKey, error) {
+	return p, nil
+}
+
+func createCertificate(t *testing.T, ca crypto.Signer, pub crypto.PublicKey, mutate func(*ssh.Certificate)) *ssh.Certificate {
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("error converting public key: %v", err)
+	}
+	cert := &ssh.Certificate{
+		Key:             sshPub,
+		Serial:          1,
+		CertType:        ssh.UserCert,
+		KeyId:           "alice@example.com",
+		ValidPrincipals: []string{"alice"},
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+	}
+	if mutate != nil {
+		mutate(cert)
+	}
+	caSigner, err := ssh.NewSignerFromSigner(ca)
+	if err != nil {
+		t.Fatalf("error creating CA signer: %v", err)
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatalf("error signing certificate: %v", err)
+	}
+	return cert
+}
+
+func TestAuth_Authenticate_UserCA(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mStore := store.NewMockStore(ctrl)
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating CA key: %v", err)
+	}
+	_, otherCAKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating CA key: %v", err)
+	}
+	caPub, err := ssh.NewPublicKey(caKey.Public())
+	if err != nil {
+		t.Fatalf("error converting CA key: %v", err)
+	}
+	_, userKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating user key: %v", err)
+	}
+
+	revoked, err := auth.ParseRevocationList(strings.NewReader("# revoked\nserial: 100-200\nid: mallory@example.com\n"))
+	if err != nil {
+		t.Fatalf("error parsing revocation list: %v", err)
+	}
+	principalRoles, err := auth.ParsePrincipalRoles("alice=admin,ci=reader")
+	if err != nil {
+		t.Fatalf("error parsing principal roles: %v", err)
+	}
+
+	type test struct {
+		name          string
+		cert          *ssh.Certificate
+		expectedRoles []auth.Role
+		expectedErr   bool
+	}
+
+	tests := []test{
+		{
+			name:          "should authenticate a valid certificate",
+			cert:          createCertificate(t, caKey, userKey.Public(), nil),
+			expectedRoles: []auth.Role{auth.RoleAdmin},
+		},
+		{
+			name: "should map roles from every principal of the certificate",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.ValidPrincipals = []string{"unknown", "ci"}
+			}),
+			expectedRoles: []auth.Role{auth.RoleReader},
+		},
+		{
+			name: "should reject a certificate without a mapped principal",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.ValidPrincipals = []string{"bob"}
+			}),
+			expectedErr: true,
+		},
+		{
+			name:        "should reject a certificate issued by an untrusted CA",
+			cert:        createCertificate(t, otherCAKey, userKey.Public(), nil),
+			expectedErr: true,
+		},
+		{
+			name: "should reject an expired certificate",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.ValidBefore = uint64(time.Now().Add(-time.Minute).Unix())
+			}),
+			expectedErr: true,
+		},
+		{
+			name: "should reject a certificate that is not yet valid",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.ValidAfter = uint64(time.Now().Add(time.Minute).Unix())
+			}),
+			expectedErr: true,
+		},
+		{
+			name: "should reject a certificate with a revoked serial",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.Serial = 150
+			}),
+			expectedErr: true,
+		},
+		{
+			name: "should reject a certificate with a revoked key id",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.KeyId = "mallory@example.com"
+			}),
+			expectedErr: true,
+		},
+		{
+			name: "should reject a host certificate",
+			cert: createCertificate(t, caKey, userKey.Public(), func(c *ssh.Certificate) {
+				c.CertType = ssh.HostCert
+			}),
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := auth.NewAuth(mStore, auth.WithTrustedUserCA(caProvider{caPub}, principalRoles, revoked))
+			if err != nil {
+				t.Fatalf("error initializing auth: %v", err)
+			}
+			keyring := agent.NewKeyring().(agent.ExtendedAgent)
+			if err := keyring.Add(agent.AddedKey{PrivateKey: userKey, Certificate: tt.cert}); err != nil {
+				t.Fatalf("error adding key to agent: %v", err)
+			}
+			sshPub, _ := ssh.NewPublicKey(userKey.Public())
+			signer, err := auth.NewAgentSigner(keyring, ssh.FingerprintSHA256(sshPub))
+			if err != nil {
+				t.Fatalf("error creating agent signer: %v", err)
+			}
+			if signer.Certificate() == nil {
+				t.Fatalf("expected the certificate identity to be selected")
+			}
+			header, err := auth.SignedAuthHeader(signer, "GET", "/key")
+			if err != nil {
+				t.Fatalf("error signing request: %v", err)
+			}
+			req := newRequest(t, "GET", "/key")
+			req.Header.Set("Authorization", header)
+
+			identity, err := a.Authenticate(req)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("expected request to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected request to be authenticated: %v", err)
+			}
+			if identity.Name != "alice@example.com" {
+				t.Errorf("expected identity to be alice@example.com, got %s", identity.Name)
+			}
+			if !reflect.DeepEqual(identity.Roles, tt.expectedRoles) {
+				t.Errorf("expected roles %v, got %v", tt.expectedRoles, identity.Roles)
+			}
+		})
+	}
+
+	t.Run("should reject tokens signed without a certificate when no root key is configured", func(t *testing.T) {
+		a, err := auth.NewAuth(mStore, auth.WithTrustedUserCA(caProvider{caPub}, principalRoles, revoked))
+		if err != nil {
+			t.Fatalf("error initializing auth: %v", err)
+		}
+		req := signRequest(t, userKey, newRequest(t, "GET", "/key"), newToken(t, "GET", "/key"))
+		if _, err := a.Authenticate(req); err == nil {
+			t.Errorf("expected request to be rejected")
+		}
+	})
+}
+
+func TestAuth_Authenticate_ClientCertificate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mStore := store.NewMockStore(ctrl)
+	principalRoles, err := auth.ParsePrincipalRoles("ci=reader,deploy@example.com=writer")
+	if err != nil {
+		t.Fatalf("error parsing principal roles: %v", err)
+	}
+	a, err := auth.NewAuth(mStore, auth.WithClientCertificates(principalRoles))
+	if err != nil {
+		t.Fatalf("error initializing auth: %v", err)
+	}
+
+	type test struct {
+		name          string
+		cert          *x509.Certificate
+		expectedRoles []auth.Role
+		expectedErr   bool
+	}
+
+	tests := []test{
+		{
+			name:          "should map the common name to roles",
+			cert:          &x509.Certificate{Subject: pkix.Name{CommonName: "ci"}},
+			expectedRoles: []auth.Role{auth.RoleReader},
+		},
+		{
+			name: "should map email addresses to roles",
+			cert: &x509.Certificate{
+				Subject:        pkix.Name{CommonName: "deploy"},
+				EmailAddresses: []string{"deploy@example.com"},
+			},
+			expectedRoles: []auth.Role{auth.RoleWriter},
+		},
+		{
+			name:        "should reject a certificate without a mapped subject",
+			cert:        &x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}},
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newRequest(t, "GET", "/key")
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{tt.cert}}}
+			identity, err := a.Authenticate(req)
+			if tt.expectedErr {
+				if err == nil {
+					t.Errorf("expected request to be rejected")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected request to be authenticated: %v", err)
+			}
+			if !reflect.DeepEqual(identity.Roles, tt.expectedRoles) {
+				t.Errorf("expected roles %v, got %v", tt.expectedRoles, identity.Roles)
+			}
+		})
+	}
+
+	t.Run("should reject requests without a verified client certificate", func(t *testing.T) {
+		if _, err := a.Authenticate(newRequest(t, "GET", "/key")); err == nil {
+			t.Errorf("expected request to be rejected")
+		}
+	})
 }
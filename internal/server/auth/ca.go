@@ -0,0 +1,201 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RevocationList holds revoked certificates and keys.
+// It is parsed from the text format accepted by `ssh-keygen -k`:
+//
+//	serial: 1
+//	serial: 10-20
+//	id: alice@example.com
+//	key: ssh-ed25519 AAAA...
+//	hash: SHA256:...
+//
+// Blank lines and lines starting with # are ignored.
+type RevocationList struct {
+	serials      []serialRange
+	keyIDs       map[string]struct{}
+	fingerprints map[string]struct{}
+}
+
+type serialRange struct {
+	from, to uint64
+}
+
+// ParseRevocationList parses a revocation list specification.
+func ParseRevocationList(r io.Reader) (*RevocationList, error) {
+	l := &RevocationList{
+		keyIDs:       make(map[string]struct{}),
+		fingerprints: make(map[string]struct{}),
+	}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, value, found := strings.Cut(line, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: missing revocation type", lineNo)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(strings.TrimSpace(kind)) {
+		case "serial":
+			sr, err := parseSerialRange(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			l.serials = append(l.serials, sr)
+		case "id":
+			l.keyIDs[value] = struct{}{}
+		case "key":
+			pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(value))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: error parsing key: %w", lineNo, err)
+			}
+			l.fingerprints[ssh.FingerprintSHA256(pub)] = struct{}{}
+		case "hash", "sha256":
+			fp := value
+			if !strings.HasPrefix(fp, "SHA256:") {
+				fp = "SHA256:" + fp
+			}
+			l.fingerprints[fp] = struct{}{}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported revocation type %q", lineNo, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading revocation list: %w", err)
+	}
+	return l, nil
+}
+
+func parseSerialRange(value string) (serialRange, error) {
+	from, to, isRange := strings.Cut(value, "-")
+	f, err := strconv.ParseUint(strings.TrimSpace(from), 0, 64)
+	if err != nil {
+		return serialRange{}, fmt.Errorf("invalid serial %q: %w", value, err)
+	}
+	if !isRange {
+		return serialRange{from: f, to: f}, nil
+	}
+	t, err := strconv.ParseUint(strings.TrimSpace(to), 0, 64)
+	if err != nil || t < f {
+		return serialRange{}, fmt.Errorf("invalid serial range %q", value)
+	}
+	return serialRange{from: f, to: t}, nil
+}
+
+// IsRevoked returns true if the certificate, its key or its signing CA has been revoked.
+func (l *RevocationList) IsRevoked(cert *ssh.Certificate) bool {
+	if l == nil {
+		return false
+	}
+	for _, sr := range l.serials {
+		if cert.Serial >= sr.from && cert.Serial <= sr.to {
+			return true
+		}
+	}
+	if _, found := l.keyIDs[cert.KeyId]; found {
+		return true
+	}
+	for _, k := range []ssh.PublicKey{cert.Key, cert.SignatureKey} {
+		if _, found := l.fingerprints[ssh.FingerprintSHA256(k)]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// userCA checks OpenSSH user certificates against trusted certificate authorities.
+type userCA struct {
+	authorities    []ssh.PublicKey
+	principalRoles map[string][]Role
+	revoked        *RevocationList
+	now            func() time.Time
+}
+
+func (ca *userCA) isAuthority(key ssh.PublicKey) bool {
+	for _, a := range ca.authorities {
+		if bytes.Equal(a.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCertificate decodes a base64 encoded certificate as embedded in a Token.
+func parseCertificate(encoded string) (*ssh.Certificate, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding certificate: %w", err)
+	}
+	pub, err := ssh.ParsePublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("token certificate is not an SSH certificate")
+	}
+	return cert, nil
+}
+
+// authenticate checks the certificate is a valid user certificate issued by a trusted CA
+// and returns the identity with the roles mapped from its principals.
+func (ca *userCA) authenticate(cert *ssh.Certificate) (*Identity, error) {
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("certificate has type %d, expected a user certificate", cert.CertType)
+	}
+	if !ca.isAuthority(cert.SignatureKey) {
+		return nil, errors.New("certificate is signed by an unrecognized authority")
+	}
+
+	identity := &Identity{
+		Name:       cert.KeyId,
+		Principals: cert.ValidPrincipals,
+	}
+	principal := ""
+	for _, p := range cert.ValidPrincipals {
+		roles, found := ca.principalRoles[p]
+		if !found {
+			continue
+		}
+		if principal == "" {
+			principal = p
+		}
+		identity.Roles = append(identity.Roles, roles...)
+	}
+	if len(identity.Roles) == 0 {
+		return nil, fmt.Errorf("no role is mapped to certificate principals %q", cert.ValidPrincipals)
+	}
+
+	checker := &ssh.CertChecker{
+		Clock:     ca.now,
+		IsRevoked: ca.revoked.IsRevoked,
+	}
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// CertificateHolder is implemented by TokenSigners signing with a key that holds an SSH certificate.
+// The certificate is embedded into the signed token so the server can check it against a trusted CA.
+type CertificateHolder interface {
+	Certificate() *ssh.Certificate
+}
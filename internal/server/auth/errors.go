@@ -0,0 +1,46 @@
+package auth
+
+import "errors"
+
+// Authentication failures returned by Authenticate wrapped with the details of the failure.
+var (
+	ErrMissingCredentials = errors.New("no authorization header")
+	ErrMalformedToken     = errors.New("malformed token")
+	ErrUntrustedSigner    = errors.New("untrusted signer")
+	ErrInvalidSignature   = errors.New("invalid signature")
+	ErrTokenMismatch      = errors.New("token issued for another request")
+	ErrTokenExpired       = errors.New("token outside of the allowed clock skew")
+	ErrTokenReplayed      = errors.New("token already used")
+	// ErrRoleNotGranted is returned by the server when an authenticated identity lacks the role of a request.
+	ErrRoleNotGranted = errors.New("role not granted")
+	// ErrRateLimited is returned by the server when a source IP or an identity exceeds its rate limit.
+	ErrRateLimited = errors.New("rate limited")
+	// ErrLockedOut is returned by the server when a source IP is locked out after repeated signature failures.
+	ErrLockedOut = errors.New("locked out after repeated signature failures")
+)
+
+var failureReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrMissingCredentials, "missing_credentials"},
+	{ErrMalformedToken, "malformed_token"},
+	{ErrUntrustedSigner, "untrusted_signer"},
+	{ErrInvalidSignature, "invalid_signature"},
+	{ErrTokenMismatch, "token_mismatch"},
+	{ErrTokenExpired, "token_expired"},
+	{ErrTokenReplayed, "token_replayed"},
+	{ErrRoleNotGranted, "role_not_granted"},
+	{ErrRateLimited, "rate_limited"},
+	{ErrLockedOut, "locked_out"},
+}
+
+// FailureReason returns a short snake case reason of an authentication failure e.g. invalid_signature.
+func FailureReason(err error) string {
+	for _, fr := range failureReasons {
+		if errors.Is(err, fr.err) {
+			return fr.reason
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Role is a permission granted to an authenticated identity.
+type Role string
+
+const (
+	// RoleAdmin grants every permission.
+	RoleAdmin Role = "admin"
+	// RoleWriter allows keys to be created and updated.
+	RoleWriter Role = "writer"
+	// RoleReader allows keys to be read.
+	RoleReader Role = "reader"
+	// RoleReplicator allows followers to stream the change log of the store.
+	RoleReplicator Role = "replicator"
+)
+
+// ParseRole returns the Role with the given name.
+func ParseRole(name string) (Role, bool) {
+	switch r := Role(name); r {
+	case RoleAdmin, RoleWriter, RoleReader, RoleReplicator:
+		return r, true
+	}
+	return "", false
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	// Name is the root key name or the key ID of the certificate.
+	Name string
+	// Principals are the principals of the certificate the request was signed with.
+	Principals []string
+	Roles      []Role
+}
+
+const rootIdentityName = "root"
+
+// RootIdentity returns the identity of requests signed with the root key.
+func RootIdentity() *Identity {
+	return &Identity{
+		Name:  rootIdentityName,
+		Roles: []Role{RoleAdmin},
+	}
+}
+
+// HasRole returns true if the identity has been granted the role.
+// Admins are granted every role.
+func (i *Identity) HasRole(role Role) bool {
+	for _, r := range i.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePrincipalRoles parses a comma separated list of principal=role pairs
+// e.g. "alice=admin,ci=reader,ci=writer" into the roles granted to each principal.
+func ParsePrincipalRoles(s string) (map[string][]Role, error) {
+	principalRoles := make(map[string][]Role)
+	if strings.TrimSpace(s) == "" {
+		return principalRoles, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		principal, name, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || principal == "" {
+			return nil, fmt.Errorf("invalid principal role mapping %q", pair)
+		}
+		role, ok := ParseRole(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown role %q for principal %s", name, principal)
+		}
+		principalRoles[principal] = append(principalRoles[principal], role)
+	}
+	return principalRoles, nil
+}
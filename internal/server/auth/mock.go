@@ -5,10 +5,10 @@
 package auth
 
 import (
-	rsa "crypto/rsa"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
+	ssh "golang.org/x/crypto/ssh"
 )
 
 // MockPubKeyProvider is a mock of PubKeyProvider interface.
@@ -35,10 +35,10 @@ func (m *MockPubKeyProvider) EXPECT() *MockPubKeyProviderMockRecorder {
 }
 
 // Get mocks base method.
-func (m *MockPubKeyProvider) Get() (*rsa.PublicKey, error) {
+func (m *MockPubKeyProvider) Get() (Verifier, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "Get")
-	ret0, _ := ret[0].(*rsa.PublicKey)
+	ret0, _ := ret[0].(Verifier)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
@@ -48,3 +48,41 @@ func (mr *MockPubKeyProviderMockRecorder) Get() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockPubKeyProvider)(nil).Get))
 }
+
+// MockCAProvider is a mock of CAProvider interface.
+type MockCAProvider struct {
+	ctrl     *gomock.Controller
+	recorder *MockCAProviderMockRecorder
+}
+
+// MockCAProviderMockRecorder is the mock recorder for MockCAProvider.
+type MockCAProviderMockRecorder struct {
+	mock *MockCAProvider
+}
+
+// NewMockCAProvider creates a new mock instance.
+func NewMockCAProvider(ctrl *gomock.Controller) *MockCAProvider {
+	mock := &MockCAProvider{ctrl: ctrl}
+	mock.recorder = &MockCAProviderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCAProvider) EXPECT() *MockCAProviderMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method.
+func (m *MockCAProvider) Get() ([]ssh.PublicKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get")
+	ret0, _ := ret[0].([]ssh.PublicKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockCAProviderMockRecorder) Get() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockCAProvider)(nil).Get))
+}
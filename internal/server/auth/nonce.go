@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// NonceCache remembers nonces of tokens that have already been used.
+type NonceCache interface {
+	// Use records the nonce until expiresAt.
+	// It returns false if the nonce has already been recorded and not yet expired.
+	Use(nonce string, expiresAt time.Time) bool
+}
+
+// MemoryNonceCache is an in-memory NonceCache.
+// Expired nonces are pruned lazily while new nonces are recorded.
+type MemoryNonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextPrune time.Time
+	now       func() time.Time
+}
+
+const nonceCachePruneInterval = time.Minute
+
+// NewMemoryNonceCache returns an empty MemoryNonceCache.
+func NewMemoryNonceCache() *MemoryNonceCache {
+	return &MemoryNonceCache{
+		nonces: make(map[string]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Use records the nonce until expiresAt.
+// It returns false if the nonce has already been recorded and not yet expired.
+func (c *MemoryNonceCache) Use(nonce string, expiresAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.After(c.nextPrune) {
+		for n, exp := range c.nonces {
+			if now.After(exp) {
+				delete(c.nonces, n)
+			}
+		}
+		c.nextPrune = now.Add(nonceCachePruneInterval)
+	}
+
+	if exp, found := c.nonces[nonce]; found && !now.After(exp) {
+		return false
+	}
+	c.nonces[nonce] = expiresAt
+	return true
+}
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// TokenSigner signs encoded request tokens on behalf of a client.
+type TokenSigner interface {
+	SignToken(encoded string) ([]byte, error)
+}
+
+// ParsePrivateKey parses a PEM encoded private key in PKCS#1, PKCS#8, SEC 1 or OpenSSH format.
+func ParsePrivateKey(pemBytes []byte) (crypto.Signer, error) {
+	raw, err := ssh.ParseRawPrivateKey(pemBytes)
+	if err != nil {
+		return nil, err
+	}
+	// OpenSSH ed25519 keys are returned as a pointer
+	if k, ok := raw.(*ed25519.PrivateKey); ok {
+		return *k, nil
+	}
+	signer, ok := raw.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", raw)
+	}
+	return signer, nil
+}
+
+// KeySigner is a TokenSigner using a private key loaded in memory.
+type KeySigner struct {
+	key crypto.Signer
+	pss bool
+}
+
+// NewKeySigner returns a KeySigner for the key.
+// When pss is true RSA keys produce RSA-PSS signatures.
+func NewKeySigner(key crypto.Signer, pss bool) *KeySigner {
+	return &KeySigner{key: key, pss: pss}
+}
+
+// SignToken signs the encoded token with the private key.
+func (s *KeySigner) SignToken(encoded string) ([]byte, error) {
+	return sign(s.key, []byte(encoded), s.pss)
+}
+
+// AgentSigner is a TokenSigner delegating signatures to an identity held by ssh-agent
+// so the private key never has to be read by zypher.
+type AgentSigner struct {
+	agent agent.ExtendedAgent
+	key   ssh.PublicKey
+	cert  *ssh.Certificate
+}
+
+// DialAgent connects to the ssh-agent listening on SSH_AUTH_SOCK.
+// The returned connection should be closed once the agent is no longer needed.
+func DialAgent() (agent.ExtendedAgent, net.Conn, error) {
+	sock, found := os.LookupEnv("SSH_AUTH_SOCK")
+	if !found || sock == "" {
+		return nil, nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error connecting to ssh-agent at %s: %w", sock, err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// NewAgentSigner returns an AgentSigner for the agent identity matching the fingerprint.
+// Both SHA256 and legacy MD5 fingerprints are accepted.
+// When the matching key is also loaded with an SSH certificate the certificate identity is preferred.
+// When fingerprint is empty the agent must hold exactly one identity.
+func NewAgentSigner(a agent.ExtendedAgent, fingerprint string) (*AgentSigner, error) {
+	keys, err := a.List()
+	if err != nil {
+		return nil, fmt.Errorf("error listing agent identities: %w", err)
+	}
+	if fingerprint == "" {
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("agent holds %d identities, a fingerprint is required to pick one", len(keys))
+		}
+		return newAgentSigner(a, keys[0])
+	}
+
+	var match *agent.Key
+	for _, k := range keys {
+		pub, err := ssh.ParsePublicKey(k.Blob)
+		if err != nil {
+			continue
+		}
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			if matchFingerprint(cert.Key, fingerprint) || matchFingerprint(cert, fingerprint) {
+				return newAgentSigner(a, k)
+			}
+			continue
+		}
+		if match == nil && matchFingerprint(pub, fingerprint) {
+			match = k
+		}
+	}
+	if match == nil {
+		return nil, fmt.Errorf("no agent identity matches fingerprint %s", fingerprint)
+	}
+	return newAgentSigner(a, match)
+}
+
+func newAgentSigner(a agent.ExtendedAgent, k *agent.Key) (*AgentSigner, error) {
+	s := &AgentSigner{agent: a, key: k}
+	pub, err := ssh.ParsePublicKey(k.Blob)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing agent identity: %w", err)
+	}
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		s.cert = cert
+	}
+	return s, nil
+}
+
+func matchFingerprint(pub ssh.PublicKey, fingerprint string) bool {
+	return ssh.FingerprintSHA256(pub) == fingerprint || ssh.FingerprintLegacyMD5(pub) == strings.TrimPrefix(fingerprint, "MD5:")
+}
+
+// PublicKey returns the public key of the selected agent identity.
+func (s *AgentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+// Certificate returns the SSH certificate of the selected agent identity or nil.
+func (s *AgentSigner) Certificate() *ssh.Certificate {
+	return s.cert
+}
+
+// SignToken asks the agent to sign the encoded token and returns the SSH signature blob.
+// RSA identities are asked for rsa-sha2-256 signatures.
+func (s *AgentSigner) SignToken(encoded string) ([]byte, error) {
+	var flags agent.SignatureFlags
+	if s.key.Type() == ssh.KeyAlgoRSA || s.key.Type() == ssh.CertAlgoRSAv01 {
+		flags = agent.SignatureFlagRsaSha256
+	}
+	sig, err := s.agent.SignWithFlags(s.key, []byte(encoded), flags)
+	if err != nil {
+		return nil, fmt.Errorf("error signing token with agent: %w", err)
+	}
+	return ssh.Marshal(sig), nil
+}
+
+// SignedAuthHeader creates a token for the method and path, optionally followed by ?query, signs it with the signer
+// and returns the value of the Authorization header.
+// The certificate of signers implementing CertificateHolder is embedded into the token.
+func SignedAuthHeader(s TokenSigner, method, path string) (string, error) {
+	token, err := NewToken(method, path)
+	if err != nil {
+		return "", err
+	}
+	if ch, ok := s.(CertificateHolder); ok && ch.Certificate() != nil {
+		token.Certificate = base64.StdEncoding.EncodeToString(ch.Certificate().Marshal())
+	}
+	encoded, err := token.Encode()
+	if err != nil {
+		return "", err
+	}
+	sig, err := s.SignToken(encoded)
+	if err != nil {
+		return "", fmt.Errorf("error signing token: %w", err)
+	}
+	return AuthHeader(encoded, sig), nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Verifier verifies a signature over a message with a public key.
+type Verifier interface {
+	Verify(message, sig []byte) error
+}
+
+// NewVerifier returns a Verifier for the given public key.
+// Supported keys are RSA (PKCS#1 v1.5 or PSS signatures with SHA-256),
+// ECDSA P-256/P-384/P-521 (ASN.1 signatures with SHA-256/SHA-384/SHA-512) and Ed25519.
+func NewVerifier(pub crypto.PublicKey) (Verifier, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return &rsaVerifier{pub: k}, nil
+	case *ecdsa.PublicKey:
+		return &ecdsaVerifier{pub: k}, nil
+	case ed25519.PublicKey:
+		return ed25519Verifier(k), nil
+	case *ed25519.PublicKey:
+		return ed25519Verifier(*k), nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// NewSSHVerifier returns a Verifier for the SSH public key.
+// It verifies SSH signature blobs as produced by ssh-agent
+// and falls back to the raw signatures accepted by NewVerifier.
+// Legacy ssh-rsa signatures using SHA-1 are rejected.
+func NewSSHVerifier(pub ssh.PublicKey) (Verifier, error) {
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %s", pub.Type())
+	}
+	raw, err := NewVerifier(cpk.CryptoPublicKey())
+	if err != nil {
+		return nil, err
+	}
+	return &sshVerifier{pub: pub, raw: raw}, nil
+}
+
+type sshVerifier struct {
+	pub ssh.PublicKey
+	raw Verifier
+}
+
+func (v *sshVerifier) Verify(message, sig []byte) error {
+	sshSig := &ssh.Signature{}
+	if err := ssh.Unmarshal(sig, sshSig); err != nil {
+		return v.raw.Verify(message, sig)
+	}
+	if sshSig.Format == ssh.KeyAlgoRSA {
+		return errors.New("ssh-rsa signatures using SHA-1 are not accepted")
+	}
+	return v.pub.Verify(message, sshSig)
+}
+
+type rsaVerifier struct {
+	pub *rsa.PublicKey
+}
+
+// Verify accepts both PKCS#1 v1.5 and PSS signatures over the SHA-256 digest of the message.
+func (v *rsaVerifier) Verify(message, sig []byte) error {
+	hashed := sha256.Sum256(message)
+	if err := rsa.VerifyPKCS1v15(v.pub, crypto.SHA256, hashed[:], sig); err == nil {
+		return nil
+	}
+	return rsa.VerifyPSS(v.pub, crypto.SHA256, hashed[:], sig, nil)
+}
+
+type ecdsaVerifier struct {
+	pub *ecdsa.PublicKey
+}
+
+func (v *ecdsaVerifier) Verify(message, sig []byte) error {
+	h := ecdsaHash(v.pub).New()
+	h.Write(message)
+	if !ecdsa.VerifyASN1(v.pub, h.Sum(nil), sig) {
+		return errors.New("ecdsa: verification error")
+	}
+	return nil
+}
+
+type ed25519Verifier ed25519.PublicKey
+
+func (v ed25519Verifier) Verify(message, sig []byte) error {
+	if !ed25519.Verify(ed25519.PublicKey(v), message, sig) {
+		return errors.New("ed25519: verification error")
+	}
+	return nil
+}
+
+// ecdsaHash returns the hash matching the curve size as used by OpenSSH.
+func ecdsaHash(pub *ecdsa.PublicKey) crypto.Hash {
+	switch bits := pub.Curve.Params().BitSize; {
+	case bits <= 256:
+		return crypto.SHA256
+	case bits <= 384:
+		return crypto.SHA384
+	default:
+		return crypto.SHA512
+	}
+}
+
+// Sign signs the message with the private key using the scheme expected by NewVerifier.
+// RSA keys produce PKCS#1 v1.5 signatures, use SignPSS for RSA-PSS.
+func Sign(key crypto.Signer, message []byte) ([]byte, error) {
+	return sign(key, message, false)
+}
+
+// SignPSS is like Sign but produces RSA-PSS signatures for RSA keys.
+func SignPSS(key crypto.Signer, message []byte) ([]byte, error) {
+	return sign(key, message, true)
+}
+
+func sign(key crypto.Signer, message []byte, pss bool) ([]byte, error) {
+	switch k := key.Public().(type) {
+	case *rsa.PublicKey:
+		hashed := sha256.Sum256(message)
+		var opts crypto.SignerOpts = crypto.SHA256
+		if pss {
+			opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		}
+		return key.Sign(rand.Reader, hashed[:], opts)
+	case *ecdsa.PublicKey:
+		hf := ecdsaHash(k)
+		h := hf.New()
+		h.Write(message)
+		return key.Sign(rand.Reader, h.Sum(nil), hf)
+	case ed25519.PublicKey:
+		return key.Sign(rand.Reader, message, crypto.Hash(0))
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
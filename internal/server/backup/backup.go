@@ -0,0 +1,208 @@
+// Package backup writes and restores encrypted snapshots of the key store.
+//
+// A backup starts with a plaintext magic line and a random 16 bytes backup ID followed by frames of up to 64KiB
+// of the snapshot, each encrypted on its own so backups can be streamed. A frame is a big-endian uint32 length
+// followed by the ciphertext of a flag byte, the uint64 sequence number of the frame and the data.
+// The sequence number and the flag marking the last frame detect reordered and truncated backups,
+// the magic line and backup ID authenticated with every frame detect frames spliced from another backup.
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	magic     = "ZYPHER-BACKUP-2\n"
+	idSize    = 16
+	chunkSize = 64 << 10
+	// maxFrameSize bounds the ciphertext of a frame so a corrupted length cannot exhaust memory
+	maxFrameSize = chunkSize + 1024
+	headerSize   = 1 + 8
+	finalFrame   = 1
+)
+
+var (
+	// ErrInvalidBackup is returned when the input is not a backup or is corrupted.
+	ErrInvalidBackup = errors.New("invalid backup")
+	// ErrTruncatedBackup is returned when the backup ends before its last frame.
+	ErrTruncatedBackup = errors.New("truncated backup")
+)
+
+// Cipher encrypts and decrypts the frames of a backup along with the header of the backup as additional data.
+type Cipher interface {
+	EncryptWithAD(plaintext, additionalData []byte) ([]byte, error)
+	DecryptWithAD(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// Writer encrypts everything written to it as a backup.
+// Close must be called to write the last frame.
+type Writer struct {
+	w      io.Writer
+	cipher Cipher
+	// header is the magic line and the backup ID
+	header  []byte
+	buf     []byte
+	seq     uint64
+	started bool
+	closed  bool
+}
+
+// NewWriter returns a Writer writing the backup encrypted by the cipher to w.
+func NewWriter(w io.Writer, c Cipher) *Writer {
+	return &Writer{
+		w:      w,
+		cipher: c,
+		buf:    make([]byte, 0, chunkSize),
+	}
+}
+
+func (bw *Writer) Write(p []byte) (int, error) {
+	if bw.closed {
+		return 0, errors.New("write to closed backup writer")
+	}
+	n := len(p)
+	for len(p) > 0 {
+		free := chunkSize - len(bw.buf)
+		if len(p) < free {
+			free = len(p)
+		}
+		bw.buf = append(bw.buf, p[:free]...)
+		p = p[free:]
+		if len(bw.buf) == chunkSize {
+			if err := bw.flush(0); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close writes the buffered data as the last frame of the backup.
+func (bw *Writer) Close() error {
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+	return bw.flush(finalFrame)
+}
+
+func (bw *Writer) flush(flags byte) error {
+	if !bw.started {
+		header := make([]byte, len(magic)+idSize)
+		copy(header, magic)
+		if _, err := rand.Read(header[len(magic):]); err != nil {
+			return fmt.Errorf("error generating backup ID: %w", err)
+		}
+		if _, err := bw.w.Write(header); err != nil {
+			return fmt.Errorf("error writing backup header: %w", err)
+		}
+		bw.header = header
+		bw.started = true
+	}
+	plaintext := make([]byte, headerSize, headerSize+len(bw.buf))
+	plaintext[0] = flags
+	binary.BigEndian.PutUint64(plaintext[1:], bw.seq)
+	plaintext = append(plaintext, bw.buf...)
+	ciphertext, err := bw.cipher.EncryptWithAD(plaintext, bw.header)
+	if err != nil {
+		return fmt.Errorf("error encrypting backup frame: %w", err)
+	}
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(ciphertext)))
+	if _, err := bw.w.Write(length[:]); err != nil {
+		return fmt.Errorf("error writing backup frame: %w", err)
+	}
+	if _, err := bw.w.Write(ciphertext); err != nil {
+		return fmt.Errorf("error writing backup frame: %w", err)
+	}
+	bw.seq++
+	bw.buf = bw.buf[:0]
+	return nil
+}
+
+// Reader decrypts a backup written by a Writer.
+type Reader struct {
+	r       io.Reader
+	cipher  Cipher
+	header  []byte
+	buf     []byte
+	seq     uint64
+	started bool
+	done    bool
+}
+
+// NewReader returns a Reader decrypting the backup read from r with the cipher.
+func NewReader(r io.Reader, c Cipher) *Reader {
+	return &Reader{
+		r:      r,
+		cipher: c,
+	}
+}
+
+func (br *Reader) Read(p []byte) (int, error) {
+	for len(br.buf) == 0 {
+		if br.done {
+			return 0, io.EOF
+		}
+		if err := br.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.buf)
+	br.buf = br.buf[n:]
+	return n, nil
+}
+
+func (br *Reader) next() error {
+	if !br.started {
+		header := make([]byte, len(magic)+idSize)
+		if _, err := io.ReadFull(br.r, header); err != nil || !bytes.Equal(header[:len(magic)], []byte(magic)) {
+			return fmt.Errorf("%w: missing backup header", ErrInvalidBackup)
+		}
+		br.header = header
+		br.started = true
+	}
+	var length [4]byte
+	if _, err := io.ReadFull(br.r, length[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncatedBackup
+		}
+		return fmt.Errorf("error reading backup frame: %w", err)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxFrameSize {
+		return fmt.Errorf("%w: frame of %d bytes", ErrInvalidBackup, size)
+	}
+	ciphertext := make([]byte, size)
+	if _, err := io.ReadFull(br.r, ciphertext); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return ErrTruncatedBackup
+		}
+		return fmt.Errorf("error reading backup frame: %w", err)
+	}
+	plaintext, err := br.cipher.DecryptWithAD(ciphertext, br.header)
+	if err != nil {
+		return fmt.Errorf("%w: error decrypting frame %d, is the backup key correct?: %v", ErrInvalidBackup, br.seq, err)
+	}
+	if len(plaintext) < headerSize {
+		return fmt.Errorf("%w: frame %d is too short", ErrInvalidBackup, br.seq)
+	}
+	if seq := binary.BigEndian.Uint64(plaintext[1:headerSize]); seq != br.seq {
+		return fmt.Errorf("%w: expected frame %d, got %d", ErrInvalidBackup, br.seq, seq)
+	}
+	br.seq++
+	br.buf = plaintext[headerSize:]
+	if plaintext[0]&finalFrame != 0 {
+		br.done = true
+		// nothing may follow the last frame
+		if n, _ := br.r.Read(make([]byte, 1)); n > 0 {
+			return fmt.Errorf("%w: data after the last frame", ErrInvalidBackup)
+		}
+	}
+	return nil
+}
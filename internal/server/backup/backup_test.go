@@ -0,0 +1,193 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher"
+	"github.com/vtno/zypher/internal/server/backup"
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+const key = "0123456789abcdef0123456789abcdef"
+
+func encrypt(t *testing.T, plaintext []byte) []byte {
+	var b bytes.Buffer
+	bw := backup.NewWriter(&b, zypher.NewCipher(key))
+	if _, err := bw.Write(plaintext); err != nil {
+		t.Fatalf("error writing backup: %v", err)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("error closing backup: %v", err)
+	}
+	return b.Bytes()
+}
+
+func TestReader(t *testing.T) {
+	plaintext := make([]byte, 200<<10)
+	if _, err := rand.Read(plaintext); err != nil {
+		t.Fatalf("error generating plaintext: %v", err)
+	}
+	encrypted := encrypt(t, plaintext)
+	// the last frame holds what is left after three full frames of 64KiB, its ciphertext adds
+	// a 4 bytes length, a 12 bytes nonce, a 9 bytes frame header and a 16 bytes tag
+	lastFrame := 4 + 12 + 9 + len(plaintext) - 3*(64<<10) + 16
+	// the first frame follows the 16 bytes magic line and the 16 bytes backup ID
+	header, fullFrame := 16+16, 4+12+9+(64<<10)+16
+	spliced := append([]byte{}, encrypted...)
+	copy(spliced[header:header+fullFrame], encrypt(t, plaintext)[header:header+fullFrame])
+
+	tests := []struct {
+		name        string
+		input       []byte
+		key         string
+		expected    []byte
+		expectedErr error
+	}{
+		{
+			name:     "should decrypt a backup spanning several frames",
+			input:    encrypted,
+			key:      key,
+			expected: plaintext,
+		},
+		{
+			name:     "should decrypt an empty backup",
+			input:    encrypt(t, nil),
+			key:      key,
+			expected: []byte{},
+		},
+		{
+			name:        "should reject a backup encrypted with another key",
+			input:       encrypted,
+			key:         "abcdef0123456789abcdef0123456789",
+			expectedErr: backup.ErrInvalidBackup,
+		},
+		{
+			name:        "should reject a frame spliced from another backup",
+			input:       spliced,
+			key:         key,
+			expectedErr: backup.ErrInvalidBackup,
+		},
+		{
+			name:        "should reject a truncated backup",
+			input:       encrypted[:len(encrypted)-100],
+			key:         key,
+			expectedErr: backup.ErrTruncatedBackup,
+		},
+		{
+			name:        "should reject a backup missing its last frame",
+			input:       encrypted[:len(encrypted)-lastFrame],
+			key:         key,
+			expectedErr: backup.ErrTruncatedBackup,
+		},
+		{
+			name:        "should reject data after the last frame",
+			input:       append(append([]byte{}, encrypted...), 0),
+			key:         key,
+			expectedErr: backup.ErrInvalidBackup,
+		},
+		{
+			name:        "should reject a file which is not a backup",
+			input:       []byte("not a backup"),
+			key:         key,
+			expectedErr: backup.ErrInvalidBackup,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := io.ReadAll(backup.NewReader(bytes.NewReader(tt.input), zypher.NewCipher(tt.key)))
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected error %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error reading backup: %v", err)
+			}
+			if !bytes.Equal(out, tt.expected) {
+				t.Errorf("expected the decrypted backup to match the plaintext")
+			}
+		})
+	}
+}
+
+func TestWriteFileAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := store.NewBBoltStore(filepath.Join(dir, "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	if _, err := s.Put(context.Background(), store.DefaultBucket, "twitter#prd", []byte("secret")); err != nil {
+		t.Fatalf("error setting value: %v", err)
+	}
+
+	backupDir := filepath.Join(dir, "backups")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var paths []string
+	for i := 0; i < 4; i++ {
+		path, err := backup.WriteFile(backupDir, s, zypher.NewCipher(key), start.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("error writing backup: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	if filepath.Base(paths[0]) != "zypher-20240101T000000Z.backup" {
+		t.Errorf("expected backup to be named after its time, got %s", paths[0])
+	}
+
+	removed, err := backup.Prune(backupDir, 2)
+	if err != nil {
+		t.Fatalf("error pruning backups: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != paths[0] || removed[1] != paths[1] {
+		t.Errorf("expected the 2 oldest backups to be removed, got %v", removed)
+	}
+
+	dbPath := filepath.Join(dir, "zypher.db")
+	f, err := os.Open(paths[3])
+	if err != nil {
+		t.Fatalf("error opening backup: %v", err)
+	}
+	defer f.Close()
+	if err := backup.Restore(f, zypher.NewCipher(key), dbPath); err == nil {
+		t.Fatalf("expected restoring a db file in use to fail")
+	}
+
+	if _, err := s.Put(context.Background(), store.DefaultBucket, "twitter#prd", []byte("changed")); err != nil {
+		t.Fatalf("error setting value: %v", err)
+	}
+	s.Close()
+	if err := backup.Restore(f, zypher.NewCipher(key), dbPath); err != nil {
+		t.Fatalf("error restoring backup: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".pre-restore"); err != nil {
+		t.Errorf("expected the replaced db file to be kept: %v", err)
+	}
+	restored, err := store.NewBBoltStore(dbPath)
+	if err != nil {
+		t.Fatalf("error opening restored store: %v", err)
+	}
+	defer restored.Close()
+	if e, err := restored.Get(context.Background(), store.DefaultBucket, "twitter#prd"); err != nil || string(e.Value) != "secret" {
+		t.Errorf("expected the restored value to be secret, got %+v %v", e, err)
+	}
+}
+
+func TestRestore_rejectsInvalidSnapshot(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "zypher.db")
+	input := bytes.NewReader(encrypt(t, []byte("not a bbolt db")))
+	if err := backup.Restore(input, zypher.NewCipher(key), dbPath); err == nil {
+		t.Errorf("expected an invalid snapshot to be rejected")
+	}
+	if _, err := os.Stat(dbPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no db file to be created, got %v", err)
+	}
+}
@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+const (
+	filePrefix = "zypher-"
+	fileSuffix = ".backup"
+	timeFormat = "20060102T150405Z"
+)
+
+// FileName returns the name of a backup taken at t e.g. zypher-20240101T000000Z.backup.
+func FileName(t time.Time) string {
+	return filePrefix + t.UTC().Format(timeFormat) + fileSuffix
+}
+
+// Write writes an encrypted snapshot of the store to w.
+func Write(w io.Writer, s store.Snapshotter, c Cipher) error {
+	bw := NewWriter(w, c)
+	if _, err := s.Snapshot(bw); err != nil {
+		return err
+	}
+	return bw.Close()
+}
+
+// WriteFile writes an encrypted snapshot of the store to a file of dir named after the time
+// and returns its path. The file only appears once the snapshot is complete.
+func WriteFile(dir string, s store.Snapshotter, c Cipher, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("error creating backup dir %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, FileName(now))
+	tmp, err := os.CreateTemp(dir, ".zypher-backup-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating backup file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := Write(tmp, s, c); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("error syncing backup file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("error closing backup file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("error renaming backup file: %w", err)
+	}
+	return path, nil
+}
+
+// Prune removes all but the keep most recent backups written by WriteFile to dir and returns their paths.
+func Prune(dir string, keep int) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup dir %s: %w", dir, err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), filePrefix) && strings.HasSuffix(e.Name(), fileSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= keep {
+		return nil, nil
+	}
+	// names sort chronologically by their timestamp
+	sort.Strings(names)
+	var removed []string
+	for _, name := range names[:len(names)-keep] {
+		path := filepath.Join(dir, name)
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("error removing backup %s: %w", path, err)
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// Restore decrypts the backup read from r and replaces the db file at dbPath with it once it is verified.
+// The replaced db file is kept at dbPath with a .pre-restore suffix.
+// The db file must not be in use by a running server.
+func Restore(r io.Reader, c Cipher, dbPath string) error {
+	inUse, err := store.BBoltInUse(dbPath)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return fmt.Errorf("%s is in use, stop the server before restoring", dbPath)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dbPath), filepath.Base(dbPath)+".restore-*")
+	if err != nil {
+		return fmt.Errorf("error creating restore file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, NewReader(r, c)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error decrypting backup: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing restore file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing restore file: %w", err)
+	}
+	if err := store.VerifyBBolt(tmp.Name()); err != nil {
+		return fmt.Errorf("error verifying backup: %w", err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".pre-restore"); err != nil {
+			return fmt.Errorf("error keeping the replaced db file: %w", err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), dbPath); err != nil {
+		return fmt.Errorf("error replacing db file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/backup"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBackupKeyPath = "zypher-backup.key"
+	defaultBackupDir     = "backups"
+	// DefaultBackupRetention is the number of scheduled backups kept by default.
+	DefaultBackupRetention = 7
+)
+
+// WithBackupKey serves encrypted snapshots of the store on GET /sys/backup to admins.
+func WithBackupKey(c backup.Cipher) ServerOption {
+	return func(s *Server) {
+		s.backupCipher = c
+	}
+}
+
+// WithScheduledBackups writes an encrypted snapshot of the store to dir every interval
+// and keeps the retention most recent ones. It requires WithBackupKey.
+func WithScheduledBackups(dir string, interval time.Duration, retention int) ServerOption {
+	return func(s *Server) {
+		s.backupDir = dir
+		s.backupInterval = interval
+		s.backupRetention = retention
+	}
+}
+
+// startBackups writes backups in background every backup interval until the server is stopped.
+func (s *Server) startBackups() {
+	if s.backupInterval <= 0 || s.backupCipher == nil || s.snapshotter == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopBackups = cancel
+	go func() {
+		ticker := time.NewTicker(s.backupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				s.writeBackup(now)
+			}
+		}
+	}()
+}
+
+func (s *Server) writeBackup(now time.Time) {
+	path, err := backup.WriteFile(s.backupDir, s.snapshotter, s.backupCipher, now)
+	if err != nil {
+		s.logger.Error("error writing scheduled backup", zap.Error(err))
+		return
+	}
+	s.logger.Info("wrote scheduled backup", zap.String("path", path))
+	removed, err := backup.Prune(s.backupDir, s.backupRetention)
+	if err != nil {
+		s.logger.Error("error pruning backups", zap.Error(err))
+	}
+	for _, path := range removed {
+		s.logger.Info("removed expired backup", zap.String("path", path))
+	}
+}
+
+// snapshotterOf returns the store as a Snapshotter if it supports snapshots.
+func snapshotterOf(s store.Store) store.Snapshotter {
+	if snapshotter, ok := s.(store.Snapshotter); ok {
+		return snapshotter
+	}
+	return nil
+}
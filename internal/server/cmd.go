@@ -1,17 +1,32 @@
 package server
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
+	"syscall"
 
+	"github.com/vtno/zypher/client"
 	"github.com/vtno/zypher/internal/config"
+	"github.com/vtno/zypher/internal/keyserver"
+	"github.com/vtno/zypher/internal/server/audit"
 	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/keyring"
+	"github.com/vtno/zypher/internal/server/metrics"
 	"github.com/vtno/zypher/internal/server/provider"
+	"github.com/vtno/zypher/internal/server/ratelimit"
+	"github.com/vtno/zypher/internal/server/replication"
 	"github.com/vtno/zypher/internal/server/store"
+	"github.com/vtno/zypher/internal/server/webhook"
+	"github.com/vtno/zypher/internal/tracing"
 	"go.uber.org/zap"
 )
 
@@ -20,7 +35,62 @@ type ServerCmd struct{}
 const (
 	HelpMsg = `Usage: zypher server [options]
     -p, --port          a port to start the server. default: 8080
-		    --rootKeyPath		a path of root public key. default: ~/.ssh/id_rsa.pub
+		    --store			the store of the keys: bbolt://<path>, file://<dir> or sqlite://<path>.
+					default: bbolt://zypher.db
+		    --store-key-file		a path of the key encrypting the files of a file:// store. default: zypher-store.key
+		    --rootKeyPath		a path of root public key, optional with --trusted-user-ca or --client-ca.
+					default: ~/.ssh/id_rsa.pub
+		    --clock-skew		maximum age of a signed request token. default: 5m
+		    --trusted-user-ca		a path of trusted SSH user CA public keys
+		    --principal-roles		comma separated principal=role pairs granted to certificate principals
+					roles: admin, writer, reader, replicator. e.g. alice=admin,ci=reader
+		    --revoked-keys		a path of revoked certificates and keys in ssh-keygen -k specification format
+		    --tls-cert			a path of the PEM encoded TLS certificate. reloaded on SIGHUP
+		    --tls-key			a path of the PEM encoded TLS private key. reloaded on SIGHUP
+		    --client-ca			a path of PEM encoded CA certificates required to sign client certificates.
+					client certificate subjects are mapped to roles with --principal-roles
+		    --tls-self-signed		serves TLS with a certificate generated at startup. for development only
+		    --rotation-interval		how often keys are checked against their rotation policy. 0 disables rotation. default: 1h
+		    --expiry-sweep-interval	how often expired keys are purged. 0 disables purging. default: 5m
+		    --expiry-grace		how long expired keys are kept, answered with 410, before they are purged. default: 24h
+		    --audit-log			a path of the hash-chained audit log of every request. empty disables auditing.
+					default: zypher-audit.jsonl
+		    --metrics			serves Prometheus metrics on GET /metrics of the API, without authentication.
+					anyone reaching the API can read them, use --metrics-addr to keep them private
+		    --metrics-addr		serves Prometheus metrics on a separate listen address instead. e.g. :9090
+		    --tracing			exports OpenTelemetry traces of requests to stdout, stderr, file://<path>
+					or an OTLP/HTTP collector url e.g. http://localhost:4318
+		    --ip-rate-limit		requests per second allowed from a source IP. 0 disables it. default: 10
+		    --ip-rate-burst		bursts of requests allowed from a source IP. default: 20
+		    --identity-rate-limit	requests per second allowed from an identity. 0 disables it. default: 20
+		    --identity-rate-burst	bursts of requests allowed from an identity. default: 40
+		    --lockout-threshold		consecutive signature failures locking a source IP out. 0 disables it. default: 5
+		    --lockout-base		first lockout, doubled on every further failure. default: 10s
+		    --lockout-max		maximum lockout. default: 15m
+		    --backup-key-file		a path of the key encrypting backups. GET /sys/backup is served to admins when it exists.
+					default: zypher-backup.key
+		    --backup-interval		how often a backup is written to --backup-dir. 0 disables scheduled backups. default: 0
+		    --backup-dir		a dir of scheduled backups. default: backups
+		    --backup-retention		number of scheduled backups kept. default: 7
+		    --change-log		records a change log of every mutation of a bbolt:// store served to followers
+					at /sys/replication. followers always record one
+		    --change-log-compaction-interval	how often the change log is truncated up to the oldest change
+					replicated by a follower. 0 disables compaction. default: 1m
+		    --change-log-retention	how long the changes a follower has not replicated are kept since it was
+					last seen. followers behind load a snapshot instead. default: 1h
+		    --follow			a url of the primary key server to replicate as a read-only follower
+		    --replication-key		a path of the private key signing requests to the primary.
+					it must be granted the replicator role. default: zypher
+		    --replication-ca		a path of PEM encoded CA certificates trusted to serve the primary
+		    --forward-writes		forwards writes to the primary instead of rejecting them with 421
+		    --webhooks			comma separated urls POSTed a signed JSON event on every key change
+					and failed authentication burst. GET /sys/webhooks/deliveries is served to admins
+		    --webhook-secret-file	a path of the secret signing webhook events. default: zypher-webhook.key
+		    --webhook-events		comma separated events delivered: key.created, key.updated, key.rotated,
+					key.deleted, key.expired, auth.failure_burst. default: every event
+		    --webhook-prefix		only delivers the events of keys under a path prefix e.g. /prod/
+		    --auth-failure-burst	failed authentications from a source IP notified as a burst. 0 disables it. default: 10
+		    --auth-failure-window	the window of failed authentications counted in a burst. default: 1m
     `
 	Synopsis = "starts a key server"
 )
@@ -43,16 +113,102 @@ func (s *ServerCmd) Run(arg []string) int {
 
 	fs := flag.NewFlagSet("server", flag.ContinueOnError)
 	fs.IntVar(&cfg.Port, "port", 8080, "a port to start the server")
+	fs.StringVar(&cfg.Store, "store", defaultStore, "the store of the keys: bbolt://<path>, file://<dir> or sqlite://<path>")
+	fs.StringVar(&cfg.StoreKeyPath, "store-key-file", defaultStoreKeyPath, "a path of the key encrypting the files of a file:// store")
 	fs.StringVar(&cfg.RootPubKeyPath, "rootKeyPath", "zypher.pub", "a path of root public key")
+	fs.DurationVar(&cfg.ClockSkew, "clock-skew", auth.DefaultClockSkew, "maximum age of a signed request token")
+	fs.StringVar(&cfg.TrustedUserCAPath, "trusted-user-ca", "", "a path of trusted SSH user CA public keys")
+	fs.StringVar(&cfg.PrincipalRoles, "principal-roles", "", "comma separated principal=role pairs")
+	fs.StringVar(&cfg.RevokedKeysPath, "revoked-keys", "", "a path of revoked certificates and keys")
+	fs.StringVar(&cfg.TLSCertPath, "tls-cert", "", "a path of the PEM encoded TLS certificate")
+	fs.StringVar(&cfg.TLSKeyPath, "tls-key", "", "a path of the PEM encoded TLS private key")
+	fs.StringVar(&cfg.ClientCAPath, "client-ca", "", "a path of PEM encoded CA certificates required to sign client certificates")
+	fs.BoolVar(&cfg.TLSSelfSigned, "tls-self-signed", false, "serves TLS with a certificate generated at startup")
+	fs.StringVar(&cfg.AuditLogPath, "audit-log", defaultAuditLogPath, "a path of the hash-chained audit log of every request")
+	fs.DurationVar(&cfg.RotationInterval, "rotation-interval", DefaultRotationInterval, "how often keys are checked against their rotation policy")
+	fs.DurationVar(&cfg.ExpirySweepInterval, "expiry-sweep-interval", DefaultExpirySweepInterval, "how often expired keys are purged")
+	fs.DurationVar(&cfg.ExpiryGrace, "expiry-grace", DefaultExpiryGrace, "how long expired keys are kept before they are purged")
+	fs.BoolVar(&cfg.Metrics, "metrics", false, "serves Prometheus metrics on GET /metrics of the API, without authentication")
+	fs.StringVar(&cfg.MetricsAddr, "metrics-addr", "", "serves Prometheus metrics on a separate listen address")
+	fs.StringVar(&cfg.Tracing, "tracing", "", "exports OpenTelemetry traces of requests")
+	fs.Float64Var(&cfg.IPRate, "ip-rate-limit", DefaultIPRate, "requests per second allowed from a source IP")
+	fs.IntVar(&cfg.IPBurst, "ip-rate-burst", DefaultIPBurst, "bursts of requests allowed from a source IP")
+	fs.Float64Var(&cfg.IdentityRate, "identity-rate-limit", DefaultIdentityRate, "requests per second allowed from an identity")
+	fs.IntVar(&cfg.IdentityBurst, "identity-rate-burst", DefaultIdentityBurst, "bursts of requests allowed from an identity")
+	fs.IntVar(&cfg.LockoutThreshold, "lockout-threshold", DefaultLockoutThreshold, "consecutive signature failures locking a source IP out")
+	fs.DurationVar(&cfg.LockoutBase, "lockout-base", DefaultLockoutBase, "first lockout, doubled on every further failure")
+	fs.DurationVar(&cfg.LockoutMax, "lockout-max", DefaultLockoutMax, "maximum lockout")
+	fs.StringVar(&cfg.BackupKeyPath, "backup-key-file", defaultBackupKeyPath, "a path of the key encrypting backups")
+	fs.DurationVar(&cfg.BackupInterval, "backup-interval", 0, "how often a backup is written to --backup-dir")
+	fs.StringVar(&cfg.BackupDir, "backup-dir", defaultBackupDir, "a dir of scheduled backups")
+	fs.IntVar(&cfg.BackupRetention, "backup-retention", DefaultBackupRetention, "number of scheduled backups kept")
+	fs.BoolVar(&cfg.ChangeLog, "change-log", false, "records a change log of every mutation served to followers")
+	fs.DurationVar(&cfg.ChangeLogCompactionInterval, "change-log-compaction-interval", DefaultChangeLogCompactionInterval, "how often the change log is truncated")
+	fs.DurationVar(&cfg.ChangeLogRetention, "change-log-retention", DefaultChangeLogRetention, "how long the changes a follower has not replicated are kept")
+	fs.StringVar(&cfg.Follow, "follow", "", "a url of the primary key server to replicate as a read-only follower")
+	fs.StringVar(&cfg.ReplicationKeyPath, "replication-key", "zypher", "a path of the private key signing requests to the primary")
+	fs.StringVar(&cfg.ReplicationCAPath, "replication-ca", "", "a path of PEM encoded CA certificates trusted to serve the primary")
+	fs.BoolVar(&cfg.ForwardWrites, "forward-writes", false, "forwards writes to the primary instead of rejecting them")
+	fs.StringVar(&cfg.Webhooks, "webhooks", "", "comma separated urls POSTed a signed JSON event on every key change")
+	fs.StringVar(&cfg.WebhookSecretPath, "webhook-secret-file", defaultWebhookSecretPath, "a path of the secret signing webhook events")
+	fs.StringVar(&cfg.WebhookEvents, "webhook-events", "", "comma separated events delivered")
+	fs.StringVar(&cfg.WebhookPrefix, "webhook-prefix", "", "only delivers the events of keys under a path prefix")
+	fs.IntVar(&cfg.AuthFailureBurst, "auth-failure-burst", webhook.DefaultAuthFailureBurst, "failed authentications from a source IP notified as a burst")
+	fs.DurationVar(&cfg.AuthFailureWindow, "auth-failure-window", webhook.DefaultAuthFailureWindow, "the window of failed authentications counted in a burst")
+	if err := fs.Parse(arg); err != nil {
+		fmt.Printf("error parsing flags: %v", err)
+		return 1
+	}
+
+	st, err := openStore(cfg)
+	if err != nil {
+		fmt.Printf("error opening store: %v", err)
+		return 1
+	}
+	if cfg.ChangeLog && changeLogOf(st) == nil {
+		fmt.Printf("error opening store: %s does not record a change log", cfg.Store)
+		return 1
+	}
+
+	// followers replicate the keys migrated by their primary
+	if cfg.Follow == "" {
+		migrated, skipped, err := keyring.New(st).MigrateLegacy(ctx)
+		if err != nil {
+			fmt.Printf("error migrating name#env keys to paths: %v", err)
+			return 1
+		}
+		if len(migrated) > 0 {
+			fmt.Printf("migrated %d name#env keys to paths\n", len(migrated))
+		}
+		for _, lookupKey := range skipped {
+			fmt.Printf("kept %s as is, its name is not a valid path or its path is taken\n", lookupKey)
+		}
+	}
 
-	bbStore, err := store.NewBBoltStore(defaultDbPath)
+	principalRoles, err := auth.ParsePrincipalRoles(cfg.PrincipalRoles)
 	if err != nil {
-		fmt.Printf("error creating bbStore: %v", err)
+		fmt.Printf("error parsing principal roles: %v", err)
 		return 1
 	}
 
-	pkProvider := provider.NewPubKeyProvider(cfg.RootPubKeyPath)
-	a, err := auth.NewAuth(bbStore, auth.WithPubKeyProvider(pkProvider))
+	authOpts := []auth.AuthOption{auth.WithClockSkew(cfg.ClockSkew)}
+	// the root key is optional when requests can be authenticated with SSH or TLS client certificates
+	certificates := cfg.TrustedUserCAPath != "" || cfg.ClientCAPath != ""
+	if _, err := os.Stat(cfg.RootPubKeyPath); err == nil || !certificates {
+		authOpts = append(authOpts, auth.WithPubKeyProvider(provider.NewPubKeyProvider(cfg.RootPubKeyPath)))
+	}
+	if cfg.TrustedUserCAPath != "" {
+		caOpt, err := trustedUserCAOption(cfg, principalRoles)
+		if err != nil {
+			fmt.Printf("error configuring trusted user CA: %v", err)
+			return 1
+		}
+		authOpts = append(authOpts, caOpt)
+	}
+	if cfg.ClientCAPath != "" {
+		authOpts = append(authOpts, auth.WithClientCertificates(principalRoles))
+	}
+	a, err := auth.NewAuth(st, authOpts...)
 	if err != nil {
 		fmt.Printf("error creating auth: %v", err)
 		return 1
@@ -64,9 +220,85 @@ func (s *ServerCmd) Run(arg []string) int {
 		return 1
 	}
 
-	srv, err := NewServer(bbStore, a, logger)
+	srvOpts := []ServerOption{
+		WithPort(cfg.Port),
+		WithRotationInterval(cfg.RotationInterval),
+		WithExpirySweep(cfg.ExpirySweepInterval, cfg.ExpiryGrace),
+		WithChangeLogCompaction(cfg.ChangeLogCompactionInterval, cfg.ChangeLogRetention),
+	}
+	if cfg.TLSSelfSigned || cfg.TLSCertPath != "" || cfg.ClientCAPath != "" {
+		reloader, err := newTLSReloader(cfg)
+		if err != nil {
+			fmt.Printf("error configuring TLS: %v", err)
+			return 1
+		}
+		srvOpts = append(srvOpts, WithTLSConfig(reloader.Config()))
+		reloadOnHangup(reloader, logger)
+	}
+
+	if cfg.AuditLogPath != "" {
+		auditLog, err := audit.OpenLog(cfg.AuditLogPath, audit.WithLogger(logger))
+		if err != nil {
+			fmt.Printf("error opening audit log: %v", err)
+			return 1
+		}
+		fmt.Printf("audit log head is %s\n", auditLog.Head())
+		srvOpts = append(srvOpts, WithAuditLog(auditLog))
+	}
+
+	if cfg.IPRate > 0 {
+		srvOpts = append(srvOpts, WithIPRateLimit(ratelimit.NewLimiter(cfg.IPRate, cfg.IPBurst)))
+	}
+	if cfg.IdentityRate > 0 {
+		srvOpts = append(srvOpts, WithIdentityRateLimit(ratelimit.NewLimiter(cfg.IdentityRate, cfg.IdentityBurst)))
+	}
+	if cfg.LockoutThreshold > 0 {
+		srvOpts = append(srvOpts, WithLockout(ratelimit.NewLockout(cfg.LockoutThreshold, cfg.LockoutBase, cfg.LockoutMax)))
+	}
+
+	backupOpts, err := backupOptions(cfg)
 	if err != nil {
-		fmt.Printf("error creating a server %v", err)
+		fmt.Printf("error configuring backups: %v", err)
+		return 1
+	}
+	srvOpts = append(srvOpts, backupOpts...)
+
+	if cfg.Follow != "" {
+		followOpts, err := followerOptions(cfg, st, logger)
+		if err != nil {
+			fmt.Printf("error configuring replication: %v", err)
+			return 1
+		}
+		srvOpts = append(srvOpts, followOpts...)
+	}
+
+	if cfg.Webhooks != "" {
+		webhookOpts, err := webhookOptions(cfg, st, logger)
+		if err != nil {
+			fmt.Printf("error configuring webhooks: %v", err)
+			return 1
+		}
+		srvOpts = append(srvOpts, webhookOpts...)
+	}
+
+	if cfg.Metrics || cfg.MetricsAddr != "" {
+		srvOpts = append(srvOpts, WithMetrics(metrics.New(), cfg.MetricsAddr))
+	}
+
+	if cfg.Tracing != "" {
+		shutdown, err := tracing.Setup(ctx, "zypher-server", cfg.Tracing)
+		if err != nil {
+			fmt.Printf("error configuring tracing: %v", err)
+			return 1
+		}
+		defer shutdown(ctx)
+		srvOpts = append(srvOpts, WithTracing())
+	}
+
+	srv, err := NewServer(st, a, logger, srvOpts...)
+	if err != nil {
+		fmt.Printf("error creating a server: %v\n", err)
+		return 1
 	}
 
 	sig := make(chan os.Signal, 1)
@@ -84,3 +316,173 @@ func (s *ServerCmd) Run(arg []string) int {
 
 	return 0
 }
+
+func trustedUserCAOption(cfg *config.ServerConfig, principalRoles map[string][]auth.Role) (auth.AuthOption, error) {
+	var revoked *auth.RevocationList
+	if cfg.RevokedKeysPath != "" {
+		f, err := os.Open(cfg.RevokedKeysPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening revoked keys: %w", err)
+		}
+		defer f.Close()
+		revoked, err = auth.ParseRevocationList(f)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing revoked keys: %w", err)
+		}
+	}
+	return auth.WithTrustedUserCA(provider.NewTrustedCAProvider(cfg.TrustedUserCAPath), principalRoles, revoked), nil
+}
+
+// backupOptions serves backups when the backup key exists and schedules them when an interval is set.
+// The default backup key is optional unless backups are scheduled.
+func backupOptions(cfg *config.ServerConfig) ([]ServerOption, error) {
+	_, err := os.Stat(cfg.BackupKeyPath)
+	if errors.Is(err, os.ErrNotExist) && cfg.BackupKeyPath == defaultBackupKeyPath && cfg.BackupInterval <= 0 {
+		return nil, nil
+	}
+	c, err := loadBackupKey(cfg.BackupKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	opts := []ServerOption{WithBackupKey(c)}
+	if cfg.BackupInterval > 0 {
+		opts = append(opts, WithScheduledBackups(cfg.BackupDir, cfg.BackupInterval, cfg.BackupRetention))
+	}
+	return opts, nil
+}
+
+// webhookOptions delivers events to the webhooks signed with the webhook secret.
+// Followers don't deliver webhooks, their primary does.
+func webhookOptions(cfg *config.ServerConfig, st store.Store, logger *zap.Logger) ([]ServerOption, error) {
+	if cfg.Follow != "" {
+		return nil, fmt.Errorf("--webhooks cannot be used with --follow, configure them on the primary")
+	}
+	secret, err := os.ReadFile(cfg.WebhookSecretPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading webhook secret at %s: %w", cfg.WebhookSecretPath, err)
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("empty webhook secret at %s", cfg.WebhookSecretPath)
+	}
+	urls := strings.Split(cfg.Webhooks, ",")
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("invalid webhook url %q", u)
+		}
+	}
+	opts := []webhook.DispatcherOption{
+		webhook.WithAuthFailureBurst(cfg.AuthFailureBurst, cfg.AuthFailureWindow),
+		webhook.WithLogger(logger),
+	}
+	if cfg.WebhookEvents != "" {
+		events := strings.Split(cfg.WebhookEvents, ",")
+		for _, e := range events {
+			switch e {
+			case webhook.KeyCreated, webhook.KeyUpdated, webhook.KeyRotated, webhook.KeyDeleted, webhook.KeyExpired,
+				webhook.AuthFailureBurst:
+			default:
+				return nil, fmt.Errorf("unknown webhook event %q", e)
+			}
+		}
+		opts = append(opts, webhook.WithEvents(events...))
+	}
+	if cfg.WebhookPrefix != "" {
+		opts = append(opts, webhook.WithPrefix(cfg.WebhookPrefix))
+	}
+	return []ServerOption{WithWebhooks(webhook.NewDispatcher(urls, secret, st, opts...))}, nil
+}
+
+// openStore opens the store selected by cfg.Store, recording a change log if it is served to followers
+// or if the server is a follower which may be promoted.
+func openStore(cfg *config.ServerConfig) (store.Store, error) {
+	var opts []store.BBoltOption
+	if cfg.ChangeLog || cfg.Follow != "" {
+		opts = append(opts, store.WithChangeLog())
+	}
+	return openStoreURL(cfg.Store, cfg.StoreKeyPath, opts...)
+}
+
+// openStoreURL opens the store at u e.g. bbolt://zypher.db, file stores are encrypted with the key at keyPath.
+// bbolt stores are opened with the options.
+func openStoreURL(u, keyPath string, bboltOpts ...store.BBoltOption) (store.Migratable, error) {
+	scheme, path, found := strings.Cut(u, "://")
+	if !found || path == "" {
+		return nil, fmt.Errorf("invalid store %q, expected <scheme>://<path>", u)
+	}
+	switch scheme {
+	case "bbolt":
+		return store.NewBBoltStore(path, bboltOpts...)
+	case "file":
+		c, err := loadKey(keyPath, "store")
+		if err != nil {
+			return nil, err
+		}
+		return store.NewFileStore(path, c)
+	case "sqlite":
+		return store.NewSQLiteStore(path)
+	}
+	return nil, fmt.Errorf("unknown store scheme %s, expected bbolt, file or sqlite", scheme)
+}
+
+// followerOptions replicates the primary at cfg.Follow into the store,
+// signing requests with the replication key and optionally forwarding writes to the primary.
+func followerOptions(cfg *config.ServerConfig, st store.Store, logger *zap.Logger) ([]ServerOption, error) {
+	log, ok := st.(store.ChangeLog)
+	if !ok || !log.Recording() {
+		return nil, fmt.Errorf("%s does not record a change log to replicate", cfg.Store)
+	}
+	primaryURL, err := url.Parse(cfg.Follow)
+	if err != nil || primaryURL.Scheme == "" || primaryURL.Host == "" {
+		return nil, fmt.Errorf("invalid primary url %q", cfg.Follow)
+	}
+	httpClient, err := keyserver.HTTPClient(cfg.ReplicationCAPath)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := client.NewFileSigner(cfg.ReplicationKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	primary := client.New(cfg.Follow, signer, client.WithHTTPClient(httpClient))
+	opts := []ServerOption{WithFollower(replication.NewFollower(primary, log, logger), primaryURL)}
+	if cfg.ForwardWrites {
+		transport := httpClient.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		opts = append(opts, WithWriteForwarding(transport))
+	}
+	return opts, nil
+}
+
+func newTLSReloader(cfg *config.ServerConfig) (*TLSReloader, error) {
+	if cfg.TLSSelfSigned {
+		reloader, err := NewSelfSignedTLSReloader(cfg.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint := sha256.Sum256(reloader.Certificate().Leaf.Raw)
+		fmt.Printf("serving a self-signed certificate with SHA256 fingerprint %x\n", fingerprint)
+		return reloader, nil
+	}
+	if cfg.TLSCertPath == "" || cfg.TLSKeyPath == "" {
+		return nil, fmt.Errorf("both --tls-cert and --tls-key are required")
+	}
+	return NewTLSReloader(cfg.TLSCertPath, cfg.TLSKeyPath, cfg.ClientCAPath)
+}
+
+// reloadOnHangup reloads the TLS certificate, key and client CAs whenever the process receives SIGHUP.
+func reloadOnHangup(reloader *TLSReloader, logger *zap.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				logger.Error("error reloading TLS certificates", zap.Error(err))
+				continue
+			}
+			logger.Info("reloaded TLS certificates")
+		}
+	}()
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/audit"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultExpirySweepInterval is how often expired keys are looked for by default.
+	DefaultExpirySweepInterval = 5 * time.Minute
+	// DefaultExpiryGrace is how long expired keys are kept before they are purged by default.
+	DefaultExpiryGrace = 24 * time.Hour
+)
+
+// WithExpirySweep purges the keys expired for longer than grace every interval.
+// Expired keys are answered with 410 until they are purged. The sweep is disabled when interval is zero.
+func WithExpirySweep(interval, grace time.Duration) ServerOption {
+	return func(s *Server) {
+		s.expirySweepInterval = interval
+		s.expiryGrace = grace
+	}
+}
+
+// startExpirySweep purges expired keys in background every sweep interval until the server is stopped.
+func (s *Server) startExpirySweep() {
+	if s.expirySweepInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopExpirySweep = cancel
+	go func() {
+		ticker := time.NewTicker(s.expirySweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.purgeExpiredKeys()
+			}
+		}
+	}()
+}
+
+// purgeExpiredKeys purges the keys expired for longer than the grace period and records them in the audit log
+// unless the server follows a primary, which purges them instead.
+func (s *Server) purgeExpiredKeys() {
+	if s.isFollowing() {
+		return
+	}
+	purged, err := s.keyring.PurgeExpired(context.Background(), s.expiryGrace)
+	for _, path := range purged {
+		s.logger.Info("purged expired key", zap.String("key", path))
+		if s.auditLog == nil {
+			continue
+		}
+		i := strings.LastIndex(path, "/")
+		if err := s.auditLog.Append(audit.Event{
+			RequestID: newRequestID(),
+			Action:    "key.expire",
+			Path:      path,
+			Name:      strings.TrimPrefix(path[:i], "/"),
+			Env:       path[i+1:],
+			Result:    audit.ResultSuccess,
+		}); err != nil {
+			s.logger.Error("error appending audit event", zap.String("key", path), zap.Error(err))
+		}
+	}
+	for _, err := range unjoin(err) {
+		s.logger.Error("error purging expired keys", zap.Error(err))
+	}
+}
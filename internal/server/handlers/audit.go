@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/audit"
+)
+
+type AuditHandler struct {
+	log *audit.Log
+}
+
+type AuditResponse struct {
+	Events []audit.Event `json:"events"`
+	// Head is the last event of the log. It can be recorded to later detect truncation with zypher audit verify.
+	Head audit.Head `json:"head"`
+}
+
+func NewAuditHandler(log *audit.Log) *AuditHandler {
+	return &AuditHandler{
+		log: log,
+	}
+}
+
+// Query returns the audit events matching the identity, action, path, name, env, since, until and limit query parameters.
+// since and until are RFC 3339 timestamps.
+func (ah *AuditHandler) Query(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	filter := audit.Filter{
+		Identity: params.Get("identity"),
+		Action:   params.Get("action"),
+		Path:     params.Get("path"),
+		Name:     params.Get("name"),
+		Env:      params.Get("env"),
+	}
+	var err error
+	if v := params.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := ah.log.Query(filter)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := json.Marshal(&AuditResponse{
+		Events: events,
+		Head:   ah.log.Head(),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/backup"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/zap"
+)
+
+// BackupHandler streams encrypted snapshots of the store.
+type BackupHandler struct {
+	snapshotter store.Snapshotter
+	cipher      backup.Cipher
+}
+
+// NewBackupHandler returns a BackupHandler encrypting snapshots of the store with the cipher.
+func NewBackupHandler(s store.Snapshotter, c backup.Cipher) *BackupHandler {
+	return &BackupHandler{
+		snapshotter: s,
+		cipher:      c,
+	}
+}
+
+// Get streams a consistent snapshot of the store encrypted with the backup key.
+// A snapshot failing midway is detected as truncated on restore since the status has already been sent.
+func (bh *BackupHandler) Get(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*zap.Logger)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backup.FileName(time.Now())))
+	if err := backup.Write(w, bh.snapshotter, bh.cipher); err != nil {
+		logger.Error("error writing backup", zap.Error(err))
+	}
+}
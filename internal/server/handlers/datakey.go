@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/vtno/zypher/internal/keygen"
+	"go.uber.org/zap"
+)
+
+type DataKeyRequest struct {
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+	Env  string `json:"env,omitempty"`
+}
+
+type DataKeyResponse struct {
+	// Key is the plaintext data key. It is never stored on the server.
+	Key string `json:"key"`
+	// WrappedKey is the base64 encoded data key encrypted with the master key.
+	WrappedKey string `json:"wrapped_key"`
+}
+
+type DataKeyUnwrapRequest struct {
+	Path       string `json:"path,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Env        string `json:"env,omitempty"`
+	WrappedKey string `json:"wrapped_key" validate:"required,base64"`
+}
+
+type DataKeyUnwrapResponse struct {
+	Key string `json:"key"`
+}
+
+// DataKey generates a random data key and returns it along with its copy wrapped by the master key by path or name and env.
+// Callers encrypt locally with the data key and keep only the wrapped copy.
+func (th *TransitHandler) DataKey(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	dkr := &DataKeyRequest{
+		Path: params.Get("path"),
+		Name: params.Get("name"),
+		Env:  params.Get("env"),
+	}
+	validate := validator.New()
+	if err := validate.Struct(dkr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	logger := r.Context().Value("logger").(*zap.Logger)
+
+	path, err := keyPath(dkr.Path, dkr.Name, dkr.Env)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ciphers, ok := th.ciphers(w, r, path)
+	if !ok {
+		return
+	}
+	dataKey, err := keygen.GenerateKey()
+	if err != nil {
+		logger.Error("error generating data key", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	wrapped, err := ciphers[0].Encrypt([]byte(dataKey))
+	if err != nil {
+		logger.Error("error wrapping data key", zap.String("key", path), zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res, err := json.Marshal(&DataKeyResponse{
+		Key:        dataKey,
+		WrappedKey: base64.StdEncoding.EncodeToString(wrapped),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	w.Write(res)
+}
+
+// UnwrapDataKey decrypts a data key wrapped by DataKey with any version of the master key by path or name and env.
+// 400 is returned if the data key was not wrapped by the master key.
+func (th *TransitHandler) UnwrapDataKey(w http.ResponseWriter, r *http.Request) {
+	var dur DataKeyUnwrapRequest
+	logger := r.Context().Value("logger").(*zap.Logger)
+
+	if err := json.NewDecoder(r.Body).Decode(&dur); err != nil {
+		logger.Error("error decoding as json", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	validate := validator.New()
+	if err := validate.Struct(dur); err != nil {
+		logger.Error("error validating request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(dur.WrappedKey)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	path, err := keyPath(dur.Path, dur.Name, dur.Env)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ciphers, ok := th.ciphers(w, r, path)
+	if !ok {
+		return
+	}
+	dataKey, err := decryptAny(ciphers, wrapped)
+	if err != nil {
+		logger.Warn("error unwrapping data key", zap.String("key", path), zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	res, err := json.Marshal(&DataKeyUnwrapResponse{
+		Key: string(dataKey),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
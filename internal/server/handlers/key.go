@@ -4,50 +4,189 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/vtno/zypher/internal/server/keyring"
 	"github.com/vtno/zypher/internal/server/store"
 	"github.com/go-playground/validator/v10"
 	"go.uber.org/zap"
 )
 
 type KeyHandler struct {
-	store store.Store
+	store   store.Store
+	keyring *keyring.Keyring
 }
 
 type KeyPostRequest struct {
-	Name string `json:"name" validate:"required"`
-	Env  string `json:"env" validate:"required"`
+	// Path names the key e.g. /payments/stripe/prod, name and env are the path /<name>/<env> when it is empty.
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+	Env  string `json:"env,omitempty"`
 	Key  string `json:"key" validate:"required"`
+	// NonExportable makes GET /key refuse to return the key so it can only be used through the transit API.
+	// Once set it sticks to the key until the key is deleted.
+	NonExportable bool `json:"non_exportable,omitempty"`
+	// RotationPeriod rotates the key automatically once it gets older than the period e.g. 90d.
+	// "0" removes the rotation policy, the current policy is kept when empty.
+	RotationPeriod string `json:"rotation_period,omitempty"`
+	// TTL expires the key once the duration elapsed e.g. 7d, ExpiresAt expires it at a given time.
+	// They are exclusive, a TTL of "0" removes the expiry and the current expiry is kept when both are empty.
+	TTL       string     `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 type KeyGetRequest struct {
-	Name string `json:"name" validate:"required"`
-	Env  string `json:"env" validate:"required"`
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+	Env  string `json:"env,omitempty"`
+	// Version selects a previous version of the key, the current version is returned when zero.
+	Version int `json:"version" validate:"gte=0"`
 }
 
 type KeyGetResponse struct {
-	Key string `json:"key"`
+	Key     string `json:"key"`
+	Version int    `json:"version,omitempty"`
+	KeyRotation
+	KeyExpiry
 }
 
-func NewKeyHandler(store store.Store) *KeyHandler {
+// KeyRotation reports the age of the current version of a key and its rotation policy.
+type KeyRotation struct {
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	// Age is how long ago the current version was created e.g. 2160h0m0s.
+	Age            string `json:"age,omitempty"`
+	RotationPeriod string `json:"rotation_period,omitempty"`
+	// Overdue is true if the key is older than its rotation period.
+	Overdue bool `json:"overdue,omitempty"`
+}
+
+// KeyExpiry reports when a key expires. Expired keys are answered with 410 until they are purged.
+type KeyExpiry struct {
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired,omitempty"`
+}
+
+type KeyDeleteRequest struct {
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+	Env  string `json:"env,omitempty"`
+}
+
+type KeyListEntry struct {
+	Path string `json:"path"`
+	// Name and Env are the namespace and last segment of the path.
+	Name    string `json:"name"`
+	Env     string `json:"env"`
+	Version int    `json:"version,omitempty"`
+	KeyRotation
+	KeyExpiry
+}
+
+type KeyListResponse struct {
+	Keys []KeyListEntry `json:"keys"`
+}
+
+func NewKeyHandler(store store.Store, kr *keyring.Keyring) *KeyHandler {
 	return &KeyHandler{
-		store: store,
+		store:   store,
+		keyring: kr,
+	}
+}
+
+// keyRotation reports the rotation of the key described by the metadata.
+func (kh *KeyHandler) keyRotation(m *keyring.Metadata) KeyRotation {
+	kr := KeyRotation{}
+	now := kh.keyring.Now()
+	if !m.RotatedAt.IsZero() {
+		rotatedAt := m.RotatedAt
+		kr.RotatedAt = &rotatedAt
+		kr.Age = m.Age(now).Round(time.Second).String()
+	}
+	if m.RotationPeriod > 0 {
+		kr.RotationPeriod = m.RotationPeriod.String()
+		kr.Overdue = m.Overdue(now)
+	}
+	return kr
+}
+
+// keyExpiry reports the expiry of the key described by the metadata.
+func (kh *KeyHandler) keyExpiry(m *keyring.Metadata) KeyExpiry {
+	return KeyExpiry{
+		ExpiresAt: m.ExpiresAt,
+		Expired:   m.Expired(kh.keyring.Now()),
+	}
+}
+
+// expiry returns the expiry requested by kpr, nil to keep the current one and a zero time to remove it.
+func (kpr *KeyPostRequest) expiry(now time.Time) (*time.Time, error) {
+	switch {
+	case kpr.TTL != "" && kpr.ExpiresAt != nil:
+		return nil, fmt.Errorf("ttl and expires_at are exclusive")
+	case kpr.TTL != "":
+		ttl, err := keyring.ParsePeriod(kpr.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ttl: %w", err)
+		}
+		if ttl == 0 {
+			return &time.Time{}, nil
+		}
+		expiresAt := now.Add(ttl)
+		return &expiresAt, nil
+	case kpr.ExpiresAt != nil && !kpr.ExpiresAt.After(now):
+		return nil, fmt.Errorf("expires_at %s is in the past", kpr.ExpiresAt.Format(time.RFC3339))
 	}
+	return kpr.ExpiresAt, nil
 }
 
 func (kh *KeyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	params := r.URL.Query()
 	kgr := &KeyGetRequest{
+		Path: params.Get("path"),
 		Name: params.Get("name"),
 		Env:  params.Get("env"),
 	}
+	if v := params.Get("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		kgr.Version = version
+	}
 	validate := validator.New()
 	if err := validate.Struct(kgr); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	lookupKey := fmt.Sprintf("%s#%s", kgr.Name, kgr.Env)
-	v, err := kh.store.Get(lookupKey)
+	path, err := keyPath(kgr.Path, kgr.Name, kgr.Env)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !authorizePath(w, r, kh.keyring, path, false) {
+		return
+	}
+	m, err := kh.keyring.Metadata(ctx, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if m.Expired(kh.keyring.Now()) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+	version := m.Version
+	if kgr.Version != 0 {
+		version = kgr.Version
+	}
+	v, err := kh.keyring.GetVersion(ctx, path, version)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -56,8 +195,20 @@ func (kh *KeyHandler) Get(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	nonExportable, err := kh.keyring.NonExportable(ctx, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if nonExportable {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 	response := &KeyGetResponse{
-		Key: v,
+		Key:         v,
+		Version:     version,
+		KeyRotation: kh.keyRotation(m),
+		KeyExpiry:   kh.keyExpiry(m),
 	}
 	res, err := json.Marshal(response)
 	if err != nil {
@@ -68,6 +219,7 @@ func (kh *KeyHandler) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 func (kh *KeyHandler) Post(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var kpr KeyPostRequest
 	logger := r.Context().Value("logger").(*zap.Logger)
 
@@ -84,11 +236,121 @@ func (kh *KeyHandler) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	lookupKey := fmt.Sprintf("%s#%s", kpr.Name, kpr.Env)
-	if err := kh.store.Set(lookupKey, kpr.Key); err != nil {
+	var period *time.Duration
+	if kpr.RotationPeriod != "" {
+		p, err := keyring.ParsePeriod(kpr.RotationPeriod)
+		if err != nil {
+			logger.Error("error parsing rotation period", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		period = &p
+	}
+	expiresAt, err := kpr.expiry(kh.keyring.Now())
+	if err != nil {
+		logger.Error("error validating key expiry", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	path, err := keyPath(kpr.Path, kpr.Name, kpr.Env)
+	if err != nil {
+		logger.Error("error validating key path", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !authorizePath(w, r, kh.keyring, path, true) {
+		return
+	}
+	if err := kh.keyring.Put(ctx, path, kpr.Key, period, expiresAt, kpr.NonExportable); err != nil {
+		logger.Error("error storing key", zap.Error(err))
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
 }
+
+// List returns the path of every key the identity of the request may read, optionally filtered by a path prefix
+// e.g. /payments/ lists every key below the payments namespace. A prefix without a leading / is relative to the root.
+func (kh *KeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	prefix := r.URL.Query().Get("prefix")
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	paths, err := kh.keyring.List(ctx, prefix)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := &KeyListResponse{
+		Keys: make([]KeyListEntry, 0, len(paths)),
+	}
+	for _, path := range paths {
+		allowed, err := allowedPath(r, kh.keyring, path, false)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			continue
+		}
+		i := strings.LastIndex(path, "/")
+		entry := KeyListEntry{Path: path, Name: path[1:i], Env: path[i+1:]}
+		m, err := kh.keyring.Metadata(ctx, path)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if m != nil {
+			entry.Version = m.Version
+			entry.KeyRotation = kh.keyRotation(m)
+			entry.KeyExpiry = kh.keyExpiry(m)
+		}
+		response.Keys = append(response.Keys, entry)
+	}
+	res, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+func (kh *KeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	params := r.URL.Query()
+	kdr := &KeyDeleteRequest{
+		Path: params.Get("path"),
+		Name: params.Get("name"),
+		Env:  params.Get("env"),
+	}
+	validate := validator.New()
+	if err := validate.Struct(kdr); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	path, err := keyPath(kdr.Path, kdr.Name, kdr.Env)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !authorizePath(w, r, kh.keyring, path, true) {
+		return
+	}
+	current, err := kh.keyring.Get(ctx, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if current == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := kh.keyring.Delete(ctx, path); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/keyring"
+	"go.uber.org/zap"
+)
+
+// keyPath returns the path of a key named either by its path or by its name and env, the name#env of earlier releases.
+func keyPath(path, name, env string) (string, error) {
+	if path != "" {
+		return keyring.ParsePath(path)
+	}
+	if name == "" || env == "" {
+		return "", fmt.Errorf("either a path or a name and env are required")
+	}
+	return keyring.PathOf(name, env)
+}
+
+// authorizePath writes 403 and returns false if the policies of the path deny the identity of the request
+// reading the key, or writing it if write is true.
+func authorizePath(w http.ResponseWriter, r *http.Request, kr *keyring.Keyring, path string, write bool) bool {
+	allowed, err := allowedPath(r, kr, path, write)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+	if !allowed {
+		if logger, ok := r.Context().Value("logger").(*zap.Logger); ok {
+			name := ""
+			if identity, ok := r.Context().Value("identity").(*auth.Identity); ok {
+				name = identity.Name
+			}
+			logger.Warn("denied by policy", zap.String("identity", name), zap.String("key", path))
+		}
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// allowedPath returns true if the policies of the path allow the identity of the request to read the key,
+// or to write it if write is true. Admins are never denied, requests without an identity always are.
+func allowedPath(r *http.Request, kr *keyring.Keyring, path string, write bool) (bool, error) {
+	identity, ok := r.Context().Value("identity").(*auth.Identity)
+	if !ok || identity == nil {
+		return false, nil
+	}
+	if identity.HasRole(auth.RoleAdmin) {
+		return true, nil
+	}
+	p, err := kr.EffectivePolicy(r.Context(), path)
+	if err != nil {
+		return false, err
+	}
+	if write {
+		return p.CanWrite(identity.Name), nil
+	}
+	return p.CanRead(identity.Name), nil
+}
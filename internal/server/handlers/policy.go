@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/keyring"
+	"go.uber.org/zap"
+)
+
+// PolicyHandler attaches policies to namespaces and keys.
+type PolicyHandler struct {
+	keyring *keyring.Keyring
+}
+
+// PolicyRequest attaches a policy to the namespace or key at Path e.g. /payments or /payments/stripe/prod.
+type PolicyRequest struct {
+	Path string `json:"path"`
+	PolicyResponse
+}
+
+type PolicyResponse struct {
+	// Readers and Writers are the identities allowed to read and write the keys, every identity when empty.
+	Readers []string `json:"readers,omitempty"`
+	Writers []string `json:"writers,omitempty"`
+	// RotationPeriod rotates the keys without a rotation period of their own e.g. 90d.
+	RotationPeriod string `json:"rotation_period,omitempty"`
+	NonExportable  bool   `json:"non_exportable,omitempty"`
+}
+
+func NewPolicyHandler(kr *keyring.Keyring) *PolicyHandler {
+	return &PolicyHandler{
+		keyring: kr,
+	}
+}
+
+// Get returns the policy attached to the path, or the policies of every level of the path merged with effective=true.
+func (ph *PolicyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	path, err := keyring.ParseNamespace(params.Get("path"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var p *keyring.Policy
+	if params.Get("effective") == "true" {
+		p, err = ph.keyring.EffectivePolicy(r.Context(), path)
+	} else {
+		p, err = ph.keyring.Policy(r.Context(), path)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	response := &PolicyResponse{
+		Readers:       p.Readers,
+		Writers:       p.Writers,
+		NonExportable: p.NonExportable,
+	}
+	if p.RotationPeriod > 0 {
+		response.RotationPeriod = p.RotationPeriod.String()
+	}
+	res, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+// Put attaches the policy to the path, replacing the previous one.
+func (ph *PolicyHandler) Put(w http.ResponseWriter, r *http.Request) {
+	var pr PolicyRequest
+	logger := r.Context().Value("logger").(*zap.Logger)
+
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+		logger.Error("error decoding as json", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	path, err := keyring.ParseNamespace(pr.Path)
+	if err != nil {
+		logger.Error("error validating policy path", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var period time.Duration
+	if pr.RotationPeriod != "" {
+		if period, err = keyring.ParsePeriod(pr.RotationPeriod); err != nil {
+			logger.Error("error parsing rotation period", zap.Error(err))
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	p := &keyring.Policy{
+		Readers:        pr.Readers,
+		Writers:        pr.Writers,
+		RotationPeriod: period,
+		NonExportable:  pr.NonExportable,
+	}
+	if err := ph.keyring.SetPolicy(r.Context(), path, p); err != nil {
+		logger.Error("error storing policy", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Delete detaches the policy of the path.
+func (ph *PolicyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	path, err := keyring.ParseNamespace(r.URL.Query().Get("path"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := ph.keyring.DeletePolicy(r.Context(), path); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
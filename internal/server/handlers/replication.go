@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultChangesLimit is the maximum number of changes returned by a single request by default.
+	DefaultChangesLimit = 1000
+	// MaxChangesWait is the longest a request waits for changes.
+	MaxChangesWait = time.Minute
+)
+
+// ReplicationHandler serves the change log of the store to followers.
+type ReplicationHandler struct {
+	log store.ChangeLog
+	// replicated is called with the name of a follower and the sequence number it replicated up to
+	replicated func(follower string, seq uint64)
+}
+
+// ReplicationResponse holds changes of the store and the sequence number of the last change.
+type ReplicationResponse struct {
+	Head    uint64         `json:"head"`
+	Changes []store.Change `json:"changes"`
+}
+
+// ReplicationStatus describes the replication role of a key server.
+type ReplicationStatus struct {
+	// Role is either primary or follower.
+	Role string `json:"role"`
+	// Primary is the URL of the primary replicated by a follower.
+	Primary string `json:"primary,omitempty"`
+	// Head is the sequence number of the last change of the store.
+	Head uint64 `json:"head"`
+}
+
+func NewReplicationHandler(log store.ChangeLog, replicated func(follower string, seq uint64)) *ReplicationHandler {
+	return &ReplicationHandler{
+		log:        log,
+		replicated: replicated,
+	}
+}
+
+// Snapshot returns every value of the store as set changes along with the head they were dumped at.
+func (rh *ReplicationHandler) Snapshot(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*zap.Logger)
+	dump, head, err := rh.log.Dump()
+	if err != nil {
+		logger.Error("error dumping store", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rh.write(w, logger, &ReplicationResponse{Head: head, Changes: dump})
+}
+
+// Changes returns up to limit changes recorded after the since sequence number.
+// When there are none it waits up to the wait duration for a change to be recorded.
+// 410 is returned when the changes cannot be replayed from since and the follower has to load a snapshot.
+func (rh *ReplicationHandler) Changes(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*zap.Logger)
+	params := r.URL.Query()
+	since, err := strconv.ParseUint(params.Get("since"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	limit := DefaultChangesLimit
+	if v := params.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	var wait time.Duration
+	if v := params.Get("wait"); v != "" {
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if wait > MaxChangesWait {
+			wait = MaxChangesWait
+		}
+	}
+	// a follower asks for the changes following the ones it applied
+	if identity, ok := r.Context().Value("identity").(*auth.Identity); ok && rh.replicated != nil {
+		rh.replicated(identity.Name, since)
+	}
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		// the channel is taken before reading so a change recorded in between is not missed
+		changed := rh.log.Changed()
+		changes, err := rh.log.Changes(since, limit)
+		if errors.Is(err, store.ErrChangeLogGap) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		if err != nil {
+			logger.Error("error reading change log", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(changes) > 0 {
+			rh.write(w, logger, &ReplicationResponse{Head: changes[len(changes)-1].Seq, Changes: changes})
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			rh.write(w, logger, &ReplicationResponse{Head: since, Changes: []store.Change{}})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (rh *ReplicationHandler) write(w http.ResponseWriter, logger *zap.Logger, res *ReplicationResponse) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		logger.Error("error marshaling changes", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/vtno/zypher/internal/server/keyring"
+	"go.uber.org/zap"
+)
+
+// Cipher encrypts and decrypts payloads with a key.
+type Cipher interface {
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+}
+
+// TransitHandler encrypts and decrypts payloads with keys stored on the server
+// so the keys never have to leave it.
+// Payloads are encrypted with the current version of a key and can be decrypted with any of its versions.
+type TransitHandler struct {
+	keyring   *keyring.Keyring
+	newCipher func(key string) Cipher
+}
+
+type TransitRequest struct {
+	Path string `json:"path,omitempty"`
+	Name string `json:"name,omitempty"`
+	Env  string `json:"env,omitempty"`
+	// Payload is the base64 encoded plaintext to encrypt or ciphertext to decrypt.
+	Payload string `json:"payload" validate:"required,base64"`
+}
+
+type TransitResponse struct {
+	// Payload is the base64 encoded ciphertext or plaintext.
+	Payload string `json:"payload"`
+}
+
+// NewTransitHandler returns a TransitHandler creating ciphers for the keys of the keyring with newCipher.
+func NewTransitHandler(kr *keyring.Keyring, newCipher func(key string) Cipher) *TransitHandler {
+	return &TransitHandler{
+		keyring:   kr,
+		newCipher: newCipher,
+	}
+}
+
+// Encrypt encrypts the payload with the key by path or name and env.
+func (th *TransitHandler) Encrypt(w http.ResponseWriter, r *http.Request) {
+	// encryption only fails if the stored key is invalid
+	th.handle(w, r, "encrypt", http.StatusInternalServerError, func(ciphers []Cipher, payload []byte) ([]byte, error) {
+		return ciphers[0].Encrypt(payload)
+	})
+}
+
+// Decrypt decrypts the payload with the key by path or name and env.
+// 400 is returned if the payload cannot be decrypted with any version of the key.
+func (th *TransitHandler) Decrypt(w http.ResponseWriter, r *http.Request) {
+	th.handle(w, r, "decrypt", http.StatusBadRequest, decryptAny)
+}
+
+func (th *TransitHandler) handle(w http.ResponseWriter, r *http.Request, op string, failStatus int, run func([]Cipher, []byte) ([]byte, error)) {
+	var tr TransitRequest
+	logger := r.Context().Value("logger").(*zap.Logger)
+
+	if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+		logger.Error("error decoding as json", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	validate := validator.New()
+	if err := validate.Struct(tr); err != nil {
+		logger.Error("error validating request body", zap.Error(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	payload, err := base64.StdEncoding.DecodeString(tr.Payload)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	path, err := keyPath(tr.Path, tr.Name, tr.Env)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ciphers, ok := th.ciphers(w, r, path)
+	if !ok {
+		return
+	}
+
+	out, err := run(ciphers, payload)
+	if err != nil {
+		// the payload is never logged
+		logger.Warn("error running transit "+op, zap.String("key", path), zap.Error(err))
+		w.WriteHeader(failStatus)
+		return
+	}
+	res, err := json.Marshal(&TransitResponse{
+		Payload: base64.StdEncoding.EncodeToString(out),
+	})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Write(res)
+}
+
+// ciphers returns a Cipher for every version of the stored key starting with the current one.
+// It writes 404 and returns false if the key does not exist, 410 if it expired, or 403 if its policies deny
+// the identity of the request.
+func (th *TransitHandler) ciphers(w http.ResponseWriter, r *http.Request, path string) ([]Cipher, bool) {
+	if !authorizePath(w, r, th.keyring, path, false) {
+		return nil, false
+	}
+	m, err := th.keyring.Metadata(r.Context(), path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if m != nil && m.Expired(th.keyring.Now()) {
+		w.WriteHeader(http.StatusGone)
+		return nil, false
+	}
+	versions, err := th.keyring.Versions(r.Context(), path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	if len(versions) == 0 || versions[0] == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return nil, false
+	}
+	ciphers := make([]Cipher, 0, len(versions))
+	for _, key := range versions {
+		ciphers = append(ciphers, th.newCipher(key))
+	}
+	return ciphers, true
+}
+
+// decryptAny decrypts the payload with the first cipher able to authenticate it.
+func decryptAny(ciphers []Cipher, payload []byte) ([]byte, error) {
+	var err error
+	for _, c := range ciphers {
+		var out []byte
+		if out, err = c.Decrypt(payload); err == nil {
+			return out, nil
+		}
+	}
+	return nil, err
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/keyring"
+	"go.uber.org/zap"
+)
+
+// MaxWatchWait is the longest a watch request waits for a change.
+const MaxWatchWait = time.Minute
+
+// WatchHandler notifies clients caching keys when the keys they watch change.
+type WatchHandler struct {
+	keyring *keyring.Keyring
+	changed func() <-chan struct{}
+}
+
+// KeyVersion is the current version of a watched key, zero once the key is deleted.
+type KeyVersion struct {
+	Path    string `json:"path"`
+	Version int    `json:"version"`
+}
+
+type KeyWatchResponse struct {
+	// Since is the since parameter of the next request: the version of the watched key
+	// or a digest of the versions of the keys under the watched prefix.
+	Since string `json:"since"`
+	// Changed is false when the wait elapsed without the watched keys changing.
+	Changed bool `json:"changed"`
+	// Keys are the current versions of the watched keys when they changed.
+	Keys []KeyVersion `json:"keys,omitempty"`
+}
+
+// NewWatchHandler returns a WatchHandler waking up watches on the channels returned by changed,
+// which have to be closed on every change of the keys.
+func NewWatchHandler(kr *keyring.Keyring, changed func() <-chan struct{}) *WatchHandler {
+	return &WatchHandler{
+		keyring: kr,
+		changed: changed,
+	}
+}
+
+// Watch returns the version of the key by path or name and env, or of every key readable by the identity of the
+// request under a path prefix, once it differs from the since parameter of the request.
+// A request without since returns the current versions immediately.
+// When nothing changed it waits up to the wait duration for a change then returns with changed set to false.
+func (wh *WatchHandler) Watch(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*zap.Logger)
+	params := r.URL.Query()
+	prefix := params.Get("prefix")
+	var path string
+	if prefix == "" {
+		var err error
+		if path, err = keyPath(params.Get("path"), params.Get("name"), params.Get("env")); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !authorizePath(w, r, wh.keyring, path, false) {
+			return
+		}
+	} else if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	var wait time.Duration
+	if v := params.Get("wait"); v != "" {
+		var err error
+		if wait, err = time.ParseDuration(v); err != nil || wait < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if wait > MaxWatchWait {
+			wait = MaxWatchWait
+		}
+	}
+	since, watching := params.Get("since"), params.Has("since")
+
+	timeout := time.NewTimer(wait)
+	defer timeout.Stop()
+	for {
+		// the channel is taken before reading so a change committed in between is not missed
+		changed := wh.changed()
+		var versions []KeyVersion
+		var err error
+		if prefix == "" {
+			versions, err = wh.keyVersion(r, path)
+		} else {
+			versions, err = wh.prefixVersions(r, prefix)
+		}
+		if err != nil {
+			logger.Error("error reading watched keys", zap.Error(err))
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		current := versionsDigest(prefix, versions)
+		if !watching || current != since {
+			wh.write(w, logger, &KeyWatchResponse{Since: current, Changed: true, Keys: versions})
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout.C:
+			wh.write(w, logger, &KeyWatchResponse{Since: since})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (wh *WatchHandler) keyVersion(r *http.Request, path string) ([]KeyVersion, error) {
+	m, err := wh.keyring.Metadata(r.Context(), path)
+	if err != nil {
+		return nil, err
+	}
+	kv := KeyVersion{Path: path}
+	if m != nil {
+		kv.Version = m.Version
+	}
+	return []KeyVersion{kv}, nil
+}
+
+// prefixVersions returns the versions of the keys under the prefix the identity of the request may read.
+func (wh *WatchHandler) prefixVersions(r *http.Request, prefix string) ([]KeyVersion, error) {
+	paths, err := wh.keyring.List(r.Context(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	versions := []KeyVersion{}
+	for _, path := range paths {
+		allowed, err := allowedPath(r, wh.keyring, path, false)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+		m, err := wh.keyring.Metadata(r.Context(), path)
+		if err != nil {
+			return nil, err
+		}
+		// the key may have been deleted since it was listed
+		if m != nil {
+			versions = append(versions, KeyVersion{Path: path, Version: m.Version})
+		}
+	}
+	return versions, nil
+}
+
+// versionsDigest returns the version of a watched key, or a digest of the versions of the keys under a prefix
+// so the state of a prefix fits in a query parameter and survives restarts of the server.
+func versionsDigest(prefix string, versions []KeyVersion) string {
+	if prefix == "" {
+		return strconv.Itoa(versions[0].Version)
+	}
+	h := sha256.New()
+	for _, kv := range versions {
+		h.Write([]byte(kv.Path + "#" + strconv.Itoa(kv.Version) + "\n"))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+func (wh *WatchHandler) write(w http.ResponseWriter, logger *zap.Logger, res *KeyWatchResponse) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		logger.Error("error marshaling watched keys", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/vtno/zypher/internal/server/webhook"
+	"go.uber.org/zap"
+)
+
+// WebhookHandler reports the deliveries of webhook events.
+type WebhookHandler struct {
+	dispatcher *webhook.Dispatcher
+}
+
+type WebhookDeliveriesResponse struct {
+	// Deliveries are the most recent deliveries since the server started, the oldest first.
+	Deliveries []webhook.Delivery `json:"deliveries"`
+	// DeadLetters are the deliveries that failed every attempt, the oldest first.
+	DeadLetters []webhook.Delivery `json:"dead_letters"`
+}
+
+// NewWebhookHandler returns a WebhookHandler reporting the deliveries of the dispatcher.
+func NewWebhookHandler(d *webhook.Dispatcher) *WebhookHandler {
+	return &WebhookHandler{
+		dispatcher: d,
+	}
+}
+
+// Deliveries returns the recent deliveries and the dead letters.
+func (wh *WebhookHandler) Deliveries(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value("logger").(*zap.Logger)
+	dead, err := wh.dispatcher.DeadLetters(r.Context())
+	if err != nil {
+		logger.Error("error listing webhook dead letters", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	b, err := json.Marshal(&WebhookDeliveriesResponse{
+		Deliveries:  wh.dispatcher.Recent(),
+		DeadLetters: dead,
+	})
+	if err != nil {
+		logger.Error("error marshaling webhook deliveries", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
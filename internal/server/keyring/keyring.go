@@ -0,0 +1,571 @@
+// Package keyring manages the versions and policies of the keys stored on the key server.
+// Keys are named by paths such as /payments/stripe/prod, see ParsePath.
+package keyring
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vtno/zypher/internal/keygen"
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+const (
+	// metadataBucket holds the Metadata of every key by path
+	metadataBucket = "key_metadata"
+	// versionsBucket holds the previous versions of every key by path and version
+	versionsBucket = "key_versions"
+	// namespacesBucket indexes the namespaces holding at least one key
+	namespacesBucket = "namespaces"
+	// nonExportableBucket holds the paths of keys that can only be used through the transit API
+	nonExportableBucket = "non_exportable"
+)
+
+// Metadata describes the current version of a key, its rotation policy and expiry.
+type Metadata struct {
+	Version   int       `json:"version"`
+	RotatedAt time.Time `json:"rotated_at"`
+	// RotationPeriod is how often the key is rotated, zero if the key is never rotated automatically.
+	RotationPeriod time.Duration `json:"rotation_period,omitempty"`
+	// ExpiresAt is when the key stops being served, nil if the key never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Age returns how long ago the current version was created.
+func (m *Metadata) Age(now time.Time) time.Duration {
+	return now.Sub(m.RotatedAt)
+}
+
+// Overdue returns true if the key has a rotation policy and is older than its rotation period.
+func (m *Metadata) Overdue(now time.Time) bool {
+	return m.RotationPeriod > 0 && m.Age(now) >= m.RotationPeriod
+}
+
+// Expired returns true if the key has an expiry that has passed.
+func (m *Metadata) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !now.Before(*m.ExpiresAt)
+}
+
+// Keyring stores keys along with their metadata and previous versions.
+// The current version of a key is kept in the bucket of its namespace, e.g. /payments/stripe/prod is the key prod
+// of the /payments/stripe bucket. Every change to a key is written in a single store transaction.
+type Keyring struct {
+	store     store.Store
+	now       func() time.Time
+	listeners []func(context.Context, Change)
+
+	changedMu sync.Mutex
+	changed   chan struct{}
+}
+
+type KeyringOption func(*Keyring)
+
+// WithClock sets the clock used to timestamp rotations.
+func WithClock(now func() time.Time) KeyringOption {
+	return func(k *Keyring) {
+		k.now = now
+	}
+}
+
+// ChangeType names how a key was changed.
+type ChangeType string
+
+const (
+	Created ChangeType = "created"
+	Updated ChangeType = "updated"
+	Rotated ChangeType = "rotated"
+	Deleted ChangeType = "deleted"
+	// Expired is the deletion of a key purged after its expiry.
+	Expired ChangeType = "expired"
+	// PolicySet and PolicyDeleted are changes of the policy of a namespace or key path.
+	PolicySet     ChangeType = "policy_set"
+	PolicyDeleted ChangeType = "policy_deleted"
+)
+
+// KeyChange returns true if t is a change of a key rather than of a policy.
+func (t ChangeType) KeyChange() bool {
+	return t != PolicySet && t != PolicyDeleted
+}
+
+// Change is a change of a key or of a policy committed through the keyring.
+type Change struct {
+	Type ChangeType
+	// Path is the path of the key, or the namespace or key path of the policy.
+	Path string
+	// Version is the version of the key after the change, or the last one of a deleted key.
+	Version int
+}
+
+// WithListener calls l with the context of every change of a key or policy once the change is committed.
+func WithListener(l func(context.Context, Change)) KeyringOption {
+	return func(k *Keyring) {
+		k.listeners = append(k.listeners, l)
+	}
+}
+
+// New returns a Keyring backed by the store.
+func New(s store.Store, opts ...KeyringOption) *Keyring {
+	k := &Keyring{
+		store:   s,
+		now:     time.Now,
+		changed: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(k)
+	}
+	return k
+}
+
+// Now returns the current time of the keyring clock.
+func (k *Keyring) Now() time.Time {
+	return k.now()
+}
+
+// Changed returns a channel closed once a key or policy is written, rotated or deleted through the keyring.
+func (k *Keyring) Changed() <-chan struct{} {
+	k.changedMu.Lock()
+	defer k.changedMu.Unlock()
+	return k.changed
+}
+
+// update runs fn in a store transaction then notifies the waiters of Changed and the listeners of the change
+// returned by fn once it is committed. Nothing is notified when fn returns no change.
+func (k *Keyring) update(ctx context.Context, fn func(store.Tx) (*Change, error)) error {
+	var change *Change
+	err := k.store.Update(ctx, func(tx store.Tx) error {
+		var err error
+		change, err = fn(tx)
+		return err
+	})
+	if err != nil || change == nil {
+		return err
+	}
+	k.changedMu.Lock()
+	close(k.changed)
+	k.changed = make(chan struct{})
+	k.changedMu.Unlock()
+	for _, l := range k.listeners {
+		l(ctx, *change)
+	}
+	return nil
+}
+
+// reader reads values from a store or a transaction.
+type reader func(bucket, key string) (*store.Entry, error)
+
+func (k *Keyring) reader(ctx context.Context) reader {
+	return func(bucket, key string) (*store.Entry, error) {
+		return k.store.Get(ctx, bucket, key)
+	}
+}
+
+// get returns the value of the key or an empty string if it does not exist.
+func (r reader) get(bucket, key string) (string, error) {
+	e, err := r(bucket, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(e.Value), nil
+}
+
+// current returns the current version of the key at path or an empty string if it does not exist.
+func (r reader) current(path string) (string, error) {
+	ns, name, err := split(path)
+	if err != nil {
+		return "", err
+	}
+	return r.get(ns, name)
+}
+
+// Get returns the current version of the key or an empty string if it does not exist.
+func (k *Keyring) Get(ctx context.Context, path string) (string, error) {
+	return k.reader(ctx).current(path)
+}
+
+// GetVersion returns the given version of the key or an empty string if it does not exist.
+func (k *Keyring) GetVersion(ctx context.Context, path string, version int) (string, error) {
+	r := k.reader(ctx)
+	m, err := r.metadata(path)
+	if err != nil {
+		return "", err
+	}
+	if m == nil {
+		return "", nil
+	}
+	if version == m.Version {
+		return r.current(path)
+	}
+	return r.get(versionsBucket, versionKey(path, version))
+}
+
+// Versions returns every available version of the key starting with the current one.
+func (k *Keyring) Versions(ctx context.Context, path string) ([]string, error) {
+	r := k.reader(ctx)
+	m, err := r.metadata(path)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	current, err := r.current(path)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{current}
+	for v := m.Version - 1; v > 0; v-- {
+		key, err := r.get(versionsBucket, versionKey(path, v))
+		if err != nil {
+			return nil, err
+		}
+		if key != "" {
+			versions = append(versions, key)
+		}
+	}
+	return versions, nil
+}
+
+// Metadata returns the metadata of the key or nil if the key does not exist.
+// A key without a rotation period of its own reports the rotation period of its policies.
+// Keys stored before metadata was recorded are reported as version 1 with an unknown rotation time.
+func (k *Keyring) Metadata(ctx context.Context, path string) (*Metadata, error) {
+	r := k.reader(ctx)
+	m, err := r.metadata(path)
+	if err != nil || m == nil || m.RotationPeriod > 0 {
+		return m, err
+	}
+	p, err := r.effectivePolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	m.RotationPeriod = p.RotationPeriod
+	return m, nil
+}
+
+// metadata returns the metadata stored for the key.
+func (r reader) metadata(path string) (*Metadata, error) {
+	v, err := r.get(metadataBucket, path)
+	if err != nil {
+		return nil, err
+	}
+	if v == "" {
+		current, err := r.current(path)
+		if err != nil || current == "" {
+			return nil, err
+		}
+		return &Metadata{Version: 1}, nil
+	}
+	m := &Metadata{}
+	if err := json.Unmarshal([]byte(v), m); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata of %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// List returns the paths of the keys starting with prefix sorted.
+func (k *Keyring) List(ctx context.Context, prefix string) ([]string, error) {
+	return listPaths(func(bucket, prefix string) ([]string, error) {
+		return k.store.List(ctx, bucket, prefix)
+	}, prefix)
+}
+
+// listPaths returns the paths of the keys starting with prefix sorted, listing the buckets with list.
+func listPaths(list func(bucket, prefix string) ([]string, error), prefix string) ([]string, error) {
+	namespaces, err := list(namespacesBucket, "")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, ns := range namespaces {
+		// the namespace is below the prefix or the prefix is below the namespace
+		if !strings.HasPrefix(ns+"/", prefix) && !strings.HasPrefix(prefix, ns+"/") {
+			continue
+		}
+		names, err := list(ns, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if path := ns + "/" + name; strings.HasPrefix(path, prefix) {
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// Put stores the key. If a different key is already stored it is kept as the previous version.
+// The rotation period of the key is replaced when period is not nil, and its expiry when expiresAt is not nil,
+// a zero expiresAt removing the expiry. A non-exportable key is usable through the transit API only until it is
+// deleted, the mark is written along with the key.
+func (k *Keyring) Put(ctx context.Context, path, key string, period *time.Duration, expiresAt *time.Time, nonExportable bool) error {
+	if _, err := ParsePath(path); err != nil {
+		return err
+	}
+	return k.update(ctx, func(tx store.Tx) (*Change, error) {
+		change, err := k.put(tx, path, key, period, expiresAt)
+		if err != nil || !nonExportable {
+			return change, err
+		}
+		if _, err := tx.Put(nonExportableBucket, path, []byte("true")); err != nil {
+			return nil, err
+		}
+		return change, nil
+	})
+}
+
+func (k *Keyring) put(tx store.Tx, path, key string, period *time.Duration, expiresAt *time.Time) (*Change, error) {
+	r := reader(tx.Get)
+	m, err := r.metadata(path)
+	if err != nil {
+		return nil, err
+	}
+	current, err := r.current(path)
+	if err != nil {
+		return nil, err
+	}
+
+	change := &Change{Type: Updated, Path: path}
+	switch {
+	case m == nil:
+		m = &Metadata{Version: 1, RotatedAt: k.now()}
+		change.Type = Created
+	case current != key:
+		if _, err := tx.Put(versionsBucket, versionKey(path, m.Version), []byte(current)); err != nil {
+			return nil, err
+		}
+		m.Version++
+		m.RotatedAt = k.now()
+	}
+	if period != nil {
+		m.RotationPeriod = *period
+	}
+	if expiresAt != nil {
+		m.ExpiresAt = nil
+		if !expiresAt.IsZero() {
+			e := *expiresAt
+			m.ExpiresAt = &e
+		}
+	}
+	change.Version = m.Version
+
+	ns, name, _ := split(path)
+	if current == "" {
+		if _, err := tx.Put(namespacesBucket, ns, []byte{}); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Put(ns, name, []byte(key)); err != nil {
+		return nil, err
+	}
+	return change, setMetadata(tx, path, m)
+}
+
+// Rotate replaces the key with a newly generated one keeping the current version as the previous one.
+func (k *Keyring) Rotate(ctx context.Context, path string) error {
+	return k.update(ctx, func(tx store.Tx) (*Change, error) {
+		return k.rotate(tx, path)
+	})
+}
+
+func (k *Keyring) rotate(tx store.Tx, path string) (*Change, error) {
+	key, err := keygen.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %w", err)
+	}
+	change, err := k.put(tx, path, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	change.Type = Rotated
+	return change, nil
+}
+
+// RotateDue rotates every key older than its rotation period, or the one of its policies, and returns their paths.
+// Expired keys are left to be purged instead.
+// Each key is rotated in its own transaction, a failure is returned along with the other failures once every due
+// key has been tried.
+func (k *Keyring) RotateDue(ctx context.Context) ([]string, error) {
+	return k.updateDue(ctx, k.rotationDue, func(tx store.Tx, path string, _ *Metadata) (*Change, error) {
+		return k.rotate(tx, path)
+	}, "rotating")
+}
+
+// rotationDue returns the metadata of the key if it is overdue and not expired, nil otherwise.
+func (k *Keyring) rotationDue(r reader, path string) (*Metadata, error) {
+	m, err := r.metadata(path)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	if m.RotationPeriod == 0 {
+		p, err := r.effectivePolicy(path)
+		if err != nil {
+			return nil, err
+		}
+		m.RotationPeriod = p.RotationPeriod
+	}
+	if !m.Overdue(k.now()) || m.Expired(k.now()) {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// Delete deletes every version, the metadata and the non-exportable mark of the key.
+func (k *Keyring) Delete(ctx context.Context, path string) error {
+	if _, err := ParsePath(path); err != nil {
+		return err
+	}
+	return k.update(ctx, func(tx store.Tx) (*Change, error) {
+		m, err := reader(tx.Get).metadata(path)
+		if err != nil || m == nil {
+			return nil, err
+		}
+		return deleteKey(tx, path, m, Deleted)
+	})
+}
+
+// PurgeExpired deletes every key expired for longer than grace and returns their paths.
+// Each key is deleted in its own transaction, a failure is returned along with the other failures once every due
+// key has been tried.
+func (k *Keyring) PurgeExpired(ctx context.Context, grace time.Duration) ([]string, error) {
+	expired := func(r reader, path string) (*Metadata, error) {
+		m, err := r.metadata(path)
+		if err != nil || m == nil || !m.Expired(k.now().Add(-grace)) {
+			return nil, err
+		}
+		return m, nil
+	}
+	return k.updateDue(ctx, expired, func(tx store.Tx, path string, m *Metadata) (*Change, error) {
+		return deleteKey(tx, path, m, Expired)
+	}, "purging")
+}
+
+// updateDue finds the keys for which due returns metadata in a single read-only transaction, then runs fn in a
+// transaction of its own for each of them once due confirmed the key is still due. It returns the paths of the
+// updated keys along with the errors of the others, each naming its key and the action.
+func (k *Keyring) updateDue(
+	ctx context.Context,
+	due func(reader, string) (*Metadata, error),
+	fn func(store.Tx, string, *Metadata) (*Change, error),
+	action string,
+) ([]string, error) {
+	var paths []string
+	err := k.store.View(ctx, func(tx store.Tx) error {
+		all, err := listPaths(tx.List, "/")
+		if err != nil {
+			return err
+		}
+		for _, path := range all {
+			m, err := due(tx.Get, path)
+			if err != nil {
+				return fmt.Errorf("error checking %s: %w", path, err)
+			}
+			if m != nil {
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var updated []string
+	var errs []error
+	for _, path := range paths {
+		var change *Change
+		err := k.update(ctx, func(tx store.Tx) (*Change, error) {
+			// the key may have been changed or deleted since it was found due
+			m, err := due(tx.Get, path)
+			if err != nil || m == nil {
+				return nil, err
+			}
+			change, err = fn(tx, path, m)
+			return change, err
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error %s %s: %w", action, path, err))
+			continue
+		}
+		if change != nil {
+			updated = append(updated, path)
+		}
+	}
+	return updated, errors.Join(errs...)
+}
+
+// deleteKey deletes the key described by m and reports the change as t.
+func deleteKey(tx store.Tx, path string, m *Metadata, t ChangeType) (*Change, error) {
+	ns, name, _ := split(path)
+	for v := m.Version - 1; v > 0; v-- {
+		if err := tx.Delete(versionsBucket, versionKey(path, v)); err != nil {
+			return nil, err
+		}
+	}
+	for _, bucket := range []string{metadataBucket, nonExportableBucket} {
+		if err := tx.Delete(bucket, path); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Delete(ns, name); err != nil {
+		return nil, err
+	}
+	change := &Change{Type: t, Path: path, Version: m.Version}
+	names, err := tx.List(ns, "")
+	if err != nil || len(names) > 0 {
+		return change, err
+	}
+	return change, tx.Delete(namespacesBucket, ns)
+}
+
+// NonExportable returns true if the key or one of its policies has been marked as non-exportable.
+func (k *Keyring) NonExportable(ctx context.Context, path string) (bool, error) {
+	r := k.reader(ctx)
+	v, err := r.get(nonExportableBucket, path)
+	if err != nil || v == "true" {
+		return v == "true", err
+	}
+	p, err := r.effectivePolicy(path)
+	if err != nil {
+		return false, err
+	}
+	return p.NonExportable, nil
+}
+
+func setMetadata(tx store.Tx, path string, m *Metadata) error {
+	v, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("error marshaling metadata of %s: %w", path, err)
+	}
+	_, err = tx.Put(metadataBucket, path, v)
+	return err
+}
+
+func versionKey(path string, version int) string {
+	return path + "#" + strconv.Itoa(version)
+}
+
+// ParsePeriod parses a rotation period such as "90d" or "12h".
+// In addition to the units of time.ParseDuration, "d" stands for 24 hours.
+func ParsePeriod(s string) (time.Duration, error) {
+	if days, found := strings.CutSuffix(s, "d"); found {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid rotation period %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid rotation period %q", s)
+	}
+	return d, nil
+}
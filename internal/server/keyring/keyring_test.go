@@ -0,0 +1,546 @@
+package keyring_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/keyring"
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+var ctx = context.Background()
+
+func newKeyring(t *testing.T, now *time.Time) (*keyring.Keyring, store.Store) {
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return keyring.New(s, keyring.WithClock(func() time.Time { return *now })), s
+}
+
+func TestKeyring_Put(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr, _ := newKeyring(t, &now)
+	period := 90 * 24 * time.Hour
+
+	if err := kr.Put(ctx, "/twitter/prd", "key1", &period, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	now = now.Add(time.Hour)
+	// putting the same key only updates the policy
+	if err := kr.Put(ctx, "/twitter/prd", "key1", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := kr.Put(ctx, "/twitter/prd", "key2", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	m, err := kr.Metadata(ctx, "/twitter/prd")
+	if err != nil {
+		t.Fatalf("error getting metadata: %v", err)
+	}
+	expected := &keyring.Metadata{Version: 2, RotatedAt: now, RotationPeriod: period}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("expected metadata %+v, got %+v", expected, m)
+	}
+
+	versions, err := kr.Versions(ctx, "/twitter/prd")
+	if err != nil {
+		t.Fatalf("error getting versions: %v", err)
+	}
+	if !reflect.DeepEqual(versions, []string{"key2", "key1"}) {
+		t.Errorf("expected versions [key2 key1], got %v", versions)
+	}
+	if key, _ := kr.GetVersion(ctx, "/twitter/prd", 1); key != "key1" {
+		t.Errorf("expected version 1 to be key1, got %s", key)
+	}
+
+	if err := kr.Delete(ctx, "/twitter/prd"); err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+	if m, _ := kr.Metadata(ctx, "/twitter/prd"); m != nil {
+		t.Errorf("expected deleted key to have no metadata, got %+v", m)
+	}
+	if key, _ := kr.GetVersion(ctx, "/twitter/prd", 1); key != "" {
+		t.Errorf("expected previous versions to be deleted, got %s", key)
+	}
+}
+
+func TestKeyring_PutNonExportable(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	defer s.Close()
+	var kr *keyring.Keyring
+	var marked []bool
+	kr = keyring.New(s,
+		keyring.WithClock(func() time.Time { return now }),
+		keyring.WithListener(func(ctx context.Context, c keyring.Change) {
+			nonExportable, _ := kr.NonExportable(ctx, c.Path)
+			marked = append(marked, nonExportable)
+		}),
+	)
+
+	if err := kr.Put(ctx, "/stripe/prd", "key1", nil, nil, true); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	// putting the key again keeps the mark
+	if err := kr.Put(ctx, "/stripe/prd", "key2", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	// the mark is committed along with the key
+	if !reflect.DeepEqual(marked, []bool{true, true}) {
+		t.Errorf("expected the key to be non-exportable once stored, got %v", marked)
+	}
+
+	if err := kr.Delete(ctx, "/stripe/prd"); err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+	if err := kr.Put(ctx, "/stripe/prd", "key1", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if nonExportable, _ := kr.NonExportable(ctx, "/stripe/prd"); nonExportable {
+		t.Errorf("expected the mark to be deleted with the key")
+	}
+}
+
+func TestKeyring_concurrentPuts(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr, _ := newKeyring(t, &now)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := kr.Put(ctx, "/twitter/prd", fmt.Sprintf("key%d", i), nil, nil, false); err != nil {
+				t.Errorf("error putting key: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// every put is a transaction so no version is lost
+	m, err := kr.Metadata(ctx, "/twitter/prd")
+	if err != nil || m.Version != 10 {
+		t.Fatalf("expected version 10, got %+v, %v", m, err)
+	}
+	versions, err := kr.Versions(ctx, "/twitter/prd")
+	if err != nil || len(versions) != 10 {
+		t.Errorf("expected 10 versions, got %v, %v", versions, err)
+	}
+}
+
+func TestKeyring_RotateDue(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr, s := newKeyring(t, &now)
+	period := 24 * time.Hour
+
+	if err := kr.Put(ctx, "/twitter/prd", "key1", &period, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := kr.Put(ctx, "/payments/stripe/prd", "key1", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	// keys stored before metadata was recorded are never rotated
+	if _, err := s.Put(ctx, store.DefaultBucket, "legacy#prd", []byte("key1")); err != nil {
+		t.Fatalf("error setting key: %v", err)
+	}
+	if _, _, err := kr.MigrateLegacy(ctx); err != nil {
+		t.Fatalf("error migrating legacy keys: %v", err)
+	}
+
+	rotated, err := kr.RotateDue(ctx)
+	if err != nil {
+		t.Fatalf("error rotating keys: %v", err)
+	}
+	if len(rotated) != 0 {
+		t.Errorf("expected no key to be rotated, got %v", rotated)
+	}
+
+	now = now.Add(period)
+	rotated, err = kr.RotateDue(ctx)
+	if err != nil {
+		t.Fatalf("error rotating keys: %v", err)
+	}
+	if !reflect.DeepEqual(rotated, []string{"/twitter/prd"}) {
+		t.Errorf("expected /twitter/prd to be rotated, got %v", rotated)
+	}
+
+	versions, err := kr.Versions(ctx, "/twitter/prd")
+	if err != nil {
+		t.Fatalf("error getting versions: %v", err)
+	}
+	if len(versions) != 2 || versions[1] != "key1" || len(versions[0]) != 32 {
+		t.Errorf("expected a generated key followed by key1, got %v", versions)
+	}
+	m, _ := kr.Metadata(ctx, "/twitter/prd")
+	if m.Overdue(now) {
+		t.Errorf("expected rotated key not to be overdue")
+	}
+	if legacy, _ := kr.Metadata(ctx, "/legacy/prd"); legacy.Version != 1 || !legacy.RotatedAt.IsZero() {
+		t.Errorf("expected legacy key to be reported as version 1, got %+v", legacy)
+	}
+}
+
+func TestKeyring_PurgeExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var changes []keyring.Change
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	defer s.Close()
+	kr := keyring.New(s,
+		keyring.WithClock(func() time.Time { return now }),
+		keyring.WithListener(func(_ context.Context, c keyring.Change) { changes = append(changes, c) }),
+	)
+	expiresAt := now.Add(time.Hour)
+	grace := 24 * time.Hour
+
+	if err := kr.Put(ctx, "/contractors/alice", "key1", nil, &expiresAt, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := kr.Put(ctx, "/contractors/alice", "key2", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := kr.Put(ctx, "/contractors/bob", "key1", nil, &expiresAt, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	// a zero expiry removes the expiry of bob
+	if err := kr.Put(ctx, "/contractors/bob", "key1", nil, &time.Time{}, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	m, _ := kr.Metadata(ctx, "/contractors/alice")
+	if m.ExpiresAt == nil || !m.ExpiresAt.Equal(expiresAt) || m.Expired(now) {
+		t.Errorf("expected the expiry to be kept by updates, got %+v", m)
+	}
+	now = expiresAt
+	if m, _ := kr.Metadata(ctx, "/contractors/alice"); !m.Expired(now) {
+		t.Errorf("expected the key to be expired at %s", expiresAt)
+	}
+	if m, _ := kr.Metadata(ctx, "/contractors/bob"); m.ExpiresAt != nil || m.Expired(now) {
+		t.Errorf("expected the expiry to be removed, got %+v", m)
+	}
+
+	changed := kr.Changed()
+	purged, err := kr.PurgeExpired(ctx, grace)
+	if err != nil || len(purged) != 0 {
+		t.Errorf("expected no key to be purged within the grace period, got %v, %v", purged, err)
+	}
+	select {
+	case <-changed:
+		t.Errorf("expected a sweep purging no key to notify no change")
+	default:
+	}
+	now = now.Add(grace)
+	purged, err = kr.PurgeExpired(ctx, grace)
+	if err != nil || !reflect.DeepEqual(purged, []string{"/contractors/alice"}) {
+		t.Errorf("expected /contractors/alice to be purged, got %v, %v", purged, err)
+	}
+	if versions, _ := kr.Versions(ctx, "/contractors/alice"); len(versions) != 0 {
+		t.Errorf("expected every version to be purged, got %v", versions)
+	}
+	if last := changes[len(changes)-1]; last != (keyring.Change{Type: keyring.Expired, Path: "/contractors/alice", Version: 2}) {
+		t.Errorf("expected the purge to be notified as an expiry, got %+v", last)
+	}
+}
+
+// failingStore fails the first failures updates.
+type failingStore struct {
+	store.Store
+	failures int
+}
+
+func (s *failingStore) Update(ctx context.Context, fn func(store.Tx) error) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("disk full")
+	}
+	return s.Store.Update(ctx, fn)
+}
+
+func TestKeyring_PurgeExpired_failure(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	bs, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	defer bs.Close()
+	s := &failingStore{Store: bs}
+	kr := keyring.New(s, keyring.WithClock(func() time.Time { return now }))
+	expiresAt := now.Add(time.Hour)
+	for _, path := range []string{"/contractors/alice", "/contractors/bob"} {
+		if err := kr.Put(ctx, path, "key1", nil, &expiresAt, false); err != nil {
+			t.Fatalf("error putting key: %v", err)
+		}
+	}
+
+	now = expiresAt
+	s.failures = 1
+	purged, err := kr.PurgeExpired(ctx, 0)
+	if err == nil || !strings.Contains(err.Error(), "/contractors/alice") {
+		t.Errorf("expected the failure to purge /contractors/alice to be returned, got %v", err)
+	}
+	if !reflect.DeepEqual(purged, []string{"/contractors/bob"}) {
+		t.Errorf("expected /contractors/bob to be purged despite the failure, got %v", purged)
+	}
+	purged, err = kr.PurgeExpired(ctx, 0)
+	if err != nil || !reflect.DeepEqual(purged, []string{"/contractors/alice"}) {
+		t.Errorf("expected /contractors/alice to be purged by the next sweep, got %v, %v", purged, err)
+	}
+}
+
+func TestKeyring_listener(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	defer s.Close()
+	var changes []keyring.Change
+	kr := keyring.New(s,
+		keyring.WithClock(func() time.Time { return now }),
+		keyring.WithListener(func(_ context.Context, c keyring.Change) { changes = append(changes, c) }),
+	)
+
+	if err := kr.Put(ctx, "/twitter/prd", "key1", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := kr.Put(ctx, "/twitter/prd", "key2", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := kr.Rotate(ctx, "/twitter/prd"); err != nil {
+		t.Fatalf("error rotating key: %v", err)
+	}
+	if err := kr.Delete(ctx, "/twitter/prd"); err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+	// deleting a missing key changes nothing
+	if err := kr.Delete(ctx, "/twitter/prd"); err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+	if err := kr.SetPolicy(ctx, "/twitter", &keyring.Policy{Readers: []string{"ci"}}); err != nil {
+		t.Fatalf("error setting policy: %v", err)
+	}
+	changed := kr.Changed()
+	if err := kr.DeletePolicy(ctx, "/twitter"); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+	select {
+	case <-changed:
+	default:
+		t.Errorf("expected deleting a policy to close the changed channel")
+	}
+	// deleting a missing policy changes nothing
+	if err := kr.DeletePolicy(ctx, "/twitter"); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+
+	expected := []keyring.Change{
+		{Type: keyring.Created, Path: "/twitter/prd", Version: 1},
+		{Type: keyring.Updated, Path: "/twitter/prd", Version: 2},
+		{Type: keyring.Rotated, Path: "/twitter/prd", Version: 3},
+		{Type: keyring.Deleted, Path: "/twitter/prd", Version: 3},
+		{Type: keyring.PolicySet, Path: "/twitter"},
+		{Type: keyring.PolicyDeleted, Path: "/twitter"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %+v, got %+v", expected, changes)
+	}
+}
+
+func TestKeyring_List(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr, _ := newKeyring(t, &now)
+	for _, path := range []string{"/payments/stripe/prd", "/payments/stripe/stg", "/payments/adyen/prd", "/paymentsv2/prd", "/twitter/prd"} {
+		if err := kr.Put(ctx, path, "key1", nil, nil, false); err != nil {
+			t.Fatalf("error putting %s: %v", path, err)
+		}
+	}
+	if err := kr.Delete(ctx, "/twitter/prd"); err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+
+	tests := []struct {
+		prefix   string
+		expected []string
+	}{
+		{prefix: "/", expected: []string{"/payments/adyen/prd", "/payments/stripe/prd", "/payments/stripe/stg", "/paymentsv2/prd"}},
+		{prefix: "/payments/", expected: []string{"/payments/adyen/prd", "/payments/stripe/prd", "/payments/stripe/stg"}},
+		{prefix: "/payments/stripe/p", expected: []string{"/payments/stripe/prd"}},
+		{prefix: "/payments", expected: []string{"/payments/adyen/prd", "/payments/stripe/prd", "/payments/stripe/stg", "/paymentsv2/prd"}},
+		{prefix: "/twitter/", expected: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.prefix, func(t *testing.T) {
+			paths, err := kr.List(ctx, tt.prefix)
+			if err != nil {
+				t.Fatalf("error listing keys: %v", err)
+			}
+			if !reflect.DeepEqual(paths, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+}
+
+func TestKeyring_policies(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr, _ := newKeyring(t, &now)
+	if err := kr.Put(ctx, "/payments/stripe/prd", "key1", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	policies := map[string]*keyring.Policy{
+		"/":                {RotationPeriod: 90 * 24 * time.Hour},
+		"/payments":        {Readers: []string{"billing", "ci"}, Writers: []string{"ci"}, NonExportable: true},
+		"/payments/stripe": {Readers: []string{"billing"}, RotationPeriod: 24 * time.Hour},
+	}
+	for path, p := range policies {
+		if err := kr.SetPolicy(ctx, path, p); err != nil {
+			t.Fatalf("error setting policy of %s: %v", path, err)
+		}
+	}
+
+	p, err := kr.EffectivePolicy(ctx, "/payments/stripe/prd")
+	if err != nil {
+		t.Fatalf("error getting effective policy: %v", err)
+	}
+	expected := &keyring.Policy{Readers: []string{"billing"}, Writers: []string{"ci"}, RotationPeriod: 24 * time.Hour, NonExportable: true}
+	if !reflect.DeepEqual(p, expected) {
+		t.Errorf("expected effective policy %+v, got %+v", expected, p)
+	}
+	if p.CanRead("ci") || !p.CanRead("billing") || !p.CanWrite("ci") {
+		t.Errorf("expected billing to read and ci to write only, got %+v", p)
+	}
+	if nonExportable, _ := kr.NonExportable(ctx, "/payments/stripe/prd"); !nonExportable {
+		t.Errorf("expected the key to be non-exportable by policy")
+	}
+	if m, _ := kr.Metadata(ctx, "/payments/stripe/prd"); m.RotationPeriod != 24*time.Hour {
+		t.Errorf("expected the rotation period of the policy, got %+v", m)
+	}
+
+	now = now.Add(24 * time.Hour)
+	rotated, err := kr.RotateDue(ctx)
+	if err != nil || !reflect.DeepEqual(rotated, []string{"/payments/stripe/prd"}) {
+		t.Errorf("expected the key to be rotated by policy, got %v, %v", rotated, err)
+	}
+
+	if err := kr.DeletePolicy(ctx, "/payments/stripe"); err != nil {
+		t.Fatalf("error deleting policy: %v", err)
+	}
+	if p, _ := kr.EffectivePolicy(ctx, "/payments/stripe/prd"); !p.CanRead("ci") || p.RotationPeriod != 90*24*time.Hour {
+		t.Errorf("expected the policies of /payments and / to apply, got %+v", p)
+	}
+}
+
+func TestKeyring_MigrateLegacy(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	kr, s := newKeyring(t, &now)
+	legacy := map[string]map[string]string{
+		store.DefaultBucket: {"twitter#prd": "key2", "stripe#prd": "key1", "a#b#prd": "key1", "taken#prd": "legacy"},
+		"key_metadata":      {"twitter#prd": `{"version":2,"rotated_at":"2024-01-01T00:00:00Z"}`},
+		"key_versions":      {"twitter#prd#1": "key1"},
+		"non_exportable":    {"stripe#prd": "true"},
+	}
+	for bucket, values := range legacy {
+		for k, v := range values {
+			if _, err := s.Put(ctx, bucket, k, []byte(v)); err != nil {
+				t.Fatalf("error setting %s: %v", k, err)
+			}
+		}
+	}
+	if err := kr.Put(ctx, "/taken/prd", "current", nil, nil, false); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	migrated, skipped, err := kr.MigrateLegacy(ctx)
+	if err != nil {
+		t.Fatalf("error migrating legacy keys: %v", err)
+	}
+	if !reflect.DeepEqual(migrated, []string{"/stripe/prd", "/twitter/prd"}) {
+		t.Errorf("expected /stripe/prd and /twitter/prd to be migrated, got %v", migrated)
+	}
+	if !reflect.DeepEqual(skipped, []string{"a#b#prd", "taken#prd"}) {
+		t.Errorf("expected a#b#prd and taken#prd to be skipped, got %v", skipped)
+	}
+
+	versions, err := kr.Versions(ctx, "/twitter/prd")
+	if err != nil || !reflect.DeepEqual(versions, []string{"key2", "key1"}) {
+		t.Errorf("expected versions [key2 key1], got %v, %v", versions, err)
+	}
+	if nonExportable, _ := kr.NonExportable(ctx, "/stripe/prd"); !nonExportable {
+		t.Errorf("expected /stripe/prd to stay non-exportable")
+	}
+	if key, _ := kr.Get(ctx, "/taken/prd"); key != "current" {
+		t.Errorf("expected the key at a taken path to be kept, got %s", key)
+	}
+	if _, err := s.Get(ctx, store.DefaultBucket, "twitter#prd"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected twitter#prd to be removed, got %v", err)
+	}
+
+	// migrating again only skips the keys left in place
+	migrated, skipped, err = kr.MigrateLegacy(ctx)
+	if err != nil || len(migrated) != 0 || len(skipped) != 2 {
+		t.Errorf("expected nothing to be migrated again, got %v, %v, %v", migrated, skipped, err)
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path string
+		err  bool
+	}{
+		{path: "/payments/stripe/prod"},
+		{path: "/twitter/prd"},
+		{path: "/prd", err: true},
+		{path: "twitter/prd", err: true},
+		{path: "/twitter/", err: true},
+		{path: "/twitter//prd", err: true},
+		{path: "/twitter/../prd", err: true},
+		{path: "/twitter#prd/prd", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if _, err := keyring.ParsePath(tt.path); (err != nil) != tt.err {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		period   string
+		expected time.Duration
+		err      bool
+	}{
+		{period: "90d", expected: 90 * 24 * time.Hour},
+		{period: "12h", expected: 12 * time.Hour},
+		{period: "0", expected: 0},
+		{period: "-1d", err: true},
+		{period: "soon", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			d, err := keyring.ParsePeriod(tt.period)
+			if (err != nil) != tt.err {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if d != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, d)
+			}
+		})
+	}
+}
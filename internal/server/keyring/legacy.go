@@ -0,0 +1,113 @@
+package keyring
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+// MigrateLegacy moves the keys stored as name#env in store.DefaultBucket by earlier releases to their path /name/env
+// along with their previous versions, metadata and non-exportable mark.
+// It returns the paths of the migrated keys and the legacy keys that were left in place because their name is not
+// a valid path or a key already exists at their path. Each key is moved in its own transaction so running it again
+// resumes an interrupted migration.
+func (k *Keyring) MigrateLegacy(ctx context.Context) ([]string, []string, error) {
+	lookupKeys, err := k.store.List(ctx, store.DefaultBucket, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	var migrated, skipped []string
+	for _, lookupKey := range lookupKeys {
+		i := strings.LastIndex(lookupKey, "#")
+		if i < 0 {
+			skipped = append(skipped, lookupKey)
+			continue
+		}
+		path, err := PathOf(lookupKey[:i], lookupKey[i+1:])
+		if err != nil {
+			skipped = append(skipped, lookupKey)
+			continue
+		}
+		moved := false
+		err = k.update(ctx, func(tx store.Tx) (*Change, error) {
+			var err error
+			moved, err = migrateLegacyKey(tx, lookupKey, path)
+			return nil, err
+		})
+		if err != nil {
+			return migrated, skipped, fmt.Errorf("error migrating %s to %s: %w", lookupKey, path, err)
+		}
+		if moved {
+			migrated = append(migrated, path)
+		} else {
+			skipped = append(skipped, lookupKey)
+		}
+	}
+	return migrated, skipped, nil
+}
+
+// migrateLegacyKey moves the legacy key to path, it returns false if a key already exists at path.
+func migrateLegacyKey(tx store.Tx, lookupKey, path string) (bool, error) {
+	r := reader(tx.Get)
+	current, err := r.get(store.DefaultBucket, lookupKey)
+	if err != nil || current == "" {
+		return false, err
+	}
+	if existing, err := r.current(path); err != nil || existing != "" {
+		return false, err
+	}
+
+	m, err := r.get(metadataBucket, lookupKey)
+	if err != nil {
+		return false, err
+	}
+	if m != "" {
+		if _, err := tx.Put(metadataBucket, path, []byte(m)); err != nil {
+			return false, err
+		}
+		if err := tx.Delete(metadataBucket, lookupKey); err != nil {
+			return false, err
+		}
+	}
+
+	versions, err := tx.List(versionsBucket, lookupKey+"#")
+	if err != nil {
+		return false, err
+	}
+	for _, vk := range versions {
+		v, err := r.get(versionsBucket, vk)
+		if err != nil {
+			return false, err
+		}
+		if _, err := tx.Put(versionsBucket, path+strings.TrimPrefix(vk, lookupKey), []byte(v)); err != nil {
+			return false, err
+		}
+		if err := tx.Delete(versionsBucket, vk); err != nil {
+			return false, err
+		}
+	}
+
+	ne, err := r.get(nonExportableBucket, lookupKey)
+	if err != nil {
+		return false, err
+	}
+	if ne != "" {
+		if _, err := tx.Put(nonExportableBucket, path, []byte(ne)); err != nil {
+			return false, err
+		}
+		if err := tx.Delete(nonExportableBucket, lookupKey); err != nil {
+			return false, err
+		}
+	}
+
+	ns, name, _ := split(path)
+	if _, err := tx.Put(namespacesBucket, ns, []byte{}); err != nil {
+		return false, err
+	}
+	if _, err := tx.Put(ns, name, []byte(current)); err != nil {
+		return false, err
+	}
+	return true, tx.Delete(store.DefaultBucket, lookupKey)
+}
@@ -0,0 +1,68 @@
+package keyring
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// segmentPattern matches a segment of a path, # is reserved as the version separator of the versions bucket.
+var segmentPattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ParsePath validates the path of a key such as /payments/stripe/prod.
+// The last segment names the key and the segments before it are its namespace, a key has a namespace of at least one segment.
+func ParsePath(path string) (string, error) {
+	ns, _, err := split(path)
+	if err != nil {
+		return "", err
+	}
+	if ns == "/" {
+		return "", fmt.Errorf("invalid key path %q, expected /<namespace>/<name>", path)
+	}
+	return path, nil
+}
+
+// PathOf returns the path of the key of an env, the name may itself be a path e.g. payments/stripe.
+func PathOf(name, env string) (string, error) {
+	return ParsePath("/" + strings.TrimPrefix(name, "/") + "/" + env)
+}
+
+// ParseNamespace validates the path of a namespace such as /payments, / being the root namespace.
+func ParseNamespace(path string) (string, error) {
+	if path == "/" {
+		return path, nil
+	}
+	if _, _, err := split(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// split returns the namespace of the path and its last segment.
+func split(path string) (string, string, error) {
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("invalid path %q, expected a leading /", path)
+	}
+	segments := strings.Split(path[1:], "/")
+	for _, s := range segments {
+		if !segmentPattern.MatchString(s) || s == "." || s == ".." {
+			return "", "", fmt.Errorf("invalid segment %q of path %q", s, path)
+		}
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/", path[1:], nil
+	}
+	return path[:i], path[i+1:], nil
+}
+
+// levels returns the root namespace followed by every namespace of the path and the path itself.
+func levels(path string) []string {
+	levels := []string{"/"}
+	for i := 1; i < len(path); i++ {
+		if path[i] == '/' {
+			levels = append(levels, path[:i])
+		}
+	}
+	return append(levels, path)
+}
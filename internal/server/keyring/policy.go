@@ -0,0 +1,126 @@
+package keyring
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+// policiesBucket holds the Policy attached to a namespace or key by path
+const policiesBucket = "policies"
+
+// Policy restricts the keys below the namespace or key it is attached to.
+// The policies of every level of a key are merged, the deepest level setting a field wins.
+type Policy struct {
+	// Readers are the identities allowed to read the keys, every identity granted the reader role when empty.
+	Readers []string `json:"readers,omitempty"`
+	// Writers are the identities allowed to write the keys, every identity granted the writer role when empty.
+	Writers []string `json:"writers,omitempty"`
+	// RotationPeriod rotates the keys without a rotation period of their own.
+	RotationPeriod time.Duration `json:"rotation_period,omitempty"`
+	// NonExportable makes the keys usable through the transit API only, it cannot be lifted at a deeper level.
+	NonExportable bool `json:"non_exportable,omitempty"`
+}
+
+// CanRead returns true if the identity is allowed to read the keys.
+func (p *Policy) CanRead(identity string) bool {
+	return len(p.Readers) == 0 || contains(p.Readers, identity)
+}
+
+// CanWrite returns true if the identity is allowed to write the keys.
+func (p *Policy) CanWrite(identity string) bool {
+	return len(p.Writers) == 0 || contains(p.Writers, identity)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// merge overrides the fields of p set by the deeper policy.
+func (p *Policy) merge(deeper *Policy) {
+	if deeper.Readers != nil {
+		p.Readers = deeper.Readers
+	}
+	if deeper.Writers != nil {
+		p.Writers = deeper.Writers
+	}
+	if deeper.RotationPeriod > 0 {
+		p.RotationPeriod = deeper.RotationPeriod
+	}
+	p.NonExportable = p.NonExportable || deeper.NonExportable
+}
+
+// Policy returns the policy attached to the path or nil if there is none.
+func (k *Keyring) Policy(ctx context.Context, path string) (*Policy, error) {
+	return k.reader(ctx).policy(path)
+}
+
+func (r reader) policy(path string) (*Policy, error) {
+	v, err := r.get(policiesBucket, path)
+	if err != nil || v == "" {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal([]byte(v), p); err != nil {
+		return nil, fmt.Errorf("error unmarshaling policy of %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// EffectivePolicy returns the policies attached to every level of the key path merged.
+func (k *Keyring) EffectivePolicy(ctx context.Context, path string) (*Policy, error) {
+	return k.reader(ctx).effectivePolicy(path)
+}
+
+func (r reader) effectivePolicy(path string) (*Policy, error) {
+	effective := &Policy{}
+	for _, level := range levels(path) {
+		p, err := r.policy(level)
+		if err != nil {
+			return nil, err
+		}
+		if p != nil {
+			effective.merge(p)
+		}
+	}
+	return effective, nil
+}
+
+// SetPolicy attaches the policy to the namespace or key path, replacing the previous one.
+func (k *Keyring) SetPolicy(ctx context.Context, path string, p *Policy) error {
+	if _, err := ParseNamespace(path); err != nil {
+		return err
+	}
+	v, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("error marshaling policy of %s: %w", path, err)
+	}
+	return k.update(ctx, func(tx store.Tx) (*Change, error) {
+		if _, err := tx.Put(policiesBucket, path, v); err != nil {
+			return nil, err
+		}
+		return &Change{Type: PolicySet, Path: path}, nil
+	})
+}
+
+// DeletePolicy detaches the policy of the namespace or key path.
+func (k *Keyring) DeletePolicy(ctx context.Context, path string) error {
+	return k.update(ctx, func(tx store.Tx) (*Change, error) {
+		v, err := reader(tx.Get).get(policiesBucket, path)
+		if err != nil || v == "" {
+			return nil, err
+		}
+		if err := tx.Delete(policiesBucket, path); err != nil {
+			return nil, err
+		}
+		return &Change{Type: PolicyDeleted, Path: path}, nil
+	})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/metrics"
+	"go.uber.org/zap"
+)
+
+// WithMetrics serves the metrics on GET /metrics, without authentication.
+// The metrics are served on a separate listen address when addr is set so they are not exposed with the API.
+func WithMetrics(m *metrics.Metrics, addr string) ServerOption {
+	return func(s *Server) {
+		s.metrics = m
+		if addr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", m.Handler())
+			s.metricsSrv = &http.Server{
+				Addr:    addr,
+				Handler: mux,
+			}
+		}
+	}
+}
+
+// metricsRecord collects why a measured request was denied while it is handled
+type metricsRecord struct {
+	authFailure string
+}
+
+// recordAuthFailure records the reason of the denial in the metrics.
+func recordAuthFailure(r *http.Request, err error) {
+	if mr, ok := r.Context().Value("metrics").(*metricsRecord); ok {
+		mr.authFailure = auth.FailureReason(err)
+	}
+}
+
+// measured records the latency and status of every request by the route pattern of the mux.
+func (s *Server) measured(mux *http.ServeMux, next http.Handler) http.Handler {
+	if s.metrics == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		// routes are labelled by pattern so unknown paths don't explode the label cardinality
+		route := "other"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+
+		mr := &metricsRecord{}
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), "metrics", mr)))
+
+		s.metrics.ObserveRequest(route, r.Method, sr.status, time.Since(start))
+		if mr.authFailure != "" {
+			s.metrics.AuthFailure(mr.authFailure)
+		}
+	})
+}
+
+// startMetrics serves the metrics on their own listen address in background.
+func (s *Server) startMetrics() {
+	if s.metricsSrv == nil {
+		return
+	}
+	go func() {
+		if err := s.metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("error serving metrics", zap.Error(err))
+		}
+	}()
+}
@@ -0,0 +1,158 @@
+// Package metrics collects Prometheus metrics of the key server.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+const namespace = "zypher"
+
+// Sizer is implemented by stores able to report their size on disk.
+type Sizer interface {
+	// Size returns the size of the store in bytes.
+	Size() (int64, error)
+}
+
+// Metrics holds the collectors of the key server in a registry of its own.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	authFailures    *prometheus.CounterVec
+	storeDuration   *prometheus.HistogramVec
+}
+
+// New returns Metrics with the Go runtime and process collectors registered.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of handled requests by route, method and status.",
+		}, []string{"route", "method", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of handled requests by route, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		authFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "auth",
+			Name:      "failures_total",
+			Help:      "Number of requests denied by reason.",
+		}, []string{"reason"}),
+		storeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "transaction_duration_seconds",
+			Help:      "Duration of store transactions by operation.",
+			Buckets:   []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.authFailures,
+		m.storeDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler returns a handler serving the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records a handled request.
+func (m *Metrics) ObserveRequest(route, method string, status int, d time.Duration) {
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(route, method, code).Inc()
+	m.requestDuration.WithLabelValues(route, method, code).Observe(d.Seconds())
+}
+
+// AuthFailure records a request denied for the reason.
+func (m *Metrics) AuthFailure(reason string) {
+	m.authFailures.WithLabelValues(reason).Inc()
+}
+
+// InstrumentStore returns a store recording the duration of every transaction of s.
+// The size of s is exported as well if it implements Sizer.
+func (m *Metrics) InstrumentStore(s store.Store) store.Store {
+	if sizer, ok := s.(Sizer); ok {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "store",
+			Name:      "size_bytes",
+			Help:      "Size of the store in bytes.",
+		}, func() float64 {
+			size, err := sizer.Size()
+			if err != nil {
+				return 0
+			}
+			return float64(size)
+		}))
+	}
+	return &instrumentedStore{store: s, duration: m.storeDuration}
+}
+
+type instrumentedStore struct {
+	store    store.Store
+	duration *prometheus.HistogramVec
+}
+
+func (s *instrumentedStore) observe(operation string, start time.Time) {
+	s.duration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func (s *instrumentedStore) Get(ctx context.Context, bucket, key string) (*store.Entry, error) {
+	defer s.observe("get", time.Now())
+	return s.store.Get(ctx, bucket, key)
+}
+
+func (s *instrumentedStore) Put(ctx context.Context, bucket, key string, value []byte) (*store.Metadata, error) {
+	defer s.observe("put", time.Now())
+	return s.store.Put(ctx, bucket, key, value)
+}
+
+func (s *instrumentedStore) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*store.Metadata, error) {
+	defer s.observe("compare_and_swap", time.Now())
+	return s.store.CompareAndSwap(ctx, bucket, key, version, value)
+}
+
+func (s *instrumentedStore) Delete(ctx context.Context, bucket, key string) error {
+	defer s.observe("delete", time.Now())
+	return s.store.Delete(ctx, bucket, key)
+}
+
+func (s *instrumentedStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	defer s.observe("list", time.Now())
+	return s.store.List(ctx, bucket, prefix)
+}
+
+func (s *instrumentedStore) Update(ctx context.Context, fn func(store.Tx) error) error {
+	defer s.observe("update", time.Now())
+	return s.store.Update(ctx, fn)
+}
+
+func (s *instrumentedStore) View(ctx context.Context, fn func(store.Tx) error) error {
+	defer s.observe("view", time.Now())
+	return s.store.View(ctx, fn)
+}
+
+func (s *instrumentedStore) Close() error {
+	return s.store.Close()
+}
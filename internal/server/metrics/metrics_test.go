@@ -0,0 +1,92 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vtno/zypher/internal/server/metrics"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/mock/gomock"
+)
+
+func scrape(t *testing.T, m *metrics.Metrics) string {
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("error reading metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetrics_InstrumentStore(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	mStore := store.NewMockStore(ctrl)
+	ctx := context.Background()
+	mStore.EXPECT().Get(ctx, store.DefaultBucket, "twitter#prd").Return(&store.Entry{Value: []byte("secret")}, nil).Times(1)
+	mStore.EXPECT().Put(ctx, "key_versions", "twitter#prd", []byte("secret")).Return(&store.Metadata{Version: 1}, nil).Times(1)
+
+	m := metrics.New()
+	s := m.InstrumentStore(mStore)
+	if e, err := s.Get(ctx, store.DefaultBucket, "twitter#prd"); err != nil || string(e.Value) != "secret" {
+		t.Fatalf("expected secret to be returned, got %+v %v", e, err)
+	}
+	if _, err := s.Put(ctx, "key_versions", "twitter#prd", []byte("secret")); err != nil {
+		t.Fatalf("error setting value: %v", err)
+	}
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		`zypher_store_transaction_duration_seconds_count{operation="get"} 1`,
+		`zypher_store_transaction_duration_seconds_count{operation="put"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to contain %s", expected)
+		}
+	}
+	if strings.Contains(body, "zypher_store_size_bytes") {
+		t.Errorf("expected no store size for a store not implementing Sizer")
+	}
+}
+
+func TestMetrics_StoreSize(t *testing.T) {
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	defer bbStore.Close()
+
+	size, err := bbStore.Size()
+	if err != nil {
+		t.Fatalf("error reading store size: %v", err)
+	}
+
+	m := metrics.New()
+	m.InstrumentStore(bbStore)
+	if body := scrape(t, m); !strings.Contains(body, fmt.Sprintf("zypher_store_size_bytes %d", size)) {
+		t.Errorf("expected metrics to contain the store size, got %s", body)
+	}
+}
+
+func TestMetrics_ObserveRequest(t *testing.T) {
+	m := metrics.New()
+	m.ObserveRequest("/key", "GET", 200, 0)
+	m.ObserveRequest("/key", "GET", 200, 0)
+	m.AuthFailure("token_expired")
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		`zypher_http_requests_total{method="GET",route="/key",status="200"} 2`,
+		`zypher_http_request_duration_seconds_count{method="GET",route="/key",status="200"} 2`,
+		`zypher_auth_failures_total{reason="token_expired"} 1`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected metrics to contain %s", expected)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+const (
+	MigrateHelpMsg = `Usage: zypher server migrate [options] --to=<store>
+	copies every bucket and key of a store to another one along with their versions and metadata,
+	then verifies the number of keys and checksum of every bucket.
+	keys already copied are skipped so an interrupted migration is resumed by running it again.
+	the server must be stopped.
+available options:
+	--from=<store>			store to copy. Default: bbolt://zypher.db
+	--to=<store>			store to copy to e.g. sqlite://zypher.sqlite or file://keys
+	--store-key-file=<path-to-file>	key of the file stores. Default: zypher-store.key
+	--dry-run			reports the keys to copy without writing them
+`
+	MigrateSynopsisMsg = "migrates the store of a key server to another store"
+)
+
+type MigrateCmd struct {
+	fs      *flag.FlagSet
+	from    string
+	to      string
+	keyPath string
+	dryRun  bool
+}
+
+func NewMigrateCmd() *MigrateCmd {
+	mc := &MigrateCmd{fs: flag.NewFlagSet("server migrate", flag.ContinueOnError)}
+	mc.fs.StringVar(&mc.from, "from", defaultStore, "store to copy")
+	mc.fs.StringVar(&mc.to, "to", "", "store to copy to")
+	mc.fs.StringVar(&mc.keyPath, "store-key-file", defaultStoreKeyPath, "key of the file stores")
+	mc.fs.BoolVar(&mc.dryRun, "dry-run", false, "reports the keys to copy without writing them")
+	return mc
+}
+
+func (mc *MigrateCmd) Help() string {
+	return MigrateHelpMsg
+}
+
+func (mc *MigrateCmd) Synopsis() string {
+	return MigrateSynopsisMsg
+}
+
+func (mc *MigrateCmd) Run(args []string) int {
+	if err := mc.fs.Parse(args); err != nil {
+		fmt.Printf("error parsing flag from args: %v\n", err)
+		return 1
+	}
+	if mc.to == "" || mc.fs.NArg() != 0 {
+		fmt.Print(MigrateHelpMsg)
+		return 1
+	}
+	if mc.from == mc.to {
+		fmt.Printf("cannot migrate %s to itself\n", mc.from)
+		return 1
+	}
+
+	// opening a missing store would create an empty one
+	if _, path, _ := strings.Cut(mc.from, "://"); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			fmt.Printf("error opening %s: %v\n", mc.from, err)
+			return 1
+		}
+	}
+	from, err := mc.open(mc.from)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	defer from.Close()
+	to, err := mc.open(mc.to)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	defer to.Close()
+
+	var opts []store.MigrateOption
+	if mc.dryRun {
+		opts = append(opts, store.WithDryRun())
+	}
+	report, err := store.Migrate(context.Background(), from, to, opts...)
+	for _, bm := range report {
+		fmt.Printf("%s: %d keys, %d copied, %d skipped, checksum %s\n", bm.Bucket, bm.Keys, bm.Copied, bm.Skipped, bm.Checksum)
+	}
+	if err != nil {
+		fmt.Printf("error migrating %s to %s: %v\n", mc.from, mc.to, err)
+		return 1
+	}
+	if mc.dryRun {
+		fmt.Printf("dry run, nothing was written to %s\n", mc.to)
+	} else {
+		fmt.Printf("migrated %s to %s\n", mc.from, mc.to)
+	}
+	return 0
+}
+
+// open opens the store at u, a bbolt db file in use by a running server is refused
+// rather than waiting for its lock.
+func (mc *MigrateCmd) open(u string) (store.Migratable, error) {
+	if path, found := strings.CutPrefix(u, "bbolt://"); found {
+		inUse, err := store.BBoltInUse(path)
+		if err != nil {
+			return nil, fmt.Errorf("error checking %s: %w", path, err)
+		}
+		if inUse {
+			return nil, fmt.Errorf("%s is in use, stop the server before migrating", path)
+		}
+	}
+	s, err := openStoreURL(u, mc.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening %s: %w", u, err)
+	}
+	return s, nil
+}
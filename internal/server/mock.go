@@ -5,8 +5,10 @@
 package server
 
 import (
+	http "net/http"
 	reflect "reflect"
 
+	auth "github.com/vtno/zypher/internal/server/auth"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -33,16 +35,17 @@ func (m *MockAuthGuard) EXPECT() *MockAuthGuardMockRecorder {
 	return m.recorder
 }
 
-// AuthenticateRoot mocks base method.
-func (m *MockAuthGuard) AuthenticateRoot(arg0 string) bool {
+// Authenticate mocks base method.
+func (m *MockAuthGuard) Authenticate(arg0 *http.Request) (*auth.Identity, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AuthenticateRoot", arg0)
-	ret0, _ := ret[0].(bool)
-	return ret0
+	ret := m.ctrl.Call(m, "Authenticate", arg0)
+	ret0, _ := ret[0].(*auth.Identity)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// AuthenticateRoot indicates an expected call of AuthenticateRoot.
-func (mr *MockAuthGuardMockRecorder) AuthenticateRoot(arg0 interface{}) *gomock.Call {
+// Authenticate indicates an expected call of Authenticate.
+func (mr *MockAuthGuardMockRecorder) Authenticate(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AuthenticateRoot", reflect.TypeOf((*MockAuthGuard)(nil).AuthenticateRoot), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Authenticate", reflect.TypeOf((*MockAuthGuard)(nil).Authenticate), arg0)
 }
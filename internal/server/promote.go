@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/vtno/zypher/internal/config"
+	"github.com/vtno/zypher/internal/keyserver"
+)
+
+const (
+	PromoteHelpMsg = `Usage: zypher server promote [options]
+	promotes the follower at --key-server to primary: it stops replicating its primary
+	and accepts writes. requires the admin role. the former primary must be stopped first
+	and can only rejoin as a follower with an empty db file.
+available options:
+	--key-server=<url>			url of the follower. env: ZYPHER_KEY_SERVER
+	--signing-key=<path-to-file>		private key signing requests. Default: zypher
+	--agent					sign requests with the ssh-agent at SSH_AUTH_SOCK
+	--fingerprint=<fingerprint>		fingerprint of the ssh-agent identity to sign with
+	--key-server-ca=<path-to-file>		PEM encoded CA certificates trusted to serve the key server
+`
+	PromoteSynopsisMsg = "promotes a follower key server to primary"
+)
+
+type PromoteCmd struct {
+	fs  *flag.FlagSet
+	cfg *config.Config
+}
+
+func NewPromoteCmd() *PromoteCmd {
+	pc := &PromoteCmd{
+		fs:  flag.NewFlagSet("server promote", flag.ContinueOnError),
+		cfg: &config.Config{},
+	}
+	keyserver.RegisterFlags(pc.fs, pc.cfg)
+	return pc
+}
+
+func (pc *PromoteCmd) Help() string {
+	return PromoteHelpMsg
+}
+
+func (pc *PromoteCmd) Synopsis() string {
+	return PromoteSynopsisMsg
+}
+
+func (pc *PromoteCmd) Run(args []string) int {
+	if err := pc.fs.Parse(args); err != nil {
+		fmt.Printf("error parsing flag from args: %v\n", err)
+		return 1
+	}
+	if pc.cfg.KeyServer.URL == "" {
+		fmt.Println("--key-server is required")
+		return 1
+	}
+	c, done, err := keyserver.NewClient(&pc.cfg.KeyServer)
+	if err != nil {
+		fmt.Printf("error creating key server client: %v\n", err)
+		return 1
+	}
+	defer done()
+
+	status, err := c.Promote(context.Background())
+	if err != nil {
+		fmt.Printf("error promoting key server: %v\n", err)
+		return 1
+	}
+	fmt.Printf("%s is %s at change %d\n", pc.cfg.KeyServer.URL, status.Role, status.Head)
+	return 0
+}
@@ -0,0 +1,41 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type TrustedCAProvider struct {
+	path string
+}
+
+func NewTrustedCAProvider(path string) *TrustedCAProvider {
+	return &TrustedCAProvider{
+		path: path,
+	}
+}
+
+// Get reads the trusted user CA public keys, one per line in authorized_keys format.
+func (p *TrustedCAProvider) Get() ([]ssh.PublicKey, error) {
+	fmt.Printf("loading trusted user CA keys from %s\n", p.path)
+	rest, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading trusted user CA keys at %s: %w", p.path, err)
+	}
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var pubKey ssh.PublicKey
+		pubKey, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing trusted user CA key: %w", err)
+		}
+		keys = append(keys, pubKey)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no trusted user CA key found in %s", p.path)
+	}
+	return keys, nil
+}
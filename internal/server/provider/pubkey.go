@@ -1,10 +1,10 @@
 package provider
 
 import (
-	"crypto/rsa"
 	"fmt"
 	"os"
 
+	"github.com/vtno/zypher/internal/server/auth"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -18,7 +18,10 @@ func NewPubKeyProvider(path string) *PubKeyProvider {
 	}
 }
 
-func (p *PubKeyProvider) Get() (*rsa.PublicKey, error) {
+// Get reads the public key in authorized_keys format and returns a verifier for it.
+// ssh-rsa, ssh-ed25519 and ecdsa-sha2-* keys are supported
+// and signatures can either be raw or SSH signature blobs produced by ssh-agent.
+func (p *PubKeyProvider) Get() (auth.Verifier, error) {
 	fmt.Printf("loading root public key from %s\n", p.path)
 	pub, err := os.ReadFile(p.path)
 	if err != nil {
@@ -29,5 +32,9 @@ func (p *PubKeyProvider) Get() (*rsa.PublicKey, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error parsing public key: %w", err)
 	}
-	return pubKey.(ssh.CryptoPublicKey).CryptoPublicKey().(*rsa.PublicKey), nil
+	v, err := auth.NewSSHVerifier(pubKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating verifier for %s key: %w", pubKey.Type(), err)
+	}
+	return v, nil
 }
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/ratelimit"
+	"go.uber.org/zap"
+)
+
+// Default throttling of authentication
+const (
+	DefaultIPRate           = 10
+	DefaultIPBurst          = 20
+	DefaultIdentityRate     = 20
+	DefaultIdentityBurst    = 40
+	DefaultLockoutThreshold = 5
+	DefaultLockoutBase      = 10 * time.Second
+	DefaultLockoutMax       = 15 * time.Minute
+)
+
+// WithIPRateLimit rate limits the requests of every source IP before they are authenticated.
+func WithIPRateLimit(l *ratelimit.Limiter) ServerOption {
+	return func(s *Server) {
+		s.ipLimiter = l
+	}
+}
+
+// WithIdentityRateLimit rate limits the requests of every authenticated identity.
+func WithIdentityRateLimit(l *ratelimit.Limiter) ServerOption {
+	return func(s *Server) {
+		s.identityLimiter = l
+	}
+}
+
+// WithLockout locks source IPs out after repeated signature failures.
+func WithLockout(l *ratelimit.Lockout) ServerOption {
+	return func(s *Server) {
+		s.lockout = l
+	}
+}
+
+// throttledError is returned when a request is rate limited or its source is locked out.
+type throttledError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *throttledError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", e.err, e.retryAfter.Round(time.Second))
+}
+
+func (e *throttledError) Unwrap() error {
+	return e.err
+}
+
+// throttledGuard rate limits and locks out requests before they reach the guard
+// so throttled clients don't cost a signature verification.
+// A replication request from a throttled source is still verified and let through if its own identity is
+// a replicator, so clients sharing the IP of a follower cannot stop replication. Identities are rate limited as usual.
+type throttledGuard struct {
+	guard    AuthGuard
+	ip       *ratelimit.Limiter
+	identity *ratelimit.Limiter
+	lockout  *ratelimit.Lockout
+	logger   *zap.Logger
+}
+
+func (g *throttledGuard) Authenticate(r *http.Request) (*auth.Identity, error) {
+	ip := sourceIP(r)
+	var throttled error
+	var retryAfter time.Duration
+	if g.lockout != nil {
+		if locked, wait := g.lockout.Locked(ip); locked {
+			throttled, retryAfter = auth.ErrLockedOut, wait
+		}
+	}
+	if throttled == nil && g.ip != nil {
+		if ok, wait := g.ip.Allow(ip); !ok {
+			throttled, retryAfter = auth.ErrRateLimited, wait
+		}
+	}
+
+	// replication requests are the only ones a follower can make, the others are throttled unverified
+	if throttled != nil && !strings.HasPrefix(r.URL.Path, "/sys/replication/") {
+		return nil, g.throttled(r, ip, "", throttled, retryAfter)
+	}
+
+	identity, err := g.guard.Authenticate(r)
+	switch {
+	case throttled != nil:
+		// only the verified identity of a follower is let through a throttled source
+		if err != nil || !isReplicator(identity) {
+			return nil, g.throttled(r, ip, "", throttled, retryAfter)
+		}
+	case err != nil:
+		if g.lockout != nil && (errors.Is(err, auth.ErrInvalidSignature) || errors.Is(err, auth.ErrUntrustedSigner)) {
+			if lockout := g.lockout.Fail(ip); lockout > 0 {
+				g.logger.Warn("locking out source after repeated signature failures",
+					zap.String("source_ip", ip), zap.Duration("lockout", lockout))
+			}
+		}
+		return nil, err
+	case g.lockout != nil:
+		g.lockout.Succeed(ip)
+	}
+
+	if g.identity != nil {
+		if ok, wait := g.identity.Allow(identity.Name); !ok {
+			return nil, g.throttled(r, ip, identity.Name, auth.ErrRateLimited, wait)
+		}
+	}
+	return identity, nil
+}
+
+// isReplicator returns true if the identity has been granted the replicator role itself,
+// admins being granted every role are not exempt.
+func isReplicator(identity *auth.Identity) bool {
+	for _, r := range identity.Roles {
+		if r == auth.RoleReplicator {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *throttledGuard) throttled(r *http.Request, ip, identity string, err error, retryAfter time.Duration) error {
+	g.logger.Warn("throttled request",
+		zap.String("path", r.URL.Path),
+		zap.String("source_ip", ip),
+		zap.String("identity", identity),
+		zap.String("reason", auth.FailureReason(err)),
+		zap.Duration("retry_after", retryAfter),
+	)
+	return &throttledError{err: err, retryAfter: retryAfter}
+}
+
+// unauthenticated writes 429 with a Retry-After header if the request was throttled, 401 otherwise.
+func unauthenticated(w http.ResponseWriter, r *http.Request, logger *zap.Logger, err error) {
+	recordDenial(r, err)
+	var te *throttledError
+	if errors.As(err, &te) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(te.retryAfter.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	logger.Warn("unauthenticated request", zap.String("path", r.URL.Path), zap.Error(err))
+	w.WriteHeader(http.StatusUnauthorized)
+}
@@ -0,0 +1,186 @@
+// Package ratelimit throttles key server requests with token buckets and locks out sources of repeated failures.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// maxEntries is the number of tracked keys above which idle entries are evicted
+const maxEntries = 10000
+
+type options struct {
+	now func() time.Time
+}
+
+type Option func(*options)
+
+// WithClock sets the clock used to refill buckets and expire lockouts.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{now: time.Now}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Limiter is a token bucket rate limiter per key e.g. a source IP or an identity.
+type Limiter struct {
+	rate    float64
+	burst   float64
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter allowing rate requests per second per key with bursts of up to burst requests.
+func NewLimiter(rate float64, burst int, opts ...Option) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		now:     newOptions(opts).now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of the key.
+// It returns false and how long to wait for the next token if the bucket is empty.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, found := l.buckets[key]
+	if !found {
+		l.evict(now)
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// evict forgets the buckets refilled by now once too many keys are tracked.
+func (l *Limiter) evict(now time.Time) {
+	if len(l.buckets) < maxEntries {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Lockout locks keys out after consecutive failures.
+// The lockout starts at base once threshold failures are reached and doubles with every further failure up to max.
+type Lockout struct {
+	threshold int
+	base      time.Duration
+	max       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	entries   map[string]*lockoutEntry
+}
+
+type lockoutEntry struct {
+	failures    int
+	lockedUntil time.Time
+	last        time.Time
+}
+
+// NewLockout returns a Lockout locking keys out after threshold consecutive failures.
+func NewLockout(threshold int, base, max time.Duration, opts ...Option) *Lockout {
+	return &Lockout{
+		threshold: threshold,
+		base:      base,
+		max:       max,
+		now:       newOptions(opts).now,
+		entries:   make(map[string]*lockoutEntry),
+	}
+}
+
+// Locked returns true and the remaining lockout of the key if it is locked out.
+func (l *Lockout) Locked(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, found := l.entries[key]
+	if !found {
+		return false, 0
+	}
+	if remaining := e.lockedUntil.Sub(l.now()); remaining > 0 {
+		return true, remaining
+	}
+	return false, 0
+}
+
+// Fail records a failure of the key and returns how long it is locked out for, zero if it is not.
+func (l *Lockout) Fail(key string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	e, found := l.entries[key]
+	if !found {
+		l.evict(now)
+		e = &lockoutEntry{}
+		l.entries[key] = e
+	} else if now.Sub(e.last) > l.max && !now.Before(e.lockedUntil) {
+		// failures are forgotten once the key has been quiet for longer than the maximum lockout
+		e.failures = 0
+	}
+	e.failures++
+	e.last = now
+	if e.failures < l.threshold {
+		return 0
+	}
+	lockout := l.max
+	if shift := e.failures - l.threshold; shift < 32 {
+		lockout = l.base << shift
+	}
+	if lockout > l.max || lockout <= 0 {
+		lockout = l.max
+	}
+	e.lockedUntil = now.Add(lockout)
+	return lockout
+}
+
+// Succeed resets the failures of the key.
+func (l *Lockout) Succeed(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// evict forgets the keys which are not locked out and quiet for longer than the maximum lockout once too many keys are tracked.
+func (l *Lockout) evict(now time.Time) {
+	if len(l.entries) < maxEntries {
+		return
+	}
+	for key, e := range l.entries {
+		if !now.Before(e.lockedUntil) && now.Sub(e.last) > l.max {
+			delete(l.entries, key)
+		}
+	}
+}
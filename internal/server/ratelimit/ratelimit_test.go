@@ -0,0 +1,101 @@
+package ratelimit_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/ratelimit"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestLimiter_Allow(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := ratelimit.NewLimiter(2, 3, ratelimit.WithClock(c.Now))
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("10.0.0.1"); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	ok, wait := l.Allow("10.0.0.1")
+	if ok {
+		t.Fatalf("expected the request after the burst to be throttled")
+	}
+	if wait != 500*time.Millisecond {
+		t.Errorf("expected to wait %s, got %s", 500*time.Millisecond, wait)
+	}
+	if ok, _ := l.Allow("10.0.0.2"); !ok {
+		t.Errorf("expected another key to have its own bucket")
+	}
+
+	c.Advance(500 * time.Millisecond)
+	if ok, _ := l.Allow("10.0.0.1"); !ok {
+		t.Errorf("expected a request to be allowed once a token is refilled")
+	}
+	if ok, _ := l.Allow("10.0.0.1"); ok {
+		t.Errorf("expected the refilled token to be used")
+	}
+
+	c.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("10.0.0.1"); !ok {
+			t.Fatalf("expected the bucket to refill up to the burst")
+		}
+	}
+	if ok, _ := l.Allow("10.0.0.1"); ok {
+		t.Errorf("expected the bucket not to refill beyond the burst")
+	}
+}
+
+func TestLockout(t *testing.T) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := ratelimit.NewLockout(3, 10*time.Second, time.Minute, ratelimit.WithClock(c.Now))
+
+	tests := []struct {
+		name            string
+		expectedLockout time.Duration
+	}{
+		{name: "first failure is not locked out", expectedLockout: 0},
+		{name: "second failure is not locked out", expectedLockout: 0},
+		{name: "threshold failure is locked out for the base", expectedLockout: 10 * time.Second},
+		{name: "further failure doubles the lockout", expectedLockout: 20 * time.Second},
+		{name: "lockout doubles again", expectedLockout: 40 * time.Second},
+		{name: "lockout is capped", expectedLockout: time.Minute},
+		{name: "lockout stays capped", expectedLockout: time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if lockout := l.Fail("10.0.0.1"); lockout != tt.expectedLockout {
+				t.Errorf("expected lockout %s, got %s", tt.expectedLockout, lockout)
+			}
+		})
+	}
+
+	if locked, remaining := l.Locked("10.0.0.1"); !locked || remaining != time.Minute {
+		t.Errorf("expected key to be locked out for %s, got %v %s", time.Minute, locked, remaining)
+	}
+	if locked, _ := l.Locked("10.0.0.2"); locked {
+		t.Errorf("expected another key not to be locked out")
+	}
+
+	c.Advance(time.Minute)
+	if locked, _ := l.Locked("10.0.0.1"); locked {
+		t.Errorf("expected the lockout to expire")
+	}
+
+	l.Succeed("10.0.0.1")
+	if lockout := l.Fail("10.0.0.1"); lockout != 0 {
+		t.Errorf("expected failures to be reset by a success, got lockout %s", lockout)
+	}
+}
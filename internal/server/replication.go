@@ -0,0 +1,190 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/handlers"
+	"github.com/vtno/zypher/internal/server/replication"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/zap"
+)
+
+const (
+	rolePrimary  = "primary"
+	roleFollower = "follower"
+	// primaryHeader tells clients of a follower where to send writes
+	primaryHeader = "X-Zypher-Primary"
+
+	// DefaultChangeLogCompactionInterval is how often the change log is truncated by default.
+	DefaultChangeLogCompactionInterval = time.Minute
+	// DefaultChangeLogRetention is how long the changes a follower has not replicated are kept by default.
+	DefaultChangeLogRetention = time.Hour
+)
+
+// followerCursor is the sequence number a follower replicated up to when it was last seen.
+type followerCursor struct {
+	seq  uint64
+	seen time.Time
+}
+
+// WithFollower makes the server a read-only follower of the primary at primaryURL replicated by f.
+// Writes are rejected with 421 and the primary URL in the X-Zypher-Primary header
+// unless WithWriteForwarding is set. The store must record a change log.
+func WithFollower(f *replication.Follower, primaryURL *url.URL) ServerOption {
+	return func(s *Server) {
+		s.follower = f
+		s.primaryURL = primaryURL
+	}
+}
+
+// WithWriteForwarding forwards the writes received while following to the primary with the transport.
+func WithWriteForwarding(transport http.RoundTripper) ServerOption {
+	return func(s *Server) {
+		s.forwardTransport = transport
+	}
+}
+
+// WithChangeLogCompaction truncates the change log every interval up to the oldest change replicated by
+// the followers seen within retention. Followers behind the truncated changes load a snapshot instead.
+// The change log is never truncated when interval is zero.
+func WithChangeLogCompaction(interval, retention time.Duration) ServerOption {
+	return func(s *Server) {
+		s.compactionInterval = interval
+		s.changeLogRetention = retention
+	}
+}
+
+// changeLogOf returns the store as a ChangeLog if it records one, followers record the changes they apply.
+func changeLogOf(s store.Store) store.ChangeLog {
+	if log, ok := s.(store.ChangeLog); ok && log.Recording() {
+		return log
+	}
+	return nil
+}
+
+// replicated records the sequence number a follower replicated up to.
+func (s *Server) replicated(follower string, seq uint64) {
+	s.cursorsMu.Lock()
+	defer s.cursorsMu.Unlock()
+	s.cursors[follower] = followerCursor{seq: seq, seen: time.Now()}
+}
+
+// startCompaction truncates the change log in background every compaction interval until the server is stopped.
+func (s *Server) startCompaction() {
+	if s.changeLog == nil || s.compactionInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopCompaction = cancel
+	started := time.Now()
+	go func() {
+		ticker := time.NewTicker(s.compactionInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				// followers replicating before the server started are waited for once the retention elapsed
+				if now.Sub(started) >= s.changeLogRetention {
+					s.compactChangeLog(now)
+				}
+			}
+		}
+	}()
+}
+
+// compactChangeLog truncates the change log up to the oldest change replicated by the followers seen
+// within the retention, forgetting the others.
+func (s *Server) compactChangeLog(now time.Time) {
+	upTo, err := s.changeLog.Head()
+	if err != nil {
+		s.logger.Error("error reading change log head", zap.Error(err))
+		return
+	}
+	s.cursorsMu.Lock()
+	for follower, c := range s.cursors {
+		if now.Sub(c.seen) > s.changeLogRetention {
+			s.logger.Warn("follower not seen within the change log retention", zap.String("follower", follower))
+			delete(s.cursors, follower)
+			continue
+		}
+		if c.seq < upTo {
+			upTo = c.seq
+		}
+	}
+	s.cursorsMu.Unlock()
+	n, err := s.changeLog.Truncate(upTo)
+	if err != nil {
+		s.logger.Error("error compacting change log", zap.Error(err))
+		return
+	}
+	if n > 0 {
+		s.logger.Debug("compacted change log", zap.Int("changes", n), zap.Uint64("up_to", upTo))
+	}
+}
+
+// newForwarder returns a reverse proxy sending requests to the primary as is, signatures included.
+func newForwarder(primaryURL *url.URL, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(primaryURL)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = primaryURL.Host
+	}
+	proxy.Transport = transport
+	return proxy
+}
+
+// isFollowing returns true while the server replicates a primary.
+func (s *Server) isFollowing() bool {
+	return s.following.Load()
+}
+
+// misdirected forwards a write received while following to the primary or rejects it.
+func (s *Server) misdirected(w http.ResponseWriter, r *http.Request) {
+	if s.forwarder != nil {
+		s.forwarder.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set(primaryHeader, s.primaryURL.String())
+	w.WriteHeader(http.StatusMisdirectedRequest)
+}
+
+// promote stops following the primary and accepts writes once changes being applied are committed.
+func (s *Server) promote(w http.ResponseWriter, r *http.Request) {
+	s.promoteMu.Lock()
+	if s.isFollowing() {
+		s.follower.Stop()
+		s.following.Store(false)
+		s.logger.Info("promoted to primary", zap.String("former_primary", s.primaryURL.String()))
+	}
+	s.promoteMu.Unlock()
+	s.replicationStatus(w, r)
+}
+
+func (s *Server) replicationStatus(w http.ResponseWriter, r *http.Request) {
+	head, err := s.changeLog.Head()
+	if err != nil {
+		s.logger.Error("error reading change log head", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	status := &handlers.ReplicationStatus{Role: rolePrimary, Head: head}
+	if s.isFollowing() {
+		status.Role = roleFollower
+		status.Primary = s.primaryURL.String()
+	}
+	res, err := json.Marshal(status)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(res)
+}
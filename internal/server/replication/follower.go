@@ -0,0 +1,184 @@
+// Package replication keeps a follower key server store in sync with the change log of its primary.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultWait is how long a request for changes waits on the primary for a change to be recorded.
+	DefaultWait = 30 * time.Second
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+)
+
+// Primary is the key server replicated by a follower, it is implemented by client.Client.
+type Primary interface {
+	ReplicationSnapshot(ctx context.Context) (*client.Changes, error)
+	ReplicationChanges(ctx context.Context, since uint64, wait time.Duration) (*client.Changes, error)
+}
+
+// Follower replays the changes of a primary into a local store.
+// It loads a snapshot of the primary when it starts with an empty change log
+// or when the primary can no longer replay the changes following its head.
+type Follower struct {
+	primary Primary
+	log     store.ChangeLog
+	logger  *zap.Logger
+	wait    time.Duration
+	backoff time.Duration
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	// synced is set once the store has been loaded from the primary or caught up with it
+	synced bool
+}
+
+type FollowerOption func(*Follower)
+
+// WithWait sets how long a request for changes waits on the primary for a change to be recorded.
+func WithWait(d time.Duration) FollowerOption {
+	return func(f *Follower) {
+		f.wait = d
+	}
+}
+
+// WithBackoff sets the initial delay before retrying after the primary failed to respond.
+// The delay doubles on every consecutive failure up to 30s.
+func WithBackoff(d time.Duration) FollowerOption {
+	return func(f *Follower) {
+		f.backoff = d
+	}
+}
+
+func NewFollower(primary Primary, log store.ChangeLog, logger *zap.Logger, opts ...FollowerOption) *Follower {
+	f := &Follower{
+		primary: primary,
+		log:     log,
+		logger:  logger,
+		wait:    DefaultWait,
+		backoff: minBackoff,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
+}
+
+// Start replicates the primary in background until Stop is called.
+func (f *Follower) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	f.done = make(chan struct{})
+	go f.run(ctx, f.done)
+}
+
+// Stop stops replicating and waits for changes being applied to be committed.
+func (f *Follower) Stop() {
+	f.mu.Lock()
+	cancel, done := f.cancel, f.done
+	f.cancel = nil
+	f.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (f *Follower) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	backoff := f.backoff
+	for ctx.Err() == nil {
+		if err := f.Sync(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			f.logger.Error("error replicating primary", zap.Error(err), zap.Duration("retry_in", backoff))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+		backoff = f.backoff
+	}
+}
+
+// Sync applies the next changes of the primary, waiting for them if the store is up to date.
+func (f *Follower) Sync(ctx context.Context) error {
+	head, err := f.log.Head()
+	if err != nil {
+		return err
+	}
+	if head == 0 && !f.synced {
+		return f.load(ctx)
+	}
+	res, err := f.primary.ReplicationChanges(ctx, head, f.wait)
+	if errors.Is(err, client.ErrGone) {
+		f.logger.Warn("primary cannot replay changes, loading a snapshot", zap.Uint64("head", head))
+		return f.load(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching changes since %d: %w", head, err)
+	}
+	f.synced = true
+	err = f.log.Apply(storeChanges(res))
+	if errors.Is(err, store.ErrChangeLogGap) {
+		f.logger.Warn("changes do not follow the head, loading a snapshot", zap.Uint64("head", head), zap.Error(err))
+		return f.load(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if len(res.Changes) > 0 {
+		f.logger.Debug("applied changes", zap.Int("changes", len(res.Changes)), zap.Uint64("head", res.Head))
+	}
+	return nil
+}
+
+func (f *Follower) load(ctx context.Context) error {
+	res, err := f.primary.ReplicationSnapshot(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching snapshot: %w", err)
+	}
+	if err := f.log.Load(storeChanges(res), res.Head); err != nil {
+		return err
+	}
+	f.synced = true
+	f.logger.Info("loaded snapshot of primary", zap.Int("values", len(res.Changes)), zap.Uint64("head", res.Head))
+	return nil
+}
+
+// storeChanges returns the changes of the primary as changes of the local store.
+func storeChanges(c *client.Changes) []store.Change {
+	changes := make([]store.Change, 0, len(c.Changes))
+	for _, change := range c.Changes {
+		changes = append(changes, store.Change{
+			Seq:    change.Seq,
+			Op:     change.Op,
+			Bucket: change.Bucket,
+			Key:    change.Key,
+			Value:  change.Value,
+		})
+	}
+	return changes
+}
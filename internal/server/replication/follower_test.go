@@ -0,0 +1,143 @@
+package replication_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/client"
+	"github.com/vtno/zypher/internal/server/replication"
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/mock/gomock"
+	"go.uber.org/zap"
+)
+
+func TestFollower_Sync(t *testing.T) {
+	ctx := context.Background()
+	gone := &client.StatusError{Method: "GET", Path: "/sys/replication/changes", StatusCode: http.StatusGone, Status: "410 Gone"}
+	snapshot := &client.Changes{
+		Head:    7,
+		Changes: []client.Change{{Op: store.OpSet, Bucket: "zypher", Key: "twitter#prd", Value: []byte("v1")}},
+	}
+	next := &client.Changes{
+		Head:    8,
+		Changes: []client.Change{{Seq: 8, Op: store.OpSet, Bucket: "zypher", Key: "twitter#prd", Value: []byte("v2")}},
+	}
+
+	tests := []struct {
+		name          string
+		head          uint64
+		expect        func(*replication.MockPrimary)
+		expectedValue string
+		expectedHead  uint64
+		expectedErr   bool
+	}{
+		{
+			name: "loads a snapshot into an empty store",
+			expect: func(p *replication.MockPrimary) {
+				p.EXPECT().ReplicationSnapshot(gomock.Any()).Return(snapshot, nil)
+			},
+			expectedValue: "v1",
+			expectedHead:  7,
+		},
+		{
+			name: "applies the changes following the head",
+			head: 7,
+			expect: func(p *replication.MockPrimary) {
+				p.EXPECT().ReplicationChanges(gomock.Any(), uint64(7), time.Second).Return(next, nil)
+			},
+			expectedValue: "v2",
+			expectedHead:  8,
+		},
+		{
+			name: "loads a snapshot when the primary cannot replay the changes",
+			head: 3,
+			expect: func(p *replication.MockPrimary) {
+				p.EXPECT().ReplicationChanges(gomock.Any(), uint64(3), time.Second).Return(nil, gone)
+				p.EXPECT().ReplicationSnapshot(gomock.Any()).Return(snapshot, nil)
+			},
+			expectedValue: "v1",
+			expectedHead:  7,
+		},
+		{
+			name: "loads a snapshot when the changes do not follow the head",
+			head: 5,
+			expect: func(p *replication.MockPrimary) {
+				p.EXPECT().ReplicationChanges(gomock.Any(), uint64(5), time.Second).Return(next, nil)
+				p.EXPECT().ReplicationSnapshot(gomock.Any()).Return(snapshot, nil)
+			},
+			expectedValue: "v1",
+			expectedHead:  7,
+		},
+		{
+			name: "returns the error of the primary",
+			head: 7,
+			expect: func(p *replication.MockPrimary) {
+				p.EXPECT().ReplicationChanges(gomock.Any(), uint64(7), time.Second).Return(nil, errors.New("connection refused"))
+			},
+			expectedValue: "v0",
+			expectedHead:  7,
+			expectedErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			primary := replication.NewMockPrimary(ctrl)
+			tt.expect(primary)
+
+			s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "follower.db"))
+			if err != nil {
+				t.Fatalf("error creating store: %v", err)
+			}
+			defer s.Close()
+			if tt.head > 0 {
+				if err := s.Load([]store.Change{{Op: store.OpSet, Bucket: "zypher", Key: "twitter#prd", Value: []byte("v0")}}, tt.head); err != nil {
+					t.Fatalf("error loading store: %v", err)
+				}
+			}
+
+			f := replication.NewFollower(primary, s, zap.NewNop(), replication.WithWait(time.Second))
+			err = f.Sync(ctx)
+			if (err != nil) != tt.expectedErr {
+				t.Errorf("expected error to be %v, got %v", tt.expectedErr, err)
+			}
+			if e, err := s.Get(context.Background(), store.DefaultBucket, "twitter#prd"); err != nil || string(e.Value) != tt.expectedValue {
+				t.Errorf("expected value to be %s, got %+v", tt.expectedValue, e)
+			}
+			if h, _ := s.Head(); h != tt.expectedHead {
+				t.Errorf("expected head to be %d, got %d", tt.expectedHead, h)
+			}
+		})
+	}
+}
+
+func TestFollower_StartStop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	primary := replication.NewMockPrimary(ctrl)
+	primary.EXPECT().ReplicationSnapshot(gomock.Any()).Return(nil, errors.New("connection refused")).MinTimes(1)
+
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "follower.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	defer s.Close()
+
+	f := replication.NewFollower(primary, s, zap.NewNop(), replication.WithBackoff(time.Hour))
+	f.Start()
+	time.Sleep(50 * time.Millisecond)
+	stopped := make(chan struct{})
+	go func() {
+		f.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Errorf("expected Stop to interrupt the backoff")
+	}
+}
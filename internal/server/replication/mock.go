@@ -0,0 +1,67 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: internal/server/replication/follower.go
+
+// Package replication is a generated GoMock package.
+package replication
+
+import (
+	context "context"
+	reflect "reflect"
+	time "time"
+
+	client "github.com/vtno/zypher/client"
+	gomock "go.uber.org/mock/gomock"
+)
+
+// MockPrimary is a mock of Primary interface.
+type MockPrimary struct {
+	ctrl     *gomock.Controller
+	recorder *MockPrimaryMockRecorder
+}
+
+// MockPrimaryMockRecorder is the mock recorder for MockPrimary.
+type MockPrimaryMockRecorder struct {
+	mock *MockPrimary
+}
+
+// NewMockPrimary creates a new mock instance.
+func NewMockPrimary(ctrl *gomock.Controller) *MockPrimary {
+	mock := &MockPrimary{ctrl: ctrl}
+	mock.recorder = &MockPrimaryMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPrimary) EXPECT() *MockPrimaryMockRecorder {
+	return m.recorder
+}
+
+// ReplicationChanges mocks base method.
+func (m *MockPrimary) ReplicationChanges(ctx context.Context, since uint64, wait time.Duration) (*client.Changes, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplicationChanges", ctx, since, wait)
+	ret0, _ := ret[0].(*client.Changes)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReplicationChanges indicates an expected call of ReplicationChanges.
+func (mr *MockPrimaryMockRecorder) ReplicationChanges(ctx, since, wait interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplicationChanges", reflect.TypeOf((*MockPrimary)(nil).ReplicationChanges), ctx, since, wait)
+}
+
+// ReplicationSnapshot mocks base method.
+func (m *MockPrimary) ReplicationSnapshot(ctx context.Context) (*client.Changes, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReplicationSnapshot", ctx)
+	ret0, _ := ret[0].(*client.Changes)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ReplicationSnapshot indicates an expected call of ReplicationSnapshot.
+func (mr *MockPrimaryMockRecorder) ReplicationSnapshot(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReplicationSnapshot", reflect.TypeOf((*MockPrimary)(nil).ReplicationSnapshot), ctx)
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/vtno/zypher"
+	"github.com/vtno/zypher/internal/server/backup"
+)
+
+const (
+	RestoreHelpMsg = `Usage: zypher server restore [options] <path-to-backup>
+	decrypts and verifies a backup taken by GET /sys/backup or --backup-interval
+	and replaces the db file of the key server with it. the server must be stopped.
+	the replaced db file is kept with a .pre-restore suffix.
+available options:
+	--backup-key-file=<path-to-file>	key the backup is encrypted with. Default: zypher-backup.key
+	--db=<path-to-file>			db file to replace. Default: zypher.db
+`
+	RestoreSynopsisMsg = "restores the db of a key server from a backup"
+)
+
+type RestoreCmd struct {
+	fs      *flag.FlagSet
+	keyPath string
+	dbPath  string
+}
+
+func NewRestoreCmd() *RestoreCmd {
+	rc := &RestoreCmd{fs: flag.NewFlagSet("server restore", flag.ContinueOnError)}
+	rc.fs.StringVar(&rc.keyPath, "backup-key-file", defaultBackupKeyPath, "key the backup is encrypted with")
+	rc.fs.StringVar(&rc.dbPath, "db", defaultDbPath, "db file to replace")
+	return rc
+}
+
+func (rc *RestoreCmd) Help() string {
+	return RestoreHelpMsg
+}
+
+func (rc *RestoreCmd) Synopsis() string {
+	return RestoreSynopsisMsg
+}
+
+func (rc *RestoreCmd) Run(args []string) int {
+	if err := rc.fs.Parse(args); err != nil {
+		fmt.Printf("error parsing flag from args: %v\n", err)
+		return 1
+	}
+	if rc.fs.NArg() != 1 {
+		fmt.Print(RestoreHelpMsg)
+		return 1
+	}
+
+	c, err := loadBackupKey(rc.keyPath)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return 1
+	}
+	f, err := os.Open(rc.fs.Arg(0))
+	if err != nil {
+		fmt.Printf("error opening backup: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+
+	if err := backup.Restore(f, c, rc.dbPath); err != nil {
+		fmt.Printf("error restoring backup: %v\n", err)
+		return 1
+	}
+	fmt.Printf("restored %s from %s\n", rc.dbPath, rc.fs.Arg(0))
+	return 0
+}
+
+// loadBackupKey returns a cipher of the key file at path e.g. generated by zypher keygen.
+func loadBackupKey(path string) (backup.Cipher, error) {
+	return loadKey(path, "backup")
+}
+
+// loadKey returns a cipher of the key file at path, name describes the key in errors.
+func loadKey(path, name string) (*zypher.Cipher, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s key at %s: %w", name, path, err)
+	}
+	// key files are hex encoded, a trailing newline left by an editor is not part of the key
+	c := zypher.NewCipher(strings.TrimRightFunc(string(key), unicode.IsSpace))
+	if _, err := c.Encrypt(nil); err != nil {
+		return nil, fmt.Errorf("invalid %s key at %s: %w", name, path, err)
+	}
+	return c, nil
+}
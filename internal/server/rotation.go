@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultRotationInterval is how often keys are checked for rotation by default.
+const DefaultRotationInterval = time.Hour
+
+// WithRotationInterval sets how often keys are checked against their rotation policy.
+// Automatic rotation is disabled when d is zero.
+func WithRotationInterval(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.rotationInterval = d
+	}
+}
+
+// startRotation rotates overdue keys in background every rotation interval until the server is stopped.
+func (s *Server) startRotation() {
+	if s.rotationInterval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopRotation = cancel
+	go func() {
+		ticker := time.NewTicker(s.rotationInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.rotateKeys()
+			}
+		}
+	}()
+}
+
+// rotateKeys rotates overdue keys unless the server follows a primary, which rotates them instead.
+func (s *Server) rotateKeys() {
+	if s.isFollowing() {
+		return
+	}
+	rotated, err := s.keyring.RotateDue(context.Background())
+	for _, lookupKey := range rotated {
+		s.logger.Info("rotated key", zap.String("key", lookupKey))
+	}
+	for _, err := range unjoin(err) {
+		s.logger.Error("error rotating keys", zap.Error(err))
+	}
+}
+
+// unjoin returns the errors joined in err one by one so each of them is logged.
+func unjoin(err error) []error {
+	if err == nil {
+		return nil
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
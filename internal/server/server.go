@@ -2,24 +2,84 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/vtno/zypher"
+	"github.com/vtno/zypher/internal/server/audit"
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/backup"
 	"github.com/vtno/zypher/internal/server/handlers"
+	"github.com/vtno/zypher/internal/server/keyring"
+	"github.com/vtno/zypher/internal/server/metrics"
+	"github.com/vtno/zypher/internal/server/ratelimit"
+	"github.com/vtno/zypher/internal/server/replication"
 	"github.com/vtno/zypher/internal/server/store"
+	"github.com/vtno/zypher/internal/server/webhook"
 	"go.uber.org/zap"
 )
 
 // AuthGuard provide a guard call to check if a request is authorized
 type AuthGuard interface {
-	AuthenticateRoot(string) bool
+	// Authenticate returns the identity that signed the request or an error if the request is not authenticated.
+	Authenticate(*http.Request) (*auth.Identity, error)
 }
 
 // Server is a struct that represents a server
 type Server struct {
-	srv   *http.Server
-	store store.Store
-	logger *zap.Logger
+	srv     *http.Server
+	store   store.Store
+	logger  *zap.Logger
+	keyring *keyring.Keyring
+	// rotationInterval is how often keys are checked for rotation, rotation is disabled when zero
+	rotationInterval time.Duration
+	stopRotation     context.CancelFunc
+
+	expirySweepInterval time.Duration
+	expiryGrace         time.Duration
+	stopExpirySweep     context.CancelFunc
+
+	auditLog *audit.Log
+	metrics  *metrics.Metrics
+	// metricsSrv serves the metrics on their own listen address, metrics are served with the API when nil
+	metricsSrv *http.Server
+	tracing    bool
+
+	ipLimiter       *ratelimit.Limiter
+	identityLimiter *ratelimit.Limiter
+	lockout         *ratelimit.Lockout
+
+	// snapshotter is the unwrapped store if it supports snapshots
+	snapshotter     store.Snapshotter
+	backupCipher    backup.Cipher
+	backupDir       string
+	backupInterval  time.Duration
+	backupRetention int
+	stopBackups     context.CancelFunc
+
+	// changeLog is the unwrapped store if it records a change log
+	changeLog        store.ChangeLog
+	follower         *replication.Follower
+	primaryURL       *url.URL
+	following        atomic.Bool
+	promoteMu        sync.Mutex
+	forwardTransport http.RoundTripper
+	// forwarder sends writes to the primary while following, they are rejected when nil
+	forwarder *httputil.ReverseProxy
+	// compactionInterval is how often the change log is truncated, it is never truncated when zero
+	compactionInterval time.Duration
+	changeLogRetention time.Duration
+	stopCompaction     context.CancelFunc
+	cursorsMu          sync.Mutex
+	cursors            map[string]followerCursor
+
+	webhooks *webhook.Dispatcher
 }
 
 type ServerOption func(*Server)
@@ -31,30 +91,165 @@ func WithPort(port int) ServerOption {
 	}
 }
 
-const defaultDbPath = "zypher.db"
+// WithTLSConfig serves the API over TLS using the provided config
+func WithTLSConfig(cfg *tls.Config) ServerOption {
+	return func(s *Server) {
+		s.srv.TLSConfig = cfg
+	}
+}
+
+const (
+	defaultDbPath       = "zypher.db"
+	defaultStore        = "bbolt://" + defaultDbPath
+	defaultStoreKeyPath = "zypher-store.key"
+	defaultAuditLogPath = "zypher-audit.jsonl"
+)
 
 // NewServer returns a new Server
-func NewServer(bbStore store.Store, auth AuthGuard, logger *zap.Logger, opts ...ServerOption) (*Server, error) {
+func NewServer(bbStore store.Store, guard AuthGuard, logger *zap.Logger, opts ...ServerOption) (*Server, error) {
 	mux := http.NewServeMux()
-	kh := handlers.NewKeyHandler(bbStore)
+	httpSrv := http.Server{
+		Addr:    ":8080",
+		Handler: mux,
+	}
+
+	srv := &Server{
+		srv:                 &httpSrv,
+		logger:              logger,
+		rotationInterval:    DefaultRotationInterval,
+		expirySweepInterval: DefaultExpirySweepInterval,
+		expiryGrace:         DefaultExpiryGrace,
+		compactionInterval:  DefaultChangeLogCompactionInterval,
+		changeLogRetention:  DefaultChangeLogRetention,
+		cursors:             make(map[string]followerCursor),
+	}
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	srv.snapshotter = snapshotterOf(bbStore)
+	srv.changeLog = changeLogOf(bbStore)
+	if srv.follower != nil {
+		if srv.changeLog == nil {
+			return nil, fmt.Errorf("a follower requires a store recording a change log")
+		}
+		srv.following.Store(true)
+		if srv.forwardTransport != nil {
+			srv.forwarder = newForwarder(srv.primaryURL, srv.forwardTransport)
+		}
+	}
+	if srv.metrics != nil {
+		bbStore = srv.metrics.InstrumentStore(bbStore)
+	}
+	if srv.webhooks != nil {
+		if srv.follower != nil {
+			return nil, fmt.Errorf("a follower cannot deliver webhooks")
+		}
+		guard = &notifiedGuard{guard: guard, webhooks: srv.webhooks}
+	}
+	if srv.ipLimiter != nil || srv.identityLimiter != nil || srv.lockout != nil {
+		guard = &throttledGuard{
+			guard:    guard,
+			ip:       srv.ipLimiter,
+			identity: srv.identityLimiter,
+			lockout:  srv.lockout,
+			logger:   logger,
+		}
+	}
+	if srv.tracing {
+		bbStore = &tracedStore{store: bbStore}
+		guard = &tracedGuard{guard: guard}
+	}
+	srv.store = bbStore
+
+	var krOpts []keyring.KeyringOption
+	if srv.webhooks != nil {
+		krOpts = append(krOpts, keyring.WithListener(srv.notifyKeyChange))
+	}
+	kr := keyring.New(bbStore, krOpts...)
+	srv.keyring = kr
+	kh := handlers.NewKeyHandler(bbStore, kr)
+	getKey := srv.span("KeyHandler.Get", kh.Get)
+	postKey := srv.span("KeyHandler.Post", kh.Post)
+	deleteKey := srv.span("KeyHandler.Delete", kh.Delete)
 	mux.HandleFunc("/key", func(w http.ResponseWriter, r *http.Request) {
-		if !auth.AuthenticateRoot(r.Header.Get("Authorization")) {
-			w.WriteHeader(http.StatusUnauthorized)
+		// the primary authenticates forwarded writes
+		if (r.Method == "POST" || r.Method == "DELETE") && srv.isFollowing() {
+			srv.misdirected(w, r)
 			return
 		}
+		identity, err := guard.Authenticate(r)
+		if err != nil {
+			unauthenticated(w, r, logger, err)
+			return
+		}
+		recordIdentity(r, identity)
 
-		ctxWithLogger := context.WithValue(r.Context(), "logger", logger)
+		ctxWithLogger := requestContext(r, logger, identity)
 
 		switch r.Method {
 		case "GET":
-			kh.Get(w, r.WithContext(ctxWithLogger))
+			if !authorize(w, r, identity, auth.RoleReader) {
+				return
+			}
+			getKey(w, r.WithContext(ctxWithLogger))
 		case "POST":
-			kh.Post(w, r.WithContext(ctxWithLogger))
+			if !authorize(w, r, identity, auth.RoleWriter) {
+				return
+			}
+			postKey(w, r.WithContext(ctxWithLogger))
+		case "DELETE":
+			if !authorize(w, r, identity, auth.RoleWriter) {
+				return
+			}
+			deleteKey(w, r.WithContext(ctxWithLogger))
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/keys", guarded(guard, logger, "GET", auth.RoleReader, srv.span("KeyHandler.List", kh.List)))
+
+	// followers are written by replication rather than through the keyring, their change log notifies every commit
+	changed := kr.Changed
+	if srv.follower != nil {
+		changed = srv.changeLog.Changed
+	}
+	wh := handlers.NewWatchHandler(kr, changed)
+	mux.HandleFunc("/key/watch", guarded(guard, logger, "GET", auth.RoleReader, srv.span("WatchHandler.Watch", wh.Watch)))
+
+	ph := handlers.NewPolicyHandler(kr)
+	getPolicy := guarded(guard, logger, "GET", auth.RoleAdmin, srv.span("PolicyHandler.Get", ph.Get))
+	putPolicy := guarded(guard, logger, "PUT", auth.RoleAdmin, srv.span("PolicyHandler.Put", ph.Put))
+	deletePolicy := guarded(guard, logger, "DELETE", auth.RoleAdmin, srv.span("PolicyHandler.Delete", ph.Delete))
+	mux.HandleFunc("/policy", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			getPolicy(w, r)
+		case "PUT", "DELETE":
+			if srv.isFollowing() {
+				srv.misdirected(w, r)
+				return
+			}
+			if r.Method == "PUT" {
+				putPolicy(w, r)
+			} else {
+				deletePolicy(w, r)
+			}
 		default:
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
 	})
 
+	th := handlers.NewTransitHandler(kr, func(key string) handlers.Cipher {
+		return zypher.NewCipher(key)
+	})
+	mux.HandleFunc("/transit/encrypt", guarded(guard, logger, "POST", auth.RoleReader, srv.span("TransitHandler.Encrypt", th.Encrypt)))
+	mux.HandleFunc("/transit/decrypt", guarded(guard, logger, "POST", auth.RoleReader, srv.span("TransitHandler.Decrypt", th.Decrypt)))
+	mux.HandleFunc("/datakey", guarded(guard, logger, "POST", auth.RoleReader, srv.span("TransitHandler.DataKey", th.DataKey)))
+	mux.HandleFunc("/datakey/unwrap", guarded(guard, logger, "POST", auth.RoleReader, srv.span("TransitHandler.UnwrapDataKey", th.UnwrapDataKey)))
+
 	mux.HandleFunc("/up", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" {
 			w.Write([]byte("up"))
@@ -63,37 +258,129 @@ func NewServer(bbStore store.Store, auth AuthGuard, logger *zap.Logger, opts ...
 		}
 	})
 
-	httpSrv := http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+	if srv.auditLog != nil {
+		ah := handlers.NewAuditHandler(srv.auditLog)
+		mux.HandleFunc("/audit", guarded(guard, logger, "GET", auth.RoleAdmin, ah.Query))
 	}
-
-	srv := &Server{
-		srv:   &httpSrv,
-		store: bbStore,
+	if srv.backupCipher != nil && srv.snapshotter != nil {
+		bh := handlers.NewBackupHandler(srv.snapshotter, srv.backupCipher)
+		mux.HandleFunc("/sys/backup", guarded(guard, logger, "GET", auth.RoleAdmin, bh.Get))
 	}
-
-	for _, opt := range opts {
-		opt(srv)
+	if srv.webhooks != nil {
+		whh := handlers.NewWebhookHandler(srv.webhooks)
+		mux.HandleFunc("/sys/webhooks/deliveries", guarded(guard, logger, "GET", auth.RoleAdmin, whh.Deliveries))
+	}
+	if srv.changeLog != nil {
+		rh := handlers.NewReplicationHandler(srv.changeLog, srv.replicated)
+		mux.HandleFunc("/sys/replication/snapshot", guarded(guard, logger, "GET", auth.RoleReplicator, rh.Snapshot))
+		mux.HandleFunc("/sys/replication/changes", guarded(guard, logger, "GET", auth.RoleReplicator, rh.Changes))
+		mux.HandleFunc("/sys/replication/status", guarded(guard, logger, "GET", auth.RoleAdmin, srv.replicationStatus))
+		mux.HandleFunc("/sys/promote", guarded(guard, logger, "POST", auth.RoleAdmin, srv.promote))
 	}
+	if srv.metrics != nil && srv.metricsSrv == nil {
+		mux.Handle("/metrics", srv.metrics.Handler())
+	}
+	httpSrv.Handler = srv.traced(mux, srv.measured(mux, srv.audited(mux)))
 
 	return srv, nil
 }
 
+// guarded returns a handler only accepting requests with the method authenticated by the guard
+// for an identity granted the role.
+func guarded(guard AuthGuard, logger *zap.Logger, method string, role auth.Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity, err := guard.Authenticate(r)
+		if err != nil {
+			unauthenticated(w, r, logger, err)
+			return
+		}
+		recordIdentity(r, identity)
+		if r.Method != method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorize(w, r, identity, role) {
+			return
+		}
+		h(w, r.WithContext(requestContext(r, logger, identity)))
+	}
+}
+
+// requestContext returns the context of an authenticated request carrying its logger and identity,
+// the identity being recorded as the creator of the values it stores.
+func requestContext(r *http.Request, logger *zap.Logger, identity *auth.Identity) context.Context {
+	ctx := context.WithValue(r.Context(), "logger", logger)
+	ctx = context.WithValue(ctx, "identity", identity)
+	return store.WithCreator(ctx, identity.Name)
+}
+
+// authorize writes 403 and returns false if the identity has not been granted the role.
+func authorize(w http.ResponseWriter, r *http.Request, identity *auth.Identity, role auth.Role) bool {
+	if !identity.HasRole(role) {
+		recordDenial(r, fmt.Errorf("%w: %s is not granted the %s role", auth.ErrRoleNotGranted, identity.Name, role))
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 // Start starts the server
 func (s *Server) Start() error {
+	s.startRotation()
+	s.startExpirySweep()
+	s.startMetrics()
+	s.startBackups()
+	s.startCompaction()
+	if s.isFollowing() {
+		s.follower.Start()
+	}
+	if s.srv.TLSConfig != nil {
+		// certificates are provided by the TLS config
+		return s.srv.ListenAndServeTLS("", "")
+	}
 	return s.srv.ListenAndServe()
 }
 
 // Stop stops the server
 func (s *Server) Stop(ctx context.Context) error {
-	if err := s.store.Close(); err != nil {
-		return fmt.Errorf("error closing store: %v", err)
+	if s.stopRotation != nil {
+		s.stopRotation()
+	}
+	if s.stopExpirySweep != nil {
+		s.stopExpirySweep()
+	}
+	if s.stopBackups != nil {
+		s.stopBackups()
+	}
+	if s.stopCompaction != nil {
+		s.stopCompaction()
+	}
+	if s.follower != nil {
+		s.follower.Stop()
 	}
 
+	// the requests in flight are served before the webhooks they notify and the store they use are stopped
 	if err := s.srv.Shutdown(ctx); err != nil {
 		return fmt.Errorf("error stopping server: %v", err)
 	}
+	if s.metricsSrv != nil {
+		if err := s.metricsSrv.Shutdown(ctx); err != nil {
+			return fmt.Errorf("error stopping metrics server: %v", err)
+		}
+	}
+	if s.webhooks != nil {
+		// pending deliveries are recorded as dead letters before the store is closed
+		s.webhooks.Stop()
+	}
+	if err := s.store.Close(); err != nil {
+		return fmt.Errorf("error closing store: %v", err)
+	}
+
+	if s.auditLog != nil {
+		if err := s.auditLog.Close(); err != nil {
+			return fmt.Errorf("error closing audit log: %v", err)
+		}
+	}
 
 	return nil
 }
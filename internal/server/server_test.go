@@ -3,16 +3,44 @@ package server_test
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/vtno/zypher/client"
 	"github.com/vtno/zypher/internal/server"
+	"github.com/vtno/zypher/internal/server/audit"
+	"github.com/vtno/zypher/internal/server/auth"
 	"github.com/vtno/zypher/internal/server/handlers"
+	"github.com/vtno/zypher/internal/server/metrics"
+	"github.com/vtno/zypher/internal/server/ratelimit"
+	"github.com/vtno/zypher/internal/server/replication"
 	"github.com/vtno/zypher/internal/server/store"
+	"github.com/vtno/zypher/internal/server/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/mock/gomock"
 	"go.uber.org/zap"
 )
@@ -24,6 +52,19 @@ type test struct {
 	expectedStatus int
 }
 
+// waitForServer blocks until the server started in background accepts connections on addr.
+func waitForServer(t *testing.T, addr string) {
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("server at %s did not start", addr)
+}
+
 func TestServer_up(t *testing.T) {
 	ctrl := gomock.NewController(t)
 	mAuthGuard := server.NewMockAuthGuard(ctrl)
@@ -70,6 +111,7 @@ func TestServer_up(t *testing.T) {
 	}
 	go s.Start()
 	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8081")
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -94,13 +136,13 @@ func TestServer_key(t *testing.T) {
 	ctx := context.Background()
 	ctrl := gomock.NewController(t)
 	mAuthGuard := server.NewMockAuthGuard(ctrl)
-	mAuthGuard.EXPECT().AuthenticateRoot(gomock.Any()).Return(true).AnyTimes()
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(auth.RootIdentity(), nil).AnyTimes()
 	// create a key to test with
 	store, err := store.NewBBoltStore("zypher.db")
 	if err != nil {
 		t.Errorf("error creating store: %v", err)
 	}
-	err = store.Set("twitter#prd", "somevalue")
+	_, err = store.Put(context.Background(), "/twitter", "prd", []byte("somevalue"))
 	if err != nil {
 		t.Errorf("error setting value: %v", err)
 	}
@@ -114,6 +156,7 @@ func TestServer_key(t *testing.T) {
 		_ = s.Stop(ctx)
 		_ = os.Remove("zypher.db")
 	}()
+	waitForServer(t, "localhost:8080")
 
 	srvUrl := fmt.Sprintf("http://localhost:8080%s", "/key")
 
@@ -210,3 +253,901 @@ func TestServer_key(t *testing.T) {
 		}	
 	})
 }
+
+func TestServer_keyForbidden(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(&auth.Identity{
+		Name:  "ci",
+		Roles: []auth.Role{auth.RoleReader},
+	}, nil).AnyTimes()
+	mStore := store.NewMockStore(ctrl)
+	mStore.EXPECT().Close().Times(1)
+
+	s, err := server.NewServer(mStore, mAuthGuard, zap.NewNop(), server.WithPort(8082))
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8082")
+
+	t.Run("POST /key should return 403 when the identity is not a writer", func(t *testing.T) {
+		reqBody, err := json.Marshal(handlers.KeyPostRequest{Name: "twitter", Env: "stg", Key: "supersecretkey"})
+		if err != nil {
+			t.Errorf("error marshaling request body: %v", err)
+		}
+		resp, err := http.Post("http://localhost:8082/key", "application/json", bytes.NewBuffer(reqBody))
+		if err != nil {
+			t.Fatalf("error sending POST request to /key: %v", err)
+		}
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("expected status code to be %d, got %d", http.StatusForbidden, resp.StatusCode)
+		}
+	})
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("error writing %s: %v", path, err)
+	}
+}
+
+func writeCertAndKey(t *testing.T, cert *tls.Certificate, certPath, keyPath string) {
+	writePEM(t, certPath, "CERTIFICATE", cert.Certificate[0])
+	keyDER, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatalf("error marshaling key: %v", err)
+	}
+	writePEM(t, keyPath, "PRIVATE KEY", keyDER)
+}
+
+func createClientCert(t *testing.T, caPath string) tls.Certificate {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zypher test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating CA certificate: %v", err)
+	}
+	writePEM(t, caPath, "CERTIFICATE", caDER)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating client key: %v", err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "ci"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caTemplate, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("error creating client certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{clientDER}, PrivateKey: clientKey}
+}
+
+func TestServer_tls(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mStore := store.NewMockStore(ctrl)
+	mStore.EXPECT().Close().Times(1)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "tls.crt")
+	keyPath := filepath.Join(dir, "tls.key")
+	caPath := filepath.Join(dir, "ca.crt")
+
+	serverCert, err := server.GenerateSelfSignedCert()
+	if err != nil {
+		t.Fatalf("error generating server certificate: %v", err)
+	}
+	writeCertAndKey(t, serverCert, certPath, keyPath)
+	clientCert := createClientCert(t, caPath)
+
+	reloader, err := server.NewTLSReloader(certPath, keyPath, caPath)
+	if err != nil {
+		t.Fatalf("error loading TLS files: %v", err)
+	}
+	s, err := server.NewServer(mStore, mAuthGuard, zap.NewNop(), server.WithPort(8083), server.WithTLSConfig(reloader.Config()))
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8083")
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       certs,
+		}}}
+	}
+
+	t.Run("should serve requests from clients presenting a certificate issued by the client CA", func(t *testing.T) {
+		resp, err := newClient(clientCert).Get("https://localhost:8083/up")
+		if err != nil {
+			t.Fatalf("error sending GET request to /up: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected status code to be %d, got %d", http.StatusOK, resp.StatusCode)
+		}
+		if !bytes.Equal(resp.TLS.PeerCertificates[0].Raw, serverCert.Certificate[0]) {
+			t.Errorf("expected the configured server certificate to be served")
+		}
+	})
+
+	t.Run("should reject clients without a certificate", func(t *testing.T) {
+		if _, err := newClient().Get("https://localhost:8083/up"); err == nil {
+			t.Errorf("expected the TLS handshake to fail")
+		}
+	})
+
+	t.Run("should serve the new certificate after reload", func(t *testing.T) {
+		newCert, err := server.GenerateSelfSignedCert()
+		if err != nil {
+			t.Fatalf("error generating server certificate: %v", err)
+		}
+		writeCertAndKey(t, newCert, certPath, keyPath)
+		if err := reloader.Reload(); err != nil {
+			t.Fatalf("error reloading TLS files: %v", err)
+		}
+		resp, err := newClient(clientCert).Get("https://localhost:8083/up")
+		if err != nil {
+			t.Fatalf("error sending GET request to /up: %v", err)
+		}
+		if !bytes.Equal(resp.TLS.PeerCertificates[0].Raw, newCert.Certificate[0]) {
+			t.Errorf("expected the reloaded server certificate to be served")
+		}
+	})
+}
+
+func TestServer_metrics(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(nil, fmt.Errorf("%w: bad signature", auth.ErrInvalidSignature)).Times(1)
+	mStore := store.NewMockStore(ctrl)
+	mStore.EXPECT().Close().Times(1)
+
+	s, err := server.NewServer(mStore, mAuthGuard, zap.NewNop(), server.WithPort(8085), server.WithMetrics(metrics.New(), ":8086"))
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8085")
+	waitForServer(t, "localhost:8086")
+
+	resp, err := http.Get("http://localhost:8085/key?name=twitter&env=prd")
+	if err != nil {
+		t.Fatalf("error sending GET request to /key: %v", err)
+	}
+	resp.Body.Close()
+
+	t.Run("/metrics should not be served with the API", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8085/metrics")
+		if err != nil {
+			t.Fatalf("error sending GET request to /metrics: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status code to be %d, got %d", http.StatusNotFound, resp.StatusCode)
+		}
+	})
+
+	t.Run("/metrics should count requests and auth failures", func(t *testing.T) {
+		resp, err := http.Get("http://localhost:8086/metrics")
+		if err != nil {
+			t.Fatalf("error sending GET request to /metrics: %v", err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("error reading metrics: %v", err)
+		}
+		for _, expected := range []string{
+			`zypher_http_requests_total{method="GET",route="/key",status="401"} 1`,
+			`zypher_http_requests_total{method="GET",route="other",status="404"} 1`,
+			`zypher_auth_failures_total{reason="invalid_signature"} 1`,
+		} {
+			if !strings.Contains(string(body), expected) {
+				t.Errorf("expected metrics to contain %s, got %s", expected, body)
+			}
+		}
+	})
+}
+
+func TestServer_tracing(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(auth.RootIdentity(), nil).AnyTimes()
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	if _, err := bbStore.Put(ctx, "/twitter", "prd", []byte("somevalue")); err != nil {
+		t.Fatalf("error setting value: %v", err)
+	}
+
+	s, err := server.NewServer(bbStore, mAuthGuard, zap.NewNop(), server.WithPort(8087), server.WithTracing())
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8087")
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, err := http.NewRequest("GET", "http://localhost:8087/key?name=twitter&env=prd", nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error sending GET request to /key: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code to be %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// the server span ends once the response has been written
+	spans := map[string]tracetest.SpanStub{}
+	for i := 0; i < 50 && spans["GET /key"].Name == ""; i++ {
+		time.Sleep(10 * time.Millisecond)
+		for _, span := range exporter.GetSpans() {
+			spans[span.Name] = span
+		}
+	}
+
+	for _, name := range []string{"GET /key", "AuthGuard.Authenticate", "KeyHandler.Get", "Store.Get"} {
+		span, found := spans[name]
+		if !found {
+			t.Errorf("expected a %s span, got %v", name, spans)
+			continue
+		}
+		if span.SpanContext.TraceID().String() != traceID {
+			t.Errorf("expected %s span to continue trace %s, got %s", name, traceID, span.SpanContext.TraceID())
+		}
+	}
+	if spans["Store.Get"].Parent.SpanID() != spans["KeyHandler.Get"].SpanContext.SpanID() {
+		t.Errorf("expected store spans to be children of the KeyHandler.Get span")
+	}
+}
+
+func TestServer_throttling(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mStore := store.NewMockStore(ctrl)
+	mStore.EXPECT().Close().Times(1)
+	mStore.EXPECT().List(gomock.Any(), "namespaces", "").Return([]string{}, nil).AnyTimes()
+
+	s, err := server.NewServer(mStore, mAuthGuard, zap.NewNop(),
+		server.WithPort(8088),
+		server.WithIPRateLimit(ratelimit.NewLimiter(1, 4)),
+		server.WithLockout(ratelimit.NewLockout(2, time.Minute, time.Hour)),
+	)
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8088")
+
+	get := func(t *testing.T) *http.Response {
+		resp, err := http.Get("http://localhost:8088/keys")
+		if err != nil {
+			t.Fatalf("error sending GET request to /keys: %v", err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("should reset signature failures on success", func(t *testing.T) {
+		gomock.InOrder(
+			mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(nil, auth.ErrInvalidSignature),
+			mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(auth.RootIdentity(), nil),
+		)
+		for _, expected := range []int{http.StatusUnauthorized, http.StatusOK} {
+			if resp := get(t); resp.StatusCode != expected {
+				t.Errorf("expected status code to be %d, got %d", expected, resp.StatusCode)
+			}
+		}
+	})
+
+	t.Run("should lock the source out after repeated signature failures", func(t *testing.T) {
+		mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(nil, auth.ErrInvalidSignature).Times(2)
+		for _, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+			resp := get(t)
+			if resp.StatusCode != expected {
+				t.Errorf("expected status code to be %d, got %d", expected, resp.StatusCode)
+			}
+			if expected == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "60" {
+				t.Errorf("expected Retry-After to be 60, got %q", resp.Header.Get("Retry-After"))
+			}
+		}
+	})
+}
+
+func TestServer_followerThrottling(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"), store.WithChangeLog())
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+
+	s, err := server.NewServer(bbStore, mAuthGuard, zap.NewNop(),
+		server.WithPort(8098),
+		server.WithLockout(ratelimit.NewLockout(2, time.Minute, time.Hour)),
+	)
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8098")
+
+	get := func(t *testing.T, path string) int {
+		resp, err := http.Get("http://localhost:8098" + path)
+		if err != nil {
+			t.Fatalf("error sending GET request to %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(nil, auth.ErrInvalidSignature).Times(2)
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if status := get(t, "/keys"); status != expected {
+			t.Errorf("expected status code of request %d to be %d, got %d", i+1, expected, status)
+		}
+	}
+
+	// replication requests from the locked out source are verified, only a replicator is let through
+	gomock.InOrder(
+		mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(nil, auth.ErrInvalidSignature),
+		mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(&auth.Identity{Name: "ci", Roles: []auth.Role{auth.RoleReader}}, nil),
+		mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(&auth.Identity{Name: "root", Roles: []auth.Role{auth.RoleAdmin}}, nil),
+		mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(&auth.Identity{Name: "zypher", Roles: []auth.Role{auth.RoleReplicator}}, nil),
+	)
+	for i, expected := range []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK} {
+		if status := get(t, "/sys/replication/snapshot"); status != expected {
+			t.Errorf("expected status code of replication request %d to be %d, got %d", i+1, expected, status)
+		}
+	}
+
+	// the replicator does not exempt the other requests of its source
+	if status := get(t, "/keys"); status != http.StatusTooManyRequests {
+		t.Errorf("expected the source to stay locked out, got %d", status)
+	}
+}
+
+func TestServer_rateLimit(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(&auth.Identity{
+		Name:  "ci",
+		Roles: []auth.Role{auth.RoleReader},
+	}, nil).Times(3)
+	mStore := store.NewMockStore(ctrl)
+	mStore.EXPECT().Close().Times(1)
+	mStore.EXPECT().List(gomock.Any(), "namespaces", "").Return([]string{}, nil).Times(2)
+
+	s, err := server.NewServer(mStore, mAuthGuard, zap.NewNop(),
+		server.WithPort(8089),
+		server.WithIPRateLimit(ratelimit.NewLimiter(0.001, 3)),
+		server.WithIdentityRateLimit(ratelimit.NewLimiter(0.5, 2)),
+	)
+	if err != nil {
+		t.Errorf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8089")
+
+	// the identity is limited on the third request and the source IP on the fourth before it is authenticated
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		resp, err := http.Get("http://localhost:8089/keys")
+		if err != nil {
+			t.Fatalf("error sending GET request to /keys: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Errorf("expected status code of request %d to be %d, got %d", i+1, expected, resp.StatusCode)
+		}
+		if expected == http.StatusTooManyRequests && resp.Header.Get("Retry-After") == "" {
+			t.Errorf("expected Retry-After on request %d", i+1)
+		}
+	}
+}
+
+func TestServer_replication(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(auth.RootIdentity(), nil).AnyTimes()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating signing key: %v", err)
+	}
+	signer := client.NewKeySigner(key)
+	dir := t.TempDir()
+	stores := make(map[int]*store.BBoltStore)
+
+	start := func(t *testing.T, port int, primary string, opts ...server.ServerOption) *client.Client {
+		s, err := store.NewBBoltStore(filepath.Join(dir, fmt.Sprintf("%d.db", port)), store.WithChangeLog())
+		if err != nil {
+			t.Fatalf("error creating store: %v", err)
+		}
+		stores[port] = s
+		opts = append(opts, server.WithPort(port))
+		if primary != "" {
+			u, _ := url.Parse(primary)
+			f := replication.NewFollower(client.New(primary, signer), s, zap.NewNop(),
+				replication.WithWait(100*time.Millisecond), replication.WithBackoff(50*time.Millisecond))
+			opts = append(opts, server.WithFollower(f, u))
+		}
+		srv, err := server.NewServer(s, mAuthGuard, zap.NewNop(), opts...)
+		if err != nil {
+			t.Fatalf("error creating server: %v", err)
+		}
+		go srv.Start()
+		t.Cleanup(func() { srv.Stop(ctx) })
+		waitForServer(t, fmt.Sprintf("localhost:%d", port))
+		return client.New(fmt.Sprintf("http://localhost:%d", port), signer)
+	}
+	eventually := func(t *testing.T, c *client.Client, name, expected string) {
+		for i := 0; i < 100; i++ {
+			if key, err := c.GetKey(ctx, name, "prd"); err == nil && key == expected {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Errorf("expected %s to be replicated as %s", name, expected)
+	}
+
+	primary := start(t, 8090, "", server.WithChangeLogCompaction(20*time.Millisecond, 200*time.Millisecond))
+	if err := primary.PutKey(ctx, "twitter", "prd", "v1"); err != nil {
+		t.Fatalf("error putting key on primary: %v", err)
+	}
+	follower := start(t, 8091, "http://localhost:8090")
+
+	t.Run("follower loads a snapshot then streams changes of the primary", func(t *testing.T) {
+		eventually(t, follower, "twitter", "v1")
+		if err := primary.PutKey(ctx, "twitter", "prd", "v2"); err != nil {
+			t.Fatalf("error putting key on primary: %v", err)
+		}
+		eventually(t, follower, "twitter", "v2")
+	})
+
+	t.Run("follower notifies watches of replicated changes", func(t *testing.T) {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		updates := follower.Watch(watchCtx, "twitter", "prd")
+		for _, expected := range []int{2, 3} {
+			if expected == 3 {
+				if err := primary.PutKey(ctx, "twitter", "prd", "v3"); err != nil {
+					t.Fatalf("error putting key on primary: %v", err)
+				}
+			}
+			select {
+			case u := <-updates:
+				if u.Err != nil || u.Version != expected {
+					t.Errorf("expected version %d, got %+v", expected, u)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("expected version %d to be delivered", expected)
+			}
+		}
+	})
+
+	t.Run("primary truncates the changes replicated by its followers", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			if _, err := stores[8090].Changes(0, 1); errors.Is(err, store.ErrChangeLogGap) {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if _, err := stores[8090].Changes(0, 1); !errors.Is(err, store.ErrChangeLogGap) {
+			t.Fatalf("expected the replicated changes to be truncated, got %v", err)
+		}
+		if err := primary.PutKey(ctx, "twitter", "prd", "v4"); err != nil {
+			t.Fatalf("error putting key on primary: %v", err)
+		}
+		eventually(t, follower, "twitter", "v4")
+	})
+
+	t.Run("follower rejects writes with the primary url", func(t *testing.T) {
+		req, _ := http.NewRequest("DELETE", "http://localhost:8091/key?name=twitter&env=prd", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error sending DELETE request to /key: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMisdirectedRequest {
+			t.Errorf("expected status code to be %d, got %d", http.StatusMisdirectedRequest, resp.StatusCode)
+		}
+		if primaryURL := resp.Header.Get("X-Zypher-Primary"); primaryURL != "http://localhost:8090" {
+			t.Errorf("expected X-Zypher-Primary to be the primary url, got %q", primaryURL)
+		}
+		if err := follower.PutKey(ctx, "stripe", "prd", "v1"); !errors.Is(err, client.ErrMisdirected) {
+			t.Errorf("expected ErrMisdirected, got %v", err)
+		}
+	})
+
+	t.Run("forwarding follower sends writes to the primary", func(t *testing.T) {
+		forwarding := start(t, 8092, "http://localhost:8090", server.WithWriteForwarding(http.DefaultTransport))
+		if err := forwarding.PutKey(ctx, "slack", "prd", "v1"); err != nil {
+			t.Fatalf("error putting key through follower: %v", err)
+		}
+		if key, err := primary.GetKey(ctx, "slack", "prd"); err != nil || key != "v1" {
+			t.Errorf("expected the write to reach the primary, got %q, %v", key, err)
+		}
+		eventually(t, forwarding, "slack", "v1")
+	})
+
+	t.Run("promoted follower accepts writes", func(t *testing.T) {
+		status, err := follower.ReplicationStatus(ctx)
+		if err != nil || status.Role != "follower" || status.Primary != "http://localhost:8090" {
+			t.Errorf("expected the follower status, got %+v, %v", status, err)
+		}
+		eventually(t, follower, "slack", "v1")
+		primaryStatus, err := primary.ReplicationStatus(ctx)
+		if err != nil {
+			t.Fatalf("error getting primary status: %v", err)
+		}
+		status, err = follower.Promote(ctx)
+		if err != nil {
+			t.Fatalf("error promoting follower: %v", err)
+		}
+		if status.Role != "primary" || status.Head != primaryStatus.Head {
+			t.Errorf("expected a primary at change %d, got %+v", primaryStatus.Head, status)
+		}
+		if err := follower.PutKey(ctx, "stripe", "prd", "v1"); err != nil {
+			t.Errorf("error putting key on promoted follower: %v", err)
+		}
+		if err := primary.PutKey(ctx, "github", "prd", "v1"); err != nil {
+			t.Fatalf("error putting key on former primary: %v", err)
+		}
+		time.Sleep(200 * time.Millisecond)
+		if _, err := follower.GetKey(ctx, "github", "prd"); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected the promoted follower to stop replicating, got %v", err)
+		}
+	})
+}
+
+func TestServer_policies(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	// the identity of a request is named by a header, root by default
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).DoAndReturn(func(r *http.Request) (*auth.Identity, error) {
+		if name := r.Header.Get("X-Test-Identity"); name != "" {
+			return &auth.Identity{Name: name, Roles: []auth.Role{auth.RoleReader, auth.RoleWriter}}, nil
+		}
+		return auth.RootIdentity(), nil
+	}).AnyTimes()
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	s, err := server.NewServer(bbStore, mAuthGuard, zap.NewNop(), server.WithPort(8093))
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8093")
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating signing key: %v", err)
+	}
+	admin := client.New("http://localhost:8093", client.NewKeySigner(key))
+	if err := admin.PutKey(ctx, "payments/stripe", "prd", "v1"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := admin.PutKey(ctx, "twitter", "prd", "v1"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := admin.PutPolicy(ctx, "/payments", client.Policy{Readers: []string{"billing"}, Writers: []string{"billing"}}); err != nil {
+		t.Fatalf("error putting policy: %v", err)
+	}
+
+	send := func(t *testing.T, identity, method, path string, body []byte) *http.Response {
+		req, err := http.NewRequest(method, "http://localhost:8093"+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+		req.Header.Set("X-Test-Identity", identity)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error sending %s request to %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	tests := []struct {
+		name           string
+		identity       string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "reader of the namespace gets the key by path", identity: "billing", method: "GET", path: "/key?path=/payments/stripe/prd", expectedStatus: http.StatusOK},
+		{name: "reader of the namespace gets the key by name and env", identity: "billing", method: "GET", path: "/key?name=payments/stripe&env=prd", expectedStatus: http.StatusOK},
+		{name: "other identities are denied the key", identity: "ci", method: "GET", path: "/key?path=/payments/stripe/prd", expectedStatus: http.StatusForbidden},
+		{name: "other identities are denied writes", identity: "ci", method: "POST", path: "/key", body: `{"path":"/payments/adyen/prd","key":"v1"}`, expectedStatus: http.StatusForbidden},
+		{name: "other identities are denied transit", identity: "ci", method: "POST", path: "/transit/encrypt", body: `{"path":"/payments/stripe/prd","payload":"cGF5bG9hZA=="}`, expectedStatus: http.StatusForbidden},
+		{name: "keys outside the namespace are not restricted", identity: "ci", method: "GET", path: "/key?path=/twitter/prd", expectedStatus: http.StatusOK},
+		{name: "invalid paths are rejected", identity: "ci", method: "GET", path: "/key?path=/twitter", expectedStatus: http.StatusBadRequest},
+		{name: "policies are managed by admins only", identity: "billing", method: "PUT", path: "/policy", body: `{"path":"/payments"}`, expectedStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := send(t, tt.identity, tt.method, tt.path, []byte(tt.body))
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status code to be %d, got %d", tt.expectedStatus, resp.StatusCode)
+			}
+		})
+	}
+
+	t.Run("keys denied by policy are not listed", func(t *testing.T) {
+		for identity, expected := range map[string][]string{
+			"billing": {"/payments/stripe/prd", "/twitter/prd"},
+			"ci":      {"/twitter/prd"},
+		} {
+			klr := &handlers.KeyListResponse{}
+			if err := json.NewDecoder(send(t, identity, "GET", "/keys", nil).Body).Decode(klr); err != nil {
+				t.Fatalf("error unmarshaling response body: %v", err)
+			}
+			var paths []string
+			for _, k := range klr.Keys {
+				paths = append(paths, k.Path)
+			}
+			if strings.Join(paths, ",") != strings.Join(expected, ",") {
+				t.Errorf("expected %s to list %v, got %v", identity, expected, paths)
+			}
+		}
+	})
+
+	t.Run("effective policy merges every level of the path", func(t *testing.T) {
+		if err := admin.PutPolicy(ctx, "/", client.Policy{RotationPeriod: "90d"}); err != nil {
+			t.Fatalf("error putting policy: %v", err)
+		}
+		p, err := admin.GetPolicy(ctx, "/payments/stripe/prd", true)
+		if err != nil {
+			t.Fatalf("error getting policy: %v", err)
+		}
+		if strings.Join(p.Readers, ",") != "billing" || p.RotationPeriod != (90*24*time.Hour).String() {
+			t.Errorf("expected the policies of / and /payments to be merged, got %+v", p)
+		}
+		if err := admin.DeletePolicy(ctx, "/payments"); err != nil {
+			t.Fatalf("error deleting policy: %v", err)
+		}
+		if _, err := admin.GetPolicy(ctx, "/payments", false); !errors.Is(err, client.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestServer_webhooks(t *testing.T) {
+	ctx := context.Background()
+	secret := []byte("0123456789abcdef")
+	received := make(chan webhook.Event, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("error reading event: %v", err)
+		}
+		if !webhook.Verify(secret, r.Header.Get(webhook.TimestampHeader), body, r.Header.Get(webhook.SignatureHeader)) {
+			t.Errorf("expected the event to be signed with the secret")
+		}
+		var e webhook.Event
+		if err := json.Unmarshal(body, &e); err != nil {
+			t.Errorf("error unmarshaling event: %v", err)
+		}
+		received <- e
+	}))
+	defer receiver.Close()
+
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).DoAndReturn(func(r *http.Request) (*auth.Identity, error) {
+		if r.Header.Get("X-Test-Intruder") != "" {
+			return nil, auth.ErrInvalidSignature
+		}
+		return auth.RootIdentity(), nil
+	}).AnyTimes()
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	d := webhook.NewDispatcher([]string{receiver.URL}, secret, bbStore, webhook.WithAuthFailureBurst(2, time.Minute))
+	s, err := server.NewServer(bbStore, mAuthGuard, zap.NewNop(), server.WithPort(8095), server.WithWebhooks(d))
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8095")
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating signing key: %v", err)
+	}
+	admin := client.New("http://localhost:8095", client.NewKeySigner(key))
+	// events are delivered concurrently so each is awaited before the next change
+	expect := func(t *testing.T, expected webhook.Event) {
+		t.Helper()
+		select {
+		case e := <-received:
+			if e.Type != expected.Type || e.Path != expected.Path || e.Version != expected.Version || e.Identity != expected.Identity || e.SourceIP != expected.SourceIP {
+				t.Errorf("expected %+v, got %+v", expected, e)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %s to be delivered", expected.Type)
+		}
+	}
+
+	if err := admin.PutKey(ctx, "twitter", "prd", "v1"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	expect(t, webhook.Event{Type: webhook.KeyCreated, Path: "/twitter/prd", Version: 1, Identity: "root"})
+	if err := admin.PutKey(ctx, "twitter", "prd", "v2"); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	expect(t, webhook.Event{Type: webhook.KeyUpdated, Path: "/twitter/prd", Version: 2, Identity: "root"})
+	if err := admin.DeleteKey(ctx, "twitter", "prd"); err != nil {
+		t.Fatalf("error deleting key: %v", err)
+	}
+	expect(t, webhook.Event{Type: webhook.KeyDeleted, Path: "/twitter/prd", Version: 2, Identity: "root"})
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "http://localhost:8095/keys", nil)
+		if err != nil {
+			t.Fatalf("error creating request: %v", err)
+		}
+		req.Header.Set("X-Test-Intruder", "true")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("error sending request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected status code to be %d, got %d", http.StatusUnauthorized, resp.StatusCode)
+		}
+	}
+	expect(t, webhook.Event{Type: webhook.AuthFailureBurst, SourceIP: "127.0.0.1"})
+
+	wr, err := admin.WebhookDeliveries(ctx)
+	if err != nil {
+		t.Fatalf("error getting webhook deliveries: %v", err)
+	}
+	if len(wr.Deliveries) != 4 || len(wr.DeadLetters) != 0 {
+		t.Errorf("expected 4 deliveries and no dead letter, got %+v", wr)
+	}
+}
+
+func TestServer_expiry(t *testing.T) {
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	mAuthGuard := server.NewMockAuthGuard(ctrl)
+	mAuthGuard.EXPECT().Authenticate(gomock.Any()).Return(auth.RootIdentity(), nil).AnyTimes()
+	bbStore, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	auditLog, err := audit.OpenLog(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatalf("error opening audit log: %v", err)
+	}
+	s, err := server.NewServer(bbStore, mAuthGuard, zap.NewNop(),
+		server.WithPort(8096),
+		server.WithAuditLog(auditLog),
+		// keys expired for longer than the grace period are purged within 10ms
+		server.WithExpirySweep(10*time.Millisecond, 200*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("error creating server: %v", err)
+	}
+	go s.Start()
+	defer s.Stop(ctx)
+	waitForServer(t, "localhost:8096")
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating signing key: %v", err)
+	}
+	c := client.New("http://localhost:8096", client.NewKeySigner(key))
+
+	t.Run("invalid expiries are rejected", func(t *testing.T) {
+		for _, opts := range [][]client.PutOption{
+			{client.ExpireAt(time.Now().Add(-time.Minute))},
+			{client.ExpireAfter("soon")},
+			{client.ExpireAfter("1h"), client.ExpireAt(time.Now().Add(time.Hour))},
+		} {
+			if err := c.PutKey(ctx, "contractors", "alice", "v1", opts...); !errors.Is(err, client.ErrBadRequest) {
+				t.Errorf("expected ErrBadRequest, got %v", err)
+			}
+		}
+	})
+
+	expiresAt := time.Now().Add(200 * time.Millisecond).UTC().Truncate(time.Millisecond)
+	if err := c.PutKey(ctx, "contractors", "alice", "v1", client.ExpireAt(expiresAt)); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+	if err := c.PutKey(ctx, "contractors", "bob", "v1", client.ExpireAfter("1h")); err != nil {
+		t.Fatalf("error putting key: %v", err)
+	}
+
+	keys, err := c.ListKeys(ctx, "/contractors/")
+	if err != nil {
+		t.Fatalf("error listing keys: %v", err)
+	}
+	if len(keys) != 2 || keys[0].ExpiresAt == nil || !keys[0].ExpiresAt.Equal(expiresAt) || keys[0].Expired || keys[1].ExpiresAt == nil {
+		t.Errorf("expected the expiry of the keys to be listed, got %+v", keys)
+	}
+	if _, err := c.GetKey(ctx, "contractors", "alice"); err != nil {
+		t.Errorf("expected the key to be served until it expires, got %v", err)
+	}
+
+	time.Sleep(time.Until(expiresAt))
+	if _, err := c.GetKey(ctx, "contractors", "alice"); !errors.Is(err, client.ErrGone) {
+		t.Errorf("expected ErrGone once the key expired, got %v", err)
+	}
+	if _, err := c.Encrypt(ctx, "contractors", "alice", []byte("payload")); !errors.Is(err, client.ErrGone) {
+		t.Errorf("expected ErrGone from transit once the key expired, got %v", err)
+	}
+	if keys, _ := c.ListKeys(ctx, "/contractors/alice"); len(keys) != 1 || !keys[0].Expired {
+		t.Errorf("expected the key to be listed as expired, got %+v", keys)
+	}
+
+	time.Sleep(time.Until(expiresAt.Add(300 * time.Millisecond)))
+	if _, err := c.GetKey(ctx, "contractors", "alice"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("expected ErrNotFound once the key was purged, got %v", err)
+	}
+	if _, err := c.GetKey(ctx, "contractors", "bob"); err != nil {
+		t.Errorf("expected the key expiring later to be kept, got %v", err)
+	}
+	ar, err := c.Audit(ctx, client.AuditFilter{Action: "key.expire"})
+	if err != nil {
+		t.Fatalf("error querying audit log: %v", err)
+	}
+	if len(ar.Events) != 1 || ar.Events[0].Path != "/contractors/alice" || ar.Events[0].Name != "contractors" || ar.Events[0].Env != "alice" {
+		t.Errorf("expected the purge to be audited, got %+v", ar.Events)
+	}
+}
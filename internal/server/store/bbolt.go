@@ -1,22 +1,45 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 var (
-	defaultBucket = []byte("zypher")
+	defaultBucket = []byte(DefaultBucket)
 )
 
+// BBoltStore keeps the store in a single bbolt db file, values are stored as \x00zr1 records holding their
+// metadata. Earlier versions of the server cannot read these records, while plain values written by them are
+// still read as version 1, so a db file can be upgraded in place but not downgraded.
 type BBoltStore struct {
 	DB *bolt.DB
+	// changeLog records every mutation in the change log bucket
+	changeLog bool
+	changedMu sync.Mutex
+	changed   chan struct{}
+}
+
+type BBoltOption func(*BBoltStore)
+
+// WithChangeLog records every mutation in a change log replicated to followers, see ChangeLog.
+func WithChangeLog() BBoltOption {
+	return func(b *BBoltStore) {
+		b.changeLog = true
+	}
 }
 
 // NewBBoltStore creates a new BBoltStore.
 // it opens the db file and creates a bucket if it doesn't exist.
-func NewBBoltStore(dbFilePath string) (*BBoltStore, error) {
+func NewBBoltStore(dbFilePath string, opts ...BBoltOption) (*BBoltStore, error) {
 	db, err := bolt.Open(dbFilePath, 0666, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error opening db file for bolt: %w", err)
@@ -34,9 +57,14 @@ func NewBBoltStore(dbFilePath string) (*BBoltStore, error) {
 		return nil, fmt.Errorf("error creating bbolt bucket: %w", err)
 	}
 
-	return &BBoltStore{
-		DB: db,
-	}, nil
+	b := &BBoltStore{
+		DB:      db,
+		changed: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b, nil
 }
 
 // Close is a delegate function that closes the underlying db file.
@@ -44,68 +72,193 @@ func (b *BBoltStore) Close() error {
 	return b.DB.Close()
 }
 
-// Get retrieves the value associated with the given key.
-func (b *BBoltStore) Get(key string) (string, error) {
-	return b.GetByBucket(string(defaultBucket), key)
+// Size returns the size of the db file in bytes.
+func (b *BBoltStore) Size() (int64, error) {
+	var size int64
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		size = tx.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error reading db size: %w", err)
+	}
+	return size, nil
 }
 
-// Set stores the given value and associates it with the given key.
-func (b *BBoltStore) Set(key, value string) error {
-	err := b.DB.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(defaultBucket).Put([]byte(key), []byte(value))
+// Snapshot writes a consistent copy of the db file to w without blocking writers.
+func (b *BBoltStore) Snapshot(w io.Writer) (int64, error) {
+	var n int64
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
 	})
 	if err != nil {
-		return fmt.Errorf("error setting value in %s bucket: %w", defaultBucket, err)
+		return n, fmt.Errorf("error writing db snapshot: %w", err)
 	}
-	return nil
+	return n, nil
 }
 
-// Delete removes the value associated with the given key.
-func (b *BBoltStore) Delete(key string) error {
-	err := b.DB.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(defaultBucket).Delete([]byte(key))
-	})
+// BBoltInUse returns true if the db file at path is locked by another process e.g. a running server.
+func BBoltInUse(path string) (bool, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: 500 * time.Millisecond})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return true, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error deleting value from %s bucket: %w", defaultBucket, err)
+		// a corrupted db file can still be replaced
+		return false, nil
 	}
-	return nil
+	return false, db.Close()
 }
 
-// List returns all the keys in the store.
-// It optionally takes a prefix to filter the keys by.
-func (b *BBoltStore) List(prefix *string) ([]string, error) {
-	var keys []string
-	err := b.DB.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(defaultBucket)
-		c := bucket.Cursor()
-
-		if prefix != nil {
-			prefixBytes := []byte(*prefix)
-			for k, _ := c.Seek(prefixBytes); k != nil && len(k) > 0 && string(k[:len(prefixBytes)]) == *prefix; k, _ = c.Next() {
-				keys = append(keys, string(k))
-			}
-		} else {
-			for k, _ := c.First(); k != nil; k, _ = c.Next() {
-				keys = append(keys, string(k))
-			}
+// VerifyBBolt checks the consistency of the db file at path and that it holds a zypher store.
+func VerifyBBolt(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("error opening db file for bolt: %w", err)
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("db file is corrupted: %w", errors.Join(errs...))
+		}
+		if tx.Bucket(defaultBucket) == nil {
+			return fmt.Errorf("db file has no %s bucket", defaultBucket)
 		}
 		return nil
 	})
+}
+
+// Get retrieves the entry associated with the given key from the given bucket.
+func (b *BBoltStore) Get(ctx context.Context, bucket, key string) (*Entry, error) {
+	return get(b, ctx, bucket, key)
+}
+
+// Put stores the given value and associates it with the given key in the given bucket.
+// The bucket is created if it doesn't exist.
+func (b *BBoltStore) Put(ctx context.Context, bucket, key string, value []byte) (*Metadata, error) {
+	return put(b, ctx, bucket, key, value)
+}
+
+// CompareAndSwap stores the value only if the key is at the given version.
+func (b *BBoltStore) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	return compareAndSwap(b, ctx, bucket, key, version, value)
+}
+
+// Delete removes the value associated with the given key from the given bucket.
+func (b *BBoltStore) Delete(ctx context.Context, bucket, key string) error {
+	return del(b, ctx, bucket, key)
+}
+
+// List returns the keys of the given bucket sorted, optionally filtered by a prefix.
+func (b *BBoltStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	return list(b, ctx, bucket, prefix)
+}
+
+// Update runs fn in a read-write transaction, every change it makes is recorded in the change log.
+func (b *BBoltStore) Update(ctx context.Context, fn func(Tx) error) error {
+	return update(b, ctx, fn)
+}
+
+// View runs fn in a read-only transaction.
+func (b *BBoltStore) View(ctx context.Context, fn func(Tx) error) error {
+	return view(b, ctx, fn)
+}
+
+// Buckets returns the names of the buckets of the store sorted, the change log is not listed.
+func (b *BBoltStore) Buckets(ctx context.Context) ([]string, error) {
+	return buckets(b, ctx)
+}
+
+// Import stores the entry as it is, keeping its version and times.
+func (b *BBoltStore) Import(ctx context.Context, bucket, key string, e *Entry) error {
+	return importEntry(b, ctx, bucket, key, e)
+}
+
+func (b *BBoltStore) view(_ context.Context, fn func(rawTx) error) error {
+	return b.DB.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{store: b, tx: tx})
+	})
+}
+
+func (b *BBoltStore) update(_ context.Context, fn func(rawTx) error) error {
+	return b.commit(func(tx *bolt.Tx) error {
+		return fn(&boltTx{store: b, tx: tx})
+	})
+}
+
+// boltTx stores the entries as records in the buckets of a bbolt transaction.
+type boltTx struct {
+	store *BBoltStore
+	tx    *bolt.Tx
+}
+
+func (t *boltTx) get(bucket, key string) (*Entry, error) {
+	bkt := t.tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil, nil
+	}
+	v := bkt.Get([]byte(key))
+	if v == nil {
+		return nil, nil
+	}
+	return decodeRecord(v)
+}
+
+func (t *boltTx) put(bucket, key string, e *Entry) error {
+	record, err := encodeRecord(e)
 	if err != nil {
-		return nil, fmt.Errorf("error listing keys from %s bucket: %w", defaultBucket, err)
+		return err
+	}
+	bkt, err := t.tx.CreateBucketIfNotExists([]byte(bucket))
+	if err != nil {
+		return err
+	}
+	if err := bkt.Put([]byte(key), record); err != nil {
+		return err
+	}
+	return t.store.record(t.tx, Change{Op: OpSet, Bucket: bucket, Key: key, Value: record})
+}
+
+func (t *boltTx) delete(bucket, key string) error {
+	bkt := t.tx.Bucket([]byte(bucket))
+	if bkt == nil || bkt.Get([]byte(key)) == nil {
+		return nil
+	}
+	if err := bkt.Delete([]byte(key)); err != nil {
+		return err
+	}
+	return t.store.record(t.tx, Change{Op: OpDelete, Bucket: bucket, Key: key})
+}
+
+func (t *boltTx) list(bucket, prefix string) ([]string, error) {
+	bkt := t.tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil, nil
+	}
+	var keys []string
+	c := bkt.Cursor()
+	for k, _ := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = c.Next() {
+		keys = append(keys, string(k))
 	}
 	return keys, nil
 }
 
-// GetByBucket retrieves the value associated with the given key from the given bucket.
-func (b *BBoltStore) GetByBucket(bucket, key string) (string, error) {
-	var value []byte
-	err := b.DB.View(func(tx *bolt.Tx) error {
-		value = tx.Bucket([]byte(bucket)).Get([]byte(key))
+func (t *boltTx) buckets() ([]string, error) {
+	var names []string
+	err := t.tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if !isChangeLogBucket(name) {
+			names = append(names, string(name))
+		}
 		return nil
 	})
-	if err != nil {
-		return "", fmt.Errorf("error getting value from %s bucket: %w", bucket, err)
-	}
-	return string(value), nil
+	return names, err
 }
@@ -1,6 +1,8 @@
 package store_test
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"reflect"
@@ -10,52 +12,59 @@ import (
 	"github.com/vtno/zypher/internal/server/store"
 )
 
-func MustSet(t *testing.T, err error) {
-	if err != nil {
+var ctx = context.Background()
+
+func MustSet(t *testing.T, s store.Store, bucket, key, value string) {
+	t.Helper()
+	if _, err := s.Put(ctx, bucket, key, []byte(value)); err != nil {
 		t.Errorf("error setting value: %v", err)
 	}
 }
 
-type GetResult struct {
-	value string
-	err   error
+func MustDelete(t *testing.T, s store.Store, bucket, key string) {
+	t.Helper()
+	if err := s.Delete(ctx, bucket, key); err != nil {
+		t.Errorf("error deleting value: %v", err)
+	}
 }
 
-func MustGetExpected(t *testing.T, expected string, gr *GetResult) {
-	if gr.err != nil {
-		t.Errorf("error getting value: %v", gr.err)
+// MustGetExpected checks the value of the key, an empty expected value meaning the key doesn't exist.
+func MustGetExpected(t *testing.T, s store.Store, bucket, key, expected string) {
+	t.Helper()
+	e, err := s.Get(ctx, bucket, key)
+	if expected == "" {
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected %s to be not found, got %v", key, err)
+		}
+		return
 	}
-	if gr.value != expected {
-		t.Errorf("expected value to be %s, got %s", expected, gr.value)
+	if err != nil {
+		t.Errorf("error getting value: %v", err)
+		return
+	}
+	if string(e.Value) != expected {
+		t.Errorf("expected value to be %s, got %s", expected, e.Value)
 	}
 }
 
 func TestBBoltStore_GetSet(t *testing.T) {
 	t.Run("successfully sets a value with the provided key", func(t *testing.T) {
-		store, err := store.NewBBoltStore("test.db")
+		s, err := store.NewBBoltStore("test.db")
 		if err != nil {
 			t.Errorf("error creating store: %v", err)
 		}
 		defer func() {
-			store.Close()
+			s.Close()
 			err := os.Remove("test.db")
 			if err != nil {
 				log.Fatalf("error removing test.db: %v", err)
 			}
 		}()
 
-		MustSet(t, store.Set("prod#somekey", "somevalue"))
-		value, err := store.Get("prod#somekey")
-		MustGetExpected(t, "somevalue", &GetResult{
-			value: value,
-			err:   err,
-		})
-		MustSet(t, store.Set("prod#somekey", "newvalue"))
-		value, err = store.Get("prod#somekey")
-		MustGetExpected(t, "newvalue", &GetResult{
-			value: value,
-			err:   err,
-		})
+		MustSet(t, s, store.DefaultBucket, "prod#somekey", "somevalue")
+		MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "somevalue")
+		MustSet(t, s, store.DefaultBucket, "prod#somekey", "newvalue")
+		MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "newvalue")
 	})
 }
 
@@ -63,41 +72,40 @@ func TestBBoltStore_List(t *testing.T) {
 	type test struct {
 		name     string
 		expected []string
-		prefix   *string
+		prefix   string
 	}
-	prodPrefix := "prod"
 	tests := []test{
 		{
 			name:     "successfully lists all the keys in the store when prefix not provided",
 			expected: []string{"prod#key1", "prod#key2", "dev#key1"},
-			prefix:   nil,
+			prefix:   "",
 		},
 		{
 			name:     "successfully lists all the keys in the store when prefix provided",
 			expected: []string{"prod#key1", "prod#key2"},
-			prefix:   &prodPrefix,
+			prefix:   "prod",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			store, err := store.NewBBoltStore("test.db")
+			s, err := store.NewBBoltStore("test.db")
 			if err != nil {
 				t.Errorf("error creating store: %v", err)
 			}
 			defer func() {
-				store.Close()
+				s.Close()
 				err := os.Remove("test.db")
 				if err != nil {
 					log.Fatalf("error removing test.db: %v", err)
 				}
 			}()
 
-			MustSet(t, store.Set("prod#key1", "somevalue1"))
-			MustSet(t, store.Set("prod#key2", "somevalue2"))
-			MustSet(t, store.Set("dev#key1", "somevalue3"))
+			MustSet(t, s, store.DefaultBucket, "prod#key1", "somevalue1")
+			MustSet(t, s, store.DefaultBucket, "prod#key2", "somevalue2")
+			MustSet(t, s, store.DefaultBucket, "dev#key1", "somevalue3")
 
-			values, err := store.List(tt.prefix)
+			values, err := s.List(ctx, store.DefaultBucket, tt.prefix)
 			if err != nil {
 				t.Errorf("error listing keys: %v", err)
 			}
@@ -112,3 +120,26 @@ func TestBBoltStore_List(t *testing.T) {
 		})
 	}
 }
+
+func TestBBoltStore_ByBucket(t *testing.T) {
+	s, err := store.NewBBoltStore("test.db")
+	if err != nil {
+		t.Errorf("error creating store: %v", err)
+	}
+	defer func() {
+		s.Close()
+		err := os.Remove("test.db")
+		if err != nil {
+			log.Fatalf("error removing test.db: %v", err)
+		}
+	}()
+
+	MustGetExpected(t, s, "policies", "prod#somekey", "")
+
+	MustSet(t, s, "policies", "prod#somekey", "somevalue")
+	MustGetExpected(t, s, "policies", "prod#somekey", "somevalue")
+	MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "")
+
+	MustDelete(t, s, "policies", "prod#somekey")
+	MustGetExpected(t, s, "policies", "prod#somekey", "")
+}
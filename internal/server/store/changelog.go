@@ -0,0 +1,373 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// changeLogBucket holds the recorded changes by big-endian sequence number,
+	// the sequence of the bucket is the sequence number of the last change.
+	changeLogBucket = []byte("change_log")
+	// changeLogKeysBucket indexes the recorded set changes by bucket and key so their values
+	// can be dropped once the key is deleted.
+	changeLogKeysBucket = []byte("change_log_keys")
+)
+
+const (
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// ErrChangeLogGap is returned when changes cannot be replayed from a sequence number
+// e.g. it is ahead of the change log or the change log starts after it.
+var ErrChangeLogGap = errors.New("change log has a gap")
+
+// Change is a mutation of a key of a bucket recorded in a change log.
+type Change struct {
+	Seq    uint64 `json:"seq"`
+	Op     string `json:"op"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// Value is the record of the value along with its metadata.
+	Value []byte `json:"value,omitempty"`
+}
+
+// ChangeLog is implemented by stores recording their mutations so they can be replicated.
+// Replicated changes keep their sequence numbers so a follower can serve its change log once promoted.
+// The values of a deleted key are dropped from the changes setting it, which are replayed as deletions.
+type ChangeLog interface {
+	// Recording returns true if the store records its own mutations.
+	Recording() bool
+	// Head returns the sequence number of the last recorded change.
+	Head() (uint64, error)
+	// Changes returns up to limit changes recorded after the sequence number since.
+	Changes(since uint64, limit int) ([]Change, error)
+	// Changed returns a channel closed once a change is recorded.
+	Changed() <-chan struct{}
+	// Dump returns a set change of every value of the store and the sequence number of the last change.
+	Dump() ([]Change, uint64, error)
+	// Load replaces every value of the store by a dump taken at the sequence number head.
+	Load(dump []Change, head uint64) error
+	// Apply records changes replicated from another store.
+	// ErrChangeLogGap is returned if the first change does not follow the head.
+	Apply(changes []Change) error
+	// Truncate removes the changes up to the sequence number upTo and returns how many were removed.
+	// Followers behind the truncated changes get ErrChangeLogGap and load a dump.
+	Truncate(upTo uint64) (int, error)
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+// changeKeyPrefix returns the prefix of the index entries of the changes of a key,
+// the bucket name is length-prefixed so the prefixes of two keys never overlap.
+func changeKeyPrefix(bucket, key string) []byte {
+	k := binary.AppendUvarint(nil, uint64(len(bucket)))
+	k = append(k, bucket...)
+	return append(k, key...)
+}
+
+// isChangeLogBucket returns true if the bucket is part of the change log rather than the values of the store.
+func isChangeLogBucket(name []byte) bool {
+	return bytes.Equal(name, changeLogBucket) || bytes.Equal(name, changeLogKeysBucket)
+}
+
+// Recording returns true if the store records its own mutations.
+func (b *BBoltStore) Recording() bool {
+	return b.changeLog
+}
+
+// commit runs fn in a read-write transaction and notifies the change log waiters once it is committed.
+func (b *BBoltStore) commit(fn func(*bolt.Tx) error) error {
+	if err := b.DB.Update(fn); err != nil {
+		return err
+	}
+	if b.changeLog {
+		b.changedMu.Lock()
+		close(b.changed)
+		b.changed = make(chan struct{})
+		b.changedMu.Unlock()
+	}
+	return nil
+}
+
+// record appends the change to the change log in the transaction of the mutation.
+// A deletion drops the values of the changes recorded for the key before it.
+func (b *BBoltStore) record(tx *bolt.Tx, c Change) error {
+	if !b.changeLog {
+		return nil
+	}
+	bkt, err := tx.CreateBucketIfNotExists(changeLogBucket)
+	if err != nil {
+		return err
+	}
+	keys, err := tx.CreateBucketIfNotExists(changeLogKeysBucket)
+	if err != nil {
+		return err
+	}
+	if c.Seq == 0 {
+		if c.Seq, err = bkt.NextSequence(); err != nil {
+			return err
+		}
+	} else if err := bkt.SetSequence(c.Seq); err != nil {
+		return err
+	}
+	v, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := bkt.Put(seqKey(c.Seq), v); err != nil {
+		return err
+	}
+	prefix := changeKeyPrefix(c.Bucket, c.Key)
+	switch c.Op {
+	case OpSet:
+		return keys.Put(append(prefix, seqKey(c.Seq)...), nil)
+	case OpDelete:
+		return tombstone(bkt, keys, prefix)
+	}
+	return nil
+}
+
+// tombstone replaces the set changes indexed under prefix by deletions without a value.
+// Replaying them deletes the key earlier than it was, which converges on the same head.
+func tombstone(bkt, keys *bolt.Bucket, prefix []byte) error {
+	var indexed [][]byte
+	c := keys.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+		if len(k) == len(prefix)+8 {
+			indexed = append(indexed, append([]byte{}, k...))
+		}
+	}
+	for _, k := range indexed {
+		seq := k[len(prefix):]
+		if v := bkt.Get(seq); v != nil {
+			var change Change
+			if err := json.Unmarshal(v, &change); err != nil {
+				return fmt.Errorf("error unmarshaling change %d: %w", binary.BigEndian.Uint64(seq), err)
+			}
+			change.Op, change.Value = OpDelete, nil
+			v, err := json.Marshal(change)
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put(seq, v); err != nil {
+				return err
+			}
+		}
+		if err := keys.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func head(tx *bolt.Tx) uint64 {
+	if bkt := tx.Bucket(changeLogBucket); bkt != nil {
+		return bkt.Sequence()
+	}
+	return 0
+}
+
+// Head returns the sequence number of the last recorded change.
+func (b *BBoltStore) Head() (uint64, error) {
+	var seq uint64
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		seq = head(tx)
+		return nil
+	})
+	return seq, err
+}
+
+// Changes returns up to limit changes recorded after the sequence number since.
+func (b *BBoltStore) Changes(since uint64, limit int) ([]Change, error) {
+	var changes []Change
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		h := head(tx)
+		if since > h {
+			return fmt.Errorf("%w: %d is ahead of the head %d", ErrChangeLogGap, since, h)
+		}
+		if since == h {
+			return nil
+		}
+		c := tx.Bucket(changeLogBucket).Cursor()
+		k, v := c.Seek(seqKey(since + 1))
+		if k == nil || binary.BigEndian.Uint64(k) != since+1 {
+			return fmt.Errorf("%w: change %d is not recorded", ErrChangeLogGap, since+1)
+		}
+		for ; k != nil && len(changes) < limit; k, v = c.Next() {
+			var change Change
+			if err := json.Unmarshal(v, &change); err != nil {
+				return fmt.Errorf("error unmarshaling change %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// Changed returns a channel closed once a change is recorded.
+func (b *BBoltStore) Changed() <-chan struct{} {
+	b.changedMu.Lock()
+	defer b.changedMu.Unlock()
+	return b.changed
+}
+
+// Dump returns a set change of every value of the store and the sequence number of the last change.
+func (b *BBoltStore) Dump() ([]Change, uint64, error) {
+	var changes []Change
+	var seq uint64
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		seq = head(tx)
+		return tx.ForEach(func(name []byte, bkt *bolt.Bucket) error {
+			if isChangeLogBucket(name) {
+				return nil
+			}
+			return bkt.ForEach(func(k, v []byte) error {
+				changes = append(changes, Change{Op: OpSet, Bucket: string(name), Key: string(k), Value: append([]byte{}, v...)})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, 0, fmt.Errorf("error dumping store: %w", err)
+	}
+	return changes, seq, nil
+}
+
+// Load replaces every value of the store by a dump taken at the sequence number head.
+// The change log is emptied, changes up to head can no longer be replayed from this store.
+func (b *BBoltStore) Load(dump []Change, seq uint64) error {
+	err := b.commit(func(tx *bolt.Tx) error {
+		var names [][]byte
+		if err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket(defaultBucket); err != nil {
+			return err
+		}
+		for _, c := range dump {
+			bkt, err := tx.CreateBucketIfNotExists([]byte(c.Bucket))
+			if err != nil {
+				return err
+			}
+			if err := bkt.Put([]byte(c.Key), c.Value); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.CreateBucket(changeLogKeysBucket); err != nil {
+			return err
+		}
+		bkt, err := tx.CreateBucket(changeLogBucket)
+		if err != nil {
+			return err
+		}
+		return bkt.SetSequence(seq)
+	})
+	if err != nil {
+		return fmt.Errorf("error loading dump: %w", err)
+	}
+	return nil
+}
+
+// Apply records changes replicated from another store.
+func (b *BBoltStore) Apply(changes []Change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	err := b.commit(func(tx *bolt.Tx) error {
+		h := head(tx)
+		for _, c := range changes {
+			if c.Seq != h+1 {
+				return fmt.Errorf("%w: expected change %d, got %d", ErrChangeLogGap, h+1, c.Seq)
+			}
+			switch c.Op {
+			case OpSet:
+				bkt, err := tx.CreateBucketIfNotExists([]byte(c.Bucket))
+				if err != nil {
+					return err
+				}
+				if err := bkt.Put([]byte(c.Key), c.Value); err != nil {
+					return err
+				}
+			case OpDelete:
+				if bkt := tx.Bucket([]byte(c.Bucket)); bkt != nil {
+					if err := bkt.Delete([]byte(c.Key)); err != nil {
+						return err
+					}
+				}
+			default:
+				return fmt.Errorf("unknown operation %s of change %d", c.Op, c.Seq)
+			}
+			// replicated changes are recorded even when the store does not record its own mutations
+			if err := (&BBoltStore{changeLog: true}).record(tx, c); err != nil {
+				return err
+			}
+			h = c.Seq
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error applying changes: %w", err)
+	}
+	return nil
+}
+
+// Truncate removes the changes up to the sequence number upTo and returns how many were removed.
+// The head is kept so the following changes keep their sequence numbers.
+func (b *BBoltStore) Truncate(upTo uint64) (int, error) {
+	n := 0
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(changeLogBucket)
+		if bkt == nil {
+			return nil
+		}
+		keys := tx.Bucket(changeLogKeysBucket)
+		var seqs [][]byte
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil && binary.BigEndian.Uint64(k) <= upTo; k, v = c.Next() {
+			seqs = append(seqs, append([]byte{}, k...))
+			var change Change
+			if err := json.Unmarshal(v, &change); err != nil {
+				return fmt.Errorf("error unmarshaling change %d: %w", binary.BigEndian.Uint64(k), err)
+			}
+			if change.Op == OpSet && keys != nil {
+				if err := keys.Delete(append(changeKeyPrefix(change.Bucket, change.Key), k...)); err != nil {
+					return err
+				}
+			}
+		}
+		for _, k := range seqs {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		n = len(seqs)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error truncating change log: %w", err)
+	}
+	return n, nil
+}
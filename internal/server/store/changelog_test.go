@@ -0,0 +1,166 @@
+package store_test
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+func newChangeLogStore(t *testing.T, name string, opts ...store.BBoltOption) *store.BBoltStore {
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), name), opts...)
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBBoltStore_ChangeLog(t *testing.T) {
+	primary := newChangeLogStore(t, "primary.db", store.WithChangeLog())
+	changed := primary.Changed()
+	MustSet(t, primary, store.DefaultBucket, "twitter#prd", "v1")
+	MustSet(t, primary, "metadata", "twitter#prd", "{}")
+	MustSet(t, primary, store.DefaultBucket, "stripe#prd", "v1")
+	MustDelete(t, primary, store.DefaultBucket, "stripe#prd")
+
+	t.Run("notifies waiters of recorded changes", func(t *testing.T) {
+		select {
+		case <-changed:
+		default:
+			t.Errorf("expected the changed channel to be closed")
+		}
+	})
+
+	t.Run("returns the changes after a sequence number", func(t *testing.T) {
+		changes, err := primary.Changes(1, 2)
+		if err != nil {
+			t.Fatalf("error reading changes: %v", err)
+		}
+		// stripe#prd is deleted by change 4 so the value it was set to is dropped
+		expected := []store.Change{
+			{Seq: 2, Op: store.OpSet, Bucket: "metadata", Key: "twitter#prd"},
+			{Seq: 3, Op: store.OpDelete, Bucket: "zypher", Key: "stripe#prd"},
+		}
+		// values are recorded along with their metadata
+		if len(changes) != 2 || !bytes.HasSuffix(changes[0].Value, []byte("{}")) {
+			t.Fatalf("expected change 2 to record its value, got %v", changes)
+		}
+		changes[0].Value = nil
+		if !reflect.DeepEqual(changes, expected) {
+			t.Errorf("expected changes to be %v, got %v", expected, changes)
+		}
+		if changes, err := primary.Changes(4, 10); err != nil || len(changes) != 0 {
+			t.Errorf("expected no change after the head, got %v, %v", changes, err)
+		}
+		if _, err := primary.Changes(5, 10); !errors.Is(err, store.ErrChangeLogGap) {
+			t.Errorf("expected ErrChangeLogGap ahead of the head, got %v", err)
+		}
+	})
+
+	t.Run("replicates a dump and the following changes", func(t *testing.T) {
+		dump, head, err := primary.Dump()
+		if err != nil {
+			t.Fatalf("error dumping store: %v", err)
+		}
+		if head != 4 {
+			t.Errorf("expected head to be 4, got %d", head)
+		}
+		follower := newChangeLogStore(t, "follower.db")
+		MustSet(t, follower, store.DefaultBucket, "stale#prd", "v0")
+		if err := follower.Load(dump, head); err != nil {
+			t.Fatalf("error loading dump: %v", err)
+		}
+		// values missing from the dump are removed
+		MustGetExpected(t, follower, store.DefaultBucket, "stale#prd", "")
+		MustGetExpected(t, follower, "metadata", "twitter#prd", "{}")
+		// changes up to the head of the dump cannot be replayed from the follower
+		if _, err := follower.Changes(2, 10); !errors.Is(err, store.ErrChangeLogGap) {
+			t.Errorf("expected ErrChangeLogGap before the loaded head, got %v", err)
+		}
+
+		MustSet(t, primary, store.DefaultBucket, "twitter#prd", "v2")
+		changes, err := primary.Changes(head, 10)
+		if err != nil {
+			t.Fatalf("error reading changes: %v", err)
+		}
+		if err := follower.Apply(changes); err != nil {
+			t.Fatalf("error applying changes: %v", err)
+		}
+		MustGetExpected(t, follower, store.DefaultBucket, "twitter#prd", "v2")
+		// the records are replicated along with their metadata
+		if e, err := follower.Get(ctx, store.DefaultBucket, "twitter#prd"); err != nil || e.Version != 2 {
+			t.Errorf("expected the replicated value to be at version 2, got %+v, %v", e, err)
+		}
+		if h, _ := follower.Head(); h != 5 {
+			t.Errorf("expected the follower head to be 5, got %d", h)
+		}
+		if err := follower.Apply(changes); !errors.Is(err, store.ErrChangeLogGap) {
+			t.Errorf("expected ErrChangeLogGap applying a change twice, got %v", err)
+		}
+		if replayed, err := follower.Changes(4, 10); err != nil || !reflect.DeepEqual(replayed, changes) {
+			t.Errorf("expected the follower to replay the applied changes, got %v, %v", replayed, err)
+		}
+	})
+}
+
+func TestBBoltStore_ChangeLogCompaction(t *testing.T) {
+	primary := newChangeLogStore(t, "primary.db", store.WithChangeLog())
+	MustSet(t, primary, store.DefaultBucket, "twitter#prd", "v1")
+	MustSet(t, primary, store.DefaultBucket, "stripe#prd", "v1")
+	MustSet(t, primary, store.DefaultBucket, "twitter#prd", "v2")
+	MustDelete(t, primary, store.DefaultBucket, "twitter#prd")
+
+	t.Run("drops the values of deleted keys", func(t *testing.T) {
+		changes, err := primary.Changes(0, 10)
+		if err != nil {
+			t.Fatalf("error reading changes: %v", err)
+		}
+		expected := []store.Change{
+			{Seq: 1, Op: store.OpDelete, Bucket: "zypher", Key: "twitter#prd"},
+			{Seq: 2, Op: store.OpSet, Bucket: "zypher", Key: "stripe#prd"},
+			{Seq: 3, Op: store.OpDelete, Bucket: "zypher", Key: "twitter#prd"},
+			{Seq: 4, Op: store.OpDelete, Bucket: "zypher", Key: "twitter#prd"},
+		}
+		if len(changes) != 4 || !bytes.HasSuffix(changes[1].Value, []byte("v1")) {
+			t.Fatalf("expected the value of stripe#prd to be kept, got %v", changes)
+		}
+		changes[1].Value = nil
+		if !reflect.DeepEqual(changes, expected) {
+			t.Errorf("expected changes to be %v, got %v", expected, changes)
+		}
+
+		follower := newChangeLogStore(t, "follower.db")
+		changes, _ = primary.Changes(0, 10)
+		if err := follower.Apply(changes); err != nil {
+			t.Fatalf("error applying changes: %v", err)
+		}
+		MustGetExpected(t, follower, store.DefaultBucket, "twitter#prd", "")
+		MustGetExpected(t, follower, store.DefaultBucket, "stripe#prd", "v1")
+	})
+
+	t.Run("truncates the changes up to a sequence number", func(t *testing.T) {
+		if n, err := primary.Truncate(2); err != nil || n != 2 {
+			t.Fatalf("expected 2 changes to be truncated, got %d, %v", n, err)
+		}
+		if _, err := primary.Changes(1, 10); !errors.Is(err, store.ErrChangeLogGap) {
+			t.Errorf("expected ErrChangeLogGap before the truncated changes, got %v", err)
+		}
+		if changes, err := primary.Changes(2, 10); err != nil || len(changes) != 2 || changes[0].Seq != 3 {
+			t.Errorf("expected changes 3 and 4 to be kept, got %v, %v", changes, err)
+		}
+		MustSet(t, primary, store.DefaultBucket, "slack#prd", "v1")
+		if h, _ := primary.Head(); h != 5 {
+			t.Errorf("expected the head to be kept, got %d", h)
+		}
+		if n, err := primary.Truncate(5); err != nil || n != 3 {
+			t.Errorf("expected 3 changes to be truncated, got %d, %v", n, err)
+		}
+		if changes, err := primary.Changes(5, 10); err != nil || len(changes) != 0 {
+			t.Errorf("expected no change after the head, got %v, %v", changes, err)
+		}
+	})
+}
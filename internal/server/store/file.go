@@ -0,0 +1,385 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// fileExt is the extension of the files holding a value
+	fileExt = ".zy"
+	// lockFileName is the file locked by writers of a FileStore
+	lockFileName = ".lock"
+)
+
+// Cipher encrypts the values of a FileStore.
+type Cipher interface {
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+}
+
+// FileStore keeps every value as an individually encrypted file in a directory tree
+// so the store can be reviewed and versioned in git and mounted read-only.
+// Each bucket is a directory of the root and every # separated segment of a key is a directory of the bucket,
+// e.g. twitter#prd of the default bucket is stored in zypher/twitter/prd.zy.
+// Files hold the base64 encoded ciphertext of the value along with its metadata.
+//
+// Writes are atomic and serialized across processes by an exclusive lock on the .lock file of the root.
+type FileStore struct {
+	root   string
+	cipher Cipher
+	// mu serializes the writers of this process since file locks are held per process
+	mu sync.Mutex
+}
+
+// NewFileStore returns a FileStore rooted at dir encrypting values with c.
+// The root is created if it doesn't exist.
+func NewFileStore(dir string, c Cipher) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, string(defaultBucket)), 0700); err != nil {
+		// a read-only root is fine as long as it already holds the default bucket
+		if _, statErr := os.Stat(filepath.Join(dir, string(defaultBucket))); statErr != nil {
+			return nil, fmt.Errorf("error creating file store at %s: %w", dir, err)
+		}
+	}
+	return &FileStore{
+		root:   dir,
+		cipher: c,
+	}, nil
+}
+
+// emptySegment is the file name of an empty key segment,
+// url.PathEscape only emits uppercase escapes so it cannot be the escape of another segment.
+const emptySegment = "%2e"
+
+// escapeSegment escapes a key segment into a file name that cannot traverse or hide in the tree.
+func escapeSegment(s string) string {
+	if s == "" {
+		return emptySegment
+	}
+	e := url.PathEscape(s)
+	if e[0] == '.' {
+		// dot files, . and .. segments
+		e = "%2E" + e[1:]
+	}
+	return e
+}
+
+func unescapeSegment(s string) (string, error) {
+	if s == emptySegment {
+		return "", nil
+	}
+	return url.PathUnescape(s)
+}
+
+// path returns the file holding the key of the bucket.
+func (f *FileStore) path(bucket, key string) string {
+	segments := strings.Split(key, "#")
+	parts := make([]string, 0, len(segments)+2)
+	parts = append(parts, f.root, escapeSegment(bucket))
+	for _, s := range segments {
+		parts = append(parts, escapeSegment(s))
+	}
+	parts[len(parts)-1] += fileExt
+	return filepath.Join(parts...)
+}
+
+// Close is a no-op since files are only held open while they are read or written.
+func (f *FileStore) Close() error {
+	return nil
+}
+
+// Get retrieves the entry associated with the given key from the given bucket.
+func (f *FileStore) Get(ctx context.Context, bucket, key string) (*Entry, error) {
+	return get(f, ctx, bucket, key)
+}
+
+// Put stores the given value and associates it with the given key in the given bucket.
+func (f *FileStore) Put(ctx context.Context, bucket, key string, value []byte) (*Metadata, error) {
+	return put(f, ctx, bucket, key, value)
+}
+
+// CompareAndSwap stores the value only if the key is at the given version.
+func (f *FileStore) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	return compareAndSwap(f, ctx, bucket, key, version, value)
+}
+
+// Delete removes the value associated with the given key from the given bucket
+// along with the directories left empty.
+func (f *FileStore) Delete(ctx context.Context, bucket, key string) error {
+	return del(f, ctx, bucket, key)
+}
+
+// List returns the keys of the given bucket sorted, optionally filtered by a prefix.
+func (f *FileStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	return list(f, ctx, bucket, prefix)
+}
+
+// Update runs fn holding the lock of the root. The writes of fn are buffered and only written once it returns nil,
+// every file is replaced atomically but a crash while writing them can leave part of the writes applied.
+func (f *FileStore) Update(ctx context.Context, fn func(Tx) error) error {
+	return update(f, ctx, fn)
+}
+
+// View runs fn without holding the lock of the root, it may read the writes committed while it runs.
+func (f *FileStore) View(ctx context.Context, fn func(Tx) error) error {
+	return view(f, ctx, fn)
+}
+
+// Buckets returns the names of the buckets of the store sorted.
+func (f *FileStore) Buckets(ctx context.Context) ([]string, error) {
+	return buckets(f, ctx)
+}
+
+// Import stores the entry as it is, keeping its version and times.
+func (f *FileStore) Import(ctx context.Context, bucket, key string, e *Entry) error {
+	return importEntry(f, ctx, bucket, key, e)
+}
+
+func (f *FileStore) view(_ context.Context, fn func(rawTx) error) error {
+	return fn(&fileTx{store: f})
+}
+
+func (f *FileStore) update(_ context.Context, fn func(rawTx) error) error {
+	return f.locked(func() error {
+		tx := &fileTx{store: f, pending: map[fileKey]*Entry{}}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return tx.commit()
+	})
+}
+
+type fileKey struct {
+	bucket, key string
+}
+
+// fileTx reads the files of a FileStore, writes are kept pending until the transaction is committed.
+type fileTx struct {
+	store *FileStore
+	// pending holds the written entries, nil for a deleted key
+	pending map[fileKey]*Entry
+	order   []fileKey
+}
+
+func (t *fileTx) get(bucket, key string) (*Entry, error) {
+	if e, found := t.pending[fileKey{bucket, key}]; found {
+		return e, nil
+	}
+	return t.store.read(bucket, key)
+}
+
+func (t *fileTx) put(bucket, key string, e *Entry) error {
+	return t.set(fileKey{bucket, key}, e)
+}
+
+func (t *fileTx) delete(bucket, key string) error {
+	return t.set(fileKey{bucket, key}, nil)
+}
+
+func (t *fileTx) set(k fileKey, e *Entry) error {
+	if t.pending == nil {
+		return fmt.Errorf("file store is read-only outside of Update")
+	}
+	if _, found := t.pending[k]; !found {
+		t.order = append(t.order, k)
+	}
+	t.pending[k] = e
+	return nil
+}
+
+func (t *fileTx) list(bucket, prefix string) ([]string, error) {
+	keys, err := t.store.walk(bucket, prefix)
+	if err != nil || len(t.pending) == 0 {
+		return keys, err
+	}
+	found := map[string]bool{}
+	for _, k := range keys {
+		found[k] = true
+	}
+	for k, e := range t.pending {
+		if k.bucket == bucket && strings.HasPrefix(k.key, prefix) {
+			found[k.key] = e != nil
+		}
+	}
+	keys = keys[:0]
+	for k, exists := range found {
+		if exists {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// buckets returns the bucket directories of the root, pending writes are not taken into account.
+func (t *fileTx) buckets() ([]string, error) {
+	entries, err := os.ReadDir(t.store.root)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		name, err := unescapeSegment(e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error unescaping %s: %w", e.Name(), err)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// commit writes the pending entries in the order they were written.
+func (t *fileTx) commit() error {
+	for _, k := range t.order {
+		e := t.pending[k]
+		if e == nil {
+			if err := t.store.remove(k.bucket, k.key); err != nil {
+				return fmt.Errorf("error deleting %s from %s bucket: %w", k.key, k.bucket, err)
+			}
+			continue
+		}
+		if err := t.store.write(k.bucket, k.key, e); err != nil {
+			return fmt.Errorf("error setting %s in %s bucket: %w", k.key, k.bucket, err)
+		}
+	}
+	return nil
+}
+
+// read returns the entry stored in the file of the key or nil if there is none.
+func (f *FileStore) read(bucket, key string) (*Entry, error) {
+	b, err := os.ReadFile(f.path(bucket, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding value: %w", err)
+	}
+	record, err := f.cipher.Decrypt(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting value: %w", err)
+	}
+	return decodeRecord(record)
+}
+
+// write encrypts the entry to a temporary file renamed over the file of the key.
+func (f *FileStore) write(bucket, key string, e *Entry) error {
+	record, err := encodeRecord(e)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := f.cipher.Encrypt(record)
+	if err != nil {
+		return fmt.Errorf("error encrypting value: %w", err)
+	}
+	return writeFileAtomic(f.path(bucket, key), []byte(base64.StdEncoding.EncodeToString(ciphertext)+"\n"))
+}
+
+// remove removes the file of the key along with the directories left empty.
+func (f *FileStore) remove(bucket, key string) error {
+	path := f.path(bucket, key)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	bucketDir := filepath.Join(f.root, escapeSegment(bucket))
+	for dir := filepath.Dir(path); dir != bucketDir && strings.HasPrefix(dir, bucketDir); dir = filepath.Dir(dir) {
+		// fails once a directory is not empty
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// walk returns the keys of the bucket starting with prefix sorted.
+func (f *FileStore) walk(bucket, prefix string) ([]string, error) {
+	bucketDir := filepath.Join(f.root, escapeSegment(bucket))
+	var keys []string
+	err := filepath.WalkDir(bucketDir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == bucketDir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") || !strings.HasSuffix(d.Name(), fileExt) {
+			return nil
+		}
+		rel, err := filepath.Rel(bucketDir, strings.TrimSuffix(path, fileExt))
+		if err != nil {
+			return err
+		}
+		segments := strings.Split(filepath.ToSlash(rel), "/")
+		for i, s := range segments {
+			if segments[i], err = unescapeSegment(s); err != nil {
+				return fmt.Errorf("error unescaping %s: %w", path, err)
+			}
+		}
+		if key := strings.Join(segments, "#"); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// locked runs fn holding the lock of this process and the file lock of the root.
+func (f *FileStore) locked(fn func() error) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	lock, err := os.OpenFile(filepath.Join(f.root, lockFileName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("error opening lock file: %w", err)
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return fmt.Errorf("error locking %s: %w", lock.Name(), err)
+	}
+	defer unlockFile(lock)
+	return fn()
+}
+
+// writeFileAtomic writes data to a temporary file synced then renamed to path
+// so readers either see the previous or the new content.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,154 @@
+package store_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/vtno/zypher"
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+const fileStoreKey = "0123456789abcdef0123456789abcdef"
+
+func newFileStore(t *testing.T, dir string) *store.FileStore {
+	s, err := store.NewFileStore(dir, zypher.NewCipher(fileStoreKey))
+	if err != nil {
+		t.Fatalf("error creating file store: %v", err)
+	}
+	return s
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	s := newFileStore(t, dir)
+
+	t.Run("stores each value encrypted in its own file", func(t *testing.T) {
+		MustSet(t, s, store.DefaultBucket, "twitter#prd", "supersecretkey")
+		b, err := os.ReadFile(filepath.Join(dir, "zypher", "twitter", "prd.zy"))
+		if err != nil {
+			t.Fatalf("error reading value file: %v", err)
+		}
+		if strings.Contains(string(b), "supersecretkey") {
+			t.Errorf("expected the value file to be encrypted, got %s", b)
+		}
+		MustGetExpected(t, s, store.DefaultBucket, "twitter#prd", "supersecretkey")
+	})
+
+	t.Run("returns an empty value for a missing key or bucket", func(t *testing.T) {
+		MustGetExpected(t, s, store.DefaultBucket, "missing#prd", "")
+		MustGetExpected(t, s, "missing", "twitter#prd", "")
+	})
+
+	t.Run("keeps buckets apart", func(t *testing.T) {
+		MustSet(t, s, "versions", "twitter#prd#1", "oldkey")
+		MustGetExpected(t, s, "versions", "twitter#prd#1", "oldkey")
+		if _, err := os.Stat(filepath.Join(dir, "versions", "twitter", "prd", "1.zy")); err != nil {
+			t.Errorf("expected the version file to exist: %v", err)
+		}
+	})
+
+	t.Run("escapes segments that would escape the tree", func(t *testing.T) {
+		keys := []string{"../../etc#passwd", "a/b#prd", ".hidden#prd", "#prd", "%2e#prd", "."}
+		for _, key := range keys {
+			MustSet(t, s, "escaped", key, key)
+		}
+		for _, key := range keys {
+			MustGetExpected(t, s, "escaped", key, key)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "..", "etc")); err == nil {
+			t.Errorf("expected no file to be written outside of the root")
+		}
+	})
+
+	t.Run("lists the keys of the default bucket sorted", func(t *testing.T) {
+		MustSet(t, s, store.DefaultBucket, "stripe#prd", "key")
+		MustSet(t, s, store.DefaultBucket, "stripe#dev", "key")
+		MustSet(t, s, store.DefaultBucket, "../x#prd", "key")
+		keys, err := s.List(ctx, store.DefaultBucket, "")
+		if err != nil {
+			t.Fatalf("error listing keys: %v", err)
+		}
+		expected := []string{"../x#prd", "stripe#dev", "stripe#prd", "twitter#prd"}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("expected keys to be %v, got %v", expected, keys)
+		}
+		keys, err = s.List(ctx, store.DefaultBucket, "stripe")
+		if err != nil {
+			t.Fatalf("error listing keys: %v", err)
+		}
+		if expected := []string{"stripe#dev", "stripe#prd"}; !reflect.DeepEqual(keys, expected) {
+			t.Errorf("expected keys to be %v, got %v", expected, keys)
+		}
+	})
+
+	t.Run("removes deleted values and the directories left empty", func(t *testing.T) {
+		MustDelete(t, s, store.DefaultBucket, "twitter#prd")
+		MustDelete(t, s, "versions", "twitter#prd#1")
+		MustDelete(t, s, store.DefaultBucket, "missing#prd")
+		MustGetExpected(t, s, store.DefaultBucket, "twitter#prd", "")
+		for _, path := range []string{"zypher/twitter", "versions/twitter"} {
+			if _, err := os.Stat(filepath.Join(dir, path)); !os.IsNotExist(err) {
+				t.Errorf("expected %s to be removed, got %v", path, err)
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "versions")); err != nil {
+			t.Errorf("expected the bucket directory to be kept: %v", err)
+		}
+	})
+
+	t.Run("fails to read a value encrypted with another key", func(t *testing.T) {
+		other, err := store.NewFileStore(dir, zypher.NewCipher("fedcba9876543210fedcba9876543210"))
+		if err != nil {
+			t.Fatalf("error creating file store: %v", err)
+		}
+		if _, err := other.Get(ctx, store.DefaultBucket, "stripe#prd"); err == nil {
+			t.Errorf("expected an error decrypting with another key")
+		}
+	})
+}
+
+func TestFileStore_concurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	// stores sharing a root stand for separate processes only serialized by the lock file
+	stores := []*store.FileStore{newFileStore(t, dir), newFileStore(t, dir)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := stores[i%len(stores)]
+			if _, err := s.Put(ctx, store.DefaultBucket, "twitter#prd", []byte(fmt.Sprintf("key-%d", i))); err != nil {
+				t.Errorf("error setting value: %v", err)
+			}
+			if _, err := s.Put(ctx, store.DefaultBucket, fmt.Sprintf("key%d#prd", i), []byte("key")); err != nil {
+				t.Errorf("error setting value: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	e, err := stores[0].Get(ctx, store.DefaultBucket, "twitter#prd")
+	if err != nil || !strings.HasPrefix(string(e.Value), "key-") || e.Version != 20 {
+		t.Errorf("expected version 20 written by one of the writers, got %+v, %v", e, err)
+	}
+	keys, err := stores[1].List(ctx, store.DefaultBucket, "")
+	if err != nil {
+		t.Fatalf("error listing keys: %v", err)
+	}
+	if len(keys) != 21 {
+		t.Errorf("expected 21 keys, got %d", len(keys))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, "zypher", "twitter"))
+	if err != nil {
+		t.Fatalf("error reading dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected temporary files to be removed, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"time"
+)
+
+// ErrMigrationConflict is returned when the destination of a migration holds a different entry for a key.
+var ErrMigrationConflict = errors.New("destination holds a different entry")
+
+// BucketMigration reports the migration of a bucket.
+type BucketMigration struct {
+	Bucket string
+	// Keys is the number of keys of the source bucket.
+	Keys int
+	// Copied is the number of keys copied, or that would be copied by a dry run.
+	Copied int
+	// Skipped is the number of keys already in the destination e.g. copied before the migration was interrupted.
+	Skipped int
+	// Checksum is the SHA-256 of the keys, values and metadata of the source bucket.
+	Checksum string
+}
+
+type migration struct {
+	dryRun bool
+}
+
+type MigrateOption func(*migration)
+
+// WithDryRun reports what would be copied without writing to the destination.
+func WithDryRun() MigrateOption {
+	return func(m *migration) {
+		m.dryRun = true
+	}
+}
+
+// Migrate copies every bucket and key of from to to along with their metadata, then checks that every bucket
+// of the destination has the same number of keys and checksum as the source.
+// Keys are copied one transaction at a time and the ones already in the destination are skipped,
+// so an interrupted migration is resumed by running it again. A key holding a different entry in the destination
+// fails the migration with ErrMigrationConflict rather than being overwritten.
+// The source must not be written to during the migration.
+func Migrate(ctx context.Context, from, to Migratable, opts ...MigrateOption) ([]BucketMigration, error) {
+	m := &migration{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	buckets, err := from.Buckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var report []BucketMigration
+	for _, bucket := range buckets {
+		bm, err := m.migrateBucket(ctx, from, to, bucket)
+		if err != nil {
+			return report, err
+		}
+		report = append(report, *bm)
+	}
+	return report, nil
+}
+
+func (m *migration) migrateBucket(ctx context.Context, from, to Migratable, bucket string) (*BucketMigration, error) {
+	keys, err := from.List(ctx, bucket, "")
+	if err != nil {
+		return nil, err
+	}
+	bm := &BucketMigration{Bucket: bucket, Keys: len(keys)}
+	h := sha256.New()
+	for _, key := range keys {
+		e, err := from.Get(ctx, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		writeChecksum(h, key, e)
+
+		existing, err := to.Get(ctx, bucket, key)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			bm.Copied++
+			if m.dryRun {
+				continue
+			}
+			if err := to.Import(ctx, bucket, key, e); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		case sameEntry(existing, e):
+			bm.Skipped++
+		default:
+			return nil, fmt.Errorf("%w: %s in %s bucket", ErrMigrationConflict, key, bucket)
+		}
+	}
+	bm.Checksum = hex.EncodeToString(h.Sum(nil))
+	if m.dryRun {
+		return bm, nil
+	}
+
+	n, checksum, err := Checksum(ctx, to, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if n != bm.Keys || checksum != bm.Checksum {
+		return nil, fmt.Errorf("error verifying %s bucket: destination has %d keys with checksum %s, source has %d keys with checksum %s",
+			bucket, n, checksum, bm.Keys, bm.Checksum)
+	}
+	return bm, nil
+}
+
+// Checksum returns the number of keys of the bucket and the SHA-256 of their keys, values and metadata.
+func Checksum(ctx context.Context, s Store, bucket string) (int, string, error) {
+	keys, err := s.List(ctx, bucket, "")
+	if err != nil {
+		return 0, "", err
+	}
+	h := sha256.New()
+	for _, key := range keys {
+		e, err := s.Get(ctx, bucket, key)
+		if err != nil {
+			return 0, "", err
+		}
+		writeChecksum(h, key, e)
+	}
+	return len(keys), hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum writes the key and entry to h, every field is prefixed by its length.
+func writeChecksum(h hash.Hash, key string, e *Entry) {
+	for _, field := range [][]byte{
+		[]byte(key),
+		e.Value,
+		binary.BigEndian.AppendUint64(nil, e.Version),
+		[]byte(e.Created.UTC().Format(time.RFC3339Nano)),
+		[]byte(e.Updated.UTC().Format(time.RFC3339Nano)),
+		[]byte(e.Creator),
+	} {
+		h.Write(binary.BigEndian.AppendUint64(nil, uint64(len(field))))
+		h.Write(field)
+	}
+}
+
+func sameEntry(a, b *Entry) bool {
+	return bytes.Equal(a.Value, b.Value) && a.Version == b.Version && a.Creator == b.Creator &&
+		a.Created.Equal(b.Created) && a.Updated.Equal(b.Updated)
+}
@@ -0,0 +1,93 @@
+package store_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+func TestMigrate(t *testing.T) {
+	from := newChangeLogStore(t, "from.db")
+	MustSet(t, from, store.DefaultBucket, "twitter#prd", "key2")
+	MustSet(t, from, store.DefaultBucket, "twitter#prd", "key3")
+	MustSet(t, from, store.DefaultBucket, "stripe#prd", "key1")
+	MustSet(t, from, "key_versions", "twitter#prd#1", "key1")
+	MustSet(t, from, "non_exportable", "stripe#prd", "true")
+
+	destinations := map[string]func(t *testing.T) store.Migratable{
+		"sqlite": func(t *testing.T) store.Migratable {
+			return newSQLiteStore(t, filepath.Join(t.TempDir(), "to.sqlite"))
+		},
+		"file": func(t *testing.T) store.Migratable {
+			return newFileStore(t, t.TempDir())
+		},
+	}
+	for name, newStore := range destinations {
+		t.Run(name, func(t *testing.T) {
+			to := newStore(t)
+
+			t.Run("reports the keys to copy without writing on a dry run", func(t *testing.T) {
+				report, err := store.Migrate(ctx, from, to, store.WithDryRun())
+				if err != nil {
+					t.Fatalf("error migrating store: %v", err)
+				}
+				copied := map[string]int{}
+				for _, bm := range report {
+					copied[bm.Bucket] = bm.Copied
+				}
+				expected := map[string]int{"zypher": 2, "key_versions": 1, "non_exportable": 1}
+				if !reflect.DeepEqual(copied, expected) {
+					t.Errorf("expected %v keys to be copied, got %v", expected, copied)
+				}
+				MustGetExpected(t, to, store.DefaultBucket, "twitter#prd", "")
+			})
+
+			t.Run("resumes an interrupted migration", func(t *testing.T) {
+				// twitter#prd was copied before the migration was interrupted
+				e, err := from.Get(ctx, store.DefaultBucket, "twitter#prd")
+				if err != nil {
+					t.Fatalf("error getting value: %v", err)
+				}
+				if err := to.Import(ctx, store.DefaultBucket, "twitter#prd", e); err != nil {
+					t.Fatalf("error importing value: %v", err)
+				}
+
+				report, err := store.Migrate(ctx, from, to)
+				if err != nil {
+					t.Fatalf("error migrating store: %v", err)
+				}
+				for _, bm := range report {
+					if bm.Bucket == store.DefaultBucket && (bm.Copied != 1 || bm.Skipped != 1) {
+						t.Errorf("expected 1 key to be copied and 1 to be skipped, got %+v", bm)
+					}
+				}
+				migrated, err := to.Get(ctx, store.DefaultBucket, "twitter#prd")
+				if err != nil || string(migrated.Value) != "key3" || migrated.Version != 2 || !migrated.Created.Equal(e.Created) {
+					t.Errorf("expected key3 to be migrated along with its metadata, got %+v, %v", migrated, err)
+				}
+				MustGetExpected(t, to, "non_exportable", "stripe#prd", "true")
+			})
+
+			t.Run("refuses to overwrite a different entry", func(t *testing.T) {
+				MustSet(t, to, "key_versions", "twitter#prd#1", "changed")
+				if _, err := store.Migrate(ctx, from, to); !errors.Is(err, store.ErrMigrationConflict) {
+					t.Errorf("expected ErrMigrationConflict, got %v", err)
+				}
+			})
+		})
+	}
+}
+
+func TestMigrate_verifiesDestination(t *testing.T) {
+	from := newChangeLogStore(t, "from.db")
+	MustSet(t, from, store.DefaultBucket, "twitter#prd", "key1")
+	to := newChangeLogStore(t, "to.db")
+	MustSet(t, to, store.DefaultBucket, "stale#prd", "key1")
+
+	if _, err := store.Migrate(ctx, from, to); err == nil {
+		t.Errorf("expected the verification of a destination holding extra keys to fail")
+	}
+}
@@ -5,6 +5,8 @@
 package store
 
 import (
+	context "context"
+	io "io"
 	reflect "reflect"
 
 	gomock "go.uber.org/mock/gomock"
@@ -47,75 +49,407 @@ func (mr *MockStoreMockRecorder) Close() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStore)(nil).Close))
 }
 
+// CompareAndSwap mocks base method.
+func (m *MockStore) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareAndSwap", ctx, bucket, key, version, value)
+	ret0, _ := ret[0].(*Metadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompareAndSwap indicates an expected call of CompareAndSwap.
+func (mr *MockStoreMockRecorder) CompareAndSwap(ctx, bucket, key, version, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSwap", reflect.TypeOf((*MockStore)(nil).CompareAndSwap), ctx, bucket, key, version, value)
+}
+
 // Delete mocks base method.
-func (m *MockStore) Delete(key string) error {
+func (m *MockStore) Delete(ctx context.Context, bucket, key string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Delete", key)
+	ret := m.ctrl.Call(m, "Delete", ctx, bucket, key)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Delete indicates an expected call of Delete.
-func (mr *MockStoreMockRecorder) Delete(key interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) Delete(ctx, bucket, key interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStore)(nil).Delete), key)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStore)(nil).Delete), ctx, bucket, key)
 }
 
 // Get mocks base method.
-func (m *MockStore) Get(key string) (string, error) {
+func (m *MockStore) Get(ctx context.Context, bucket, key string) (*Entry, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Get", key)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "Get", ctx, bucket, key)
+	ret0, _ := ret[0].(*Entry)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Get indicates an expected call of Get.
-func (mr *MockStoreMockRecorder) Get(key interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) Get(ctx, bucket, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockStore)(nil).Get), ctx, bucket, key)
+}
+
+// List mocks base method.
+func (m *MockStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", ctx, bucket, prefix)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockStoreMockRecorder) List(ctx, bucket, prefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockStore)(nil).List), ctx, bucket, prefix)
+}
+
+// Put mocks base method.
+func (m *MockStore) Put(ctx context.Context, bucket, key string, value []byte) (*Metadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Put", ctx, bucket, key, value)
+	ret0, _ := ret[0].(*Metadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockStoreMockRecorder) Put(ctx, bucket, key, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockStore)(nil).Put), ctx, bucket, key, value)
+}
+
+// Update mocks base method.
+func (m *MockStore) Update(ctx context.Context, fn func(Tx) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockStoreMockRecorder) Update(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockStore)(nil).Update), ctx, fn)
+}
+
+// View mocks base method.
+func (m *MockStore) View(ctx context.Context, fn func(Tx) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "View", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// View indicates an expected call of View.
+func (mr *MockStoreMockRecorder) View(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "View", reflect.TypeOf((*MockStore)(nil).View), ctx, fn)
+}
+
+// MockTx is a mock of Tx interface.
+type MockTx struct {
+	ctrl     *gomock.Controller
+	recorder *MockTxMockRecorder
+}
+
+// MockTxMockRecorder is the mock recorder for MockTx.
+type MockTxMockRecorder struct {
+	mock *MockTx
+}
+
+// NewMockTx creates a new mock instance.
+func NewMockTx(ctrl *gomock.Controller) *MockTx {
+	mock := &MockTx{ctrl: ctrl}
+	mock.recorder = &MockTxMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTx) EXPECT() *MockTxMockRecorder {
+	return m.recorder
+}
+
+// CompareAndSwap mocks base method.
+func (m *MockTx) CompareAndSwap(bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompareAndSwap", bucket, key, version, value)
+	ret0, _ := ret[0].(*Metadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CompareAndSwap indicates an expected call of CompareAndSwap.
+func (mr *MockTxMockRecorder) CompareAndSwap(bucket, key, version, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSwap", reflect.TypeOf((*MockTx)(nil).CompareAndSwap), bucket, key, version, value)
+}
+
+// Delete mocks base method.
+func (m *MockTx) Delete(bucket, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", bucket, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockTxMockRecorder) Delete(bucket, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockTx)(nil).Delete), bucket, key)
+}
+
+// Get mocks base method.
+func (m *MockTx) Get(bucket, key string) (*Entry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", bucket, key)
+	ret0, _ := ret[0].(*Entry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockTxMockRecorder) Get(bucket, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockTx)(nil).Get), bucket, key)
+}
+
+// List mocks base method.
+func (m *MockTx) List(bucket, prefix string) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", bucket, prefix)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List.
+func (mr *MockTxMockRecorder) List(bucket, prefix interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockTx)(nil).List), bucket, prefix)
+}
+
+// Put mocks base method.
+func (m *MockTx) Put(bucket, key string, value []byte) (*Metadata, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Put", bucket, key, value)
+	ret0, _ := ret[0].(*Metadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockTxMockRecorder) Put(bucket, key, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockTx)(nil).Put), bucket, key, value)
+}
+
+// MockMigratable is a mock of Migratable interface.
+type MockMigratable struct {
+	ctrl     *gomock.Controller
+	recorder *MockMigratableMockRecorder
+}
+
+// MockMigratableMockRecorder is the mock recorder for MockMigratable.
+type MockMigratableMockRecorder struct {
+	mock *MockMigratable
+}
+
+// NewMockMigratable creates a new mock instance.
+func NewMockMigratable(ctrl *gomock.Controller) *MockMigratable {
+	mock := &MockMigratable{ctrl: ctrl}
+	mock.recorder = &MockMigratableMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockMigratable) EXPECT() *MockMigratableMockRecorder {
+	return m.recorder
+}
+
+// Buckets mocks base method.
+func (m *MockMigratable) Buckets(ctx context.Context) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Buckets", ctx)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Buckets indicates an expected call of Buckets.
+func (mr *MockMigratableMockRecorder) Buckets(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Buckets", reflect.TypeOf((*MockMigratable)(nil).Buckets), ctx)
+}
+
+// Close mocks base method.
+func (m *MockMigratable) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockMigratableMockRecorder) Close() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockStore)(nil).Get), key)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockMigratable)(nil).Close))
 }
 
-// GetByBucket mocks base method.
-func (m *MockStore) GetByBucket(bucket, key string) (string, error) {
+// CompareAndSwap mocks base method.
+func (m *MockMigratable) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetByBucket", bucket, key)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "CompareAndSwap", ctx, bucket, key, version, value)
+	ret0, _ := ret[0].(*Metadata)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetByBucket indicates an expected call of GetByBucket.
-func (mr *MockStoreMockRecorder) GetByBucket(bucket, key interface{}) *gomock.Call {
+// CompareAndSwap indicates an expected call of CompareAndSwap.
+func (mr *MockMigratableMockRecorder) CompareAndSwap(ctx, bucket, key, version, value interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByBucket", reflect.TypeOf((*MockStore)(nil).GetByBucket), bucket, key)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompareAndSwap", reflect.TypeOf((*MockMigratable)(nil).CompareAndSwap), ctx, bucket, key, version, value)
+}
+
+// Delete mocks base method.
+func (m *MockMigratable) Delete(ctx context.Context, bucket, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, bucket, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockMigratableMockRecorder) Delete(ctx, bucket, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockMigratable)(nil).Delete), ctx, bucket, key)
+}
+
+// Get mocks base method.
+func (m *MockMigratable) Get(ctx context.Context, bucket, key string) (*Entry, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", ctx, bucket, key)
+	ret0, _ := ret[0].(*Entry)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get.
+func (mr *MockMigratableMockRecorder) Get(ctx, bucket, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockMigratable)(nil).Get), ctx, bucket, key)
+}
+
+// Import mocks base method.
+func (m *MockMigratable) Import(ctx context.Context, bucket, key string, e *Entry) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Import", ctx, bucket, key, e)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Import indicates an expected call of Import.
+func (mr *MockMigratableMockRecorder) Import(ctx, bucket, key, e interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Import", reflect.TypeOf((*MockMigratable)(nil).Import), ctx, bucket, key, e)
 }
 
 // List mocks base method.
-func (m *MockStore) List(prefix *string) ([]string, error) {
+func (m *MockMigratable) List(ctx context.Context, bucket, prefix string) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "List", prefix)
+	ret := m.ctrl.Call(m, "List", ctx, bucket, prefix)
 	ret0, _ := ret[0].([]string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // List indicates an expected call of List.
-func (mr *MockStoreMockRecorder) List(prefix interface{}) *gomock.Call {
+func (mr *MockMigratableMockRecorder) List(ctx, bucket, prefix interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockStore)(nil).List), prefix)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockMigratable)(nil).List), ctx, bucket, prefix)
 }
 
-// Set mocks base method.
-func (m *MockStore) Set(key, value string) error {
+// Put mocks base method.
+func (m *MockMigratable) Put(ctx context.Context, bucket, key string, value []byte) (*Metadata, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Set", key, value)
+	ret := m.ctrl.Call(m, "Put", ctx, bucket, key, value)
+	ret0, _ := ret[0].(*Metadata)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Put indicates an expected call of Put.
+func (mr *MockMigratableMockRecorder) Put(ctx, bucket, key, value interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockMigratable)(nil).Put), ctx, bucket, key, value)
+}
+
+// Update mocks base method.
+func (m *MockMigratable) Update(ctx context.Context, fn func(Tx) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, fn)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// Set indicates an expected call of Set.
-func (mr *MockStoreMockRecorder) Set(key, value interface{}) *gomock.Call {
+// Update indicates an expected call of Update.
+func (mr *MockMigratableMockRecorder) Update(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockMigratable)(nil).Update), ctx, fn)
+}
+
+// View mocks base method.
+func (m *MockMigratable) View(ctx context.Context, fn func(Tx) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "View", ctx, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// View indicates an expected call of View.
+func (mr *MockMigratableMockRecorder) View(ctx, fn interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "View", reflect.TypeOf((*MockMigratable)(nil).View), ctx, fn)
+}
+
+// MockSnapshotter is a mock of Snapshotter interface.
+type MockSnapshotter struct {
+	ctrl     *gomock.Controller
+	recorder *MockSnapshotterMockRecorder
+}
+
+// MockSnapshotterMockRecorder is the mock recorder for MockSnapshotter.
+type MockSnapshotterMockRecorder struct {
+	mock *MockSnapshotter
+}
+
+// NewMockSnapshotter creates a new mock instance.
+func NewMockSnapshotter(ctrl *gomock.Controller) *MockSnapshotter {
+	mock := &MockSnapshotter{ctrl: ctrl}
+	mock.recorder = &MockSnapshotterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSnapshotter) EXPECT() *MockSnapshotterMockRecorder {
+	return m.recorder
+}
+
+// Snapshot mocks base method.
+func (m *MockSnapshotter) Snapshot(w io.Writer) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Snapshot", w)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Snapshot indicates an expected call of Snapshot.
+func (mr *MockSnapshotterMockRecorder) Snapshot(w interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockStore)(nil).Set), key, value)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Snapshot", reflect.TypeOf((*MockSnapshotter)(nil).Snapshot), w)
 }
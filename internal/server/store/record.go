@@ -0,0 +1,242 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// recordMagic starts the records holding a value along with its metadata.
+// Values stored before metadata was recorded never start with a NUL byte.
+var recordMagic = []byte("\x00zr1")
+
+// encodeRecord encodes the entry as the magic, the uvarint length of the JSON metadata, the metadata and the value.
+func encodeRecord(e *Entry) ([]byte, error) {
+	m, err := json.Marshal(e.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling metadata: %w", err)
+	}
+	b := make([]byte, 0, len(recordMagic)+binary.MaxVarintLen64+len(m)+len(e.Value))
+	b = append(b, recordMagic...)
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	b = append(b, m...)
+	return append(b, e.Value...), nil
+}
+
+// decodeRecord decodes a record written by encodeRecord.
+// A value stored before metadata was recorded is decoded as version 1 with unknown times.
+func decodeRecord(b []byte) (*Entry, error) {
+	if !bytes.HasPrefix(b, recordMagic) {
+		return &Entry{Value: append([]byte{}, b...), Metadata: Metadata{Version: 1}}, nil
+	}
+	b = b[len(recordMagic):]
+	n, size := binary.Uvarint(b)
+	if size <= 0 || uint64(len(b)-size) < n {
+		return nil, fmt.Errorf("record is truncated")
+	}
+	e := &Entry{}
+	if err := json.Unmarshal(b[size:size+int(n)], &e.Metadata); err != nil {
+		return nil, fmt.Errorf("error unmarshaling metadata: %w", err)
+	}
+	e.Value = append([]byte{}, b[size+int(n):]...)
+	return e, nil
+}
+
+// rawTx reads and writes the entries of a store implementation in a transaction.
+// get returns nil if the key doesn't exist.
+type rawTx interface {
+	get(bucket, key string) (*Entry, error)
+	put(bucket, key string, e *Entry) error
+	delete(bucket, key string) error
+	list(bucket, prefix string) ([]string, error)
+	buckets() ([]string, error)
+}
+
+// engine is implemented by the store implementations so they share the versioning of recordTx.
+type engine interface {
+	// view runs fn in a read-only transaction.
+	view(ctx context.Context, fn func(rawTx) error) error
+	// update runs fn in a read-write transaction committed if fn returns nil.
+	update(ctx context.Context, fn func(rawTx) error) error
+}
+
+// recordTx implements Tx on top of a rawTx, versioning and timestamping every write.
+type recordTx struct {
+	raw     rawTx
+	creator string
+	// readOnly rejects the writes of a view
+	readOnly bool
+}
+
+func newTx(ctx context.Context, raw rawTx) *recordTx {
+	return &recordTx{raw: raw, creator: creatorOf(ctx)}
+}
+
+func (t *recordTx) Get(bucket, key string) (*Entry, error) {
+	e, err := t.raw.get(bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s from %s bucket: %w", key, bucket, err)
+	}
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s in %s bucket", ErrNotFound, key, bucket)
+	}
+	return e, nil
+}
+
+func (t *recordTx) Put(bucket, key string, value []byte) (*Metadata, error) {
+	prev, err := t.raw.get(bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s from %s bucket: %w", key, bucket, err)
+	}
+	return t.put(bucket, key, prev, value)
+}
+
+func (t *recordTx) CompareAndSwap(bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	prev, err := t.raw.get(bucket, key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s from %s bucket: %w", key, bucket, err)
+	}
+	var current uint64
+	if prev != nil {
+		current = prev.Version
+	}
+	if current != version {
+		return nil, fmt.Errorf("%w: %s in %s bucket is at version %d, expected %d", ErrConflict, key, bucket, current, version)
+	}
+	return t.put(bucket, key, prev, value)
+}
+
+// put writes the value as the version following prev.
+func (t *recordTx) put(bucket, key string, prev *Entry, value []byte) (*Metadata, error) {
+	if t.readOnly {
+		return nil, fmt.Errorf("error setting %s in %s bucket: %w", key, bucket, ErrReadOnly)
+	}
+	now := time.Now().UTC()
+	m := Metadata{Version: 1, Created: now, Updated: now, Creator: t.creator}
+	if prev != nil {
+		m = prev.Metadata
+		m.Version++
+		m.Updated = now
+	}
+	if err := t.raw.put(bucket, key, &Entry{Value: value, Metadata: m}); err != nil {
+		return nil, fmt.Errorf("error setting %s in %s bucket: %w", key, bucket, err)
+	}
+	return &m, nil
+}
+
+func (t *recordTx) Delete(bucket, key string) error {
+	if t.readOnly {
+		return fmt.Errorf("error deleting %s from %s bucket: %w", key, bucket, ErrReadOnly)
+	}
+	if err := t.raw.delete(bucket, key); err != nil {
+		return fmt.Errorf("error deleting %s from %s bucket: %w", key, bucket, err)
+	}
+	return nil
+}
+
+func (t *recordTx) List(bucket, prefix string) ([]string, error) {
+	keys, err := t.raw.list(bucket, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("error listing keys from %s bucket: %w", bucket, err)
+	}
+	return keys, nil
+}
+
+// The helpers below implement the Store methods of an engine.
+
+func get(e engine, ctx context.Context, bucket, key string) (*Entry, error) {
+	var entry *Entry
+	err := e.view(ctx, func(raw rawTx) error {
+		var err error
+		entry, err = newTx(ctx, raw).Get(bucket, key)
+		return err
+	})
+	return entry, err
+}
+
+func put(e engine, ctx context.Context, bucket, key string, value []byte) (*Metadata, error) {
+	var m *Metadata
+	err := update(e, ctx, func(tx Tx) error {
+		var err error
+		m, err = tx.Put(bucket, key, value)
+		return err
+	})
+	return m, err
+}
+
+func compareAndSwap(e engine, ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	var m *Metadata
+	err := update(e, ctx, func(tx Tx) error {
+		var err error
+		m, err = tx.CompareAndSwap(bucket, key, version, value)
+		return err
+	})
+	return m, err
+}
+
+func del(e engine, ctx context.Context, bucket, key string) error {
+	return update(e, ctx, func(tx Tx) error {
+		return tx.Delete(bucket, key)
+	})
+}
+
+func list(e engine, ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	err := e.view(ctx, func(raw rawTx) error {
+		var err error
+		keys, err = newTx(ctx, raw).List(bucket, prefix)
+		return err
+	})
+	return keys, err
+}
+
+func update(e engine, ctx context.Context, fn func(Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.update(ctx, func(raw rawTx) error {
+		return fn(newTx(ctx, raw))
+	})
+}
+
+func view(e engine, ctx context.Context, fn func(Tx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return e.view(ctx, func(raw rawTx) error {
+		tx := newTx(ctx, raw)
+		tx.readOnly = true
+		return fn(tx)
+	})
+}
+
+func buckets(e engine, ctx context.Context) ([]string, error) {
+	var names []string
+	err := e.view(ctx, func(raw rawTx) error {
+		var err error
+		names, err = raw.buckets()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing buckets: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func importEntry(e engine, ctx context.Context, bucket, key string, entry *Entry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := e.update(ctx, func(raw rawTx) error {
+		return raw.put(bucket, key, entry)
+	})
+	if err != nil {
+		return fmt.Errorf("error importing %s in %s bucket: %w", key, bucket, err)
+	}
+	return nil
+}
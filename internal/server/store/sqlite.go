@@ -0,0 +1,341 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	// registers the cgo-free sqlite driver
+	_ "modernc.org/sqlite"
+)
+
+// sqliteMigrations are applied in order to bring the schema of a SQLite store to its latest version,
+// a migration must never be edited once released, changes are appended as a new migration.
+// The primary keys index the range scans listing keys by prefix.
+var sqliteMigrations = []string{
+	// 1: keys, their versions and metadata in their own tables, the other buckets share the entries table
+	`CREATE TABLE keys (
+		key   TEXT NOT NULL PRIMARY KEY,
+		value TEXT NOT NULL
+	) WITHOUT ROWID;
+	CREATE TABLE key_versions (
+		key   TEXT NOT NULL PRIMARY KEY,
+		value TEXT NOT NULL
+	) WITHOUT ROWID;
+	CREATE TABLE key_metadata (
+		key   TEXT NOT NULL PRIMARY KEY,
+		value TEXT NOT NULL
+	) WITHOUT ROWID;
+	CREATE TABLE entries (
+		bucket TEXT NOT NULL,
+		key    TEXT NOT NULL,
+		value  TEXT NOT NULL,
+		PRIMARY KEY (bucket, key)
+	) WITHOUT ROWID;`,
+	// 2: the metadata of every value, existing values are at version 1 with unknown times
+	sqliteMetadataColumns("keys") + sqliteMetadataColumns("key_versions") +
+		sqliteMetadataColumns("key_metadata") + sqliteMetadataColumns("entries"),
+}
+
+func sqliteMetadataColumns(table string) string {
+	return `ALTER TABLE ` + table + ` ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+	ALTER TABLE ` + table + ` ADD COLUMN created TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + table + ` ADD COLUMN updated TEXT NOT NULL DEFAULT '';
+	ALTER TABLE ` + table + ` ADD COLUMN creator TEXT NOT NULL DEFAULT '';
+	`
+}
+
+// sqliteTables maps the buckets stored in their own table to it.
+var sqliteTables = map[string]string{
+	string(defaultBucket): "keys",
+	"key_versions":        "key_versions",
+	"key_metadata":        "key_metadata",
+}
+
+// SQLiteStore keeps the store in a SQLite database through a cgo-free driver
+// so it can be opened by several processes at once and queried with SQL.
+// The schema is migrated to its latest version when the store is opened.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the SQLite database at path, creating it if it doesn't exist, and migrates its schema.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	// WAL lets readers proceed while another process writes, writers wait for each other up to the busy timeout
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error opening sqlite db: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{
+		db: db,
+	}, nil
+}
+
+// migrateSQLite applies the migrations newer than the schema version recorded in schema_migrations.
+func migrateSQLite(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER NOT NULL PRIMARY KEY,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+	var version int
+	if err := tx.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", version, len(sqliteMigrations))
+	}
+	for i := version; i < len(sqliteMigrations); i++ {
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			return fmt.Errorf("error applying migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)", i+1, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return fmt.Errorf("error recording migration %d: %w", i+1, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migrations: %w", err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the latest migration applied to the database.
+func (s *SQLiteStore) SchemaVersion() (int, error) {
+	var version int
+	if err := s.db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Size returns the size of the database in bytes.
+func (s *SQLiteStore) Size() (int64, error) {
+	var size int64
+	if err := s.db.QueryRow("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(&size); err != nil {
+		return 0, fmt.Errorf("error reading db size: %w", err)
+	}
+	return size, nil
+}
+
+// Get retrieves the entry associated with the given key from the given bucket.
+func (s *SQLiteStore) Get(ctx context.Context, bucket, key string) (*Entry, error) {
+	return get(s, ctx, bucket, key)
+}
+
+// Put stores the given value and associates it with the given key in the given bucket.
+func (s *SQLiteStore) Put(ctx context.Context, bucket, key string, value []byte) (*Metadata, error) {
+	return put(s, ctx, bucket, key, value)
+}
+
+// CompareAndSwap stores the value only if the key is at the given version.
+func (s *SQLiteStore) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error) {
+	return compareAndSwap(s, ctx, bucket, key, version, value)
+}
+
+// Delete removes the value associated with the given key from the given bucket.
+func (s *SQLiteStore) Delete(ctx context.Context, bucket, key string) error {
+	return del(s, ctx, bucket, key)
+}
+
+// List returns the keys of the given bucket sorted, optionally filtered by a prefix.
+func (s *SQLiteStore) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	return list(s, ctx, bucket, prefix)
+}
+
+// Update runs fn in a transaction holding the write lock of the database.
+func (s *SQLiteStore) Update(ctx context.Context, fn func(Tx) error) error {
+	return update(s, ctx, fn)
+}
+
+// View runs fn outside of a transaction, it may read the writes committed while it runs.
+func (s *SQLiteStore) View(ctx context.Context, fn func(Tx) error) error {
+	return view(s, ctx, fn)
+}
+
+// Buckets returns the names of the buckets holding at least one key sorted.
+func (s *SQLiteStore) Buckets(ctx context.Context) ([]string, error) {
+	return buckets(s, ctx)
+}
+
+// Import stores the entry as it is, keeping its version and times.
+func (s *SQLiteStore) Import(ctx context.Context, bucket, key string, e *Entry) error {
+	return importEntry(s, ctx, bucket, key, e)
+}
+
+func (s *SQLiteStore) view(ctx context.Context, fn func(rawTx) error) error {
+	return fn(&sqliteTx{ctx: ctx, q: s.db})
+}
+
+func (s *SQLiteStore) update(ctx context.Context, fn func(rawTx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(&sqliteTx{ctx: ctx, q: tx}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// sqliteQuerier is implemented by *sql.DB and *sql.Tx.
+type sqliteQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// sqliteTx reads and writes the rows of the table of every bucket.
+type sqliteTx struct {
+	ctx context.Context
+	q   sqliteQuerier
+}
+
+// sqliteWhere returns the table of the bucket along with the condition and arguments selecting its rows.
+func sqliteWhere(bucket string) (table, cond string, args []any) {
+	if table, found := sqliteTables[bucket]; found {
+		return table, "", nil
+	}
+	return "entries", "bucket = ? AND ", []any{bucket}
+}
+
+func (t *sqliteTx) get(bucket, key string) (*Entry, error) {
+	table, cond, args := sqliteWhere(bucket)
+	var value []byte
+	var created, updated string
+	e := &Entry{}
+	err := t.q.QueryRowContext(t.ctx, "SELECT value, version, created, updated, creator FROM "+table+" WHERE "+cond+"key = ?", append(args, key)...).
+		Scan(&value, &e.Version, &created, &updated, &e.Creator)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Value = value
+	if e.Created, err = parseSQLiteTime(created); err != nil {
+		return nil, err
+	}
+	if e.Updated, err = parseSQLiteTime(updated); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (t *sqliteTx) put(bucket, key string, e *Entry) error {
+	values := []any{key, e.Value, e.Version, formatSQLiteTime(e.Created), formatSQLiteTime(e.Updated), e.Creator}
+	set := "DO UPDATE SET value = excluded.value, version = excluded.version, created = excluded.created, updated = excluded.updated, creator = excluded.creator"
+	var err error
+	if table, found := sqliteTables[bucket]; found {
+		_, err = t.q.ExecContext(t.ctx, "INSERT INTO "+table+" (key, value, version, created, updated, creator) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (key) "+set, values...)
+	} else {
+		_, err = t.q.ExecContext(t.ctx, "INSERT INTO entries (bucket, key, value, version, created, updated, creator) VALUES (?, ?, ?, ?, ?, ?, ?) ON CONFLICT (bucket, key) "+set, append([]any{bucket}, values...)...)
+	}
+	return err
+}
+
+func (t *sqliteTx) delete(bucket, key string) error {
+	table, cond, args := sqliteWhere(bucket)
+	_, err := t.q.ExecContext(t.ctx, "DELETE FROM "+table+" WHERE "+cond+"key = ?", append(args, key)...)
+	return err
+}
+
+func (t *sqliteTx) list(bucket, prefix string) ([]string, error) {
+	table, cond, args := sqliteWhere(bucket)
+	query := "SELECT key FROM " + table + " WHERE " + cond + "key >= ?"
+	args = append(args, prefix)
+	if end, bounded := prefixEnd(prefix); bounded {
+		query += " AND key < ?"
+		args = append(args, end)
+	}
+	rows, err := t.q.QueryContext(t.ctx, query+" ORDER BY key", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (t *sqliteTx) buckets() ([]string, error) {
+	var names []string
+	for bucket, table := range sqliteTables {
+		var found bool
+		if err := t.q.QueryRowContext(t.ctx, "SELECT EXISTS (SELECT 1 FROM "+table+")").Scan(&found); err != nil {
+			return nil, err
+		}
+		if found {
+			names = append(names, bucket)
+		}
+	}
+	rows, err := t.q.QueryContext(t.ctx, "SELECT DISTINCT bucket FROM entries")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var bucket string
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, err
+		}
+		names = append(names, bucket)
+	}
+	return names, rows.Err()
+}
+
+// formatSQLiteTime formats t so times sort as text, the zero time is stored as an empty string.
+func formatSQLiteTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseSQLiteTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// prefixEnd returns the smallest string greater than every string starting with prefix
+// so a prefix is matched by an indexed range scan. It returns false if there is no such string.
+func prefixEnd(prefix string) (string, bool) {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1]), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,175 @@
+package store_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/vtno/zypher/internal/server/store"
+)
+
+func newSQLiteStore(t *testing.T, path string) *store.SQLiteStore {
+	s, err := store.NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStore_GetSet(t *testing.T) {
+	t.Run("successfully sets a value with the provided key", func(t *testing.T) {
+		s := newSQLiteStore(t, filepath.Join(t.TempDir(), "test.sqlite"))
+
+		MustSet(t, s, store.DefaultBucket, "prod#somekey", "somevalue")
+		MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "somevalue")
+		MustSet(t, s, store.DefaultBucket, "prod#somekey", "newvalue")
+		MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "newvalue")
+		MustDelete(t, s, store.DefaultBucket, "prod#somekey")
+		MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "")
+	})
+}
+
+func TestSQLiteStore_List(t *testing.T) {
+	type test struct {
+		name     string
+		expected []string
+		prefix   string
+	}
+	tests := []test{
+		{
+			name:     "successfully lists all the keys in the store when prefix not provided",
+			expected: []string{"prod#key1", "prod#key2", "produce#key1", "dev#key1", "\xff#key1"},
+			prefix:   "",
+		},
+		{
+			name:     "successfully lists all the keys in the store when prefix provided",
+			expected: []string{"prod#key1", "prod#key2", "produce#key1"},
+			prefix:   "prod",
+		},
+		{
+			name:     "successfully lists the keys matching a prefix without upper bound",
+			expected: []string{"\xff#key1"},
+			prefix:   "\xff",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSQLiteStore(t, filepath.Join(t.TempDir(), "test.sqlite"))
+
+			MustSet(t, s, store.DefaultBucket, "prod#key1", "somevalue1")
+			MustSet(t, s, store.DefaultBucket, "prod#key2", "somevalue2")
+			MustSet(t, s, store.DefaultBucket, "dev#key1", "somevalue3")
+			MustSet(t, s, store.DefaultBucket, "produce#key1", "somevalue4")
+			MustSet(t, s, store.DefaultBucket, "\xff#key1", "somevalue5")
+			// keys of other buckets are not listed
+			MustSet(t, s, "key_versions", "prod#key1#1", "somevalue0")
+
+			values, err := s.List(ctx, store.DefaultBucket, tt.prefix)
+			if err != nil {
+				t.Errorf("error listing keys: %v", err)
+			}
+			if len(values) != len(tt.expected) {
+				t.Errorf("expected %d keys, got %d", len(tt.expected), len(values))
+			}
+			sort.Strings(tt.expected)
+			if !reflect.DeepEqual(values, tt.expected) {
+				t.Errorf("expected %v keys, got %v", tt.expected, values)
+			}
+		})
+	}
+}
+
+func TestSQLiteStore_ByBucket(t *testing.T) {
+	s := newSQLiteStore(t, filepath.Join(t.TempDir(), "test.sqlite"))
+
+	for _, bucket := range []string{"policies", "key_versions", "key_metadata"} {
+		t.Run(bucket, func(t *testing.T) {
+			MustGetExpected(t, s, bucket, "prod#somekey", "")
+
+			MustSet(t, s, bucket, "prod#somekey", "somevalue")
+			MustGetExpected(t, s, bucket, "prod#somekey", "somevalue")
+			MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "")
+
+			MustDelete(t, s, bucket, "prod#somekey")
+			MustGetExpected(t, s, bucket, "prod#somekey", "")
+		})
+	}
+}
+
+func TestSQLiteStore_migrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+
+	t.Run("migrates a new database to the latest schema", func(t *testing.T) {
+		s := newSQLiteStore(t, path)
+		version, err := s.SchemaVersion()
+		if err != nil || version != 2 {
+			t.Errorf("expected schema version 2, got %d, %v", version, err)
+		}
+		MustSet(t, s, store.DefaultBucket, "prod#somekey", "somevalue")
+		s.Close()
+	})
+
+	t.Run("keeps the data of a migrated database", func(t *testing.T) {
+		s := newSQLiteStore(t, path)
+		MustGetExpected(t, s, store.DefaultBucket, "prod#somekey", "somevalue")
+		// a second process can open the database while the first one holds it
+		other := newSQLiteStore(t, path)
+		MustSet(t, other, store.DefaultBucket, "prod#otherkey", "othervalue")
+		MustGetExpected(t, s, store.DefaultBucket, "prod#otherkey", "othervalue")
+		s.Close()
+		other.Close()
+	})
+
+	t.Run("adds the metadata of the values stored by schema version 1", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "v1.sqlite")
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("error opening db: %v", err)
+		}
+		for _, stmt := range []string{
+			"CREATE TABLE schema_migrations (version INTEGER NOT NULL PRIMARY KEY, applied_at TEXT NOT NULL)",
+			"INSERT INTO schema_migrations (version, applied_at) VALUES (1, '')",
+			"CREATE TABLE keys (key TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL) WITHOUT ROWID",
+			"CREATE TABLE key_versions (key TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL) WITHOUT ROWID",
+			"CREATE TABLE key_metadata (key TEXT NOT NULL PRIMARY KEY, value TEXT NOT NULL) WITHOUT ROWID",
+			"CREATE TABLE entries (bucket TEXT NOT NULL, key TEXT NOT NULL, value TEXT NOT NULL, PRIMARY KEY (bucket, key)) WITHOUT ROWID",
+			"INSERT INTO keys (key, value) VALUES ('prod#somekey', 'somevalue')",
+		} {
+			if _, err := db.Exec(stmt); err != nil {
+				t.Fatalf("error creating version 1 schema: %v", err)
+			}
+		}
+		db.Close()
+
+		s := newSQLiteStore(t, path)
+		e, err := s.Get(ctx, store.DefaultBucket, "prod#somekey")
+		if err != nil || string(e.Value) != "somevalue" || e.Version != 1 {
+			t.Fatalf("expected somevalue at version 1, got %+v, %v", e, err)
+		}
+		m, err := s.Put(ctx, store.DefaultBucket, "prod#somekey", []byte("newvalue"))
+		if err != nil || m.Version != 2 || m.Updated.IsZero() {
+			t.Errorf("expected newvalue at version 2, got %+v, %v", m, err)
+		}
+	})
+
+	t.Run("refuses a database migrated by a newer version", func(t *testing.T) {
+		db, err := sql.Open("sqlite", path)
+		if err != nil {
+			t.Fatalf("error opening db: %v", err)
+		}
+		if _, err := db.Exec("INSERT INTO schema_migrations (version, applied_at) VALUES (99, '')"); err != nil {
+			t.Fatalf("error recording migration: %v", err)
+		}
+		db.Close()
+
+		_, err = store.NewSQLiteStore(path)
+		if err == nil || !strings.Contains(err.Error(), "schema version 99") {
+			t.Errorf("expected an error about schema version 99, got %v", err)
+		}
+	})
+}
@@ -1,19 +1,99 @@
 package store
 
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// DefaultBucket holds the keys of the key server.
+const DefaultBucket = "zypher"
+
+var (
+	// ErrNotFound is returned when reading a key that doesn't exist.
+	ErrNotFound = errors.New("not found")
+	// ErrReadOnly is returned when writing within Store.View.
+	ErrReadOnly = errors.New("read-only transaction")
+	// ErrConflict is returned by CompareAndSwap when the key is not at the expected version.
+	ErrConflict = errors.New("version conflict")
+)
+
+// Metadata describes the stored value of a key.
+type Metadata struct {
+	// Version starts at 1 and is incremented every time the value is written.
+	Version uint64    `json:"version"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	// Creator is the identity that first wrote the key, see WithCreator.
+	Creator string `json:"creator,omitempty"`
+}
+
+// Entry is a stored value along with its metadata.
+type Entry struct {
+	Value []byte
+	Metadata
+}
+
 // Store is an interface for storing and retrieving key-value pairs from different store implementations.
+// Values are grouped in buckets, the keys of the key server are kept in DefaultBucket.
 type Store interface {
-	// Get retrieves the value associated with the given key.
-	Get(key string) (string, error)
-	// GetByBucket retrieves the value associated with the given key from the given bucket.
-	GetByBucket(bucket, key string) (string, error)
-	// Set stores the given value and associates it with the given key.
-	Set(key, value string) error
-	// Delete removes the value associated with the given key.
-	Delete(key string) error
-	// List returns all the keys in the store.
-	// It optionally takes a prefix to filter the keys by.
-	List(prefix *string) ([]string, error)
+	// Get retrieves the entry associated with the given key from the given bucket.
+	// ErrNotFound is returned if the key doesn't exist.
+	Get(ctx context.Context, bucket, key string) (*Entry, error)
+	// Put stores the given value and associates it with the given key in the given bucket.
+	Put(ctx context.Context, bucket, key string, value []byte) (*Metadata, error)
+	// CompareAndSwap stores the value only if the key is at the given version, zero meaning the key must not exist.
+	// ErrConflict is returned otherwise.
+	CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (*Metadata, error)
+	// Delete removes the value associated with the given key from the given bucket.
+	// Deleting a missing key is not an error.
+	Delete(ctx context.Context, bucket, key string) error
+	// List returns the keys of the given bucket sorted, optionally filtered by a prefix.
+	List(ctx context.Context, bucket, prefix string) ([]string, error)
+	// Update runs fn in a transaction committed if fn returns nil and discarded otherwise.
+	Update(ctx context.Context, fn func(Tx) error) error
+	// View runs fn in a read-only transaction, the writes of fn fail with ErrReadOnly.
+	View(ctx context.Context, fn func(Tx) error) error
 
 	// Close closes the underlying store.
 	Close() error
 }
+
+// Tx reads and writes a store within a transaction, see Store.Update and Store.View.
+// A Tx must not be used once fn has returned.
+type Tx interface {
+	Get(bucket, key string) (*Entry, error)
+	Put(bucket, key string, value []byte) (*Metadata, error)
+	CompareAndSwap(bucket, key string, version uint64, value []byte) (*Metadata, error)
+	Delete(bucket, key string) error
+	List(bucket, prefix string) ([]string, error)
+}
+
+// Migratable is implemented by stores whose buckets can be listed and entries written along with their metadata
+// so they can be migrated to another store, see Migrate.
+type Migratable interface {
+	Store
+	// Buckets returns the names of the buckets of the store sorted.
+	Buckets(ctx context.Context) ([]string, error)
+	// Import stores the entry as it is, keeping its version and times.
+	Import(ctx context.Context, bucket, key string, e *Entry) error
+}
+
+type creatorKey struct{}
+
+// WithCreator returns a context recording name as the creator of the keys written with it.
+func WithCreator(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, creatorKey{}, name)
+}
+
+func creatorOf(ctx context.Context) string {
+	name, _ := ctx.Value(creatorKey{}).(string)
+	return name
+}
+
+// Snapshotter is implemented by stores able to write a consistent snapshot of their data while serving requests.
+type Snapshotter interface {
+	// Snapshot writes a snapshot of the store to w and returns the number of bytes written.
+	Snapshot(w io.Writer) (int64, error)
+}
@@ -0,0 +1,167 @@
+package store_test
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vtno/zypher/internal/server/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.Store{
+		"bbolt": func(t *testing.T) store.Store {
+			return newChangeLogStore(t, "test.db")
+		},
+		"file": func(t *testing.T) store.Store {
+			return newFileStore(t, t.TempDir())
+		},
+		"sqlite": func(t *testing.T) store.Store {
+			return newSQLiteStore(t, filepath.Join(t.TempDir(), "test.sqlite"))
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+
+			t.Run("returns ErrNotFound for a missing key or bucket", func(t *testing.T) {
+				if _, err := s.Get(ctx, store.DefaultBucket, "missing#prd"); !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("expected ErrNotFound, got %v", err)
+				}
+				if _, err := s.Get(ctx, "missing", "missing#prd"); !errors.Is(err, store.ErrNotFound) {
+					t.Errorf("expected ErrNotFound, got %v", err)
+				}
+				if keys, err := s.List(ctx, "missing", ""); err != nil || len(keys) != 0 {
+					t.Errorf("expected no key in a missing bucket, got %v, %v", keys, err)
+				}
+			})
+
+			t.Run("versions every write and records its creator", func(t *testing.T) {
+				m1, err := s.Put(store.WithCreator(ctx, "alice"), "policies", "twitter#prd", []byte("v1"))
+				if err != nil {
+					t.Fatalf("error setting value: %v", err)
+				}
+				if m1.Version != 1 || m1.Creator != "alice" || m1.Created.IsZero() || !m1.Created.Equal(m1.Updated) {
+					t.Errorf("expected version 1 created by alice, got %+v", m1)
+				}
+				m2, err := s.Put(store.WithCreator(ctx, "bob"), "policies", "twitter#prd", []byte("v2"))
+				if err != nil {
+					t.Fatalf("error setting value: %v", err)
+				}
+				if m2.Version != 2 || m2.Creator != "alice" || !m2.Created.Equal(m1.Created) || m2.Updated.Before(m1.Updated) {
+					t.Errorf("expected version 2 keeping its creation, got %+v", m2)
+				}
+				e, err := s.Get(ctx, "policies", "twitter#prd")
+				if err != nil {
+					t.Fatalf("error getting value: %v", err)
+				}
+				if string(e.Value) != "v2" || e.Version != 2 || e.Creator != "alice" || !e.Updated.Equal(m2.Updated) {
+					t.Errorf("expected v2 along with its metadata, got %+v", e)
+				}
+			})
+
+			t.Run("compares the version before swapping", func(t *testing.T) {
+				m, err := s.CompareAndSwap(ctx, "policies", "stripe#prd", 0, []byte("v1"))
+				if err != nil || m.Version != 1 {
+					t.Fatalf("expected the key to be created at version 1, got %+v, %v", m, err)
+				}
+				if _, err := s.CompareAndSwap(ctx, "policies", "stripe#prd", 0, []byte("v1")); !errors.Is(err, store.ErrConflict) {
+					t.Errorf("expected ErrConflict creating an existing key, got %v", err)
+				}
+				if _, err := s.CompareAndSwap(ctx, "policies", "stripe#prd", 2, []byte("v2")); !errors.Is(err, store.ErrConflict) {
+					t.Errorf("expected ErrConflict swapping a stale version, got %v", err)
+				}
+				if m, err := s.CompareAndSwap(ctx, "policies", "stripe#prd", 1, []byte("v2")); err != nil || m.Version != 2 {
+					t.Errorf("expected the key to be swapped to version 2, got %+v, %v", m, err)
+				}
+				MustGetExpected(t, s, "policies", "stripe#prd", "v2")
+			})
+
+			t.Run("commits the writes of an update together", func(t *testing.T) {
+				err := s.Update(ctx, func(tx store.Tx) error {
+					if _, err := tx.Put(store.DefaultBucket, "twitter#prd", []byte("key")); err != nil {
+						return err
+					}
+					if _, err := tx.Put("key_versions", "twitter#prd#1", []byte("oldkey")); err != nil {
+						return err
+					}
+					if err := tx.Delete("policies", "stripe#prd"); err != nil {
+						return err
+					}
+					// a transaction reads its own writes
+					if e, err := tx.Get(store.DefaultBucket, "twitter#prd"); err != nil || string(e.Value) != "key" {
+						t.Errorf("expected to read the written value, got %+v, %v", e, err)
+					}
+					if keys, err := tx.List("policies", ""); err != nil || !reflect.DeepEqual(keys, []string{"twitter#prd"}) {
+						t.Errorf("expected the deleted key not to be listed, got %v, %v", keys, err)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("error updating store: %v", err)
+				}
+				MustGetExpected(t, s, store.DefaultBucket, "twitter#prd", "key")
+				MustGetExpected(t, s, "key_versions", "twitter#prd#1", "oldkey")
+				MustGetExpected(t, s, "policies", "stripe#prd", "")
+			})
+
+			t.Run("discards the writes of a failed update", func(t *testing.T) {
+				failure := errors.New("failure")
+				err := s.Update(ctx, func(tx store.Tx) error {
+					if _, err := tx.Put(store.DefaultBucket, "twitter#prd", []byte("newkey")); err != nil {
+						return err
+					}
+					if _, err := tx.CompareAndSwap(store.DefaultBucket, "stripe#prd", 0, []byte("key")); err != nil {
+						return err
+					}
+					return failure
+				})
+				if !errors.Is(err, failure) {
+					t.Errorf("expected the error of the update, got %v", err)
+				}
+				MustGetExpected(t, s, store.DefaultBucket, "twitter#prd", "key")
+				MustGetExpected(t, s, store.DefaultBucket, "stripe#prd", "")
+			})
+
+			t.Run("rejects the writes of a view", func(t *testing.T) {
+				err := s.View(ctx, func(tx store.Tx) error {
+					if e, err := tx.Get(store.DefaultBucket, "twitter#prd"); err != nil || string(e.Value) != "key" {
+						t.Errorf("expected to read the stored value, got %+v, %v", e, err)
+					}
+					if _, err := tx.Put(store.DefaultBucket, "twitter#prd", []byte("newkey")); !errors.Is(err, store.ErrReadOnly) {
+						t.Errorf("expected ErrReadOnly putting a key, got %v", err)
+					}
+					if err := tx.Delete(store.DefaultBucket, "twitter#prd"); !errors.Is(err, store.ErrReadOnly) {
+						t.Errorf("expected ErrReadOnly deleting a key, got %v", err)
+					}
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("error viewing store: %v", err)
+				}
+				MustGetExpected(t, s, store.DefaultBucket, "twitter#prd", "key")
+			})
+		})
+	}
+}
+
+func TestBBoltStore_legacyValues(t *testing.T) {
+	s := newChangeLogStore(t, "test.db")
+	// values written before metadata was recorded
+	err := s.DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(store.DefaultBucket)).Put([]byte("twitter#prd"), []byte("oldkey"))
+	})
+	if err != nil {
+		t.Fatalf("error writing legacy value: %v", err)
+	}
+	e, err := s.Get(ctx, store.DefaultBucket, "twitter#prd")
+	if err != nil || string(e.Value) != "oldkey" || e.Version != 1 || !e.Created.IsZero() {
+		t.Errorf("expected oldkey at version 1 with an unknown creation time, got %+v, %v", e, err)
+	}
+	if _, err := s.CompareAndSwap(ctx, store.DefaultBucket, "twitter#prd", 1, []byte("newkey")); err != nil {
+		t.Errorf("error swapping a legacy value: %v", err)
+	}
+	MustGetExpected(t, s, store.DefaultBucket, "twitter#prd", "newkey")
+}
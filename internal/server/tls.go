@@ -0,0 +1,164 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSReloader holds the server certificate and the client CA pool loaded from files
+// and hands them out to new TLS connections so they can be reloaded without restarting the server.
+type TLSReloader struct {
+	certPath     string
+	keyPath      string
+	clientCAPath string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// NewTLSReloader loads the certificate, key and optional client CA bundle.
+// When clientCAPath is not empty clients are required to present a certificate issued by one of the CAs.
+func NewTLSReloader(certPath, keyPath, clientCAPath string) (*TLSReloader, error) {
+	r := &TLSReloader{
+		certPath:     certPath,
+		keyPath:      keyPath,
+		clientCAPath: clientCAPath,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewSelfSignedTLSReloader returns a TLSReloader serving a certificate generated in memory.
+// It is meant for development only, Reload is a no-op.
+func NewSelfSignedTLSReloader(clientCAPath string) (*TLSReloader, error) {
+	cert, err := GenerateSelfSignedCert()
+	if err != nil {
+		return nil, err
+	}
+	r := &TLSReloader{
+		clientCAPath: clientCAPath,
+		cert:         cert,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the certificate, key and client CA files again.
+// The previously loaded files keep being served if any of them fails to load.
+func (r *TLSReloader) Reload() error {
+	var cert *tls.Certificate
+	if r.certPath != "" {
+		c, err := tls.LoadX509KeyPair(r.certPath, r.keyPath)
+		if err != nil {
+			return fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		cert = &c
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAPath != "" {
+		pem, err := os.ReadFile(r.clientCAPath)
+		if err != nil {
+			return fmt.Errorf("error reading client CA at %s: %w", r.clientCAPath, err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no client CA certificate found in %s", r.clientCAPath)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cert != nil {
+		r.cert = cert
+	}
+	r.clientCAs = clientCAs
+	return nil
+}
+
+// Certificate returns the certificate currently served.
+func (r *TLSReloader) Certificate() *tls.Certificate {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert
+}
+
+// Config returns a tls.Config resolving the certificate and client CAs on every handshake.
+func (r *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.Certificate(), nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// GenerateSelfSignedCert generates an ECDSA P-256 certificate for localhost valid for a year.
+func GenerateSelfSignedCert() (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("error generating TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("error generating certificate serial: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	dnsNames := []string{"localhost"}
+	if hostname != "" && hostname != "localhost" {
+		dnsNames = append(dnsNames, hostname)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "zypher self-signed"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              dnsNames,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating self-signed certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing self-signed certificate: %w", err)
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
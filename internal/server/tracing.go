@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/store"
+	"github.com/vtno/zypher/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracing records spans of every request, its authentication, key handler and store calls
+// with the global tracer provider installed by tracing.Setup.
+// Requests continue the trace of their W3C traceparent header.
+func WithTracing() ServerOption {
+	return func(s *Server) {
+		s.tracing = true
+	}
+}
+
+// traced starts a server span of every request named by the route pattern of the mux.
+func (s *Server) traced(mux *http.ServeMux, next http.Handler) http.Handler {
+	if !s.tracing {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "other"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPMethod(r.Method), semconv.HTTPRoute(route)),
+		)
+		defer span.End()
+
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sr, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCode(sr.status))
+		if sr.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sr.status))
+		}
+	})
+}
+
+// span records a span named name around the handler when tracing is enabled.
+func (s *Server) span(name string, h http.HandlerFunc) http.HandlerFunc {
+	if !s.tracing {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Tracer().Start(r.Context(), name)
+		defer span.End()
+		h(w, r.WithContext(ctx))
+	}
+}
+
+// tracedGuard records a span of every authentication.
+type tracedGuard struct {
+	guard AuthGuard
+}
+
+func (g *tracedGuard) Authenticate(r *http.Request) (*auth.Identity, error) {
+	_, span := tracing.Tracer().Start(r.Context(), "AuthGuard.Authenticate")
+	defer span.End()
+	identity, err := g.guard.Authenticate(r)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, auth.FailureReason(err))
+		return nil, err
+	}
+	span.SetAttributes(attribute.String("zypher.identity", identity.Name))
+	return identity, nil
+}
+
+// tracedStore records a span of every store call as a child of the span of its context.
+type tracedStore struct {
+	store store.Store
+}
+
+func (s *tracedStore) start(ctx context.Context, operation, bucket string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(ctx, "Store."+operation, trace.WithAttributes(attribute.String("zypher.bucket", bucket)))
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *tracedStore) Get(ctx context.Context, bucket, key string) (e *store.Entry, err error) {
+	ctx, span := s.start(ctx, "Get", bucket)
+	defer func() { end(span, err) }()
+	return s.store.Get(ctx, bucket, key)
+}
+
+func (s *tracedStore) Put(ctx context.Context, bucket, key string, value []byte) (m *store.Metadata, err error) {
+	ctx, span := s.start(ctx, "Put", bucket)
+	defer func() { end(span, err) }()
+	return s.store.Put(ctx, bucket, key, value)
+}
+
+func (s *tracedStore) CompareAndSwap(ctx context.Context, bucket, key string, version uint64, value []byte) (m *store.Metadata, err error) {
+	ctx, span := s.start(ctx, "CompareAndSwap", bucket)
+	defer func() { end(span, err) }()
+	return s.store.CompareAndSwap(ctx, bucket, key, version, value)
+}
+
+func (s *tracedStore) Delete(ctx context.Context, bucket, key string) (err error) {
+	ctx, span := s.start(ctx, "Delete", bucket)
+	defer func() { end(span, err) }()
+	return s.store.Delete(ctx, bucket, key)
+}
+
+func (s *tracedStore) List(ctx context.Context, bucket, prefix string) (keys []string, err error) {
+	ctx, span := s.start(ctx, "List", bucket)
+	defer func() { end(span, err) }()
+	return s.store.List(ctx, bucket, prefix)
+}
+
+func (s *tracedStore) Update(ctx context.Context, fn func(store.Tx) error) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Store.Update")
+	defer func() { end(span, err) }()
+	return s.store.Update(ctx, fn)
+}
+
+func (s *tracedStore) View(ctx context.Context, fn func(store.Tx) error) (err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "Store.View")
+	defer func() { end(span, err) }()
+	return s.store.View(ctx, fn)
+}
+
+func (s *tracedStore) Close() error {
+	return s.store.Close()
+}
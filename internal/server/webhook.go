@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/vtno/zypher/internal/server/auth"
+	"github.com/vtno/zypher/internal/server/keyring"
+	"github.com/vtno/zypher/internal/server/webhook"
+)
+
+const defaultWebhookSecretPath = "zypher-webhook.key"
+
+// WithWebhooks notifies the dispatcher of every change of a key and of failed authentications,
+// and serves GET /sys/webhooks/deliveries to admins.
+func WithWebhooks(d *webhook.Dispatcher) ServerOption {
+	return func(s *Server) {
+		s.webhooks = d
+	}
+}
+
+// notifyKeyChange is the keyring listener turning key changes into webhook events, policy changes are not delivered.
+func (s *Server) notifyKeyChange(ctx context.Context, c keyring.Change) {
+	if !c.Type.KeyChange() {
+		return
+	}
+	e := webhook.Event{
+		Type:    "key." + string(c.Type),
+		Path:    c.Path,
+		Version: c.Version,
+	}
+	if identity, ok := ctx.Value("identity").(*auth.Identity); ok && identity != nil {
+		e.Identity = identity.Name
+	}
+	s.webhooks.Notify(e)
+}
+
+// notifiedGuard reports the failed authentications of the guard to the webhook dispatcher.
+type notifiedGuard struct {
+	guard    AuthGuard
+	webhooks *webhook.Dispatcher
+}
+
+func (g *notifiedGuard) Authenticate(r *http.Request) (*auth.Identity, error) {
+	identity, err := g.guard.Authenticate(r)
+	if err != nil {
+		g.webhooks.AuthFailure(sourceIP(r))
+	}
+	return identity, err
+}
@@ -0,0 +1,56 @@
+package webhook
+
+import (
+	"sync"
+	"time"
+)
+
+// burstDetector counts events by source and reports a burst once threshold events happened within a window,
+// at most once per window.
+type burstDetector struct {
+	threshold int
+	window    time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*burstWindow
+}
+
+type burstWindow struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+func newBurstDetector(threshold int, window time.Duration) *burstDetector {
+	return &burstDetector{
+		threshold: threshold,
+		window:    window,
+		windows:   make(map[string]*burstWindow),
+	}
+}
+
+// record counts an event of the source and returns the number of events of its window and true on a burst.
+func (b *burstDetector) record(source string, now time.Time) (int, bool) {
+	if b.threshold <= 0 {
+		return 0, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w, found := b.windows[source]
+	if !found || now.Sub(w.start) >= b.window {
+		// windows of quiet sources are dropped so the map does not grow with every source seen
+		for s, w := range b.windows {
+			if now.Sub(w.start) >= b.window {
+				delete(b.windows, s)
+			}
+		}
+		w = &burstWindow{start: now}
+		b.windows[source] = w
+	}
+	w.count++
+	if w.count < b.threshold || w.reported {
+		return w.count, false
+	}
+	w.reported = true
+	return w.count, true
+}
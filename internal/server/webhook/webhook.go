@@ -0,0 +1,419 @@
+// Package webhook notifies HTTP endpoints of key server events with HMAC signed JSON payloads.
+//
+// Every payload is POSTed with the X-Zypher-Signature header set to sha256=<hex HMAC-SHA256 of
+// "<X-Zypher-Timestamp>.<body>">, keyed by the webhook secret. Failed deliveries are retried with backoff
+// and recorded in a dead-letter bucket of the store once every attempt failed, only the most recent
+// MaxDeadLetters being kept.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/store"
+	"go.uber.org/zap"
+)
+
+const (
+	SignatureHeader = "X-Zypher-Signature"
+	TimestampHeader = "X-Zypher-Timestamp"
+	EventHeader     = "X-Zypher-Event"
+	DeliveryHeader  = "X-Zypher-Delivery"
+
+	// DefaultRetries is the number of times a failed delivery is retried by default.
+	DefaultRetries = 5
+	// DefaultBackoff is the delay before the first retry by default, it doubles on every retry.
+	DefaultBackoff = time.Second
+	// DefaultAuthFailureBurst is the number of failed authentications from a source IP within
+	// DefaultAuthFailureWindow notified as a burst by default.
+	DefaultAuthFailureBurst  = 10
+	DefaultAuthFailureWindow = time.Minute
+	// MaxDeadLetters is the number of dead letters kept in the store, the oldest are deleted first.
+	MaxDeadLetters = 1000
+
+	// deadLettersBucket holds the deliveries that failed every attempt by time and ID
+	deadLettersBucket = "webhook_dead_letters"
+	// recentDeliveries is the number of deliveries kept in memory for inspection
+	recentDeliveries = 100
+	maxBackoff       = 5 * time.Minute
+	// postTimeout bounds an attempt, which is cancelled earlier when the dispatcher stops
+	postTimeout = 10 * time.Second
+)
+
+// Event types.
+const (
+	KeyCreated       = "key.created"
+	KeyUpdated       = "key.updated"
+	KeyRotated       = "key.rotated"
+	KeyDeleted       = "key.deleted"
+	KeyExpired       = "key.expired"
+	AuthFailureBurst = "auth.failure_burst"
+)
+
+// Event is the JSON payload of a webhook.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	// Path and Version identify the changed key.
+	Path    string `json:"path,omitempty"`
+	Version int    `json:"version,omitempty"`
+	// Identity made the change, it is empty for automatic rotations.
+	Identity string `json:"identity,omitempty"`
+	// SourceIP and Failures describe a burst of failed authentications.
+	SourceIP string `json:"source_ip,omitempty"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusDead      = "dead"
+)
+
+// Delivery is the delivery of an event to a webhook URL.
+type Delivery struct {
+	ID       string `json:"id"`
+	URL      string `json:"url"`
+	Event    Event  `json:"event"`
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// StatusCode and Error describe the last attempt.
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Dispatcher delivers events to webhook URLs in background.
+type Dispatcher struct {
+	urls       []string
+	secret     []byte
+	store      store.Store
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	events     map[string]bool
+	prefix     string
+	bursts     *burstDetector
+	logger     *zap.Logger
+	now        func() time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopMu keeps Notify from starting deliveries once Stop waits for them
+	stopMu sync.Mutex
+	wg     sync.WaitGroup
+
+	mu     sync.Mutex
+	recent []*Delivery
+}
+
+type DispatcherOption func(*Dispatcher)
+
+// WithHTTPClient sets the http.Client delivering the events.
+func WithHTTPClient(c *http.Client) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.httpClient = c
+	}
+}
+
+// WithRetries retries failed deliveries up to retries times, the delay between attempts starting at backoff
+// and doubling on every attempt up to 5m.
+func WithRetries(retries int, backoff time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.retries = retries
+		d.backoff = backoff
+	}
+}
+
+// WithEvents only delivers events of the types, every event is delivered by default.
+func WithEvents(types ...string) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.events = make(map[string]bool, len(types))
+		for _, t := range types {
+			d.events[t] = true
+		}
+	}
+}
+
+// WithPrefix only delivers the events of keys whose path starts with prefix e.g. /prod/.
+func WithPrefix(prefix string) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.prefix = prefix
+	}
+}
+
+// WithLogger logs the deliveries that cannot be recorded with the logger.
+func WithLogger(l *zap.Logger) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.logger = l
+	}
+}
+
+// WithAuthFailureBurst notifies a burst once threshold authentications failed from a source IP within window.
+func WithAuthFailureBurst(threshold int, window time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.bursts = newBurstDetector(threshold, window)
+	}
+}
+
+// NewDispatcher returns a Dispatcher delivering events to the urls signed with the secret.
+// Deliveries failing every attempt are recorded in the store.
+func NewDispatcher(urls []string, secret []byte, s store.Store, opts ...DispatcherOption) *Dispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		urls:       urls,
+		secret:     secret,
+		store:      s,
+		httpClient: http.DefaultClient,
+		retries:    DefaultRetries,
+		backoff:    DefaultBackoff,
+		bursts:     newBurstDetector(DefaultAuthFailureBurst, DefaultAuthFailureWindow),
+		logger:     zap.NewNop(),
+		now:        time.Now,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Notify delivers the event in background unless it is filtered out or the dispatcher is stopped.
+// The ID and time of the event are set when empty.
+func (d *Dispatcher) Notify(e Event) {
+	if d.events != nil && !d.events[e.Type] {
+		return
+	}
+	if e.Path != "" && !strings.HasPrefix(e.Path, d.prefix) {
+		return
+	}
+	if e.ID == "" {
+		e.ID = newID()
+	}
+	if e.Time.IsZero() {
+		e.Time = d.now().UTC()
+	}
+	d.stopMu.Lock()
+	defer d.stopMu.Unlock()
+	if d.ctx.Err() != nil {
+		return
+	}
+	for _, u := range d.urls {
+		dl := &Delivery{
+			ID:      newID(),
+			URL:     u,
+			Event:   e,
+			Status:  StatusPending,
+			Updated: d.now().UTC(),
+		}
+		d.remember(dl)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.deliver(dl)
+		}()
+	}
+}
+
+// AuthFailure records a failed authentication from the source IP and notifies a burst of them.
+func (d *Dispatcher) AuthFailure(sourceIP string) {
+	if failures, burst := d.bursts.record(sourceIP, d.now()); burst {
+		d.Notify(Event{Type: AuthFailureBurst, SourceIP: sourceIP, Failures: failures})
+	}
+}
+
+// Stop cancels the requests and retries of pending deliveries, recording them as dead letters, and waits for them.
+// The events notified afterwards are dropped.
+func (d *Dispatcher) Stop() {
+	d.stopMu.Lock()
+	d.cancel()
+	d.stopMu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *Dispatcher) deliver(dl *Delivery) {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		d.update(dl, StatusDead, 0, 0, fmt.Errorf("error marshaling event: %w", err))
+		return
+	}
+	backoff := d.backoff
+	for attempt := 0; ; attempt++ {
+		statusCode, err := d.post(dl, body)
+		if err == nil {
+			d.update(dl, StatusDelivered, attempt+1, statusCode, nil)
+			return
+		}
+		if attempt >= d.retries {
+			d.update(dl, StatusDead, attempt+1, statusCode, err)
+			return
+		}
+		d.update(dl, StatusPending, attempt+1, statusCode, err)
+		select {
+		case <-d.ctx.Done():
+			d.update(dl, StatusDead, attempt+1, statusCode, fmt.Errorf("stopped before delivery: %w", err))
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post sends the event once and returns the status code of the response.
+func (d *Dispatcher) post(dl *Delivery, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(d.ctx, postTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event.Type)
+	req.Header.Set(DeliveryHeader, dl.ID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(d.secret, timestamp, body))
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error sending event: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// update records the result of an attempt, a dead delivery is written to the dead-letter bucket.
+func (d *Dispatcher) update(dl *Delivery, status string, attempts, statusCode int, err error) {
+	d.mu.Lock()
+	dl.Attempts = attempts
+	dl.Status = status
+	dl.StatusCode = statusCode
+	dl.Error = ""
+	if err != nil {
+		dl.Error = err.Error()
+	}
+	dl.Updated = d.now().UTC()
+	snapshot := *dl
+	d.mu.Unlock()
+
+	if status != StatusDead {
+		return
+	}
+	if err := d.recordDeadLetter(snapshot); err != nil {
+		d.logger.Error("error recording webhook dead letter",
+			zap.String("delivery", snapshot.ID),
+			zap.String("url", snapshot.URL),
+			zap.String("event", snapshot.Event.Type),
+			zap.String("path", snapshot.Event.Path),
+			zap.Error(err),
+		)
+	}
+}
+
+// recordDeadLetter writes the delivery to the dead-letter bucket and deletes the oldest dead letters
+// beyond MaxDeadLetters.
+func (d *Dispatcher) recordDeadLetter(dl Delivery) error {
+	b, err := json.Marshal(dl)
+	if err != nil {
+		return fmt.Errorf("error marshaling dead letter: %w", err)
+	}
+	key := fmt.Sprintf("%020d-%s", dl.Updated.UnixNano(), dl.ID)
+	return d.store.Update(context.Background(), func(tx store.Tx) error {
+		if _, err := tx.Put(deadLettersBucket, key, b); err != nil {
+			return err
+		}
+		keys, err := tx.List(deadLettersBucket, "")
+		if err != nil {
+			return err
+		}
+		// keys are sorted by time
+		for len(keys) > MaxDeadLetters {
+			if err := tx.Delete(deadLettersBucket, keys[0]); err != nil {
+				return err
+			}
+			keys = keys[1:]
+		}
+		return nil
+	})
+}
+
+// remember keeps the delivery among the most recent ones.
+func (d *Dispatcher) remember(dl *Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recent = append(d.recent, dl)
+	if len(d.recent) > recentDeliveries {
+		d.recent = d.recent[len(d.recent)-recentDeliveries:]
+	}
+}
+
+// Recent returns the most recent deliveries since the server started, the oldest first.
+func (d *Dispatcher) Recent() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	deliveries := make([]Delivery, 0, len(d.recent))
+	for _, dl := range d.recent {
+		deliveries = append(deliveries, *dl)
+	}
+	return deliveries
+}
+
+// DeadLetters returns the deliveries that failed every attempt, the oldest first.
+func (d *Dispatcher) DeadLetters(ctx context.Context) ([]Delivery, error) {
+	keys, err := d.store.List(ctx, deadLettersBucket, "")
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, 0, len(keys))
+	for _, k := range keys {
+		e, err := d.store.Get(ctx, deadLettersBucket, k)
+		if err != nil {
+			return nil, err
+		}
+		var dl Delivery
+		if err := json.Unmarshal(e.Value, &dl); err != nil {
+			return nil, fmt.Errorf("error unmarshaling dead letter %s: %w", k, err)
+		}
+		deliveries = append(deliveries, dl)
+	}
+	return deliveries, nil
+}
+
+// Sign returns the signature of a payload sent at the unix timestamp.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns true if the signature of the payload sent at the unix timestamp is valid.
+func Verify(secret []byte, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
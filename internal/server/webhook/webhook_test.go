@@ -0,0 +1,279 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vtno/zypher/internal/server/store"
+	"github.com/vtno/zypher/internal/server/webhook"
+)
+
+var secret = []byte("0123456789abcdef")
+
+// receiver records the events it receives, failing the first failures requests with 500.
+type receiver struct {
+	t        *testing.T
+	failures int32
+	requests atomic.Int32
+
+	mu     sync.Mutex
+	events []webhook.Event
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rc.requests.Add(1) <= rc.failures {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("error reading body: %v", err)
+	}
+	if !webhook.Verify(secret, r.Header.Get(webhook.TimestampHeader), body, r.Header.Get(webhook.SignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var e webhook.Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		rc.t.Errorf("error unmarshaling event: %v", err)
+	}
+	if r.Header.Get(webhook.EventHeader) != e.Type {
+		rc.t.Errorf("expected the %s header to be %s, got %s", webhook.EventHeader, e.Type, r.Header.Get(webhook.EventHeader))
+	}
+	rc.mu.Lock()
+	rc.events = append(rc.events, e)
+	rc.mu.Unlock()
+}
+
+func (rc *receiver) received() []webhook.Event {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]webhook.Event(nil), rc.events...)
+}
+
+func newStore(t *testing.T) store.Store {
+	s, err := store.NewBBoltStore(filepath.Join(t.TempDir(), "zypher.db"))
+	if err != nil {
+		t.Fatalf("error creating store: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// settle waits for every delivery of the dispatcher to succeed or fail its last attempt.
+func settle(t *testing.T, d *webhook.Dispatcher) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pending := false
+		for _, dl := range d.Recent() {
+			pending = pending || dl.Status == webhook.StatusPending
+		}
+		if !pending {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected the deliveries to settle, got %+v", d.Recent())
+}
+
+func TestDispatcher(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delivers signed events after retrying failures", func(t *testing.T) {
+		rc := &receiver{t: t, failures: 2}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t), webhook.WithRetries(3, time.Millisecond))
+
+		d.Notify(webhook.Event{Type: webhook.KeyRotated, Path: "/payments/stripe/prd", Version: 2})
+		settle(t, d)
+		d.Stop()
+
+		events := rc.received()
+		if len(events) != 1 || events[0].Type != webhook.KeyRotated || events[0].Path != "/payments/stripe/prd" || events[0].ID == "" {
+			t.Fatalf("expected the rotation to be received, got %+v", events)
+		}
+		deliveries := d.Recent()
+		if len(deliveries) != 1 || deliveries[0].Status != webhook.StatusDelivered || deliveries[0].Attempts != 3 {
+			t.Errorf("expected a delivery after 3 attempts, got %+v", deliveries)
+		}
+	})
+
+	t.Run("records a dead letter once every attempt failed", func(t *testing.T) {
+		rc := &receiver{t: t, failures: 100}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t), webhook.WithRetries(2, time.Millisecond))
+
+		d.Notify(webhook.Event{Type: webhook.KeyDeleted, Path: "/twitter/prd", Version: 1})
+		settle(t, d)
+		d.Stop()
+
+		dead, err := d.DeadLetters(ctx)
+		if err != nil {
+			t.Fatalf("error listing dead letters: %v", err)
+		}
+		if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].StatusCode != http.StatusInternalServerError || dead[0].Event.Type != webhook.KeyDeleted {
+			t.Errorf("expected a dead letter after 3 attempts, got %+v", dead)
+		}
+	})
+
+	t.Run("records pending deliveries as dead letters when stopped", func(t *testing.T) {
+		rc := &receiver{t: t, failures: 100}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t), webhook.WithRetries(5, time.Hour))
+
+		d.Notify(webhook.Event{Type: webhook.KeyCreated, Path: "/twitter/prd", Version: 1})
+		for rc.requests.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		d.Stop()
+
+		dead, err := d.DeadLetters(ctx)
+		if err != nil {
+			t.Fatalf("error listing dead letters: %v", err)
+		}
+		if len(dead) != 1 || dead[0].Status != webhook.StatusDead || dead[0].Event.Type != webhook.KeyCreated {
+			t.Errorf("expected the pending delivery to be a dead letter, got %+v", dead)
+		}
+	})
+
+	t.Run("keeps the most recent dead letters only", func(t *testing.T) {
+		rc := &receiver{t: t, failures: 100}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		s := newStore(t)
+		err := s.Update(ctx, func(tx store.Tx) error {
+			for i := 0; i < webhook.MaxDeadLetters; i++ {
+				b, err := json.Marshal(webhook.Delivery{ID: fmt.Sprintf("old-%d", i), Status: webhook.StatusDead})
+				if err != nil {
+					return err
+				}
+				if _, err := tx.Put("webhook_dead_letters", fmt.Sprintf("%020d-old-%d", i, i), b); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("error recording dead letters: %v", err)
+		}
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, s, webhook.WithRetries(0, time.Millisecond))
+
+		d.Notify(webhook.Event{Type: webhook.KeyDeleted, Path: "/twitter/prd", Version: 1})
+		settle(t, d)
+		d.Stop()
+
+		dead, err := d.DeadLetters(ctx)
+		if err != nil {
+			t.Fatalf("error listing dead letters: %v", err)
+		}
+		if len(dead) != webhook.MaxDeadLetters || dead[0].ID != "old-1" || dead[len(dead)-1].Event.Type != webhook.KeyDeleted {
+			t.Errorf("expected the oldest dead letter to be deleted, got %d dead letters from %s to %+v", len(dead), dead[0].ID, dead[len(dead)-1])
+		}
+	})
+
+	t.Run("cancels the pending request when stopped", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		}))
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t), webhook.WithRetries(0, time.Millisecond))
+
+		d.Notify(webhook.Event{Type: webhook.KeyCreated, Path: "/twitter/prd", Version: 1})
+		start := time.Now()
+		d.Stop()
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("expected the request to be cancelled, stopped after %s", elapsed)
+		}
+		dead, err := d.DeadLetters(ctx)
+		if err != nil {
+			t.Fatalf("error listing dead letters: %v", err)
+		}
+		if len(dead) != 1 || dead[0].Event.Type != webhook.KeyCreated {
+			t.Errorf("expected the cancelled delivery to be a dead letter, got %+v", dead)
+		}
+	})
+
+	t.Run("drops the events notified once stopped", func(t *testing.T) {
+		rc := &receiver{t: t}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t))
+
+		d.Stop()
+		d.Notify(webhook.Event{Type: webhook.KeyCreated, Path: "/twitter/prd", Version: 1})
+
+		if deliveries := d.Recent(); len(deliveries) != 0 {
+			t.Errorf("expected no delivery once stopped, got %+v", deliveries)
+		}
+		if events := rc.received(); len(events) != 0 {
+			t.Errorf("expected no event to be received once stopped, got %+v", events)
+		}
+	})
+
+	t.Run("filters events by type and path prefix", func(t *testing.T) {
+		rc := &receiver{t: t}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t),
+			webhook.WithEvents(webhook.KeyRotated, webhook.KeyDeleted, webhook.AuthFailureBurst),
+			webhook.WithPrefix("/prd/"),
+		)
+
+		d.Notify(webhook.Event{Type: webhook.KeyRotated, Path: "/stg/twitter"})
+		d.Notify(webhook.Event{Type: webhook.KeyCreated, Path: "/prd/twitter"})
+		d.Notify(webhook.Event{Type: webhook.KeyDeleted, Path: "/prd/twitter"})
+		settle(t, d)
+		d.Stop()
+
+		if events := rc.received(); len(events) != 1 || events[0].Type != webhook.KeyDeleted {
+			t.Errorf("expected the deletion of /prd/twitter only, got %+v", events)
+		}
+	})
+
+	t.Run("notifies bursts of failed authentications once per window", func(t *testing.T) {
+		rc := &receiver{t: t}
+		srv := httptest.NewServer(rc)
+		defer srv.Close()
+		d := webhook.NewDispatcher([]string{srv.URL}, secret, newStore(t), webhook.WithAuthFailureBurst(3, time.Minute))
+
+		for i := 0; i < 10; i++ {
+			d.AuthFailure("10.0.0.1")
+		}
+		d.AuthFailure("10.0.0.2")
+		settle(t, d)
+		d.Stop()
+
+		events := rc.received()
+		if len(events) != 1 || events[0].Type != webhook.AuthFailureBurst || events[0].SourceIP != "10.0.0.1" || events[0].Failures != 3 {
+			t.Errorf("expected a single burst from 10.0.0.1, got %+v", events)
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"key.rotated"}`)
+	signature := webhook.Sign(secret, "1700000000", body)
+	if !webhook.Verify(secret, "1700000000", body, signature) {
+		t.Errorf("expected the signature to be valid")
+	}
+	if webhook.Verify(secret, "1700000001", body, signature) {
+		t.Errorf("expected the signature of another timestamp to be invalid")
+	}
+	if webhook.Verify([]byte("another secret"), "1700000000", body, signature) {
+		t.Errorf("expected the signature of another secret to be invalid")
+	}
+}
@@ -0,0 +1,106 @@
+// Package tracing exports OpenTelemetry traces of zypher commands and the key server.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/vtno/zypher"
+
+// Tracer returns the tracer of the globally installed provider.
+// Spans are dropped until Setup is called.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Setup installs a global tracer provider exporting spans of the service to the exporter
+// and propagates the trace context in W3C traceparent headers.
+// The exporter is one of:
+//   - stdout or stderr: spans are printed as JSON
+//   - file://<path>: spans are appended as JSON to the file
+//   - http(s)://<host:port>: spans are sent to an OTLP/HTTP collector
+//
+// The returned func flushes the remaining spans and stops the exporter.
+func Setup(ctx context.Context, service, exporter string) (func(context.Context) error, error) {
+	exp, closer, err := newExporter(ctx, exporter)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("error creating tracing resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func(ctx context.Context) error {
+		if err := tp.Shutdown(ctx); err != nil {
+			return fmt.Errorf("error shutting down tracer provider: %w", err)
+		}
+		if closer != nil {
+			return closer.Close()
+		}
+		return nil
+	}, nil
+}
+
+func newExporter(ctx context.Context, exporter string) (sdktrace.SpanExporter, io.Closer, error) {
+	switch {
+	case exporter == "stdout", exporter == "stderr":
+		w := os.Stdout
+		if exporter == "stderr" {
+			w = os.Stderr
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating %s exporter: %w", exporter, err)
+		}
+		return exp, nil, nil
+	case strings.HasPrefix(exporter, "file://"):
+		path := strings.TrimPrefix(exporter, "file://")
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error opening trace file at %s: %w", path, err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("error creating file exporter: %w", err)
+		}
+		return exp, f, nil
+	case strings.HasPrefix(exporter, "http://"), strings.HasPrefix(exporter, "https://"):
+		u, err := url.Parse(exporter)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing collector url %s: %w", exporter, err)
+		}
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if u.Path != "" && u.Path != "/" {
+			opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+		}
+		exp, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating OTLP exporter: %w", err)
+		}
+		return exp, nil, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q, expected stdout, stderr, file://<path> or an OTLP/HTTP collector url", exporter)
+	}
+}
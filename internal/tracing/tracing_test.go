@@ -0,0 +1,51 @@
+package tracing_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vtno/zypher/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+	defer func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	}()
+
+	t.Run("should export spans to a file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "traces.json")
+		shutdown, err := tracing.Setup(ctx, "zypher-test", "file://"+path)
+		if err != nil {
+			t.Fatalf("error setting up tracing: %v", err)
+		}
+		_, span := tracing.Tracer().Start(ctx, "GET /key")
+		span.End()
+		if err := shutdown(ctx); err != nil {
+			t.Fatalf("error shutting down tracing: %v", err)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("error reading traces: %v", err)
+		}
+		for _, expected := range []string{`"Name":"GET /key"`, `"Value":"zypher-test"`} {
+			if !strings.Contains(string(b), expected) {
+				t.Errorf("expected traces to contain %s, got %s", expected, b)
+			}
+		}
+	})
+
+	t.Run("should reject unknown exporters", func(t *testing.T) {
+		if _, err := tracing.Setup(ctx, "zypher-test", "jaeger"); err == nil {
+			t.Errorf("expected an error for an unknown exporter")
+		}
+	})
+}
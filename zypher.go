@@ -45,6 +45,12 @@ func NewCipher(key string) *Cipher {
 
 // Encrypt encrypts the provided plaintext and returns the ciphertext or err.
 func (c *Cipher) Encrypt(plaintext []byte) (ciphertext []byte, err error) {
+	return c.EncryptWithAD(plaintext, nil)
+}
+
+// EncryptWithAD encrypts the provided plaintext authenticating the additional data along with it,
+// the ciphertext is only decrypted with the same additional data.
+func (c *Cipher) EncryptWithAD(plaintext, additionalData []byte) (ciphertext []byte, err error) {
 	ci, err := aes.NewCipher(c.key)
 	if err != nil {
 		return nil, fmt.Errorf("error creating aes.Cipher: %w", err)
@@ -59,11 +65,16 @@ func (c *Cipher) Encrypt(plaintext []byte) (ciphertext []byte, err error) {
 		return nil, fmt.Errorf("error randomizing nounce: %w", err)
 	}
 
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
 }
 
 // Decrypt decrypts the provided ciphertext and returns the plaintext or err.
 func (c *Cipher) Decrypt(ciphertext []byte) (plaintext []byte, err error) {
+	return c.DecryptWithAD(ciphertext, nil)
+}
+
+// DecryptWithAD decrypts the provided ciphertext encrypted with the additional data and returns the plaintext or err.
+func (c *Cipher) DecryptWithAD(ciphertext, additionalData []byte) (plaintext []byte, err error) {
 	ci, err := aes.NewCipher(c.key)
 	if err != nil {
 		return nil, fmt.Errorf("error creating aes.Cipher: %w", err)
@@ -79,5 +90,5 @@ func (c *Cipher) Decrypt(ciphertext []byte) (plaintext []byte, err error) {
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	return gcm.Open(nil, nonce, ciphertext, nil)
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
 }